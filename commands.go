@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danradchuk/raytracer/core"
+	"github.com/danradchuk/raytracer/dsl"
+	"github.com/danradchuk/raytracer/gltf"
+	"github.com/danradchuk/raytracer/scenejson"
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// runFmt rewrites scene files in canonical style. Without -w the result is
+// written to the standard output.
+func runFmt(args []string) error {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "write the result to the source file instead of the standard output")
+	list := fs.Bool("l", false, "list files whose formatting differs from the canonical style")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: raytracer fmt [-w] [-l] file...")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("fmt: no files")
+	}
+
+	for _, name := range fs.Args() {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		res, err := dsl.FormatSource(src)
+		if err != nil {
+			return fmt.Errorf("%s:%w", name, err)
+		}
+
+		if *list && !bytes.Equal(src, res) {
+			fmt.Println(name)
+		}
+		if *write {
+			if !bytes.Equal(src, res) {
+				if err := os.WriteFile(name, res, 0o644); err != nil {
+					return err
+				}
+			}
+		} else if !*list {
+			if _, err := os.Stdout.Write(res); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadScene reads a scene file in the format given by its extension:
+// JSON for .json, glTF for .gltf and .glb and the scene DSL otherwise.
+func loadScene(path string) (*core.Scene, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return scenejson.ReadFile(path)
+	case ".gltf", ".glb":
+		return gltf.ReadFile(path)
+	}
+	return dsl.ParseFile(path)
+}
+
+// runConvert converts a scene between the DSL and JSON, the formats being
+// picked by the file extensions.
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: raytracer convert input.{scene,json} output.{scene,json}")
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("convert: expected an input and an output file")
+	}
+
+	switch strings.ToLower(filepath.Ext(fs.Arg(0))) {
+	case ".gltf", ".glb":
+		// the meshes of a glTF scene aren't files a scene could refer to
+		return fmt.Errorf("convert: glTF scenes can't be converted")
+	}
+
+	s, err := loadScene(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	if strings.EqualFold(filepath.Ext(fs.Arg(1)), ".json") {
+		err = scenejson.Encode(f, s)
+	} else {
+		err = dsl.Write(f, s)
+	}
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// runExport writes the geometry of a scene, with spheres and planes
+// tessellated, to an OBJ file with its MTL library next to it or to a PLY
+// file, picked by the extension.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	frame := fs.Int("frame", 0, "place the animated primitives at this frame instead of where the scene puts them")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: raytracer export [-frame n] input output.{obj,ply}")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	// frame 0 is a frame like any other, so only an explicit flag counts
+	setFrame := false
+	fs.Visit(func(f *flag.Flag) { setFrame = setFrame || f.Name == "frame" })
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("export: expected an input and an output file")
+	}
+	output := fs.Arg(1)
+	ext := strings.ToLower(filepath.Ext(output))
+	if ext != ".obj" && ext != ".ply" {
+		return fmt.Errorf("export: can't export to %q files", ext)
+	}
+
+	s, err := loadScene(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if setFrame {
+		if err := s.SetFrame(float64(*frame)); err != nil {
+			return err
+		}
+	}
+	m, err := s.Geometry()
+	if err != nil {
+		return err
+	}
+
+	if ext == ".ply" {
+		return core.WriteFile(output, m.WritePLY)
+	}
+
+	mtl := strings.TrimSuffix(output, filepath.Ext(output)) + ".mtl"
+	err = core.WriteFile(output, func(w io.Writer) error {
+		return m.WriteOBJ(w, filepath.Base(mtl))
+	})
+	if err != nil {
+		return err
+	}
+	return core.WriteFile(mtl, func(w io.Writer) error {
+		return shading.WriteMTL(w, m.Materials, filepath.Dir(mtl))
+	})
+}
@@ -0,0 +1,335 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/danradchuk/raytracer/geometry"
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// Interpolations between the keyframes of a Track.
+const (
+	InterpolateLinear     = "linear"
+	InterpolateBezier     = "bezier"
+	InterpolateCatmullRom = "catmullrom"
+)
+
+// Interpolations are the choices of Track.Interpolation.
+var Interpolations = []string{InterpolateLinear, InterpolateBezier, InterpolateCatmullRom}
+
+// Targets of an Animation.
+const (
+	AnimateCamera    = "camera"
+	AnimateLight     = "light"
+	AnimatePrimitive = "primitive"
+)
+
+// Keyframe is the value of a property at a frame. In and Out are the Bezier
+// handles before and after the key; a handle equal to the value eases in or
+// out of the key. Scalar properties only use X.
+type Keyframe struct {
+	Frame   float64
+	Value   geometry.Vec3
+	In, Out geometry.Vec3
+}
+
+// Track interpolates keyframes sorted by frame. Before the first and after
+// the last key the property holds the value of that key.
+type Track struct {
+	Interpolation string
+	Keys          []Keyframe
+}
+
+// At returns the value of the track at a frame.
+func (t Track) At(frame float64) geometry.Vec3 {
+	keys := t.Keys
+	if len(keys) == 0 {
+		return geometry.Vec3{}
+	}
+	if frame <= keys[0].Frame {
+		return keys[0].Value
+	}
+	if frame >= keys[len(keys)-1].Frame {
+		return keys[len(keys)-1].Value
+	}
+
+	i := 0
+	for keys[i+1].Frame <= frame {
+		i++
+	}
+	k0, k1 := keys[i], keys[i+1]
+	u := (frame - k0.Frame) / (k1.Frame - k0.Frame)
+
+	switch t.Interpolation {
+	case InterpolateBezier:
+		a := 1 - u
+		return k0.Value.Scale(a * a * a).
+			Add(k0.Out.Scale(3 * a * a * u)).
+			Add(k1.In.Scale(3 * a * u * u)).
+			Add(k1.Value.Scale(u * u * u))
+	case InterpolateCatmullRom:
+		// the curve passes through the keys; the end keys are repeated
+		p0, p1, p2, p3 := k0.Value, k0.Value, k1.Value, k1.Value
+		if i > 0 {
+			p0 = keys[i-1].Value
+		}
+		if i+2 < len(keys) {
+			p3 = keys[i+2].Value
+		}
+		return p1.Scale(2).
+			Add(p2.Sub(p0).Scale(u)).
+			Add(p0.Scale(2).Sub(p1.Scale(5)).Add(p2.Scale(4)).Sub(p3).Scale(u * u)).
+			Add(p1.Scale(3).Sub(p0).Sub(p2.Scale(3)).Add(p3).Scale(u * u * u)).
+			Scale(.5)
+	default:
+		return k0.Value.Scale(1 - u).Add(k1.Value.Scale(u))
+	}
+}
+
+// Animation is a keyframed property of the camera, of a light or of a
+// primitive. Index selects the light in Scene.Lights or the primitive in
+// Scene.Primitives.
+type Animation struct {
+	Target   string
+	Index    int
+	Property string
+	Track    Track
+}
+
+// Properties returns the names of the animatable properties of a target. The
+// properties of a primitive depend on its type.
+func Properties(target string, prim geometry.Primitive) []string {
+	switch target {
+	case AnimateCamera:
+		return []string{"pos", "target"}
+	case AnimateLight:
+		return []string{"pos", "diffuse", "specular"}
+	case AnimatePrimitive:
+		switch prim.(type) {
+		case geometry.Sphere:
+			return []string{"center", "radius"}
+		case *geometry.Mesh:
+			return []string{"translate", "rotate", "scale"}
+		}
+	}
+	return nil
+}
+
+// IsScalarProperty reports whether an animatable property is a number
+// rather than a vector or a color.
+func IsScalarProperty(property string) bool {
+	return property == "radius"
+}
+
+// CheckAnimations reports animations of missing targets or properties and
+// tracks whose keys aren't sorted by frame.
+func (s *Scene) CheckAnimations() error {
+	for _, a := range s.Animations {
+		var prim geometry.Primitive
+		switch a.Target {
+		case AnimateLight:
+			if a.Index < 0 || a.Index >= len(s.Lights) {
+				return fmt.Errorf("animation of light %d: no such light", a.Index)
+			}
+		case AnimatePrimitive:
+			if a.Index < 0 || a.Index >= len(s.Primitives) {
+				return fmt.Errorf("animation of primitive %d: no such primitive", a.Index)
+			}
+			prim = s.Primitives[a.Index]
+		}
+
+		if !slices.Contains(Properties(a.Target, prim), a.Property) {
+			return fmt.Errorf("%s %d: %q can't be animated", a.Target, a.Index, a.Property)
+		}
+		if !slices.Contains(Interpolations, a.Track.Interpolation) {
+			return fmt.Errorf("%s %d: unknown interpolation %q", a.Target, a.Index, a.Track.Interpolation)
+		}
+		if len(a.Track.Keys) == 0 {
+			return fmt.Errorf("%s %d: %s has no keys", a.Target, a.Index, a.Property)
+		}
+		for i := 1; i < len(a.Track.Keys); i++ {
+			if a.Track.Keys[i].Frame <= a.Track.Keys[i-1].Frame {
+				return fmt.Errorf("%s %d: keys of %s must have increasing frames", a.Target, a.Index, a.Property)
+			}
+		}
+	}
+	return nil
+}
+
+// LastKeyframe returns the frame of the last key of all animations.
+func (s *Scene) LastKeyframe() float64 {
+	last := math.Inf(-1)
+	for _, a := range s.Animations {
+		if n := len(a.Track.Keys); n > 0 {
+			last = math.Max(last, a.Track.Keys[n-1].Frame)
+		}
+	}
+	return last
+}
+
+// motionSamples is the number of transforms sampled over the shutter
+// interval of a moving primitive.
+const motionSamples = 8
+
+// SetFrame moves the camera, the lights and the primitives to their
+// animated state at a frame. Instead of being rebuilt, the BVH of the scene
+// and those of transformed meshes are refit to the new positions.
+//
+// With an open shutter (Settings.ShutterClose > Settings.ShutterOpen) the
+// scene is set to the moment the shutter opens, and animated primitives are
+// wrapped in a geometry.Motion, so that rays hit them where they are at
+// the time of the ray.
+func (s *Scene) SetFrame(frame float64) error {
+	if len(s.Animations) == 0 {
+		return nil
+	}
+	if err := s.CheckAnimations(); err != nil {
+		return err
+	}
+
+	open, shut := frame+s.Settings.ShutterOpen, frame+s.Settings.ShutterClose
+	if shut < open {
+		shut = open
+	}
+	s.shutter = [2]float64{open, shut}
+	if s.moving == nil {
+		s.moving = make(map[int]*geometry.Motion)
+	}
+
+	// the animated primitives
+	animated := make(map[int]bool)
+	for _, a := range s.Animations {
+		if a.Target == AnimatePrimitive {
+			animated[a.Index] = true
+		}
+	}
+
+	for _, a := range s.Animations {
+		v := a.Track.At(open)
+
+		switch a.Target {
+		case AnimateCamera:
+			switch a.Property {
+			case "pos":
+				s.Camera = v
+			case "target":
+				s.Target = &v
+			}
+		case AnimateLight:
+			l := s.Lights[a.Index]
+			switch a.Property {
+			case "pos":
+				l.Pos = v
+			case "diffuse":
+				l.DiffuseIntensity = shading.Color{R: v.X, G: v.Y, B: v.Z}
+			case "specular":
+				l.SpecularIntensity = shading.Color{R: v.X, G: v.Y, B: v.Z}
+			}
+		}
+	}
+
+	for i := range animated {
+		switch p := s.Primitives[i].(type) {
+		case geometry.Sphere:
+			s.Primitives[i] = s.sphereAt(p, i, open)
+		case *geometry.Mesh:
+			p.SetTransform(s.transformAt(p, i, open))
+		}
+
+		if shut > open {
+			s.moving[i] = s.motion(i, open, shut)
+		} else {
+			delete(s.moving, i)
+		}
+	}
+
+	switch {
+	case s.AccelBVH == nil:
+	case len(s.slots) != len(s.Primitives):
+		// the BVH wasn't built by BuildBVH, so the leaves are unknown
+		s.BuildBVH()
+	default:
+		for i := range animated {
+			*s.slots[i] = s.leaf(i)
+		}
+		s.AccelBVH.Refit()
+	}
+
+	return nil
+}
+
+// leaf returns the primitive at index i as it is placed in the BVH.
+func (s *Scene) leaf(i int) geometry.Primitive {
+	if m, ok := s.moving[i]; ok {
+		return m
+	}
+	return s.Primitives[i]
+}
+
+// sphereAt returns the sphere at index i at time t.
+func (s *Scene) sphereAt(sphere geometry.Sphere, i int, t float64) geometry.Sphere {
+	for _, a := range s.Animations {
+		if a.Target != AnimatePrimitive || a.Index != i {
+			continue
+		}
+		switch v := a.Track.At(t); a.Property {
+		case "center":
+			sphere.Center = v
+		case "radius":
+			sphere.R = v.X
+		}
+	}
+	return sphere
+}
+
+// transformAt returns the transform of the mesh at index i at time t.
+func (s *Scene) transformAt(m *geometry.Mesh, i int, t float64) geometry.Transform {
+	transform := m.Transform
+	for _, a := range s.Animations {
+		if a.Target != AnimatePrimitive || a.Index != i {
+			continue
+		}
+		switch v := a.Track.At(t); a.Property {
+		case "translate":
+			transform.Translate = v
+		case "rotate":
+			transform.Rotate = v
+		case "scale":
+			transform.Scale = v
+		}
+	}
+	return transform
+}
+
+// placement returns the matrix that places the primitive at index i at time
+// t. For a sphere it places the unit sphere.
+func (s *Scene) placement(i int, t float64) geometry.Matrix4 {
+	switch p := s.Primitives[i].(type) {
+	case geometry.Sphere:
+		sphere := s.sphereAt(p, i, t)
+		return geometry.Translation(sphere.Center).Mul(geometry.Scaling(geometry.Vec3{X: sphere.R, Y: sphere.R, Z: sphere.R}))
+	case *geometry.Mesh:
+		return s.transformAt(p, i, t).Matrix()
+	}
+	return geometry.Identity()
+}
+
+// motion samples the movement of the primitive at index i from open to shut
+// relative to where it is at open.
+func (s *Scene) motion(i int, open, shut float64) *geometry.Motion {
+	start, ok := s.placement(i, open).Inverse()
+	if !ok {
+		start = geometry.Identity()
+	}
+
+	times := make([]float64, motionSamples)
+	transforms := make([]geometry.Matrix4, motionSamples)
+	for k := range times {
+		times[k] = open + (shut-open)*float64(k)/(motionSamples-1)
+		transforms[k] = s.placement(i, times[k]).Mul(start)
+	}
+
+	return geometry.NewMotion(s.Primitives[i], times, transforms)
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"math"
+	"testing"
+
+	"github.com/danradchuk/raytracer/geometry"
+)
+
+func TestTrackAt(t *testing.T) {
+	keys := []Keyframe{
+		{Frame: 0, Value: geometry.Vec3{X: 0}, Out: geometry.Vec3{X: 0}},
+		{Frame: 10, Value: geometry.Vec3{X: 10}, In: geometry.Vec3{X: 10}, Out: geometry.Vec3{X: 10}},
+		{Frame: 20, Value: geometry.Vec3{X: 0}, In: geometry.Vec3{X: 0}},
+	}
+
+	for _, interp := range Interpolations {
+		track := Track{Interpolation: interp, Keys: keys}
+
+		// every interpolation passes through the keys and holds the end values
+		for _, tc := range []struct{ frame, want float64 }{{-5, 0}, {0, 0}, {10, 10}, {20, 0}, {25, 0}} {
+			if got := track.At(tc.frame).X; math.Abs(got-tc.want) > 1e-9 {
+				t.Errorf("%s: At(%v) = %v, want %v", interp, tc.frame, got, tc.want)
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		interp string
+		want   float64
+	}{
+		{InterpolateLinear, 5},
+		{InterpolateBezier, 5},         // handles at the keys ease symmetrically
+		{InterpolateCatmullRom, 5.625}, // overshoots towards the next key
+	} {
+		if got := (Track{Interpolation: tc.interp, Keys: keys}).At(5).X; math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%s: At(5) = %v, want %v", tc.interp, got, tc.want)
+		}
+	}
+}
+
+func TestSetFrameRefitsBVH(t *testing.T) {
+	s := &Scene{
+		Primitives: []geometry.Primitive{
+			geometry.Sphere{Center: geometry.Vec3{X: -10}, R: 1},
+			geometry.Sphere{Center: geometry.Vec3{X: 20}, R: 1},
+			geometry.Sphere{Center: geometry.Vec3{X: 30}, R: 1},
+		},
+		Animations: []Animation{{
+			Target:   AnimatePrimitive,
+			Index:    0,
+			Property: "center",
+			Track: Track{Interpolation: InterpolateLinear, Keys: []Keyframe{
+				{Frame: 1, Value: geometry.Vec3{X: -10}},
+				{Frame: 11, Value: geometry.Vec3{Y: 50}},
+			}},
+		}},
+	}
+	s.BuildBVH()
+
+	if err := s.SetFrame(11); err != nil {
+		t.Fatal(err)
+	}
+
+	r := geometry.NewSecondaryRay(geometry.Vec3{Y: 50, Z: -10}, geometry.Vec3{Z: 1})
+	hit := s.AccelBVH.Intersect(r)
+	if hit == nil || math.Abs(hit.T-9) > 1e-9 {
+		t.Fatalf("Intersect() = %+v, want a hit of the moved sphere at t = 9", hit)
+	}
+	if c := s.Primitives[0].(geometry.Sphere).Center; c != (geometry.Vec3{Y: 50}) {
+		t.Errorf("sphere at %v, want it moved", c)
+	}
+}
+
+func TestSetFrameMotionBlur(t *testing.T) {
+	s := &Scene{
+		Settings: RenderSettings{ShutterOpen: 0, ShutterClose: 1},
+		Primitives: []geometry.Primitive{
+			geometry.Sphere{Center: geometry.Vec3{X: -10}, R: 1},
+			geometry.Sphere{Center: geometry.Vec3{X: 30}, R: 1},
+		},
+		Animations: []Animation{{
+			Target:   AnimatePrimitive,
+			Index:    0,
+			Property: "center",
+			Track: Track{Interpolation: InterpolateLinear, Keys: []Keyframe{
+				{Frame: 1, Value: geometry.Vec3{X: -10}},
+				{Frame: 2, Value: geometry.Vec3{X: 10}},
+			}},
+		}},
+	}
+	s.BuildBVH()
+
+	if err := s.SetFrame(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the sphere sweeps from x = -10 to x = 10 while the shutter is open
+	for _, tc := range []struct{ time, x float64 }{{1, -10}, {1.5, 0}, {2, 10}} {
+		for _, x := range []float64{-10, 0, 10} {
+			r := geometry.Ray{Origin: geometry.Vec3{X: x, Z: -10}, Direction: geometry.Vec3{Z: 1}, Time: tc.time}
+			hit := s.AccelBVH.Intersect(r)
+			if want := x == tc.x; (hit != nil) != want {
+				t.Errorf("ray at x = %v, time %v: hit %v, want %v", x, tc.time, hit != nil, want)
+			}
+		}
+	}
+}
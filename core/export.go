@@ -0,0 +1,26 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/danradchuk/raytracer/geometry"
+)
+
+// Geometry returns the primitives of the scene, as they are placed now, in
+// one mesh in world space; see geometry.Tessellate. The groups of the mesh
+// are named after the primitives and their index in Primitives, like
+// sphere_2, so that they can be told apart in other tools.
+func (s *Scene) Geometry() (*geometry.IndexedMesh, error) {
+	res := &geometry.IndexedMesh{}
+	for i, p := range s.Primitives {
+		m, err := geometry.Tessellate(p)
+		if err != nil {
+			return nil, fmt.Errorf("primitive %d: %w", i, err)
+		}
+		for k := range m.Groups {
+			m.Groups[k].Name = fmt.Sprintf("%s_%d", m.Groups[k].Name, i)
+		}
+		res.Append(m)
+	}
+	return res, nil
+}
@@ -0,0 +1,36 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/danradchuk/raytracer/geometry"
+)
+
+func TestGeometry(t *testing.T) {
+	s := &Scene{Primitives: []geometry.Primitive{
+		geometry.Plane{Normal: geometry.Vec3{Y: 1}, Width: 10},
+		geometry.Sphere{Center: geometry.Vec3{Y: 1}, R: 1},
+		&geometry.Triangle{V1: geometry.Vec3{X: 1}, V2: geometry.Vec3{Y: 1}},
+	}}
+
+	m, err := s.Geometry()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, g := range m.Groups {
+		names = append(names, g.Name)
+	}
+	if len(names) != 3 || names[0] != "plane_0" || names[1] != "sphere_1" || names[2] != "triangle_2" {
+		t.Errorf("groups %v", names)
+	}
+	if want := 2 + 2*geometry.SphereSegments*(geometry.SphereRings-1) + 1; m.NumTriangles() != want {
+		t.Errorf("got %d triangles, want %d", m.NumTriangles(), want)
+	}
+
+	s.Primitives = append(s.Primitives, geometry.BuildBVH(nil))
+	if _, err := s.Geometry(); err == nil {
+		t.Errorf("Geometry of a BVH node succeeded")
+	}
+}
@@ -0,0 +1,228 @@
+package core
+
+import (
+	"bufio"
+	"compress/lzw"
+	"context"
+	"image"
+	"io"
+	"math"
+
+	"github.com/danradchuk/raytracer/geometry"
+)
+
+// RenderGIFContext renders a turntable, or the frames of an animated scene,
+// into a .gif file, see Renderer.WriteGIF.
+func (s *Scene) RenderGIFContext(ctx context.Context, width, height, fov int, output string) error {
+	r := NewRenderer(s, Options{Width: width, Height: height, Fov: fov})
+	return WriteFile(output, func(w io.Writer) error { return r.WriteGIF(ctx, w) })
+}
+
+// WriteGIF renders a turntable of Settings.Frames frames with the camera
+// orbiting the Y axis at Settings.OrbitRadius and writes it to w. An animated
+// scene instead plays its frames Settings.FirstFrame to Settings.LastFrame, as
+// RenderSequence renders them, whatever the Frame of the options. Every frame is rendered on the tile worker pool
+// and encoded while the next one renders, so only a couple of frames are held
+// in memory at any time.
+func (r *Renderer) WriteGIF(ctx context.Context, w io.Writer) error {
+	restore, err := r.prepare(false)
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	s := r.scene
+	settings := s.Settings.WithDefaults()
+	width, height, fov := r.opts.Width, r.opts.Height, r.opts.Fov
+
+	animated := len(s.Animations) > 0
+	first, frames := 0, settings.Frames
+	if animated {
+		var last int
+		first, last = s.frameRange()
+		frames = last - first + 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	defer r.reporting(frames)()
+
+	// two frame buffers: one is rendered while the other is encoded
+	free := make(chan *image.RGBA, 2)
+	for i := 0; i < cap(free); i++ {
+		free <- image.NewRGBA(image.Rect(0, 0, width, height))
+	}
+	rendered := make(chan *image.RGBA, 1)
+
+	encoded := make(chan error, 1)
+	go func() {
+		gw := newGIFWriter(w, width, height)
+
+		var err error
+		for img := range rendered {
+			if err == nil {
+				err = gw.writeFrame(quantize(img, settings.Palette, settings.Dither), settings.FrameDelay)
+				if err != nil {
+					cancel()
+				}
+			}
+			free <- img
+		}
+		if err == nil {
+			err = gw.close()
+		}
+		encoded <- err
+	}()
+
+	var renderErr error
+	for i := 0; i < frames && renderErr == nil; i++ {
+		img := <-free
+
+		var eye geometry.Vec3
+		if animated {
+			if renderErr = s.SetFrame(float64(first + i)); renderErr != nil {
+				break
+			}
+			eye = s.Camera
+		} else {
+			theta := float64(i) * (2 * math.Pi / float64(frames))
+			eye = geometry.Vec3{
+				X: settings.OrbitRadius * math.Cos(theta),
+				Y: s.Camera.Y,
+				Z: settings.OrbitRadius * math.Sin(theta),
+			}
+		}
+
+		renderErr = s.renderRGBA(ctx, eye, fov, img)
+		if renderErr == nil {
+			rendered <- img
+		}
+	}
+	close(rendered)
+
+	if err := <-encoded; err != nil {
+		return err
+	}
+	return renderErr
+}
+
+// gifWriter encodes an endlessly looping GIF one frame at a time. Unlike
+// gif.EncodeAll it doesn't need all the frames up front; every frame
+// carries its own color table.
+type gifWriter struct {
+	w             *bufio.Writer
+	width, height int
+	err           error
+}
+
+func newGIFWriter(w io.Writer, width, height int) *gifWriter {
+	gw := &gifWriter{w: bufio.NewWriter(w), width: width, height: height}
+
+	// header and logical screen descriptor without a global color table
+	gw.write([]byte("GIF89a"))
+	gw.writeUint16(width)
+	gw.writeUint16(height)
+	gw.write([]byte{0, 0, 0})
+
+	// the NETSCAPE2.0 application extension loops the animation forever
+	gw.write([]byte{0x21, 0xFF, 0x0B})
+	gw.write([]byte("NETSCAPE2.0"))
+	gw.write([]byte{0x03, 0x01, 0x00, 0x00, 0x00})
+
+	return gw
+}
+
+// writeFrame appends a frame shown for delay hundredths of a second.
+func (gw *gifWriter) writeFrame(img *image.Paletted, delay int) error {
+	// graphic control extension
+	gw.write([]byte{0x21, 0xF9, 0x04, 0x00})
+	gw.writeUint16(delay)
+	gw.write([]byte{0x00, 0x00})
+
+	// the local color table has a power of two entries, at least 4
+	bits := 2
+	for 1<<bits < len(img.Palette) {
+		bits++
+	}
+
+	// image descriptor
+	b := img.Bounds()
+	gw.write([]byte{0x2C})
+	gw.writeUint16(b.Min.X)
+	gw.writeUint16(b.Min.Y)
+	gw.writeUint16(b.Dx())
+	gw.writeUint16(b.Dy())
+	gw.write([]byte{0x80 | byte(bits-1)})
+
+	table := make([]byte, 3<<bits)
+	for i, c := range img.Palette {
+		r, g, b, _ := c.RGBA()
+		table[3*i], table[3*i+1], table[3*i+2] = byte(r>>8), byte(g>>8), byte(b>>8)
+	}
+	gw.write(table)
+
+	// LZW compressed pixels in sub-blocks of at most 255 bytes
+	gw.write([]byte{byte(bits)})
+	blocks := &blockWriter{w: gw}
+	lw := lzw.NewWriter(blocks, lzw.LSB, bits)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		off := img.PixOffset(b.Min.X, y)
+		if _, err := lw.Write(img.Pix[off : off+b.Dx()]); err != nil {
+			return err
+		}
+	}
+	if err := lw.Close(); err != nil {
+		return err
+	}
+	blocks.flush()
+	gw.write([]byte{0x00})
+
+	return gw.err
+}
+
+// close writes the trailer of the file.
+func (gw *gifWriter) close() error {
+	gw.write([]byte{0x3B})
+	if gw.err != nil {
+		return gw.err
+	}
+	return gw.w.Flush()
+}
+
+func (gw *gifWriter) write(p []byte) {
+	if gw.err == nil {
+		_, gw.err = gw.w.Write(p)
+	}
+}
+
+func (gw *gifWriter) writeUint16(v int) {
+	gw.write([]byte{byte(v), byte(v >> 8)})
+}
+
+// blockWriter splits the image data into GIF sub-blocks.
+type blockWriter struct {
+	w   *gifWriter
+	buf [255]byte
+	n   int
+}
+
+func (bw *blockWriter) Write(p []byte) (int, error) {
+	for i := range p {
+		bw.buf[bw.n] = p[i]
+		bw.n++
+		if bw.n == len(bw.buf) {
+			bw.flush()
+		}
+	}
+	return len(p), bw.w.err
+}
+
+func (bw *blockWriter) flush() {
+	if bw.n == 0 {
+		return
+	}
+	bw.w.write([]byte{byte(bw.n)})
+	bw.w.write(bw.buf[:bw.n])
+	bw.n = 0
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"slices"
+	"testing"
+
+	"github.com/danradchuk/raytracer/geometry"
+	"github.com/danradchuk/raytracer/shading"
+)
+
+func TestGIFWriterDecodes(t *testing.T) {
+	const width, height = 20, 10
+
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			src.Set(x, y, color.RGBA{R: uint8(x * 12), G: uint8(y * 25), B: 128, A: 0xFF})
+		}
+	}
+
+	var buf bytes.Buffer
+	gw := newGIFWriter(&buf, width, height)
+	for _, q := range []struct{ palette, dither string }{
+		{PalettePlan9, DitherNone},
+		{PaletteMedianCut, DitherNone},
+		{PaletteMedianCut, DitherFloydSteinberg},
+	} {
+		if err := gw.writeFrame(quantize(src, q.palette, q.dither), 5); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := gw.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 3 || g.Delay[0] != 5 {
+		t.Fatalf("decoded %d frames with delays %v", len(g.Image), g.Delay)
+	}
+
+	// 200 distinct colors fit into a median cut palette exactly
+	frame := g.Image[1]
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, _ := frame.At(x, y).RGBA()
+			want := src.RGBAAt(x, y)
+			if uint8(r>>8) != want.R || uint8(g>>8) != want.G || uint8(b>>8) != want.B {
+				t.Fatalf("pixel (%d, %d) = %d %d %d, want %v", x, y, r>>8, g>>8, b>>8, want)
+			}
+		}
+	}
+}
+
+func TestWriteGIFAnimated(t *testing.T) {
+	first, last := 0, 2
+	s := &Scene{
+		Background: shading.Color{B: 1},
+		Camera:     geometry.Vec3{Z: -10},
+		Lights:     []*Light{{Pos: geometry.Vec3{Y: 10}, DiffuseIntensity: shading.Color{R: 1, G: 1, B: 1}}},
+		Primitives: []geometry.Primitive{geometry.Sphere{Center: geometry.Vec3{X: -6}, R: 2, Material: shading.RedRubber}},
+		Animations: []Animation{{
+			Target:   AnimatePrimitive,
+			Property: "center",
+			Track: Track{Interpolation: InterpolateLinear, Keys: []Keyframe{
+				{Frame: 0, Value: geometry.Vec3{X: -6}},
+				{Frame: 2, Value: geometry.Vec3{X: 6}},
+			}},
+		}},
+		Settings: RenderSettings{Frames: 10, FirstFrame: &first, LastFrame: &last},
+	}
+	s.BuildBVH()
+
+	var buf bytes.Buffer
+	if err := NewRenderer(s, Options{Width: 16, Height: 8}).WriteGIF(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// the keyframes are played instead of a turntable of Settings.Frames
+	g, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 3 {
+		t.Fatalf("decoded %d frames, want frames 0 to 2", len(g.Image))
+	}
+	if slices.Equal(g.Image[0].Pix, g.Image[2].Pix) {
+		t.Error("the first and the last frame are equal, want the sphere moved")
+	}
+}
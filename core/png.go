@@ -0,0 +1,38 @@
+package core
+
+import (
+	"context"
+	"image"
+	"image/png"
+	"io"
+
+	"github.com/danradchuk/raytracer/geometry"
+	"github.com/danradchuk/raytracer/shading"
+)
+
+func (s *Scene) RenderPNG(width, height, fov int, output string) error {
+	return s.RenderPNGContext(context.Background(), width, height, fov, output)
+}
+
+// RenderPNGContext renders the scene into a .png file and stops when ctx is canceled.
+func (s *Scene) RenderPNGContext(ctx context.Context, width, height, fov int, output string) error {
+	r := NewRenderer(s, Options{Width: width, Height: height, Fov: fov})
+	img, err := r.RenderImage(ctx)
+	if err != nil {
+		return err
+	}
+	return WriteFile(output, func(w io.Writer) error { return png.Encode(w, img) })
+}
+
+// renderRGBA renders the scene seen from eye into img.
+func (s *Scene) renderRGBA(ctx context.Context, eye geometry.Vec3, fov int, img *image.RGBA) error {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	return s.RenderTiles(ctx, eye, width, height, fov, func(t Tile, pixels []shading.Color) {
+		w := t.X1 - t.X0
+		for i, c := range pixels {
+			ic := c.ToImageColor()
+			off := img.PixOffset(t.X0+i%w, t.Y0+i/w)
+			img.Pix[off], img.Pix[off+1], img.Pix[off+2], img.Pix[off+3] = ic.R, ic.G, ic.B, 0xFF
+		}
+	})
+}
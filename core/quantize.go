@@ -0,0 +1,144 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"sort"
+)
+
+// Palettes of GIF frames.
+const (
+	PalettePlan9     = "plan9"
+	PaletteMedianCut = "mediancut"
+)
+
+// Dithering of GIF frames.
+const (
+	DitherNone           = "none"
+	DitherFloydSteinberg = "floydsteinberg"
+)
+
+// quantize maps a frame onto the fixed Plan9 palette or onto a palette of
+// the colors of the frame chosen by median cut, optionally with
+// Floyd-Steinberg error diffusion.
+func quantize(img *image.RGBA, paletteName, dither string) *image.Paletted {
+	p := palette.Plan9
+	if paletteName == PaletteMedianCut {
+		p = medianCut(img, 256)
+	}
+
+	dst := image.NewPaletted(img.Bounds(), p)
+	if dither == DitherFloydSteinberg {
+		draw.FloydSteinberg.Draw(dst, dst.Bounds(), img, img.Bounds().Min)
+	} else {
+		draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+
+	return dst
+}
+
+// colorBin accumulates the pixels of a cell of the color histogram.
+type colorBin struct {
+	sum [3]int // sums of the red, green and blue channels
+	n   int
+}
+
+func (b colorBin) mean(ch int) int {
+	return b.sum[ch] / b.n
+}
+
+// medianCut returns a palette of at most n colors. The histogram of the
+// image is split recursively at the median of the widest channel of the
+// box with the largest range, and every box contributes its mean color.
+func medianCut(img *image.RGBA, n int) color.Palette {
+	// 5 bits per channel
+	var hist [1 << 15]colorBin
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			off := img.PixOffset(x, y)
+			r, g, bl := int(img.Pix[off]), int(img.Pix[off+1]), int(img.Pix[off+2])
+
+			bin := &hist[r>>3<<10|g>>3<<5|bl>>3]
+			bin.sum[0] += r
+			bin.sum[1] += g
+			bin.sum[2] += bl
+			bin.n++
+		}
+	}
+
+	var bins []colorBin
+	for _, bin := range hist {
+		if bin.n > 0 {
+			bins = append(bins, bin)
+		}
+	}
+	if len(bins) == 0 {
+		return color.Palette{color.Black}
+	}
+
+	// widest returns the channel with the largest range of a box and the range
+	widest := func(box []colorBin) (int, int) {
+		ch, spread := 0, -1
+		for c := 0; c < 3; c++ {
+			lo, hi := 255, 0
+			for _, bin := range box {
+				lo = min(lo, bin.mean(c))
+				hi = max(hi, bin.mean(c))
+			}
+			if hi-lo > spread {
+				ch, spread = c, hi-lo
+			}
+		}
+		return ch, spread
+	}
+
+	boxes := [][]colorBin{bins}
+	for len(boxes) < n {
+		split, ch, spread := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if c, s := widest(box); s > spread {
+				split, ch, spread = i, c, s
+			}
+		}
+		if split < 0 {
+			break
+		}
+
+		box := boxes[split]
+		sort.Slice(box, func(i, j int) bool { return box[i].mean(ch) < box[j].mean(ch) })
+
+		// the median by pixel count, keeping both halves non-empty
+		total := 0
+		for _, bin := range box {
+			total += bin.n
+		}
+		k, count := 1, box[0].n
+		for k < len(box)-1 && count < total/2 {
+			count += box[k].n
+			k++
+		}
+
+		boxes[split] = box[:k]
+		boxes = append(boxes, box[k:])
+	}
+
+	p := make(color.Palette, len(boxes))
+	for i, box := range boxes {
+		var sum colorBin
+		for _, bin := range box {
+			for c := range sum.sum {
+				sum.sum[c] += bin.sum[c]
+			}
+			sum.n += bin.n
+		}
+		p[i] = color.RGBA{R: uint8(sum.mean(0)), G: uint8(sum.mean(1)), B: uint8(sum.mean(2)), A: 0xFF}
+	}
+
+	return p
+}
@@ -0,0 +1,252 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// Options configures a Renderer. Zero values, and nil pointers, take the
+// render settings of the scene, which also provide everything else (the
+// shutter, the terminal color, GIF frames and so on).
+type Options struct {
+	Width, Height   int
+	Fov             int // in degrees
+	SPP             int // samples per pixel
+	MaxDepth        int
+	ReflectionDepth *int
+	RefractionDepth *int
+	DiffuseDepth    *int
+	Workers         int              // render goroutines
+	TileOrder       string           // TileOrderHilbert, TileOrderSpiral or TileOrderScanline
+	Frame           *float64         // the frame of an animated scene; Settings.FirstFrame by default
+	Progress        ProgressReporter // replaces Scene.Progress when set
+}
+
+// Renderer renders a scene into memory or encodes the image to a writer.
+// Every render prepares the scene first: it builds the BVH unless the scene
+// has one, sets an animated scene to the frame of the options, and puts the
+// options into the render settings of the scene until the render ends.
+//
+// A render runs Workers goroutines of its own, but it changes the scene, so
+// it must not overlap with anything else using the same scene, including
+// other renders of it by any Renderer. A service rendering for several
+// requests at once needs a scene per request, e.g. one parse of the scene
+// file each, or has to render them one after another. Renders of different
+// scenes are independent.
+type Renderer struct {
+	scene *Scene
+	opts  Options
+}
+
+// NewRenderer creates a renderer of s.
+func NewRenderer(s *Scene, opts Options) *Renderer {
+	settings := s.Settings.WithDefaults()
+	if opts.Width == 0 {
+		opts.Width = settings.Width
+	}
+	if opts.Height == 0 {
+		opts.Height = settings.Height
+	}
+	if opts.Fov == 0 {
+		opts.Fov = settings.Fov
+	}
+	return &Renderer{scene: s, opts: opts}
+}
+
+// settings returns the render settings of the scene with the options.
+func (r *Renderer) settings() RenderSettings {
+	rs, o := r.scene.Settings, r.opts
+	rs.Width, rs.Height, rs.Fov = o.Width, o.Height, o.Fov
+	if o.SPP != 0 {
+		rs.SPP = o.SPP
+	}
+	if o.MaxDepth != 0 {
+		rs.MaxDepth = o.MaxDepth
+	}
+	if o.ReflectionDepth != nil {
+		rs.ReflectionDepth = o.ReflectionDepth
+	}
+	if o.RefractionDepth != nil {
+		rs.RefractionDepth = o.RefractionDepth
+	}
+	if o.DiffuseDepth != nil {
+		rs.DiffuseDepth = o.DiffuseDepth
+	}
+	if o.Workers != 0 {
+		rs.Workers = o.Workers
+	}
+	if o.TileOrder != "" {
+		rs.TileOrder = o.TileOrder
+	}
+	return rs
+}
+
+// prepare gets the scene ready for a render, see Renderer, and returns the
+// function giving it back its settings. A GIF plays the frames of an
+// animated scene itself, so it doesn't set one.
+func (r *Renderer) prepare(setFrame bool) (func(), error) {
+	s := r.scene
+	saved := s.Settings
+	s.Settings = r.settings()
+	restore := func() { s.Settings = saved }
+
+	if s.AccelBVH == nil {
+		s.BuildBVH()
+	}
+	if setFrame && len(s.Animations) > 0 {
+		frame := float64(*s.Settings.WithDefaults().FirstFrame)
+		if r.opts.Frame != nil {
+			frame = *r.opts.Frame
+		}
+		if err := s.SetFrame(frame); err != nil {
+			restore()
+			return nil, err
+		}
+	}
+
+	return restore, nil
+}
+
+// Framebuffer holds the colors of a rendered image row by row, in the range
+// [0, 1].
+type Framebuffer struct {
+	Width, Height int
+	Pix           []shading.Color
+}
+
+// NewFramebuffer creates a black framebuffer.
+func NewFramebuffer(width, height int) *Framebuffer {
+	return &Framebuffer{Width: width, Height: height, Pix: make([]shading.Color, width*height)}
+}
+
+// At returns the color of the pixel (x, y).
+func (fb *Framebuffer) At(x, y int) shading.Color {
+	return fb.Pix[y*fb.Width+x]
+}
+
+// Set sets the color of the pixel (x, y).
+func (fb *Framebuffer) Set(x, y int, c shading.Color) {
+	fb.Pix[y*fb.Width+x] = c
+}
+
+// Image converts the framebuffer to 8 bits per channel.
+func (fb *Framebuffer) Image() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, fb.Width, fb.Height))
+	for i, c := range fb.Pix {
+		ic := c.ToImageColor()
+		img.Pix[4*i], img.Pix[4*i+1], img.Pix[4*i+2], img.Pix[4*i+3] = ic.R, ic.G, ic.B, 0xFF
+	}
+	return img
+}
+
+// reporting tracks a render of the given number of frames.
+func (r *Renderer) reporting(frames int) func() {
+	reporter := r.opts.Progress
+	if reporter == nil {
+		reporter = r.scene.Progress
+	}
+	return r.scene.track(reporter, frames*r.opts.Width*r.opts.Height)
+}
+
+// Render renders the scene seen from the camera into a framebuffer.
+func (r *Renderer) Render(ctx context.Context) (*Framebuffer, error) {
+	restore, err := r.prepare(true)
+	if err != nil {
+		return nil, err
+	}
+	defer restore()
+	defer r.reporting(1)()
+
+	fb := NewFramebuffer(r.opts.Width, r.opts.Height)
+	err = r.scene.RenderTiles(ctx, r.scene.Camera, r.opts.Width, r.opts.Height, r.opts.Fov, func(t Tile, pixels []shading.Color) {
+		w := t.X1 - t.X0
+		for i, c := range pixels {
+			fb.Set(t.X0+i%w, t.Y0+i/w, c)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fb, nil
+}
+
+// RenderImage renders the scene seen from the camera into an image.
+func (r *Renderer) RenderImage(ctx context.Context) (*image.RGBA, error) {
+	restore, err := r.prepare(true)
+	if err != nil {
+		return nil, err
+	}
+	defer restore()
+	defer r.reporting(1)()
+
+	img := image.NewRGBA(image.Rect(0, 0, r.opts.Width, r.opts.Height))
+	if err := r.scene.renderRGBA(ctx, r.scene.Camera, r.opts.Fov, img); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// WritePPM renders the scene and writes it to w as a plain text PPM.
+func (r *Renderer) WritePPM(ctx context.Context, w io.Writer) error {
+	img, err := r.RenderImage(ctx)
+	if err != nil {
+		return err
+	}
+	return EncodePPM(w, img)
+}
+
+// WritePNG renders the scene and writes it to w as a PNG.
+func (r *Renderer) WritePNG(ctx context.Context, w io.Writer) error {
+	img, err := r.RenderImage(ctx)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, img)
+}
+
+// EncodePPM writes an image to w in the plain text PPM format (P3).
+func EncodePPM(w io.Writer, img image.Image) error {
+	bw := bufio.NewWriter(w)
+	b := img.Bounds()
+
+	if _, err := fmt.Fprintf(bw, "P3\n%d %d\n255\n", b.Dx(), b.Dy()); err != nil {
+		return err
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			if _, err := fmt.Fprintf(bw, "%d %d %d\n", c.R, c.G, c.B); err != nil {
+				return err
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// WriteFile creates a file with the output of write. A file left incomplete
+// by an error is removed.
+func WriteFile(name string, write func(w io.Writer) error) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(name)
+	}
+
+	return err
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/png"
+	"testing"
+
+	"github.com/danradchuk/raytracer/geometry"
+	"github.com/danradchuk/raytracer/shading"
+)
+
+func TestRendererImage(t *testing.T) {
+	s := &Scene{
+		Background: shading.Color{B: 1},
+		Camera:     geometry.Vec3{Z: -10},
+		Lights:     []*Light{{Pos: geometry.Vec3{Y: 10}, DiffuseIntensity: shading.Color{R: 1, G: 1, B: 1}}},
+		Primitives: []geometry.Primitive{geometry.Sphere{R: 5, Material: shading.RedRubber}},
+		Settings:   RenderSettings{Width: 1000, Height: 1000},
+	}
+	s.BuildBVH()
+
+	// the options take precedence over the settings of the scene
+	r := NewRenderer(s, Options{Width: 24, Height: 12})
+
+	fb, err := r.Render(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fb.Width != 24 || fb.Height != 12 {
+		t.Fatalf("framebuffer of %dx%d, want 24x12", fb.Width, fb.Height)
+	}
+	if c := fb.At(0, 0); c != s.Background {
+		t.Errorf("corner %v, want the background", c)
+	}
+
+	var buf bytes.Buffer
+	if err := r.WritePNG(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fb.Image()
+	for y := 0; y < fb.Height; y++ {
+		for x := 0; x < fb.Width; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			if c := want.RGBAAt(x, y); uint8(r>>8) != c.R || uint8(g>>8) != c.G || uint8(b>>8) != c.B {
+				t.Fatalf("pixel (%d, %d) of the PNG differs from the framebuffer", x, y)
+			}
+		}
+	}
+
+	buf.Reset()
+	if err := EncodePPM(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	c := want.RGBAAt(0, 0)
+	if header := fmt.Sprintf("P3\n24 12\n255\n%d %d %d\n", c.R, c.G, c.B); !bytes.HasPrefix(buf.Bytes(), []byte(header)) {
+		t.Errorf("PPM starts with %q, want %q", buf.Bytes()[:len(header)], header)
+	}
+}
+
+func TestRendererPreparesScene(t *testing.T) {
+	// a sphere moving into the view between frames 1 and 11; no BVH yet
+	s := &Scene{
+		Background: shading.Color{B: 1},
+		Camera:     geometry.Vec3{Z: -10},
+		Lights:     []*Light{{Pos: geometry.Vec3{Z: -10}, DiffuseIntensity: shading.Color{R: 1, G: 1, B: 1}}},
+		Primitives: []geometry.Primitive{geometry.Sphere{Center: geometry.Vec3{X: -100}, R: 5, Material: shading.RedRubber}},
+		Animations: []Animation{{
+			Target:   AnimatePrimitive,
+			Property: "center",
+			Track: Track{Interpolation: InterpolateLinear, Keys: []Keyframe{
+				{Frame: 1, Value: geometry.Vec3{X: -100}},
+				{Frame: 11, Value: geometry.Vec3{}},
+			}},
+		}},
+	}
+
+	frame := 11.
+	for _, tc := range []struct {
+		frame *float64
+		hit   bool
+	}{{nil, false}, {&frame, true}} {
+		opts := Options{Width: 8, Height: 8, SPP: 4, Workers: 1, TileOrder: TileOrderScanline, Frame: tc.frame}
+		fb, err := NewRenderer(s, opts).Render(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hit := fb.At(4, 4) != s.Background; hit != tc.hit {
+			t.Errorf("frame %v: sphere in the center %v, want %v", tc.frame, hit, tc.hit)
+		}
+	}
+
+	// the options were used for the render only
+	if s.AccelBVH == nil || s.Settings.SPP != 0 || s.Settings.TileOrder != "" {
+		t.Errorf("BVH %v, settings %+v after the render", s.AccelBVH != nil, s.Settings)
+	}
+
+	if _, err := NewRenderer(s, Options{TileOrder: "diagonal"}).Render(context.Background()); err == nil {
+		t.Errorf("render in an unknown tile order succeeded")
+	}
+}
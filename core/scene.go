@@ -1,20 +1,17 @@
 package core
 
 import (
-	"fmt"
-	"image"
-	"image/color"
-	palette2 "image/color/palette"
-	"image/gif"
+	"context"
+	"io"
 	"math"
-	"os"
-	"runtime"
-	"sync"
+	"math/rand/v2"
+	"time"
 
 	"github.com/danradchuk/raytracer/geometry"
 	"github.com/danradchuk/raytracer/shading"
 )
 
+// MaxDepth is the default recursion depth of RenderSettings.
 const MaxDepth = 3
 const Bias = 0.0000001
 
@@ -40,125 +37,155 @@ type Scene struct {
 	Camera           geometry.Vec3
 	Primitives       []geometry.Primitive
 	AccelBVH         *geometry.BVHNode
+	Settings         RenderSettings
+	Target           *geometry.Vec3 // the point the camera looks at; nil is DefaultTarget
+	Animations       []Animation
+	Progress         ProgressReporter // notified while rendering; may be nil
+
+	moving   map[int]*geometry.Motion // primitives moving while the shutter is open
+	slots    []*geometry.Primitive    // the leaves of the primitives in AccelBVH
+	shutter  [2]float64               // the exposure of the frame set by SetFrame
+	progress *progress                // the render being tracked
+	stats    Stats
 }
 
-func (s *Scene) RenderGIF(width, height, fov int, output string) error {
-	cameraPosRotation := func(theta, y float64) geometry.Vec3 {
-		r := 10.
-		x := r * math.Cos(theta)
-		z := r * math.Sin(theta)
-		return geometry.Vec3{X: x, Y: y, Z: z}
-	}
-
-	var images []*image.Paletted
-	var delays []int
-
-	for i := 0; i < 360; i++ {
-		img := image.NewPaletted(image.Rect(0, 0, width, height), palette2.Plan9)
-
-		theta := float64(i) * (2 * math.Pi / 360) // Convert degrees to radians
-
-		for y := 0; y < height; y++ {
-			for x := 0; x < width; x++ {
-				r := geometry.NewPrimaryRay(cameraPosRotation(theta, s.Camera.Y),
-					float64(width),
-					float64(height),
-					float64(x),
-					float64(y),
-					float64(fov),
-				)
-				c := s.castRay(r, 0).ToImageColor()
-				img.Set(x, y, color.RGBA{R: c.R, G: c.G, B: c.B, A: 0xFF})
-			}
-		}
+// DefaultTarget is the point the camera looks at unless the scene sets one.
+var DefaultTarget = geometry.Vec3{Z: 1}
 
-		images = append(images, img)
-		delays = append(delays, 0)
-	}
-
-	outFile, err := os.Create(output)
-	if err != nil {
-		return err
-	}
-	defer outFile.Close()
+// BuildBVH builds the BVH of the primitives. The order of Primitives, which
+// animations refer to, is kept. The stats of the scene start over.
+func (s *Scene) BuildBVH() {
+	start := time.Now()
+	s.AccelBVH, s.slots = geometry.BuildIndexedBVH(s.Primitives)
+	s.stats = Stats{BuildTime: time.Since(start)}
+}
 
-	err = gif.EncodeAll(outFile, &gif.GIF{
-		Image: images,
-		Delay: delays,
-	})
-	if err != nil {
-		return err
+// target returns the point the camera looks at.
+func (s *Scene) target() geometry.Vec3 {
+	if s.Target != nil {
+		return *s.Target
 	}
+	return DefaultTarget
+}
 
-	return nil
+func (s *Scene) RenderGIF(width, height, fov int, output string) error {
+	return s.RenderGIFContext(context.Background(), width, height, fov, output)
 }
 
 func (s *Scene) RenderPPM(width, height int, fov int, outputFile string) error {
-	// create an image file
-	f, err := os.Create(outputFile)
+	return s.RenderPPMContext(context.Background(), width, height, fov, outputFile)
+}
+
+// RenderPPMContext renders the scene into a .ppm file and stops when ctx is canceled.
+func (s *Scene) RenderPPMContext(ctx context.Context, width, height int, fov int, outputFile string) error {
+	r := NewRenderer(s, Options{Width: width, Height: height, Fov: fov})
+	img, err := r.RenderImage(ctx)
 	if err != nil {
 		return err
 	}
-	defer func() { _ = f.Close() }()
+	return WriteFile(outputFile, func(w io.Writer) error { return EncodePPM(w, img) })
+}
 
-	// write the format of the file
-	_, err = fmt.Fprintf(f, "P3\n%d %d\n255\n", width, height)
-	if err != nil {
-		return err
+// renderPixel averages Settings.SPP camera rays through the pixel (x, y).
+// Several samples are stratified over a grid of jittered cells.
+func (s *Scene) renderPixel(eye geometry.Vec3, width, height, x, y, fov int, stats *Stats) shading.Color {
+	spp := max(s.Settings.SPP, 1)
+
+	// a deterministic sequence per pixel keeps renders reproducible
+	var rng *rand.Rand
+	if spp > 1 || s.Settings.diffuse() > 0 || s.shutter[1] > s.shutter[0] {
+		rng = rand.New(rand.NewPCG(uint64(x), uint64(y)))
 	}
 
-	// visibility + shading
-	frameBuffer := createFrameBuffer(width, height)
-	numWorkers := runtime.NumCPU()
-	chunk := height / numWorkers
-	job := func(wg *sync.WaitGroup, startY, endY int) error {
-		defer wg.Done()
+	if spp == 1 {
+		r := geometry.NewCameraRay(eye, s.target(), float64(width), float64(height), float64(x), float64(y), float64(fov))
+		r.Time = s.sampleTime(rng)
+		r.Stats = &stats.TraversalStats
+		return s.castRay(r, newRayState(rng, stats)).Clamped()
+	}
 
-		for y := startY; y < endY; y++ {
-			for x := 0; x < width; x++ {
-				r := geometry.NewPrimaryRay(s.Camera, float64(width), float64(height), float64(x), float64(y), float64(fov))
-				color := s.castRay(r, 0).ToImageColor()
-				frameBuffer[x][y] = color
-			}
-		}
+	cols := int(math.Ceil(math.Sqrt(float64(spp))))
+	rows := (spp + cols - 1) / cols
+
+	var sum shading.Color
+	for i := 0; i < spp; i++ {
+		dx := (float64(i%cols) + rng.Float64()) / float64(cols)
+		dy := (float64(i/cols) + rng.Float64()) / float64(rows)
 
-		return nil
+		// NewPrimaryRay aims at the center of the pixel
+		r := geometry.NewCameraRay(eye, s.target(), float64(width), float64(height), float64(x)+dx-.5, float64(y)+dy-.5, float64(fov))
+		r.Time = s.sampleTime(rng)
+		r.Stats = &stats.TraversalStats
+		sum = sum.Add(s.castRay(r, newRayState(rng, stats)).Clamped())
 	}
 
-	var wg sync.WaitGroup
-	for i := 0; i < numWorkers; i++ {
-		startY := i * chunk
-		endY := (i + 1) * chunk
-		if i == numWorkers-1 {
-			endY = height
-		}
-		wg.Add(1)
-		// fmt.Printf("%d worker has started. width %d, height %d. begin %d, end %d\n", i+1, width, height, startY, endY-1)
-		go job(&wg, startY, endY)
+	return sum.MulByNum(1 / float64(spp))
+}
+
+// sampleTime returns a random moment of the exposure for a camera ray.
+func (s *Scene) sampleTime(rng *rand.Rand) float64 {
+	open, shut := s.shutter[0], s.shutter[1]
+	if shut <= open {
+		return open
 	}
+	return open + (shut-open)*rng.Float64()
+}
 
-	wg.Wait()
+// rayState tracks the bounces along a ray path and the fraction of the
+// pixel color that the current ray contributes.
+type rayState struct {
+	depth       int
+	reflections int
+	refractions int
+	diffuse     int
+	throughput  shading.Color
+	rng         *rand.Rand
+	stats       *Stats // the stats of the render goroutine
+}
 
-	// fill in the .ppm file from the frame buffer
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			color := frameBuffer[x][y]
-			_, err := fmt.Fprintf(f, "%d %d %d\n", color.R, color.G, color.B)
-			if err != nil {
-				return err
-			}
-		}
+func newRayState(rng *rand.Rand, stats *Stats) rayState {
+	return rayState{throughput: shading.Color{R: 1, G: 1, B: 1}, rng: rng, stats: stats}
+}
+
+// bounce returns the state of a secondary ray whose radiance is weighted by w.
+func (st rayState) bounce(w shading.Color) rayState {
+	st.depth++
+	st.throughput = st.throughput.Mul(w)
+	return st
+}
+
+// terminal is the color of a ray that isn't traced any further.
+func (s *Scene) terminal() shading.Color {
+	if s.Settings.Terminal == TerminalBlack {
+		return shading.Black
 	}
+	return s.Background
+}
 
-	return nil
+// trace casts a secondary ray unless its contribution is below Settings.MinContribution.
+func (s *Scene) trace(ray geometry.Ray, st rayState) shading.Color {
+	t := st.throughput
+	if math.Max(t.R, math.Max(t.G, t.B)) <= s.Settings.MinContribution {
+		return shading.Black
+	}
+	return s.castRay(ray, st)
 }
 
-func (s *Scene) castRay(ray geometry.Ray, depth int) shading.Color {
+func (s *Scene) castRay(ray geometry.Ray, st rayState) shading.Color {
 	// stop recursion
-	if depth >= MaxDepth {
-		return s.Background
+	maxDepth := s.Settings.MaxDepth
+	if maxDepth == 0 {
+		maxDepth = MaxDepth
+	}
+	if st.depth >= maxDepth {
+		return s.terminal()
 	}
 
+	if st.depth == 0 {
+		st.stats.PrimaryRays++
+	} else {
+		st.stats.SecondaryRays++
+	}
 	hitRecord := s.AccelBVH.Intersect(ray)
 	if hitRecord == nil {
 		return s.Background
@@ -169,65 +196,167 @@ func (s *Scene) castRay(ray geometry.Ray, depth int) shading.Color {
 	material := hitRecord.Material
 
 	hitPoint := ray.At(closestT)
-	hitNormal := hitRecord.Normal
+	hitNormal := shadingNormal(hitRecord)
 	viewDir := s.Camera.Sub(hitPoint).Normalize() // vector from the eye to the hitPoint
+	diffuseColor := material.KDiffuse
+	if material.DiffuseMap != nil {
+		diffuseColor = diffuseColor.Mul(material.DiffuseMap.Sample(hitRecord.UV.U, hitRecord.UV.V))
+	}
 
 	var (
 		diffuseComponent    shading.Color
 		specularComponent   shading.Color
 		reflectionComponent shading.Color
+		refractionComponent shading.Color
+		indirectComponent   shading.Color
 	)
 
 	// 1. compute reflection component
-	var reflectionDir = reflect(ray.Direction.Normalize(), hitNormal).Normalize()
-	var reflectionRayOrig geometry.Vec3
-	// avoid self-reflecting
-	if hitNormal.Dot(reflectionDir) < .0 {
-		reflectionRayOrig = hitPoint.Sub(hitNormal.Scale(Bias))
+	if limit := s.Settings.ReflectionDepth; limit == nil || st.reflections < *limit {
+		var reflectionDir = reflect(ray.Direction.Normalize(), hitNormal).Normalize()
+		reflectionRay := ray.Spawn(offsetOrigin(hitPoint, hitNormal, reflectionDir), reflectionDir)
+
+		next := st.bounce(material.KReflection)
+		next.reflections++
+		reflectionComponent = s.trace(reflectionRay, next).Mul(material.KReflection)
 	} else {
-		reflectionRayOrig = hitPoint.Add(hitNormal.Scale(Bias))
+		reflectionComponent = s.terminal().Mul(material.KReflection)
+	}
+
+	// 2. compute refraction component of transparent materials
+	transparency := material.Transparency
+	if transparency > 0 {
+		weight := shading.Color{R: transparency, G: transparency, B: transparency}
+		if limit := s.Settings.RefractionDepth; limit == nil || st.refractions < *limit {
+			refractionDir := refract(ray.Direction.Normalize(), hitNormal, material.IOR)
+			refractionRay := ray.Spawn(offsetOrigin(hitPoint, hitNormal, refractionDir), refractionDir)
+
+			next := st.bounce(weight)
+			next.refractions++
+			refractionComponent = s.trace(refractionRay, next).Mul(weight)
+		} else {
+			refractionComponent = s.terminal().Mul(weight)
+		}
+	}
+
+	// 3. compute indirect diffuse component
+	if st.diffuse < s.Settings.diffuse() && st.rng != nil {
+		n := hitNormal
+		if n.Dot(ray.Direction) > 0 {
+			n = n.Scale(-1)
+		}
+		dir := sampleHemisphere(n, st.rng)
+		indirectRay := ray.Spawn(offsetOrigin(hitPoint, hitNormal, dir), dir)
+
+		albedo := diffuseColor.MulByNum(1 - transparency)
+		next := st.bounce(albedo)
+		next.diffuse++
+		indirectComponent = s.trace(indirectRay, next).Mul(albedo)
 	}
-	reflectionRay := geometry.NewSecondaryRay(reflectionRayOrig, reflectionDir)
-	reflectionComponent = s.castRay(reflectionRay, depth+1).Mul(material.KReflection)
 
 	for _, light := range s.Lights {
 		lightDir := light.Pos.Sub(hitPoint).Normalize()
 
-		// 2. compute shadow component
-		var shadowRayOrig geometry.Vec3
-		// avoid self-shadowing
-		if hitNormal.Dot(lightDir) < .0 {
-			shadowRayOrig = hitPoint.Sub(hitNormal.Scale(Bias))
-		} else {
-			shadowRayOrig = hitPoint.Add(hitNormal.Scale(Bias))
-		}
-		shadowRay := geometry.NewSecondaryRay(shadowRayOrig, lightDir)
+		// 4. compute shadow component
+		shadowRay := ray.Spawn(offsetOrigin(hitPoint, hitNormal, lightDir), lightDir)
 		lightDistance := light.Pos.Sub(hitPoint).Norm()
 
 		shadowIntensity := 1.
+		st.stats.ShadowRays++
 		hitRecord := s.AccelBVH.IntersectExclude(shadowRay, closestPrimitive)
 		if hitRecord != nil && hitRecord.T > .0 && hitRecord.T < lightDistance {
 			shadowIntensity = .0
 		}
 
-		// 3. compute diffuse and specular components
+		// 5. compute diffuse and specular components
 		dot := math.Max(.0, hitNormal.Dot(lightDir)) // when dot < .0 then a primitive points away from the light
 		r := hitNormal.Scale(2 * dot).Sub(lightDir)
 
-		diffuseComponent = diffuseComponent.Add(light.DiffuseIntensity.Mul(material.KDiffuse).MulByNum(dot).MulByNum(shadowIntensity))
+		diffuseComponent = diffuseComponent.Add(light.DiffuseIntensity.Mul(diffuseColor).MulByNum(dot).MulByNum(shadowIntensity))
 		specularComponent = specularComponent.Add(light.SpecularIntensity.Mul(material.KSpecular).MulByNum(math.Pow(math.Max(.0, viewDir.Dot(r)), material.Alpha)).MulByNum(shadowIntensity))
 	}
 
-	return s.AmbientIntensity.Mul(material.KAmbient).Add(diffuseComponent).Add(specularComponent).Add(reflectionComponent)
+	// light passing through a transparent surface isn't scattered by it
+	local := s.AmbientIntensity.Mul(material.KAmbient).Add(diffuseComponent).MulByNum(1 - transparency)
+
+	return local.Add(specularComponent).Add(reflectionComponent).Add(refractionComponent).Add(indirectComponent)
+}
+
+// offsetOrigin moves the origin of a secondary ray off the surface to the side
+// the ray leaves to, which avoids self-intersections.
+func offsetOrigin(p, n, dir geometry.Vec3) geometry.Vec3 {
+	if n.Dot(dir) < .0 {
+		return p.Sub(n.Scale(Bias))
+	}
+	return p.Add(n.Scale(Bias))
+}
+
+// refract returns the direction of a ray refracted by Snell's law. The ray
+// enters the surface if it points against the normal and leaves it otherwise.
+// On total internal reflection the reflected direction is returned.
+func refract(v, n geometry.Vec3, ior float64) geometry.Vec3 {
+	if ior == 0 {
+		ior = 1
+	}
+
+	cosI := -v.Dot(n)
+	etaI, etaT := 1., ior
+	if cosI < 0 {
+		// leaving the object
+		cosI = -cosI
+		n = n.Scale(-1)
+		etaI, etaT = etaT, etaI
+	}
+
+	eta := etaI / etaT
+	k := 1 - eta*eta*(1-cosI*cosI)
+	if k < 0 {
+		return reflect(v, n).Normalize()
+	}
+
+	return v.Scale(eta).Add(n.Scale(eta*cosI - math.Sqrt(k))).Normalize()
 }
 
-func createFrameBuffer(w, h int) [][]shading.ImageColor {
-	frameBuffer := make([][]shading.ImageColor, w)
-	for i := range frameBuffer {
-		frameBuffer[i] = make([]shading.ImageColor, h)
+// sampleHemisphere returns a cosine-weighted random direction around n.
+func sampleHemisphere(n geometry.Vec3, rng *rand.Rand) geometry.Vec3 {
+	// orthonormal basis around n
+	var a geometry.Vec3
+	if math.Abs(n.X) > .9 {
+		a = geometry.Vec3{Y: 1}
+	} else {
+		a = geometry.Vec3{X: 1}
+	}
+	u := n.Cross(a).Normalize()
+	v := n.Cross(u)
+
+	r := math.Sqrt(rng.Float64())
+	phi := 2 * math.Pi * rng.Float64()
+
+	return u.Scale(r * math.Cos(phi)).Add(v.Scale(r * math.Sin(phi))).Add(n.Scale(math.Sqrt(1 - r*r))).Normalize()
+}
+
+// shadingNormal returns the normal of the hit perturbed by the bump map of its material.
+func shadingNormal(h *geometry.HitRecord) geometry.Vec3 {
+	bump := h.Material.BumpMap
+	if bump == nil || h.DPDU == (geometry.Vec3{}) || h.DPDV == (geometry.Vec3{}) {
+		return h.Normal
+	}
+
+	// finite differences of the height over one texel
+	du := 1. / float64(bump.Width)
+	dv := 1. / float64(bump.Height)
+	height := bump.Luminance(h.UV.U, h.UV.V)
+	dhdu := (bump.Luminance(h.UV.U+du, h.UV.V) - height) / du * h.Material.BumpScale
+	dhdv := (bump.Luminance(h.UV.U, h.UV.V+dv) - height) / dv * h.Material.BumpScale
+
+	dpdu := h.DPDU.Add(h.Normal.Scale(dhdu))
+	dpdv := h.DPDV.Add(h.Normal.Scale(dhdv))
+	n := dpdu.Cross(dpdv).Normalize()
+	if n.Dot(h.Normal) < 0 {
+		n = n.Scale(-1)
 	}
 
-	return frameBuffer
+	return n
 }
 
 func reflect(V geometry.Vec3, N geometry.Vec3) geometry.Vec3 {
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/danradchuk/raytracer/geometry"
+	"github.com/danradchuk/raytracer/shading"
+)
+
+func TestRefract(t *testing.T) {
+	n := geometry.Vec3{Y: 1}
+
+	// a ray along the normal passes straight through
+	if d := refract(geometry.Vec3{Y: -1}, n, 1.5); d.Sub(geometry.Vec3{Y: -1}).Norm() > 1e-12 {
+		t.Errorf("refract() along the normal = %v", d)
+	}
+
+	// entering glass at 45 degrees bends towards the normal by Snell's law
+	v := geometry.Vec3{X: 1, Y: -1}.Normalize()
+	d := refract(v, n, 1.5)
+	if sin := d.X; math.Abs(sin-math.Sin(math.Pi/4)/1.5) > 1e-12 || d.Y >= 0 {
+		t.Errorf("refract() into glass = %v, want sin %v", d, math.Sin(math.Pi/4)/1.5)
+	}
+
+	// leaving glass at a grazing angle reflects totally
+	v = geometry.Vec3{X: 1, Y: .2}.Normalize()
+	if d := refract(v, n, 1.5); d.Y >= 0 || math.Abs(d.X-v.X) > 1e-12 {
+		t.Errorf("refract() out of glass = %v, want the reflection of %v", d, v)
+	}
+}
+
+func TestBounceLimits(t *testing.T) {
+	// a clear ball in front of a blue sky; its back surface is the second refraction
+	clear := shading.Material{Transparency: 1, IOR: 1}
+	s := &Scene{
+		Background: shading.Color{B: 1},
+		Primitives: []geometry.Primitive{geometry.Sphere{Center: geometry.Vec3{Z: 10}, R: 2, Material: clear}},
+		Settings:   RenderSettings{Terminal: TerminalBlack},
+	}
+	s.BuildBVH()
+	ray := geometry.NewSecondaryRay(geometry.Vec3{}, geometry.Vec3{Z: 1})
+
+	zero, one := 0, 1
+	for _, tc := range []struct {
+		limit *int
+		want  shading.Color
+	}{
+		{nil, s.Background},
+		{&one, shading.Black},
+		{&zero, shading.Black},
+	} {
+		s.Settings.RefractionDepth = tc.limit
+		if got := s.castRay(ray, newRayState(nil, &Stats{})); got != tc.want {
+			t.Errorf("refractiondepth %v: got %v, want %v", tc.limit, got, tc.want)
+		}
+	}
+
+	// without lights a white ball is lit only by indirect diffuse bounces off the sky
+	s.Primitives = []geometry.Primitive{geometry.Sphere{Center: geometry.Vec3{Z: 10}, R: 2, Material: shading.Material{KDiffuse: shading.Color{R: 1, G: 1, B: 1}}}}
+	s.BuildBVH()
+	rng := rand.New(rand.NewPCG(1, 2))
+	if got := s.castRay(ray, newRayState(rng, &Stats{})); got != shading.Black {
+		t.Errorf("no diffuse bounces: got %v, want black", got)
+	}
+	s.Settings.DiffuseDepth = &one
+	if got := s.castRay(ray, newRayState(rng, &Stats{})); got.B <= 0 {
+		t.Errorf("diffusedepth 1: got %v, want the sky", got)
+	}
+}
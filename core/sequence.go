@@ -0,0 +1,63 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RenderSequence renders the frames Settings.FirstFrame to Settings.LastFrame
+// of an animated scene; without a LastFrame the sequence ends at the last
+// keyframe. Every frame goes into a file named after output with the frame
+// number, e.g. frame_0001.png for frame.png, and the extension of output
+// selects ppm or png.
+func (s *Scene) RenderSequence(ctx context.Context, width, height, fov int, output string) error {
+	first, last := s.frameRange()
+
+	ext := filepath.Ext(output)
+	stem := strings.TrimSuffix(output, ext)
+
+	var write func(*Renderer, context.Context, io.Writer) error
+	switch strings.ToLower(ext) {
+	case ".ppm":
+		write = (*Renderer).WritePPM
+	case ".png":
+		write = (*Renderer).WritePNG
+	default:
+		return fmt.Errorf("can't render a sequence of %q images", ext)
+	}
+
+	// sequences usually go into a directory of their own
+	if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+		return err
+	}
+
+	defer s.track(s.Progress, (last-first+1)*width*height)()
+
+	for frame := first; frame <= last; frame++ {
+		at := float64(frame)
+		r := NewRenderer(s, Options{Width: width, Height: height, Fov: fov, Frame: &at})
+
+		name := fmt.Sprintf("%s_%04d%s", stem, frame, ext)
+		if err := WriteFile(name, func(w io.Writer) error { return write(r, ctx, w) }); err != nil {
+			return fmt.Errorf("frame %d: %w", frame, err)
+		}
+	}
+
+	return nil
+}
+
+// frameRange returns the first and the last frame of an animated scene.
+func (s *Scene) frameRange() (first, last int) {
+	settings := s.Settings.WithDefaults()
+	first = *settings.FirstFrame
+	last = max(first, int(math.Ceil(s.LastKeyframe())))
+	if settings.LastFrame != nil {
+		last = *settings.LastFrame
+	}
+	return first, last
+}
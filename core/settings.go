@@ -0,0 +1,157 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Values of RenderSettings.Terminal.
+const (
+	TerminalBackground = "background"
+	TerminalBlack      = "black"
+)
+
+// RenderSettings are the parameters of a shot stored with the scene.
+// Zero values stand for the defaults of DefaultRenderSettings.
+//
+// MaxDepth limits the length of every ray path. ReflectionDepth and
+// RefractionDepth additionally limit the number of bounces of their kind:
+// nil means no limit besides MaxDepth and 0 disables them. DiffuseDepth is
+// the number of indirect diffuse bounces, which are off unless it is set. A
+// ray that hits a limit returns the Terminal color: the background or black.
+// Rays whose contribution to the pixel is at most MinContribution are not
+// traced.
+type RenderSettings struct {
+	Width           int
+	Height          int
+	Fov             int
+	SPP             int // samples per pixel
+	MaxDepth        int
+	ReflectionDepth *int
+	RefractionDepth *int
+	DiffuseDepth    *int
+	Terminal        string
+	MinContribution float64
+	Workers         int     // render goroutines; runtime.NumCPU by default
+	TileOrder       string  // TileOrderHilbert (default), TileOrderSpiral or TileOrderScanline
+	Frames          int     // frames of a GIF turntable
+	OrbitRadius     float64 // distance of the turntable camera from the Y axis
+	FrameDelay      int     // hundredths of a second between GIF frames
+	Palette         string  // PalettePlan9 (default) or PaletteMedianCut
+	Dither          string  // DitherNone (default) or DitherFloydSteinberg
+	FirstFrame      *int    // first frame of an animation; 1 by default
+	LastFrame       *int    // last frame of an animation; the last keyframe by default
+	ShutterOpen     float64 // start of the exposure relative to the frame, in frames
+	ShutterClose    float64 // end of the exposure; motion blur is off unless it is after ShutterOpen
+	Output          string  // file name; its extension selects the image type
+}
+
+// diffuse returns the number of indirect diffuse bounces.
+func (rs RenderSettings) diffuse() int {
+	if rs.DiffuseDepth == nil {
+		return 0
+	}
+	return *rs.DiffuseDepth
+}
+
+// DefaultRenderSettings returns the settings used when neither the scene nor the command line sets them.
+func DefaultRenderSettings() RenderSettings {
+	firstFrame := 1
+	return RenderSettings{
+		Width:       1366,
+		Height:      768,
+		Fov:         90,
+		SPP:         1,
+		MaxDepth:    MaxDepth,
+		Terminal:    TerminalBackground,
+		TileOrder:   TileOrderHilbert,
+		Frames:      360,
+		OrbitRadius: 10,
+		Palette:     PalettePlan9,
+		Dither:      DitherNone,
+		FirstFrame:  &firstFrame,
+		Output:      "image.ppm",
+	}
+}
+
+// WithDefaults returns the settings with zero values replaced by the defaults.
+func (rs RenderSettings) WithDefaults() RenderSettings {
+	def := DefaultRenderSettings()
+	if rs.Width == 0 {
+		rs.Width = def.Width
+	}
+	if rs.Height == 0 {
+		rs.Height = def.Height
+	}
+	if rs.Fov == 0 {
+		rs.Fov = def.Fov
+	}
+	if rs.SPP == 0 {
+		rs.SPP = def.SPP
+	}
+	if rs.MaxDepth == 0 {
+		rs.MaxDepth = def.MaxDepth
+	}
+	if rs.Terminal == "" {
+		rs.Terminal = def.Terminal
+	}
+	if rs.TileOrder == "" {
+		rs.TileOrder = def.TileOrder
+	}
+	if rs.Frames == 0 {
+		rs.Frames = def.Frames
+	}
+	if rs.OrbitRadius == 0 {
+		rs.OrbitRadius = def.OrbitRadius
+	}
+	if rs.Palette == "" {
+		rs.Palette = def.Palette
+	}
+	if rs.Dither == "" {
+		rs.Dither = def.Dither
+	}
+	if rs.FirstFrame == nil {
+		rs.FirstFrame = def.FirstFrame
+	}
+	if rs.Output == "" {
+		rs.Output = def.Output
+	}
+	return rs
+}
+
+// Choices of the settings with a fixed set of values.
+var (
+	Terminals  = []string{TerminalBackground, TerminalBlack}
+	TileOrders = []string{TileOrderHilbert, TileOrderSpiral, TileOrderScanline}
+	Palettes   = []string{PalettePlan9, PaletteMedianCut}
+	Dithers    = []string{DitherNone, DitherFloydSteinberg}
+)
+
+// Validate reports settings with values that aren't among their choices and
+// a last frame before the first one.
+func (rs RenderSettings) Validate() error {
+	for _, c := range []struct {
+		name, value string
+		choices     []string
+	}{
+		{"terminal", rs.Terminal, Terminals},
+		{"tileorder", rs.TileOrder, TileOrders},
+		{"palette", rs.Palette, Palettes},
+		{"dither", rs.Dither, Dithers},
+	} {
+		if c.value != "" && !slices.Contains(c.choices, c.value) {
+			return fmt.Errorf("%s must be one of %s, found %q", c.name, strings.Join(c.choices, ", "), c.value)
+		}
+	}
+	if first := *rs.WithDefaults().FirstFrame; rs.LastFrame != nil && *rs.LastFrame < first {
+		return fmt.Errorf("lastframe %d is before firstframe %d", *rs.LastFrame, first)
+	}
+	return nil
+}
+
+// ImageType returns the image type selected by the extension of Output, e.g. "ppm" or "gif".
+func (rs RenderSettings) ImageType() string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(rs.Output), "."))
+}
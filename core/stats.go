@@ -0,0 +1,178 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danradchuk/raytracer/geometry"
+)
+
+// Stats counts the rays cast by the renders of a scene since its BVH was
+// built and the time spent building the BVH and rendering.
+type Stats struct {
+	PrimaryRays   int64 // camera rays
+	SecondaryRays int64 // reflected, refracted and indirect diffuse rays
+	ShadowRays    int64
+	geometry.TraversalStats
+
+	BuildTime  time.Duration
+	RenderTime time.Duration
+}
+
+// Rays returns the number of rays of every kind.
+func (st Stats) Rays() int64 {
+	return st.PrimaryRays + st.SecondaryRays + st.ShadowRays
+}
+
+// RaysPerSecond returns the number of rays cast per second of render time.
+func (st Stats) RaysPerSecond() float64 {
+	if st.RenderTime <= 0 {
+		return 0
+	}
+	return float64(st.Rays()) / st.RenderTime.Seconds()
+}
+
+// NodeTestsPerRay returns the average number of BVH node boxes a ray tests.
+func (st Stats) NodeTestsPerRay() float64 {
+	return st.perRay(st.NodeTests)
+}
+
+// PrimitiveTestsPerRay returns the average number of primitives a ray tests.
+func (st Stats) PrimitiveTestsPerRay() float64 {
+	return st.perRay(st.PrimitiveTests)
+}
+
+func (st Stats) perRay(n int64) float64 {
+	if st.Rays() == 0 {
+		return 0
+	}
+	return float64(n) / float64(st.Rays())
+}
+
+func (st *Stats) add(other Stats) {
+	st.PrimaryRays += other.PrimaryRays
+	st.SecondaryRays += other.SecondaryRays
+	st.ShadowRays += other.ShadowRays
+	st.TraversalStats.Add(other.TraversalStats)
+	st.RenderTime += other.RenderTime
+}
+
+// MarshalJSON writes the counts, the derived rates and the times in seconds.
+func (st Stats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		PrimaryRays          int64   `json:"primaryrays"`
+		SecondaryRays        int64   `json:"secondaryrays"`
+		ShadowRays           int64   `json:"shadowrays"`
+		RaysPerSecond        float64 `json:"rayspersecond"`
+		NodeTests            int64   `json:"nodetests"`
+		PrimitiveTests       int64   `json:"primitivetests"`
+		NodeTestsPerRay      float64 `json:"nodetestsperray"`
+		PrimitiveTestsPerRay float64 `json:"primitivetestsperray"`
+		BuildTime            float64 `json:"buildtime"`
+		RenderTime           float64 `json:"rendertime"`
+	}{
+		PrimaryRays:          st.PrimaryRays,
+		SecondaryRays:        st.SecondaryRays,
+		ShadowRays:           st.ShadowRays,
+		RaysPerSecond:        st.RaysPerSecond(),
+		NodeTests:            st.NodeTests,
+		PrimitiveTests:       st.PrimitiveTests,
+		NodeTestsPerRay:      st.NodeTestsPerRay(),
+		PrimitiveTestsPerRay: st.PrimitiveTestsPerRay(),
+		BuildTime:            st.BuildTime.Seconds(),
+		RenderTime:           st.RenderTime.Seconds(),
+	})
+}
+
+// String formats the stats as a report of one line per figure.
+func (st Stats) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "primary rays      %d\n", st.PrimaryRays)
+	fmt.Fprintf(&b, "secondary rays    %d\n", st.SecondaryRays)
+	fmt.Fprintf(&b, "shadow rays       %d\n", st.ShadowRays)
+	fmt.Fprintf(&b, "rays per second   %.0f\n", st.RaysPerSecond())
+	fmt.Fprintf(&b, "node tests/ray    %.2f\n", st.NodeTestsPerRay())
+	fmt.Fprintf(&b, "prim tests/ray    %.2f\n", st.PrimitiveTestsPerRay())
+	fmt.Fprintf(&b, "build time        %v\n", st.BuildTime.Round(time.Microsecond))
+	fmt.Fprintf(&b, "render time       %v\n", st.RenderTime.Round(time.Microsecond))
+	return b.String()
+}
+
+// Progress is the state of a running render.
+type Progress struct {
+	Pixels  int // pixels rendered so far over all frames
+	Total   int // pixels of the whole render
+	Elapsed time.Duration
+}
+
+// Fraction returns the rendered fraction of the pixels.
+func (p Progress) Fraction() float64 {
+	if p.Total == 0 {
+		return 1
+	}
+	return float64(p.Pixels) / float64(p.Total)
+}
+
+// ETA estimates the time left from the rate of the pixels rendered so far.
+func (p Progress) ETA() time.Duration {
+	if p.Pixels == 0 {
+		return 0
+	}
+	return time.Duration(float64(p.Elapsed) * float64(p.Total-p.Pixels) / float64(p.Pixels))
+}
+
+// ProgressReporter is notified of the progress of a render after every
+// tile. Calls are serialized, but come from the render goroutines, so a
+// reporter should return quickly.
+type ProgressReporter interface {
+	Progress(p Progress)
+}
+
+// ProgressFunc is a function used as a ProgressReporter.
+type ProgressFunc func(p Progress)
+
+// Progress calls f(p).
+func (f ProgressFunc) Progress(p Progress) {
+	f(p)
+}
+
+// progress tracks the pixels of a render over all of its frames.
+type progress struct {
+	mu       sync.Mutex
+	reporter ProgressReporter
+	start    time.Time
+	pixels   int
+	total    int
+}
+
+// track reports the progress of a render of total pixels to reporter until
+// the returned function is called. Renders that are part of a tracked render
+// aren't tracked again.
+func (s *Scene) track(reporter ProgressReporter, total int) func() {
+	if s.progress != nil {
+		return func() {}
+	}
+	s.progress = &progress{reporter: reporter, start: time.Now(), total: total}
+	return func() { s.progress = nil }
+}
+
+// add counts rendered pixels and notifies the reporter.
+func (p *progress) add(pixels int) {
+	if p.reporter == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pixels += pixels
+	p.reporter.Progress(Progress{Pixels: p.pixels, Total: p.total, Elapsed: time.Since(p.start)})
+}
+
+// Stats returns the stats of the renders since the BVH was built.
+func (s *Scene) Stats() Stats {
+	return s.stats
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danradchuk/raytracer/geometry"
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// TileSize is the edge length in pixels of the tiles the renderer works on.
+const TileSize = 16
+
+// Orders in which the tiles of an image are rendered.
+const (
+	TileOrderHilbert  = "hilbert"
+	TileOrderSpiral   = "spiral"
+	TileOrderScanline = "scanline"
+)
+
+// Tile is a rectangle of pixels from (X0, Y0) up to, but excluding, (X1, Y1).
+type Tile struct {
+	X0, Y0, X1, Y1 int
+}
+
+// Tiles splits an image into tiles of size x size pixels in the given order.
+// An empty order is the Hilbert order.
+func Tiles(width, height, size int, order string) ([]Tile, error) {
+	nx := (width + size - 1) / size
+	ny := (height + size - 1) / size
+
+	tiles := make([]Tile, 0, nx*ny)
+	for ty := 0; ty < ny; ty++ {
+		for tx := 0; tx < nx; tx++ {
+			tiles = append(tiles, Tile{
+				X0: tx * size,
+				Y0: ty * size,
+				X1: min((tx+1)*size, width),
+				Y1: min((ty+1)*size, height),
+			})
+		}
+	}
+
+	var key func(tx, ty int) float64
+	switch order {
+	case "", TileOrderHilbert:
+		// the curve covers the smallest power of two square containing the grid
+		n := 1
+		for n < nx || n < ny {
+			n *= 2
+		}
+		key = func(tx, ty int) float64 { return float64(hilbertIndex(n, tx, ty)) }
+	case TileOrderSpiral:
+		// rings around the center, each walked by angle
+		cx, cy := float64(nx-1)/2, float64(ny-1)/2
+		key = func(tx, ty int) float64 {
+			dx, dy := float64(tx)-cx, float64(ty)-cy
+			ring := math.Ceil(math.Max(math.Abs(dx), math.Abs(dy)))
+			return ring*8 + math.Atan2(dy, dx) + math.Pi
+		}
+	case TileOrderScanline:
+		return tiles, nil
+	default:
+		return nil, fmt.Errorf("unknown tile order %q", order)
+	}
+
+	sort.SliceStable(tiles, func(i, j int) bool {
+		return key(tiles[i].X0/size, tiles[i].Y0/size) < key(tiles[j].X0/size, tiles[j].Y0/size)
+	})
+
+	return tiles, nil
+}
+
+// hilbertIndex returns the distance of the cell (x, y) along the Hilbert curve
+// filling an n x n grid, where n is a power of two.
+func hilbertIndex(n, x, y int) int {
+	d := 0
+	for s := n / 2; s > 0; s /= 2 {
+		var rx, ry int
+		if x&s > 0 {
+			rx = 1
+		}
+		if y&s > 0 {
+			ry = 1
+		}
+		d += s * s * ((3 * rx) ^ ry)
+
+		// rotate the quadrant
+		if ry == 0 {
+			if rx == 1 {
+				x = s - 1 - x
+				y = s - 1 - y
+			}
+			x, y = y, x
+		}
+	}
+	return d
+}
+
+// RenderTiles renders the image seen from eye on a pool of Settings.Workers
+// goroutines (runtime.NumCPU by default). The workers pull tiles in
+// Settings.TileOrder from a shared queue, so a tile full of geometry doesn't
+// hold up the others, and pass every finished tile to done. done may be
+// called concurrently; its pixels are stored row by row and are only valid
+// during the call. Rendering stops early when ctx is canceled.
+//
+// After every tile the progress is reported to Scene.Progress. The rays of
+// the render are added to the stats of the scene.
+func (s *Scene) RenderTiles(ctx context.Context, eye geometry.Vec3, width, height, fov int, done func(t Tile, pixels []shading.Color)) error {
+	tiles, err := Tiles(width, height, TileSize, s.Settings.TileOrder)
+	if err != nil {
+		return err
+	}
+
+	workers := s.Settings.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	defer s.track(s.Progress, width*height)()
+	start := time.Now()
+
+	// every worker counts into stats of its own, which are summed at the end
+	stats := make([]*Stats, min(workers, len(tiles)))
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for i := range stats {
+		stats[i] = new(Stats)
+		wg.Add(1)
+		go func(stats *Stats) {
+			defer wg.Done()
+
+			pixels := make([]shading.Color, TileSize*TileSize)
+			for ctx.Err() == nil {
+				i := int(next.Add(1)) - 1
+				if i >= len(tiles) {
+					return
+				}
+
+				t := tiles[i]
+				w := t.X1 - t.X0
+				for y := t.Y0; y < t.Y1; y++ {
+					for x := t.X0; x < t.X1; x++ {
+						pixels[(y-t.Y0)*w+(x-t.X0)] = s.renderPixel(eye, width, height, x, y, fov, stats)
+					}
+				}
+				done(t, pixels[:w*(t.Y1-t.Y0)])
+				s.progress.add(w * (t.Y1 - t.Y0))
+			}
+		}(stats[i])
+	}
+
+	wg.Wait()
+
+	for _, st := range stats {
+		s.stats.add(*st)
+	}
+	s.stats.RenderTime += time.Since(start)
+
+	return ctx.Err()
+}
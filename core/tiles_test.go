@@ -0,0 +1,90 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/danradchuk/raytracer/geometry"
+	"github.com/danradchuk/raytracer/shading"
+)
+
+func TestTilesCoverImage(t *testing.T) {
+	const width, height = 70, 37
+
+	for _, order := range []string{TileOrderHilbert, TileOrderSpiral, TileOrderScanline} {
+		tiles, err := Tiles(width, height, TileSize, order)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var covered [width][height]int
+		for _, tile := range tiles {
+			for y := tile.Y0; y < tile.Y1; y++ {
+				for x := tile.X0; x < tile.X1; x++ {
+					covered[x][y]++
+				}
+			}
+		}
+		for x := range covered {
+			for y, n := range covered[x] {
+				if n != 1 {
+					t.Fatalf("%s: pixel (%d, %d) is covered %d times", order, x, y, n)
+				}
+			}
+		}
+	}
+
+	if _, err := Tiles(width, height, TileSize, "zigzag"); err == nil {
+		t.Error("expected an error for an unknown tile order")
+	}
+}
+
+func TestRenderTilesCanceled(t *testing.T) {
+	s := &Scene{AccelBVH: geometry.BuildBVH(nil), Settings: RenderSettings{Workers: 2}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := s.RenderTiles(ctx, geometry.Vec3{}, 64, 64, 90, func(Tile, []shading.Color) {
+		t.Error("rendered a tile after cancellation")
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("RenderTiles() = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestRenderTilesProgressAndStats(t *testing.T) {
+	const width, height = 40, 20
+
+	s := &Scene{
+		Camera:     geometry.Vec3{Z: -10},
+		Lights:     []*Light{{Pos: geometry.Vec3{Y: 10}}},
+		Primitives: []geometry.Primitive{geometry.Sphere{R: 5}},
+		Settings:   RenderSettings{Workers: 2, SPP: 4},
+	}
+	s.BuildBVH()
+
+	var reports []Progress
+	s.Progress = ProgressFunc(func(p Progress) { reports = append(reports, p) })
+
+	if err := s.RenderTiles(context.Background(), s.Camera, width, height, 90, func(Tile, []shading.Color) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	// one report per tile, the last one of the whole image
+	if len(reports) != 6 {
+		t.Fatalf("got %d reports, want one per tile", len(reports))
+	}
+	if last := reports[len(reports)-1]; last.Pixels != width*height || last.Total != width*height {
+		t.Errorf("last report %+v, want all %d pixels", last, width*height)
+	}
+
+	st := s.Stats()
+	if st.PrimaryRays != width*height*4 {
+		t.Errorf("%d primary rays, want %d", st.PrimaryRays, width*height*4)
+	}
+	if st.ShadowRays == 0 || st.NodeTests < st.Rays() || st.PrimitiveTests == 0 {
+		t.Errorf("stats %+v, want shadow rays and a node test per ray", st)
+	}
+}
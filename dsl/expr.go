@@ -0,0 +1,401 @@
+package dsl
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/danradchuk/raytracer/geometry"
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// value is the result of an expression: a number or a vector.
+// Colors are vectors of their R, G and B components.
+type value struct {
+	num   float64
+	vec   geometry.Vec3
+	isVec bool
+}
+
+func number(f float64) value {
+	return value{num: f}
+}
+
+func vector(v geometry.Vec3) value {
+	return value{vec: v, isVec: true}
+}
+
+func truth(b bool) value {
+	if b {
+		return number(1)
+	}
+	return number(0)
+}
+
+func (v value) kind() string {
+	if v.isVec {
+		return "vector"
+	}
+	return "number"
+}
+
+// builtin is a math function callable from expressions.
+// Functions with a negative arity take one or more arguments.
+type builtin struct {
+	arity int
+	fn    func(args []float64) float64
+}
+
+func unary(fn func(float64) float64) builtin {
+	return builtin{1, func(a []float64) float64 { return fn(a[0]) }}
+}
+
+var builtins = map[string]builtin{
+	"sin":   unary(math.Sin),
+	"cos":   unary(math.Cos),
+	"tan":   unary(math.Tan),
+	"asin":  unary(math.Asin),
+	"acos":  unary(math.Acos),
+	"atan":  unary(math.Atan),
+	"sqrt":  unary(math.Sqrt),
+	"abs":   unary(math.Abs),
+	"floor": unary(math.Floor),
+	"ceil":  unary(math.Ceil),
+	"radians": unary(func(deg float64) float64 {
+		return deg * math.Pi / 180
+	}),
+	"degrees": unary(func(rad float64) float64 {
+		return rad * 180 / math.Pi
+	}),
+	"pow": {2, func(a []float64) float64 { return math.Pow(a[0], a[1]) }},
+	"min": {-1, func(a []float64) float64 {
+		res := a[0]
+		for _, f := range a[1:] {
+			res = math.Min(res, f)
+		}
+		return res
+	}},
+	"rand": {-1, random},
+	"max": {-1, func(a []float64) float64 {
+		res := a[0]
+		for _, f := range a[1:] {
+			res = math.Max(res, f)
+		}
+		return res
+	}},
+}
+
+// random returns a number in [0, 1) that only depends on its arguments,
+// so scenes generated with rand(seed) are the same on every parse.
+func random(seeds []float64) float64 {
+	var h uint64 = 0x9E3779B97F4A7C15
+	for _, s := range seeds {
+		h = splitMix64(h ^ math.Float64bits(s))
+	}
+	return float64(h>>11) / (1 << 53)
+}
+
+func splitMix64(x uint64) uint64 {
+	x += 0x9E3779B97F4A7C15
+	x = (x ^ (x >> 30)) * 0xBF58476D1CE4E5B9
+	x = (x ^ (x >> 27)) * 0x94D049BB133111EB
+	return x ^ (x >> 31)
+}
+
+// parseValue parses an expression or a vector literal of three
+// comma-separated expressions:
+//
+//	value   = expr [ "," expr "," expr ]
+//	expr    = and { "||" and }
+//	and     = cmp { "&&" cmp }
+//	cmp     = sum [ ("==" | "!=" | "<" | "<=" | ">" | ">=") sum ]
+//	sum     = term { ("+" | "-") term }
+//	term    = unary { ("*" | "/" | "%") unary }
+//	unary   = ("-" | "+" | "!") unary | primary
+//	primary = number | color | name | name "(" args ")" | "(" value ")"
+//
+// Comparisons and logical operators work on numbers and yield 1 (true) or 0 (false).
+func (p *Parser) parseValue() (value, bool) {
+	pos := p.peek().Pos
+
+	x, ok := p.parseExpr()
+	if !ok || p.peek().Kind != Comma {
+		return x, ok
+	}
+
+	components := []value{x}
+	for len(components) < 3 {
+		if _, ok := p.expect(Comma); !ok {
+			return value{}, false
+		}
+		c, ok := p.parseExpr()
+		if !ok {
+			return value{}, false
+		}
+		components = append(components, c)
+	}
+
+	for _, c := range components {
+		if c.isVec {
+			p.errorf(pos, "vector components must be numbers")
+			return value{}, false
+		}
+	}
+
+	return vector(geometry.Vec3{X: components[0].num, Y: components[1].num, Z: components[2].num}), true
+}
+
+func (p *Parser) parseExpr() (value, bool) {
+	x, ok := p.parseAnd()
+	for ok && p.peek().Kind == Or {
+		op := p.next()
+
+		var y value
+		if y, ok = p.parseAnd(); ok {
+			x, ok = p.binary(op, x, y)
+		}
+	}
+	return x, ok
+}
+
+func (p *Parser) parseAnd() (value, bool) {
+	x, ok := p.parseCmp()
+	for ok && p.peek().Kind == And {
+		op := p.next()
+
+		var y value
+		if y, ok = p.parseCmp(); ok {
+			x, ok = p.binary(op, x, y)
+		}
+	}
+	return x, ok
+}
+
+func (p *Parser) parseCmp() (value, bool) {
+	x, ok := p.parseSum()
+	if !ok {
+		return x, false
+	}
+
+	switch p.peek().Kind {
+	case Eq, Neq, Lt, Le, Gt, Ge:
+		op := p.next()
+		y, ok := p.parseSum()
+		if !ok {
+			return y, false
+		}
+		return p.binary(op, x, y)
+	}
+	return x, true
+}
+
+func (p *Parser) parseSum() (value, bool) {
+	x, ok := p.parseTerm()
+	for ok {
+		op := p.peek()
+		if op.Kind != Plus && op.Kind != Minus {
+			break
+		}
+		p.next()
+
+		var y value
+		if y, ok = p.parseTerm(); ok {
+			x, ok = p.binary(op, x, y)
+		}
+	}
+	return x, ok
+}
+
+func (p *Parser) parseTerm() (value, bool) {
+	x, ok := p.parseUnary()
+	for ok {
+		op := p.peek()
+		if op.Kind != Star && op.Kind != Slash && op.Kind != Percent {
+			break
+		}
+		p.next()
+
+		var y value
+		if y, ok = p.parseUnary(); ok {
+			x, ok = p.binary(op, x, y)
+		}
+	}
+	return x, ok
+}
+
+func (p *Parser) parseUnary() (value, bool) {
+	switch op := p.peek(); op.Kind {
+	case Minus:
+		p.next()
+		x, ok := p.parseUnary()
+		if !ok {
+			return x, false
+		}
+		return p.binary(Token{Kind: Star, Pos: op.Pos}, number(-1), x)
+	case Plus:
+		p.next()
+		return p.parseUnary()
+	case Not:
+		p.next()
+		x, ok := p.parseUnary()
+		if !ok {
+			return x, false
+		}
+		return p.binary(op, number(0), x)
+	}
+	return p.parsePrimary()
+}
+
+func (p *Parser) parsePrimary() (value, bool) {
+	tok := p.next()
+	switch tok.Kind {
+	case Number:
+		f, err := strconv.ParseFloat(tok.Text, 64)
+		if err != nil {
+			p.errorf(tok.Pos, "invalid number %q", tok.Text)
+			return value{}, false
+		}
+		return number(f), true
+	case HexColor:
+		c, ok := p.hexColor(tok)
+		return vector(geometry.Vec3{X: c.R, Y: c.G, Z: c.B}), ok
+	case LParen:
+		x, ok := p.parseValue()
+		if !ok {
+			return x, false
+		}
+		_, ok = p.expect(RParen)
+		return x, ok
+	case Ident:
+		if p.peek().Kind == LParen {
+			return p.parseCall(tok)
+		}
+		if v, ok := p.env.vars[tok.Text]; ok {
+			return v, true
+		}
+		p.errorf(tok.Pos, "undefined: %s", tok.Text)
+		return value{}, false
+	}
+
+	p.errorf(tok.Pos, "expected an expression, found %s", tok)
+	return value{}, false
+}
+
+func (p *Parser) parseCall(name Token) (value, bool) {
+	p.next() // consume "("
+
+	var args []float64
+	for p.peek().Kind != RParen {
+		if len(args) > 0 {
+			if _, ok := p.expect(Comma); !ok {
+				return value{}, false
+			}
+		}
+
+		pos := p.peek().Pos
+		x, ok := p.parseExpr()
+		if !ok {
+			return value{}, false
+		}
+		if x.isVec {
+			p.errorf(pos, "%s: arguments must be numbers", name.Text)
+			return value{}, false
+		}
+		args = append(args, x.num)
+	}
+	p.next() // consume ")"
+
+	f, ok := builtins[name.Text]
+	if !ok {
+		p.errorf(name.Pos, "undefined function: %s", name.Text)
+		return value{}, false
+	}
+	if (f.arity >= 0 && len(args) != f.arity) || len(args) == 0 {
+		p.errorf(name.Pos, "%s: wrong number of arguments: %d", name.Text, len(args))
+		return value{}, false
+	}
+
+	return number(f.fn(args)), true
+}
+
+// binary applies an arithmetic operator. Vectors can be added to and subtracted
+// from vectors, and multiplied or divided by numbers.
+func (p *Parser) binary(op Token, x, y value) (value, bool) {
+	switch {
+	case !x.isVec && !y.isVec:
+		switch op.Kind {
+		case Plus:
+			return number(x.num + y.num), true
+		case Minus:
+			return number(x.num - y.num), true
+		case Star:
+			return number(x.num * y.num), true
+		case Slash, Percent:
+			if y.num == 0 {
+				p.errorf(op.Pos, "division by zero")
+				return value{}, false
+			}
+			if op.Kind == Percent {
+				return number(math.Mod(x.num, y.num)), true
+			}
+			return number(x.num / y.num), true
+		case Eq:
+			return truth(x.num == y.num), true
+		case Neq:
+			return truth(x.num != y.num), true
+		case Lt:
+			return truth(x.num < y.num), true
+		case Le:
+			return truth(x.num <= y.num), true
+		case Gt:
+			return truth(x.num > y.num), true
+		case Ge:
+			return truth(x.num >= y.num), true
+		case And:
+			return truth(x.num != 0 && y.num != 0), true
+		case Or:
+			return truth(x.num != 0 || y.num != 0), true
+		case Not:
+			// unary: x is a placeholder
+			return truth(y.num == 0), true
+		}
+	case x.isVec && y.isVec:
+		switch op.Kind {
+		case Plus:
+			return vector(x.vec.Add(y.vec)), true
+		case Minus:
+			return vector(x.vec.Sub(y.vec)), true
+		case Eq:
+			return truth(x.vec == y.vec), true
+		case Neq:
+			return truth(x.vec != y.vec), true
+		}
+	case op.Kind == Star:
+		if x.isVec {
+			return vector(x.vec.Scale(y.num)), true
+		}
+		return vector(y.vec.Scale(x.num)), true
+	case op.Kind == Slash && x.isVec:
+		if y.num == 0 {
+			p.errorf(op.Pos, "division by zero")
+			return value{}, false
+		}
+		return vector(x.vec.Scale(1 / y.num)), true
+	}
+
+	p.errorf(op.Pos, "invalid operation: %s %s %s", x.kind(), op.Text, y.kind())
+	return value{}, false
+}
+
+func (p *Parser) hexColor(tok Token) (shading.Color, bool) {
+	rgb, err := strconv.ParseUint(tok.Text[1:], 16, 32)
+	if err != nil {
+		p.errorf(tok.Pos, "invalid color %q", tok.Text)
+		return shading.Color{}, false
+	}
+
+	return shading.Color{
+		R: float64(rgb>>16&0xFF) / 255.,
+		G: float64(rgb>>8&0xFF) / 255.,
+		B: float64(rgb&0xFF) / 255.,
+	}, true
+}
@@ -0,0 +1,139 @@
+package dsl
+
+import (
+	"bytes"
+	"strings"
+)
+
+// FormatSource rewrites scene source in canonical style while keeping its
+// statements, comments and line structure: lines are indented by four
+// spaces per open block, tokens are separated by single spaces (none
+// before commas and inside parentheses, one after commas) and runs of
+// blank lines are collapsed. Properties of a block written on one line may
+// be set apart by two spaces, as in "box { min 0, 0, 0  max 1, 1, 1 }", and
+// keep them. Only lexical errors are reported.
+func FormatSource(src []byte) ([]byte, error) {
+	var errs ErrorList
+	tokens := lexWithComments("", string(src), &errs)
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	depth := 0
+	lastLine := 0
+	for i := 0; i < len(tokens) && tokens[i].Kind != EOF; {
+		// collect the tokens of one source line
+		line := tokens[i].Pos.Line
+		j := i
+		for j < len(tokens) && tokens[j].Kind != EOF && tokens[j].Pos.Line == line {
+			j++
+		}
+		lineTokens := tokens[i:j]
+
+		indent := depth
+		if lineTokens[0].Kind == RBrace {
+			indent--
+		}
+		for _, tok := range lineTokens {
+			switch tok.Kind {
+			case LBrace:
+				depth++
+			case RBrace:
+				depth--
+			}
+		}
+
+		// keep a single blank line between statements, none at the start or end of a block
+		if lastLine > 0 && line-lastLine > 1 && lineTokens[0].Kind != RBrace && !bytes.HasSuffix(buf.Bytes(), []byte("{\n")) {
+			buf.WriteByte('\n')
+		}
+
+		buf.WriteString(strings.Repeat("    ", max(indent, 0)))
+		open := 0 // blocks opened on this line
+		for k, tok := range lineTokens {
+			if k > 0 && space(lineTokens, k) {
+				buf.WriteByte(' ')
+				if open > 0 && wide(lineTokens, k) {
+					buf.WriteByte(' ')
+				}
+			}
+			buf.WriteString(tokenSource(tok))
+
+			switch tok.Kind {
+			case LBrace:
+				open++
+			case RBrace:
+				open = max(open-1, 0)
+			}
+		}
+		buf.WriteByte('\n')
+
+		lastLine = line
+		i = j
+	}
+
+	return buf.Bytes(), nil
+}
+
+// space reports whether a space separates tokens[k-1] and tokens[k].
+func space(tokens []Token, k int) bool {
+	prev, tok := tokens[k-1], tokens[k]
+
+	switch {
+	case tok.Kind == Comment:
+		return true
+	case tok.Kind == Comma || tok.Kind == RParen || tok.Kind == DotDot:
+		return false
+	case prev.Kind == LParen || prev.Kind == DotDot || prev.Kind == Not:
+		return false
+	case tok.Kind == LParen && prev.Kind == Ident:
+		_, isFunc := builtins[prev.Text]
+		return !isFunc
+	case prev.Kind == Minus || prev.Kind == Plus:
+		return !isUnary(tokens, k-1)
+	}
+
+	return true
+}
+
+// wide reports whether more than one space separates tokens[k-1] and
+// tokens[k] in the source, apart from the padding inside braces and before
+// comments.
+func wide(tokens []Token, k int) bool {
+	prev, tok := tokens[k-1], tokens[k]
+	if prev.Kind == LBrace || tok.Kind == RBrace || tok.Kind == Comment {
+		return false
+	}
+	return tok.Pos.Col > prev.Pos.Col+len(tokenSource(prev))+1
+}
+
+// isUnary reports whether the sign tokens[k] is a unary operator. After an
+// operator it must be; after a value the source spacing decides, e.g. "pos -1"
+// versus "x - 1".
+func isUnary(tokens []Token, k int) bool {
+	if k == 0 {
+		return true
+	}
+
+	switch tokens[k-1].Kind {
+	case Ident, Number, HexColor, RParen, String:
+		if k+1 == len(tokens) {
+			return false
+		}
+		prev, sign, next := tokens[k-1], tokens[k], tokens[k+1]
+		tight := next.Pos.Line == sign.Pos.Line && next.Pos.Col == sign.Pos.Col+1
+		spaced := prev.Pos.Line != sign.Pos.Line || sign.Pos.Col > prev.Pos.Col+len(tokenSource(prev))
+		return tight && spaced
+	}
+
+	return true
+}
+
+// tokenSource returns the source text of a token.
+func tokenSource(tok Token) string {
+	if tok.Kind == String {
+		return quote(tok.Text)
+	}
+	return tok.Text
+}
@@ -0,0 +1,393 @@
+package dsl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TokenKind is the kind of a lexical token of the scene language.
+type TokenKind int
+
+const (
+	EOF TokenKind = iota
+	Ident
+	Number
+	String
+	HexColor
+	Comma
+	LBrace
+	RBrace
+	LParen
+	RParen
+	Assign
+	Plus
+	Minus
+	Star
+	Slash
+	Percent
+	Not
+	Eq
+	Neq
+	Lt
+	Le
+	Gt
+	Ge
+	And
+	Or
+	DotDot
+	Comment
+)
+
+var tokenKinds = [...]string{
+	EOF:      "end of file",
+	Ident:    "identifier",
+	Number:   "number",
+	String:   "string",
+	HexColor: "color",
+	Comma:    "','",
+	LBrace:   "'{'",
+	RBrace:   "'}'",
+	LParen:   "'('",
+	RParen:   "')'",
+	Assign:   "'='",
+	Plus:     "'+'",
+	Minus:    "'-'",
+	Star:     "'*'",
+	Slash:    "'/'",
+	Percent:  "'%'",
+	Not:      "'!'",
+	Eq:       "'=='",
+	Neq:      "'!='",
+	Lt:       "'<'",
+	Le:       "'<='",
+	Gt:       "'>'",
+	Ge:       "'>='",
+	And:      "'&&'",
+	Or:       "'||'",
+	DotDot:   "'..'",
+	Comment:  "comment",
+}
+
+// operators maps two-character tokens to their kinds.
+var operators = map[string]TokenKind{
+	"==": Eq,
+	"!=": Neq,
+	"<=": Le,
+	">=": Ge,
+	"&&": And,
+	"||": Or,
+	"..": DotDot,
+}
+
+// punctuation maps single-character tokens to their kinds.
+var punctuation = map[byte]TokenKind{
+	',': Comma,
+	'{': LBrace,
+	'}': RBrace,
+	'(': LParen,
+	')': RParen,
+	'=': Assign,
+	'+': Plus,
+	'-': Minus,
+	'*': Star,
+	'/': Slash,
+	'%': Percent,
+	'!': Not,
+	'<': Lt,
+	'>': Gt,
+}
+
+func (k TokenKind) String() string {
+	if int(k) < len(tokenKinds) {
+		return tokenKinds[k]
+	}
+	return fmt.Sprintf("token(%d)", int(k))
+}
+
+// Pos is a position in a scene source. Line and Col start at 1.
+type Pos struct {
+	File string
+	Line int
+	Col  int
+}
+
+func (p Pos) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Col)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
+
+// Token is a lexical token. Text is the source text of the token,
+// except for strings where it holds the unquoted value.
+type Token struct {
+	Kind TokenKind
+	Text string
+	Pos  Pos
+}
+
+func (t Token) String() string {
+	switch t.Kind {
+	case Ident, Number, HexColor:
+		return fmt.Sprintf("%s %q", t.Kind, t.Text)
+	case String:
+		return fmt.Sprintf("string %q", t.Text)
+	}
+	return t.Kind.String()
+}
+
+// Error is a diagnostic at a position of a scene source.
+type Error struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+// ErrorList is a list of diagnostics. It is returned by the parser
+// when a scene source has one or more errors.
+type ErrorList []*Error
+
+// add appends a diagnostic unless the same one was reported before,
+// e.g. by an earlier iteration of a loop.
+func (l *ErrorList) add(pos Pos, msg string) {
+	for _, e := range *l {
+		if e.Pos == pos && e.Msg == msg {
+			return
+		}
+	}
+	*l = append(*l, &Error{Pos: pos, Msg: msg})
+}
+
+func (l ErrorList) sort() {
+	sort.SliceStable(l, func(i, j int) bool {
+		a, b := l[i].Pos, l[j].Pos
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Col < b.Col
+	})
+}
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, e := range l {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Err returns nil for an empty list and the list itself otherwise.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// Lex splits a scene source into tokens. Comments start with "#" or "//" and
+// run to the end of the line; "#" directly followed by six hex digits is a color.
+// Lexical errors are appended to errs; the offending characters are skipped.
+func Lex(file, src string, errs *ErrorList) []Token {
+	l := lexer{file: file, src: src, line: 1, col: 1, errs: errs}
+	return l.run()
+}
+
+// lexWithComments is like Lex but keeps comments as Comment tokens.
+func lexWithComments(file, src string, errs *ErrorList) []Token {
+	l := lexer{file: file, src: src, line: 1, col: 1, errs: errs, comments: true}
+	return l.run()
+}
+
+type lexer struct {
+	file      string
+	src       string
+	off       int
+	line, col int
+	errs      *ErrorList
+	comments  bool
+	tokens    []Token
+}
+
+func (l *lexer) run() []Token {
+	for {
+		l.skipSpaceAndComments()
+		if l.off >= len(l.src) {
+			l.emit(Token{Kind: EOF, Pos: l.pos()})
+			return l.tokens
+		}
+
+		pos := l.pos()
+		start := l.off
+		c := l.src[l.off]
+
+		op, isOp := TokenKind(0), false
+		if l.off+1 < len(l.src) {
+			op, isOp = operators[l.src[l.off:l.off+2]]
+		}
+		kind, isPunct := punctuation[c]
+
+		switch {
+		case isOp:
+			l.advance(2)
+			l.emit(Token{Kind: op, Text: l.src[start:l.off], Pos: pos})
+		case isPunct:
+			l.advance(1)
+			l.emit(Token{Kind: kind, Text: l.src[start:l.off], Pos: pos})
+		case c == '#':
+			// skipSpaceAndComments only leaves colors behind
+			l.advance(7)
+			l.emit(Token{Kind: HexColor, Text: l.src[start:l.off], Pos: pos})
+		case c == '"':
+			if s, ok := l.string(); ok {
+				l.emit(Token{Kind: String, Text: s, Pos: pos})
+			}
+		case isDigit(c) || c == '.':
+			l.number()
+			l.emit(Token{Kind: Number, Text: l.src[start:l.off], Pos: pos})
+		case isLetter(c):
+			for l.off < len(l.src) && (isLetter(l.src[l.off]) || isDigit(l.src[l.off])) {
+				l.advance(1)
+			}
+			l.emit(Token{Kind: Ident, Text: l.src[start:l.off], Pos: pos})
+		default:
+			r := []rune(l.src[l.off:])[0]
+			l.errs.add(pos, fmt.Sprintf("unexpected character %q", r))
+			l.advance(len(string(r)))
+		}
+	}
+}
+
+func (l *lexer) emit(tok Token) {
+	l.tokens = append(l.tokens, tok)
+}
+
+func (l *lexer) pos() Pos {
+	return Pos{File: l.file, Line: l.line, Col: l.col}
+}
+
+// advance moves n bytes forward keeping track of lines and columns.
+func (l *lexer) advance(n int) {
+	for i := 0; i < n && l.off < len(l.src); i++ {
+		if l.src[l.off] == '\n' {
+			l.line++
+			l.col = 1
+		} else if l.src[l.off]&0xC0 != 0x80 {
+			// count runes, not continuation bytes
+			l.col++
+		}
+		l.off++
+	}
+}
+
+func (l *lexer) skipSpaceAndComments() {
+	for l.off < len(l.src) {
+		c := l.src[l.off]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			l.advance(1)
+		case (c == '#' && !l.isHexColor()) || (c == '/' && strings.HasPrefix(l.src[l.off:], "//")):
+			pos, start := l.pos(), l.off
+			l.skipLine()
+			if l.comments {
+				l.emit(Token{Kind: Comment, Text: strings.TrimRight(l.src[start:l.off], " \t\r"), Pos: pos})
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) skipLine() {
+	for l.off < len(l.src) && l.src[l.off] != '\n' {
+		l.advance(1)
+	}
+}
+
+// isHexColor reports whether the "#" at the current offset starts a #RRGGBB color.
+func (l *lexer) isHexColor() bool {
+	s := l.src[l.off+1:]
+	if len(s) < 6 {
+		return false
+	}
+	for i := 0; i < 6; i++ {
+		if !isHexDigit(s[i]) {
+			return false
+		}
+	}
+	return len(s) == 6 || !(isLetter(s[6]) || isDigit(s[6]))
+}
+
+// number lexes an unsigned number; signs are unary operators.
+func (l *lexer) number() {
+	l.digits()
+	// the dot of a range (0..10) is not a decimal point
+	if l.off < len(l.src) && l.src[l.off] == '.' && !strings.HasPrefix(l.src[l.off:], "..") {
+		l.advance(1)
+		l.digits()
+	}
+	if l.off < len(l.src) && (l.src[l.off] == 'e' || l.src[l.off] == 'E') {
+		l.advance(1)
+		if l.off < len(l.src) && (l.src[l.off] == '-' || l.src[l.off] == '+') {
+			l.advance(1)
+		}
+		l.digits()
+	}
+}
+
+func (l *lexer) digits() {
+	for l.off < len(l.src) && isDigit(l.src[l.off]) {
+		l.advance(1)
+	}
+}
+
+// string lexes a double-quoted string with \" and \\ escapes.
+func (l *lexer) string() (string, bool) {
+	pos := l.pos()
+	l.advance(1)
+
+	var sb strings.Builder
+	for l.off < len(l.src) {
+		c := l.src[l.off]
+		switch {
+		case c == '"':
+			l.advance(1)
+			return sb.String(), true
+		case c == '\n':
+			l.errs.add(pos, "string literal not terminated")
+			return "", false
+		case c == '\\' && l.off+1 < len(l.src) && (l.src[l.off+1] == '"' || l.src[l.off+1] == '\\'):
+			sb.WriteByte(l.src[l.off+1])
+			l.advance(2)
+		default:
+			sb.WriteByte(c)
+			l.advance(1)
+		}
+	}
+
+	l.errs.add(pos, "string literal not terminated")
+	return "", false
+}
+
+// quote returns s as a string literal that the lexer reads back as s.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func isLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c == '_'
+}
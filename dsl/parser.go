@@ -2,7 +2,10 @@ package dsl
 
 import (
 	"fmt"
-	"strconv"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/danradchuk/raytracer/core"
@@ -10,326 +13,1566 @@ import (
 	"github.com/danradchuk/raytracer/shading"
 )
 
+// Parser constructs a core.Scene from the scene description language.
+// File is the name used in diagnostics and Dir is the directory that relative
+// file paths (e.g. of meshes and included files) are resolved against.
 type Parser struct {
-	Words     []string
-	currToken string
-	peekToken string
-	peekPos   int
+	File    string
+	Dir     string
+	content string
+	tokens  []Token
+	pos     int
+	errors  *ErrorList
+	env     *env
+}
+
+// env is the state shared by a parser and the parsers of the files it includes.
+type env struct {
+	vars      map[string]value
+	materials map[string]shading.Material
+	includes  []string // files being parsed, outermost first
+	loops     int      // loop iterations so far, see maxIterations
+	exhausted bool     // the loops went beyond maxIterations
 }
 
 func NewParser(content string) *Parser {
-	tokens := strings.Fields(content)
+	return &Parser{
+		content: content,
+		errors:  &ErrorList{},
+		env: &env{
+			vars:      map[string]value{"pi": number(math.Pi)},
+			materials: make(map[string]shading.Material),
+		},
+	}
+}
+
+// ParseFile parses the scene file at path. Paths inside the file are
+// resolved relative to the directory of the file.
+func ParseFile(path string) (*core.Scene, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := NewParser(string(content))
+	p.File = path
+	p.Dir = filepath.Dir(path)
+
+	return p.Parse()
+}
+
+// Parse parses the whole source. The parser recovers from syntax errors
+// at the end of the line, so that an ErrorList with all of them is returned.
+func (p *Parser) Parse() (*core.Scene, error) {
+	var scene = &core.Scene{}
+	p.parse(scene)
+
+	// animations in the order the writer emits them: camera, lights, primitives
+	rank := map[string]int{core.AnimateCamera: 0, core.AnimateLight: 1, core.AnimatePrimitive: 2}
+	slices.SortStableFunc(scene.Animations, func(a, b core.Animation) int {
+		if c := rank[a.Target] - rank[b.Target]; c != 0 {
+			return c
+		}
+		return a.Index - b.Index
+	})
 
-	p := &Parser{Words: tokens}
-	p.nextToken()
-	p.nextToken()
+	if len(*p.errors) > 0 {
+		p.errors.sort()
+		return nil, *p.errors
+	}
 
-	return p
+	return scene, nil
 }
 
-func (p *Parser) nextToken() {
-	if p.peekPos >= len(p.Words) {
-		p.currToken = "EOF"
+// parse adds the statements of the source to the scene.
+func (p *Parser) parse(scene *core.Scene) {
+	if p.File != "" {
+		if abs, err := filepath.Abs(p.File); err == nil {
+			p.env.includes = append(p.env.includes, abs)
+			defer func() { p.env.includes = p.env.includes[:len(p.env.includes)-1] }()
+		}
+	}
+
+	p.tokens = Lex(p.File, p.content, p.errors)
+	p.pos = 0
+
+	p.parseStatements(scene, len(p.tokens)-1)
+}
+
+// parseStatements parses statements up to the token with index end.
+func (p *Parser) parseStatements(scene *core.Scene, end int) {
+	for p.pos < end && p.peek().Kind != EOF {
+		p.parseStatement(scene)
+	}
+}
+
+// parseStatement parses one top-level statement. Blocks recover from their
+// own errors; after an error in a simple statement the rest of it is skipped.
+func (p *Parser) parseStatement(scene *core.Scene) {
+	start := p.pos
+	numErrors := len(*p.errors)
+
+	tok := p.next()
+	if tok.Kind != Ident {
+		p.errorf(tok.Pos, "expected a statement, found %s", tok)
+		p.recover(start)
 		return
-	} else {
-		p.currToken = p.peekToken
 	}
-	p.peekToken = p.Words[p.peekPos]
-	p.peekPos++
+
+	switch tok.Text {
+	case "background":
+		if c, ok := p.parseColor(); ok {
+			scene.Background = c
+		}
+	case "ambient":
+		if c, ok := p.parseColor(); ok {
+			scene.AmbientIntensity = c
+		}
+	case "camera":
+		if p.peek().Kind == LBrace {
+			p.parseCamera(scene)
+			return
+		}
+		if eye, ok := p.parseVec(); ok {
+			scene.Camera = eye
+		}
+	case "light":
+		p.parseLight(scene)
+		return
+	case "sphere":
+		p.parseSphere(scene)
+		return
+	case "triangle":
+		p.parseTriangle(scene)
+		return
+	case "plane":
+		p.parsePlane(scene)
+		return
+	case "box":
+		p.parseBox(scene)
+		return
+	case "cylinder":
+		p.parseCylinder(scene)
+		return
+	case "cone":
+		p.parseCone(scene)
+		return
+	case "disk":
+		p.parseDisk(scene)
+		return
+	case "torus":
+		p.parseTorus(scene)
+		return
+	case "union", "intersection", "difference":
+		p.parseCSG(scene, geometry.CSGOp(tok.Text))
+		return
+	case "sdf":
+		p.parseSDF(scene)
+		return
+	case "quadric":
+		p.parseQuadric(scene)
+		return
+	case "ellipsoid", "paraboloid", "hyperboloid":
+		p.parseQuadricInstance(scene, tok.Text)
+		return
+	case "implicit":
+		p.parseImplicit(scene)
+		return
+	case "heightfield":
+		p.parseHeightfield(scene)
+		return
+	case "mesh":
+		p.parseMesh(scene)
+		return
+	case "material":
+		p.parseMaterialDef()
+		return
+	case "render":
+		p.parseRender(scene)
+		return
+	case "let":
+		p.parseLet()
+	case "include":
+		p.parseInclude(scene)
+	case "for":
+		p.parseFor(scene)
+		return
+	case "if":
+		p.parseIf(scene)
+		return
+	default:
+		p.errorf(tok.Pos, "unknown statement %q", tok.Text)
+	}
+
+	if len(*p.errors) > numErrors {
+		p.recover(start)
+	}
 }
 
-func (p *Parser) Parse() (*core.Scene, error) {
-	var scene = &core.Scene{}
-	for p.currToken != "EOF" {
-		switch p.currToken {
-		case "background":
-			tok := p.peekToken
-			if !strings.HasPrefix(tok, "#") && len(tok) != 7 && !isASCII(tok) {
-				return nil, fmt.Errorf("background: invalid color format: %s", tok)
+// parseCamera parses the block form of the camera with its target and animations.
+func (p *Parser) parseCamera(scene *core.Scene) {
+	eye := scene.Camera
+	var target *geometry.Vec3
+	var anims []core.Animation
+	ok := p.parseBlock("camera", func(key Token) bool {
+		switch key.Text {
+		case "pos":
+			eye, _ = p.parseVec()
+		case "target":
+			if v, ok := p.parseVec(); ok {
+				target = &v
 			}
+		case "animate":
+			anims = p.parseAnimate(anims, core.AnimateCamera, nil)
+		default:
+			return false
+		}
+		return true
+	})
+	if ok {
+		scene.Camera = eye
+		scene.Target = target
+		scene.Animations = append(scene.Animations, anims...)
+	}
+}
 
-			red, err := strconv.ParseInt(tok[1:3], 16, 64)
-			if err != nil {
-				return nil, err
-			}
+func (p *Parser) parseLight(scene *core.Scene) {
+	var light = &core.Light{}
+	var anims []core.Animation
+	ok := p.parseBlock("light", func(key Token) bool {
+		switch key.Text {
+		case "pos":
+			light.Pos, _ = p.parseVec()
+		case "diffuse":
+			light.DiffuseIntensity, _ = p.parseColor()
+		case "specular":
+			light.SpecularIntensity, _ = p.parseColor()
+		case "animate":
+			anims = p.parseAnimate(anims, core.AnimateLight, nil)
+		default:
+			return false
+		}
+		return true
+	})
+	if ok {
+		addAnimations(scene, anims, len(scene.Lights))
+		scene.Lights = append(scene.Lights, light)
+	}
+}
 
-			green, err := strconv.ParseInt(tok[3:5], 16, 64)
-			if err != nil {
-				return nil, err
-			}
+// parseAnimate parses the keyframes of a property of the block being parsed:
+//
+//	animate <property> [interpolation] {
+//	    key <frame> <value> [in <handle>] [out <handle>]
+//	}
+//
+// A frame computed by an expression is written in parentheses.
+func (p *Parser) parseAnimate(anims []core.Animation, target string, prim geometry.Primitive) []core.Animation {
+	prop, ok := p.expect(Ident)
+	if !ok {
+		return anims
+	}
+	if !slices.Contains(core.Properties(target, prim), prop.Text) {
+		p.errorf(prop.Pos, "%q can't be animated", prop.Text)
+		return anims
+	}
 
-			blue, err := strconv.ParseInt(tok[5:7], 16, 64)
-			if err != nil {
-				return nil, err
-			}
+	track := core.Track{Interpolation: core.InterpolateLinear}
+	if p.peek().Kind == Ident {
+		if track.Interpolation, ok = p.parseChoice(core.Interpolations); !ok {
+			return anims
+		}
+	}
 
-			color := shading.Color{
-				R: float64(red) / 255.,
-				G: float64(green) / 255.,
-				B: float64(blue) / 255.,
-			}
-			scene.Background = color
-			p.nextToken() // consume hex number
-		case "ambient":
-			c, err := parseColor(p.peekToken)
-			if err != nil {
-				return nil, err
+	// parseKeyValue parses a number or a vector depending on the property
+	parseKeyValue := func() (geometry.Vec3, bool) {
+		switch {
+		case core.IsScalarProperty(prop.Text):
+			f, ok := p.parseNumber()
+			return geometry.Vec3{X: f}, ok
+		case prop.Text == "scale":
+			return p.parseScale()
+		default:
+			return p.parseVec()
+		}
+	}
+
+	ok = p.parseBlock("animate", func(key Token) bool {
+		if key.Text != "key" {
+			return false
+		}
+
+		// the frame is a single operand, so that the value may start with a sign
+		var k core.Keyframe
+		pos := p.peek().Pos
+		frame, ok := p.parseUnary()
+		if ok && frame.isVec {
+			p.errorf(pos, "expected a frame number, found a vector")
+			return true
+		}
+		if !ok {
+			return true
+		}
+		k.Frame = frame.num
+		if n := len(track.Keys); n > 0 && k.Frame <= track.Keys[n-1].Frame {
+			p.errorf(key.Pos, "keys must have increasing frames")
+			return true
+		}
+		if k.Value, ok = parseKeyValue(); !ok {
+			return true
+		}
+		k.In, k.Out = k.Value, k.Value
+
+		for p.peek().Pos.Line == key.Pos.Line && (p.peek().Text == "in" || p.peek().Text == "out") {
+			if p.next().Text == "in" {
+				k.In, ok = parseKeyValue()
+			} else {
+				k.Out, ok = parseKeyValue()
 			}
-			p.nextToken()
-			scene.AmbientIntensity = *c
-		case "light":
-			tok := p.peekToken
-			if tok != "{" {
-				return nil, fmt.Errorf("unexpected character: %s", tok)
+			if !ok {
+				return true
 			}
+		}
+
+		track.Keys = append(track.Keys, k)
+		return true
+	})
+	if !ok {
+		return anims
+	}
+	if len(track.Keys) == 0 {
+		p.errorf(prop.Pos, "%s has no keys", prop.Text)
+		return anims
+	}
+
+	return append(anims, core.Animation{Target: target, Property: prop.Text, Track: track})
+}
+
+// addAnimations adds the animations of a light or primitive at index to the scene.
+func addAnimations(scene *core.Scene, anims []core.Animation, index int) {
+	for _, a := range anims {
+		a.Index = index
+		scene.Animations = append(scene.Animations, a)
+	}
+}
+
+func (p *Parser) parseSphere(scene *core.Scene) {
+	var sphere = geometry.Sphere{}
+	var anims []core.Animation
+	ok := p.parseBlock("sphere", func(key Token) bool {
+		switch key.Text {
+		case "radius":
+			sphere.R, _ = p.parseNumber()
+		case "center":
+			sphere.Center, _ = p.parseVec()
+		case "material":
+			sphere.Material, _ = p.parseMaterial()
+		case "animate":
+			anims = p.parseAnimate(anims, core.AnimatePrimitive, sphere)
+		default:
+			return false
+		}
+		return true
+	})
+	if ok {
+		addAnimations(scene, anims, len(scene.Primitives))
+		scene.Primitives = append(scene.Primitives, sphere)
+	}
+}
+
+func (p *Parser) parseTriangle(scene *core.Scene) {
+	var triangle = &geometry.Triangle{}
+	ok := p.parseBlock("triangle", func(key Token) bool {
+		switch key.Text {
+		case "v0":
+			triangle.V0, _ = p.parseVec()
+		case "v1":
+			triangle.V1, _ = p.parseVec()
+		case "v2":
+			triangle.V2, _ = p.parseVec()
+		case "material":
+			triangle.Material, _ = p.parseMaterial()
+		default:
+			return false
+		}
+		return true
+	})
+	if ok {
+		scene.Primitives = append(scene.Primitives, triangle)
+	}
+}
+
+func (p *Parser) parsePlane(scene *core.Scene) {
+	var plane = geometry.Plane{}
+	ok := p.parseBlock("plane", func(key Token) bool {
+		switch key.Text {
+		case "width":
+			plane.Width, _ = p.parseNumber()
+		case "point":
+			plane.Point, _ = p.parseVec()
+		case "normal":
+			plane.Normal, _ = p.parseVec()
+		case "material":
+			plane.Material, _ = p.parseMaterial()
+		default:
+			return false
+		}
+		return true
+	})
+	if ok {
+		scene.Primitives = append(scene.Primitives, plane)
+	}
+}
+
+func (p *Parser) parseBox(scene *core.Scene) {
+	var box = geometry.Box{}
+	ok := p.parseBlock("box", func(key Token) bool {
+		switch key.Text {
+		case "min":
+			box.Min, _ = p.parseVec()
+		case "max":
+			box.Max, _ = p.parseVec()
+		case "rotate":
+			box.Rotate, _ = p.parseVec()
+		case "material":
+			box.Material, _ = p.parseMaterial()
+		default:
+			return false
+		}
+		return true
+	})
+	if ok {
+		scene.Primitives = append(scene.Primitives, box)
+	}
+}
 
-			p.nextToken()
-
-			var light = &core.Light{}
-			for p.peekToken != "}" {
-				switch p.peekToken {
-				case "pos":
-					p.nextToken()
-					pos, err := parseVec(p.peekToken)
-					if err != nil {
-						return nil, err
-					}
-					light.Pos = *pos
-				case "diffuse":
-					p.nextToken()
-					c, err := parseColor(p.peekToken)
-					if err != nil {
-						return nil, err
-					}
-					light.DiffuseIntensity = *c
-				case "specular":
-					p.nextToken()
-					c, err := parseColor(p.peekToken)
-					if err != nil {
-						return nil, err
-					}
-					light.SpecularIntensity = *c
+// parseCylinder parses a cylinder; the property open, which takes no value,
+// leaves out the disks at the ends.
+func (p *Parser) parseCylinder(scene *core.Scene) {
+	var cylinder = geometry.Cylinder{}
+	ok := p.parseBlock("cylinder", func(key Token) bool {
+		switch key.Text {
+		case "base":
+			cylinder.Base, _ = p.parseVec()
+		case "top":
+			cylinder.Top, _ = p.parseVec()
+		case "radius":
+			cylinder.Radius, _ = p.parseNumber()
+		case "open":
+			cylinder.Open = true
+		case "material":
+			cylinder.Material, _ = p.parseMaterial()
+		default:
+			return false
+		}
+		return true
+	})
+	if ok {
+		scene.Primitives = append(scene.Primitives, cylinder)
+	}
+}
+
+// parseCone parses a cone like a cylinder with the radius at the base and
+// an optional topradius.
+func (p *Parser) parseCone(scene *core.Scene) {
+	var cone = geometry.Cone{}
+	ok := p.parseBlock("cone", func(key Token) bool {
+		switch key.Text {
+		case "base":
+			cone.Base, _ = p.parseVec()
+		case "top":
+			cone.Top, _ = p.parseVec()
+		case "radius":
+			cone.Radius, _ = p.parseNumber()
+		case "topradius":
+			cone.TopRadius, _ = p.parseNumber()
+		case "open":
+			cone.Open = true
+		case "material":
+			cone.Material, _ = p.parseMaterial()
+		default:
+			return false
+		}
+		return true
+	})
+	if ok {
+		scene.Primitives = append(scene.Primitives, cone)
+	}
+}
+
+func (p *Parser) parseDisk(scene *core.Scene) {
+	var disk = geometry.Disk{}
+	ok := p.parseBlock("disk", func(key Token) bool {
+		switch key.Text {
+		case "center":
+			disk.Center, _ = p.parseVec()
+		case "normal":
+			disk.Normal, _ = p.parseVec()
+		case "radius":
+			disk.Radius, _ = p.parseNumber()
+		case "inner":
+			disk.Inner, _ = p.parseNumber()
+		case "material":
+			disk.Material, _ = p.parseMaterial()
+		default:
+			return false
+		}
+		return true
+	})
+	if ok {
+		scene.Primitives = append(scene.Primitives, disk)
+	}
+}
+
+func (p *Parser) parseTorus(scene *core.Scene) {
+	var torus = geometry.Torus{}
+	ok := p.parseBlock("torus", func(key Token) bool {
+		switch key.Text {
+		case "center":
+			torus.Center, _ = p.parseVec()
+		case "axis":
+			torus.Axis, _ = p.parseVec()
+		case "major":
+			torus.Major, _ = p.parseNumber()
+		case "minor":
+			torus.Minor, _ = p.parseNumber()
+		case "material":
+			torus.Material, _ = p.parseMaterial()
+		default:
+			return false
+		}
+		return true
+	})
+	if ok {
+		scene.Primitives = append(scene.Primitives, torus)
+	}
+}
+
+// parseCSG parses a CSG block of two or more solids, which are combined
+// from the first one on: difference { a b c } cuts b and c out of a.
+func (p *Parser) parseCSG(scene *core.Scene, op geometry.CSGOp) {
+	pos := p.tokens[p.pos-1].Pos
+	solids := map[string]func(*core.Scene){
+		"sphere":   p.parseSphere,
+		"box":      p.parseBox,
+		"cylinder": p.parseCylinder,
+		"cone":     p.parseCone,
+		"torus":    p.parseTorus,
+	}
+	for _, name := range geometry.CSGOps {
+		solids[name] = func(s *core.Scene) { p.parseCSG(s, geometry.CSGOp(name)) }
+	}
+
+	parts := &core.Scene{}
+	ok := p.parseBlock(string(op), func(key Token) bool {
+		switch key.Text {
+		case "plane", "triangle", "disk", "mesh":
+			p.errorf(key.Pos, "%s: a %s has no inside", op, key.Text)
+			return true
+		}
+		parse, ok := solids[key.Text]
+		if !ok {
+			return false
+		}
+
+		n := len(parts.Primitives)
+		parse(parts)
+		if len(parts.Primitives) > n {
+			switch part := parts.Primitives[n].(type) {
+			case geometry.Cylinder:
+				if part.Open {
+					p.errorf(key.Pos, "%s: an open cylinder has no inside", op)
+				}
+			case geometry.Cone:
+				if part.Open {
+					p.errorf(key.Pos, "%s: an open cone has no inside", op)
 				}
-				p.nextToken()
 			}
+		}
+		return true
+	})
+	if !ok {
+		return
+	}
+
+	if len(parts.Animations) > 0 {
+		p.errorf(pos, "%s: the parts can't be animated", op)
+		return
+	}
+	if len(parts.Primitives) < 2 {
+		p.errorf(pos, "%s: expected at least two solids, found %d", op, len(parts.Primitives))
+		return
+	}
+
+	solid := parts.Primitives[0].(geometry.Solid)
+	for _, part := range parts.Primitives[1:] {
+		csg, err := geometry.NewCSG(op, solid, part.(geometry.Solid))
+		if err != nil {
+			p.errorf(pos, "%v", err)
+			return
+		}
+		solid = csg
+	}
+	scene.Primitives = append(scene.Primitives, solid)
+}
+
+// parseSDF parses a shape given by a distance function: its bounds and
+// material and a tree of SDF shapes and operators.
+func (p *Parser) parseSDF(scene *core.Scene) {
+	pos := p.tokens[p.pos-1].Pos
+	var prim = &geometry.SDFPrimitive{}
+	var shapes []geometry.SDF
+	ok := p.parseBlock("sdf", func(key Token) bool {
+		switch key.Text {
+		case "min":
+			prim.Min, _ = p.parseVec()
+		case "max":
+			prim.Max, _ = p.parseVec()
+		case "material":
+			prim.Material, _ = p.parseMaterial()
+		default:
+			return p.parseSDFShape(key, &shapes)
+		}
+		return true
+	})
+	if !ok {
+		return
+	}
+
+	if !below(prim.Min, prim.Max) {
+		p.errorf(pos, "sdf: expected the bounds min below max")
+		return
+	}
+	if len(shapes) != 1 {
+		p.errorf(pos, "sdf: expected one shape, found %d", len(shapes))
+		return
+	}
+	prim.Shape = shapes[0]
+	scene.Primitives = append(scene.Primitives, prim)
+}
 
-			if p.peekToken != "}" {
-				return nil, fmt.Errorf("unexpected character: %s", p.peekToken)
+// parseSDFShape parses the SDF shape or operator starting with key and
+// appends it to shapes; it reports false for other keys.
+func (p *Parser) parseSDFShape(key Token, shapes *[]geometry.SDF) bool {
+	var shape geometry.SDF
+	var ok bool
+	switch key.Text {
+	case "sphere":
+		var sphere geometry.SDFSphere
+		ok = p.parseBlock("sphere", func(key Token) bool {
+			switch key.Text {
+			case "center":
+				sphere.Center, _ = p.parseVec()
+			case "radius":
+				sphere.Radius, _ = p.parseNumber()
+			default:
+				return false
 			}
-			p.nextToken()
-			scene.Lights = append(scene.Lights, light)
-		case "camera":
-			eye, err := parseVec(p.peekToken)
-			if err != nil {
-				return nil, err
+			return true
+		})
+		shape = sphere
+	case "box":
+		var box geometry.SDFBox
+		ok = p.parseBlock("box", func(key Token) bool {
+			switch key.Text {
+			case "center":
+				box.Center, _ = p.parseVec()
+			case "size":
+				box.Size, _ = p.parseVec()
+			case "round":
+				box.Round, _ = p.parseNumber()
+			default:
+				return false
 			}
-			scene.Camera = *eye
-			p.nextToken()
-		case "sphere":
-			tok := p.peekToken
-			if tok != "{" {
-				return nil, fmt.Errorf("unexpected character: %s", tok)
+			return true
+		})
+		shape = box
+	case "torus":
+		var torus geometry.SDFTorus
+		ok = p.parseBlock("torus", func(key Token) bool {
+			switch key.Text {
+			case "center":
+				torus.Center, _ = p.parseVec()
+			case "major":
+				torus.Major, _ = p.parseNumber()
+			case "minor":
+				torus.Minor, _ = p.parseNumber()
+			default:
+				return false
 			}
-
-			p.nextToken()
-
-			var sphere = geometry.Sphere{}
-			for p.peekToken != "}" {
-				switch p.peekToken {
-				case "radius":
-					p.nextToken()
-					r, err := strconv.ParseFloat(p.peekToken, 64)
-					if err != nil {
-						return nil, err
-					}
-					sphere.R = r
-				case "center":
-					p.nextToken()
-					center, err := parseVec(p.peekToken)
-					if err != nil {
-						return nil, err
-					}
-					sphere.Center = *center
-				case "material":
-					p.nextToken()
-					m := parseMaterial(p.peekToken)
-					if m != nil {
-						sphere.Material = *m
-					}
-				}
-				p.nextToken()
+			return true
+		})
+		shape = torus
+	case "cylinder":
+		var cylinder geometry.SDFCylinder
+		ok = p.parseBlock("cylinder", func(key Token) bool {
+			switch key.Text {
+			case "center":
+				cylinder.Center, _ = p.parseVec()
+			case "radius":
+				cylinder.Radius, _ = p.parseNumber()
+			case "height":
+				cylinder.Height, _ = p.parseNumber()
+			default:
+				return false
 			}
-
-			if p.peekToken != "}" {
-				return nil, fmt.Errorf("unexpected character: %s", p.peekToken)
+			return true
+		})
+		shape = cylinder
+	case "mandelbulb":
+		var bulb = geometry.SDFMandelbulb{Scale: 1, Power: 8, Iterations: 8}
+		ok = p.parseBlock("mandelbulb", func(key Token) bool {
+			switch key.Text {
+			case "center":
+				bulb.Center, _ = p.parseVec()
+			case "scale":
+				bulb.Scale, _ = p.parseNumber()
+			case "power":
+				bulb.Power, _ = p.parseNumber()
+			case "iterations":
+				bulb.Iterations, _ = p.parseInt()
+			default:
+				return false
 			}
-			p.nextToken()
-			scene.Primitives = append(scene.Primitives, sphere)
-		case "triangle":
-			tok := p.peekToken
-			if tok != "{" {
-				return nil, fmt.Errorf("unexpected character: %s", tok)
+			return true
+		})
+		shape = bulb
+	case "union", "intersection", "difference":
+		var combine = geometry.SDFCombine{Op: geometry.CSGOp(key.Text)}
+		ok = p.parseBlock(key.Text, func(key Token) bool {
+			if key.Text == "smooth" {
+				combine.Smooth, _ = p.parseNumber()
+				return true
 			}
-
-			p.nextToken()
-
-			var triangle = &geometry.Triangle{}
-			for p.peekToken != "}" {
-				switch p.peekToken {
-				case "v0":
-					p.nextToken()
-					coords, err := parseVec(p.peekToken)
-					if err != nil {
-						return nil, err
-					}
-					triangle.V0 = *coords
-				case "v1":
-					p.nextToken()
-					coords, err := parseVec(p.peekToken)
-					if err != nil {
-						return nil, err
-					}
-					triangle.V1 = *coords
-				case "v2":
-					p.nextToken()
-					coords, err := parseVec(p.peekToken)
-					if err != nil {
-						return nil, err
-					}
-					triangle.V2 = *coords
-				case "material":
-					p.nextToken()
-					m := parseMaterial(p.peekToken)
-					if m != nil {
-						triangle.Material = *m
-					}
-				}
-				p.nextToken()
+			return p.parseSDFShape(key, &combine.Parts)
+		})
+		if ok && len(combine.Parts) < 2 {
+			p.errorf(key.Pos, "%s: expected at least two shapes, found %d", key.Text, len(combine.Parts))
+			ok = false
+		}
+		shape = combine
+	case "twist":
+		var twist geometry.SDFTwist
+		twist.Shape, ok = p.parseSDFOperator(key, func(key Token) bool {
+			if key.Text != "angle" {
+				return false
 			}
-
-			if p.peekToken != "}" {
-				return nil, fmt.Errorf("unexpected character: %s", p.peekToken)
+			twist.Angle, _ = p.parseNumber()
+			return true
+		})
+		shape = twist
+	case "translate":
+		var translate geometry.SDFTranslate
+		translate.Shape, ok = p.parseSDFOperator(key, func(key Token) bool {
+			if key.Text != "offset" {
+				return false
 			}
-			p.nextToken()
-			scene.Primitives = append(scene.Primitives, triangle)
-		case "plane":
-			tok := p.peekToken
-			if tok != "{" {
-				return nil, fmt.Errorf("unexpected character: %s", tok)
+			translate.Offset, _ = p.parseVec()
+			return true
+		})
+		shape = translate
+	case "repeat":
+		var repeat geometry.SDFRepeat
+		repeat.Shape, ok = p.parseSDFOperator(key, func(key Token) bool {
+			if key.Text != "period" {
+				return false
 			}
+			repeat.Period, _ = p.parseVec()
+			return true
+		})
+		shape = repeat
+	default:
+		return false
+	}
 
-			p.nextToken()
-
-			var plane = geometry.Plane{}
-			for p.peekToken != "}" {
-				switch p.peekToken {
-				case "width":
-					p.nextToken()
-					w, err := strconv.ParseFloat(p.peekToken, 64)
-					if err != nil {
-						return nil, err
-					}
-					plane.Width = w
-				case "point":
-					p.nextToken()
-					point, err := parseVec(p.peekToken)
-					if err != nil {
-						return nil, err
-					}
-					plane.Point = *point
-				case "normal":
-					p.nextToken()
-					n, err := parseVec(p.peekToken)
-					if err != nil {
-						return nil, err
-					}
-					plane.Normal = *n
-				case "material":
-					p.nextToken()
-					m := parseMaterial(p.peekToken)
-					if m != nil {
-						plane.Material = *m
-					}
-				}
-				p.nextToken()
-			}
+	if ok {
+		*shapes = append(*shapes, shape)
+	}
+	return true
+}
 
-			if p.peekToken != "}" {
-				return nil, fmt.Errorf("unexpected character: %s", p.peekToken)
-			}
-			p.nextToken()
-			scene.Primitives = append(scene.Primitives, plane)
+// parseSDFOperator parses the block of an operator on a single shape and
+// returns the shape; param parses the parameters of the operator.
+func (p *Parser) parseSDFOperator(key Token, param func(key Token) bool) (geometry.SDF, bool) {
+	var shapes []geometry.SDF
+	ok := p.parseBlock(key.Text, func(key Token) bool {
+		return param(key) || p.parseSDFShape(key, &shapes)
+	})
+	if !ok {
+		return nil, false
+	}
+	if len(shapes) != 1 {
+		p.errorf(key.Pos, "%s: expected one shape, found %d", key.Text, len(shapes))
+		return nil, false
+	}
+	return shapes[0], true
+}
+
+// parseQuadric parses a quadric given by its coefficients and bounds.
+func (p *Parser) parseQuadric(scene *core.Scene) {
+	pos := p.tokens[p.pos-1].Pos
+	var quadric = geometry.Quadric{}
+	ok := p.parseBlock("quadric", func(key Token) bool {
+		switch key.Text {
+		case "squares":
+			quadric.Squares, _ = p.parseVec()
+		case "mixed":
+			quadric.Mixed, _ = p.parseVec()
+		case "linear":
+			quadric.Linear, _ = p.parseVec()
+		case "constant":
+			quadric.Constant, _ = p.parseNumber()
+		case "min":
+			quadric.Min, _ = p.parseVec()
+		case "max":
+			quadric.Max, _ = p.parseVec()
+		case "material":
+			quadric.Material, _ = p.parseMaterial()
+		default:
+			return false
 		}
+		return true
+	})
+	if !ok {
+		return
+	}
 
-		p.nextToken()
+	if !below(quadric.Min, quadric.Max) {
+		p.errorf(pos, "quadric: expected the bounds min below max")
+		return
 	}
+	scene.Primitives = append(scene.Primitives, quadric)
+}
 
-	return scene, nil
+// parseQuadricInstance parses an ellipsoid, paraboloid or hyperboloid scaled
+// by its radii, which becomes a quadric.
+func (p *Parser) parseQuadricInstance(scene *core.Scene, name string) {
+	pos := p.tokens[p.pos-1].Pos
+	var center, radii geometry.Vec3
+	var height float64
+	var sheets = 1
+	var material shading.Material
+	ok := p.parseBlock(name, func(key Token) bool {
+		switch {
+		case key.Text == "center":
+			center, _ = p.parseVec()
+		case key.Text == "radii":
+			radii, _ = p.parseVec()
+		case key.Text == "height" && name != "ellipsoid":
+			height, _ = p.parseNumber()
+		case key.Text == "sheets" && name == "hyperboloid":
+			sheets, _ = p.parseInt()
+		case key.Text == "material":
+			material, _ = p.parseMaterial()
+		default:
+			return false
+		}
+		return true
+	})
+	if !ok {
+		return
+	}
+
+	if radii.X == 0 || radii.Y == 0 || radii.Z == 0 {
+		p.errorf(pos, "%s: expected nonzero radii", name)
+		return
+	}
+	if name != "ellipsoid" && height <= 0 {
+		p.errorf(pos, "%s: expected a positive height", name)
+		return
+	}
+	if sheets > 2 {
+		p.errorf(pos, "hyperboloid: expected 1 or 2 sheets, found %d", sheets)
+		return
+	}
+
+	var quadric geometry.Quadric
+	switch name {
+	case "ellipsoid":
+		quadric = geometry.NewEllipsoid(center, radii, material)
+	case "paraboloid":
+		quadric = geometry.NewParaboloid(center, radii, height, material)
+	case "hyperboloid":
+		quadric = geometry.NewHyperboloid(center, radii, height, sheets, material)
+	}
+	scene.Primitives = append(scene.Primitives, quadric)
 }
 
-func parseMaterial(token string) *shading.Material {
-	if token == "red" {
-		return &shading.RedRubber
-	} else if token == "ivory" {
-		return &shading.Ivory
-	} else if token == "glass" {
-		return &shading.Glass
+// parseImplicit parses an algebraic surface: its equation in x, y and z,
+// bounds and material.
+func (p *Parser) parseImplicit(scene *core.Scene) {
+	pos := p.tokens[p.pos-1].Pos
+	var equation string
+	var implicit = &geometry.Implicit{}
+	ok := p.parseBlock("implicit", func(key Token) bool {
+		switch key.Text {
+		case "equation":
+			equation, _ = p.parseString()
+		case "min":
+			implicit.Min, _ = p.parseVec()
+		case "max":
+			implicit.Max, _ = p.parseVec()
+		case "material":
+			implicit.Material, _ = p.parseMaterial()
+		default:
+			return false
+		}
+		return true
+	})
+	if !ok {
+		return
+	}
+
+	if equation == "" {
+		p.errorf(pos, "implicit: missing equation")
+		return
+	}
+	var err error
+	if implicit.Equation, err = geometry.ParsePolynomial(equation); err != nil {
+		p.errorf(pos, "implicit: %v", err)
+		return
 	}
+	if implicit.Equation.Degree() == 0 {
+		p.errorf(pos, "implicit: expected an equation in x, y or z")
+		return
+	}
+	if !below(implicit.Min, implicit.Max) {
+		p.errorf(pos, "implicit: expected the bounds min below max")
+		return
+	}
+	scene.Primitives = append(scene.Primitives, implicit)
+}
 
-	return nil
+// below reports whether the box from lo to hi has a volume.
+func below(lo, hi geometry.Vec3) bool {
+	return lo.X < hi.X && lo.Y < hi.Y && lo.Z < hi.Z
 }
 
-func parseColor(token string) (*shading.Color, error) {
-	vec := strings.Split(token, ",")
-	r, err := strconv.ParseFloat(vec[0], 64)
-	if err != nil {
-		return nil, err
+// parseHeightfield parses a terrain from a height map file.
+func (p *Parser) parseHeightfield(scene *core.Scene) {
+	pos := p.tokens[p.pos-1].Pos
+
+	var file string
+	var origin geometry.Vec3
+	var scale = geometry.Vec3{X: 1, Y: 1, Z: 1}
+	var material shading.Material
+	ok := p.parseBlock("heightfield", func(key Token) bool {
+		switch key.Text {
+		case "file":
+			file, _ = p.parseString()
+		case "origin":
+			origin, _ = p.parseVec()
+		case "scale":
+			scale, _ = p.parseVec()
+		case "material":
+			material, _ = p.parseMaterial()
+		default:
+			return false
+		}
+		return true
+	})
+	if !ok {
+		return
 	}
 
-	g, err := strconv.ParseFloat(vec[1], 64)
+	if file == "" {
+		p.errorf(pos, "heightfield: missing file")
+		return
+	}
+	path := file
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(p.Dir, path)
+	}
+	data, err := geometry.LoadHeightMap(path)
 	if err != nil {
-		return nil, err
+		p.errorf(pos, "heightfield: %v", err)
+		return
 	}
+	scene.Primitives = append(scene.Primitives, geometry.NewHeightfield(file, data, origin, scale, material))
+}
+
+func (p *Parser) parseMesh(scene *core.Scene) {
+	pos := p.tokens[p.pos-1].Pos
 
-	b, err := strconv.ParseFloat(vec[2], 64)
+	var file string
+	var material *shading.Material
+	var transform = geometry.IdentityTransform()
+	var anims []core.Animation
+	ok := p.parseBlock("mesh", func(key Token) bool {
+		switch key.Text {
+		case "file":
+			file, _ = p.parseString()
+		case "material":
+			if m, ok := p.parseMaterial(); ok {
+				material = &m
+			}
+		case "translate":
+			transform.Translate, _ = p.parseVec()
+		case "rotate":
+			transform.Rotate, _ = p.parseVec()
+		case "scale":
+			transform.Scale, _ = p.parseScale()
+		case "animate":
+			anims = p.parseAnimate(anims, core.AnimatePrimitive, (*geometry.Mesh)(nil))
+		default:
+			return false
+		}
+		return true
+	})
+	if !ok {
+		return
+	}
+
+	mesh, err := p.loadMesh(file, transform, material)
 	if err != nil {
-		return nil, err
+		p.errorf(pos, "mesh: %v", err)
+		return
 	}
-	return &shading.Color{
-		R: r,
-		G: g,
-		B: b,
-	}, nil
+	addAnimations(scene, anims, len(scene.Primitives))
+	scene.Primitives = append(scene.Primitives, mesh)
 }
 
-func parseVec(token string) (*geometry.Vec3, error) {
-	vec := strings.Split(token, ",")
-	x, err := strconv.ParseFloat(vec[0], 64)
-	if err != nil {
-		return nil, err
+// parseRender parses the render settings of the scene.
+func (p *Parser) parseRender(scene *core.Scene) {
+	pos := p.tokens[p.pos-1].Pos
+	settings := scene.Settings
+	ok := p.parseBlock("render", func(key Token) bool {
+		switch key.Text {
+		case "width":
+			settings.Width, _ = p.parseInt()
+		case "height":
+			settings.Height, _ = p.parseInt()
+		case "fov":
+			settings.Fov, _ = p.parseInt()
+		case "spp":
+			settings.SPP, _ = p.parseInt()
+		case "maxdepth":
+			settings.MaxDepth, _ = p.parseInt()
+		case "reflectiondepth":
+			if n, ok := p.parseCount(); ok {
+				settings.ReflectionDepth = &n
+			}
+		case "refractiondepth":
+			if n, ok := p.parseCount(); ok {
+				settings.RefractionDepth = &n
+			}
+		case "diffusedepth":
+			if n, ok := p.parseCount(); ok {
+				settings.DiffuseDepth = &n
+			}
+		case "terminal":
+			settings.Terminal, _ = p.parseChoice(core.Terminals)
+		case "mincontribution":
+			settings.MinContribution, _ = p.parseNumber()
+		case "workers":
+			settings.Workers, _ = p.parseInt()
+		case "tileorder":
+			settings.TileOrder, _ = p.parseChoice(core.TileOrders)
+		case "frames":
+			settings.Frames, _ = p.parseInt()
+		case "radius":
+			settings.OrbitRadius, _ = p.parseNumber()
+		case "delay":
+			settings.FrameDelay, _ = p.parseCount()
+		case "palette":
+			settings.Palette, _ = p.parseChoice(core.Palettes)
+		case "dither":
+			settings.Dither, _ = p.parseChoice(core.Dithers)
+		case "firstframe":
+			if n, ok := p.parseCount(); ok {
+				settings.FirstFrame = &n
+			}
+		case "lastframe":
+			if n, ok := p.parseCount(); ok {
+				settings.LastFrame = &n
+			}
+		case "shutteropen":
+			settings.ShutterOpen, _ = p.parseNumber()
+		case "shutterclose":
+			settings.ShutterClose, _ = p.parseNumber()
+		case "output":
+			settings.Output, _ = p.parseString()
+		default:
+			return false
+		}
+		return true
+	})
+	if !ok {
+		return
+	}
+
+	if err := settings.Validate(); err != nil {
+		p.errorf(pos, "render: %v", err)
+		return
+	}
+	scene.Settings = settings
+}
+
+// parseMaterialDef parses a named material: "material name { ... }".
+func (p *Parser) parseMaterialDef() {
+	keyword := p.pos - 1
+	name, ok := p.expect(Ident)
+	if !ok {
+		p.recover(keyword)
+		return
+	}
+
+	var m = shading.Material{Name: name.Text, IOR: 1}
+	ok = p.parseBlock("material", func(key Token) bool {
+		switch key.Text {
+		case "ambient":
+			m.KAmbient, _ = p.parseColor()
+		case "diffuse":
+			m.KDiffuse, _ = p.parseColor()
+		case "specular":
+			m.KSpecular, _ = p.parseColor()
+		case "reflection":
+			m.KReflection, _ = p.parseColor()
+		case "shininess":
+			m.Alpha, _ = p.parseNumber()
+		case "ior":
+			m.IOR, _ = p.parseNumber()
+		case "transparency":
+			m.Transparency, _ = p.parseNumber()
+		default:
+			return false
+		}
+		return true
+	})
+	if ok {
+		p.env.materials[name.Text] = m
+	}
+}
+
+// parseLet parses a variable definition: "let name = value".
+func (p *Parser) parseLet() {
+	name, ok := p.expect(Ident)
+	if !ok {
+		return
+	}
+	if _, ok := p.expect(Assign); !ok {
+		return
+	}
+
+	if v, ok := p.parseValue(); ok {
+		p.env.vars[name.Text] = v
+	}
+}
+
+// parseInclude parses the statements of another file into the scene.
+// The path is relative to the directory of the including file.
+func (p *Parser) parseInclude(scene *core.Scene) {
+	tok, ok := p.expect(String)
+	if !ok {
+		return
 	}
 
-	y, err := strconv.ParseFloat(vec[1], 64)
+	path := tok.Text
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(p.Dir, path)
+	}
+
+	abs, err := filepath.Abs(path)
 	if err != nil {
-		return nil, err
+		p.errorf(tok.Pos, "include: %v", err)
+		return
+	}
+	for i, f := range p.env.includes {
+		if f == abs {
+			cycle := ""
+			for _, f := range p.env.includes[i:] {
+				cycle += filepath.Base(f) + " -> "
+			}
+			p.errorf(tok.Pos, "include cycle: %s%s", cycle, filepath.Base(abs))
+			return
+		}
 	}
 
-	z, err := strconv.ParseFloat(vec[2], 64)
+	content, err := os.ReadFile(path)
 	if err != nil {
-		return nil, err
+		p.errorf(tok.Pos, "include: %v", err)
+		return
+	}
+
+	included := &Parser{
+		File:    path,
+		Dir:     filepath.Dir(path),
+		content: string(content),
+		errors:  p.errors,
+		env:     p.env,
+	}
+	included.parse(scene)
+}
+
+// maxIterations limits the number of loop iterations of a parse, of nested
+// loops and included files together, so that a scene can't run forever.
+const maxIterations = 1000000
+
+// parseFor parses "for name in start..end { statements }" and runs the
+// statements for every integer from start up to, but excluding, end.
+// The loop variable is restored when the loop is done.
+func (p *Parser) parseFor(scene *core.Scene) {
+	keyword := p.pos - 1
+
+	name, ok := p.expect(Ident)
+	if ok {
+		if in := p.next(); in.Kind != Ident || in.Text != "in" {
+			p.errorf(in.Pos, "expected in, found %s", in)
+			ok = false
+		}
+	}
+	var start, end float64
+	if ok {
+		start, ok = p.parseNumber()
+	}
+	if ok {
+		_, ok = p.expect(DotDot)
+	}
+	if ok {
+		end, ok = p.parseNumber()
+	}
+	if ok {
+		// the iterations are counted before they run; once the limit is
+		// reached, the remaining loops are skipped without more errors
+		n := max(math.Ceil(end-start), 0)
+		switch {
+		case p.env.exhausted:
+			ok = false
+		case float64(p.env.loops)+n > maxIterations:
+			p.errorf(name.Pos, "for: more than %d loop iterations", maxIterations)
+			p.env.exhausted = true
+			ok = false
+		default:
+			p.env.loops += int(n)
+		}
+	}
+
+	bodyStart, bodyEnd := p.skipBody(keyword, ok)
+	if !ok || bodyEnd < 0 {
+		return
+	}
+
+	prev, defined := p.env.vars[name.Text]
+	for i := start; i < end && !p.env.exhausted; i++ {
+		p.env.vars[name.Text] = number(i)
+		p.runBody(scene, bodyStart, bodyEnd)
+	}
+	p.pos = bodyEnd + 1
+
+	if defined {
+		p.env.vars[name.Text] = prev
+	} else {
+		delete(p.env.vars, name.Text)
+	}
+}
+
+// parseIf parses "if cond { statements } [else if ... | else { statements }]".
+func (p *Parser) parseIf(scene *core.Scene) {
+	p.parseBranch(scene, false)
+}
+
+// parseBranch parses a condition with its body and the else branches that
+// follow it. done reports whether an earlier branch of the chain was taken,
+// in which case the condition is not evaluated and nothing is executed.
+func (p *Parser) parseBranch(scene *core.Scene, done bool) {
+	keyword := p.pos - 1
+
+	ok, taken := true, false
+	if done {
+		p.skipExpr()
+	} else {
+		var cond float64
+		cond, ok = p.parseNumber()
+		taken = ok && cond != 0
+	}
+
+	start, end := p.skipBody(keyword, ok)
+	if end < 0 {
+		return
+	}
+	if taken {
+		p.runBody(scene, start, end)
+	}
+
+	if tok := p.peek(); tok.Kind != Ident || tok.Text != "else" {
+		return
+	}
+	p.next()
+
+	if tok := p.peek(); tok.Kind == Ident && tok.Text == "if" {
+		p.next()
+		p.parseBranch(scene, done || taken)
+		return
+	}
+
+	start, end = p.skipBody(p.pos-1, true)
+	if end >= 0 && !done && !taken {
+		p.runBody(scene, start, end)
 	}
-	return &geometry.Vec3{
-		X: x,
-		Y: y,
-		Z: z,
-	}, nil
 }
 
-func isASCII(s string) bool {
-	for _, c := range s {
-		if c > 127 {
+// runBody parses the statements between the tokens start and end and
+// continues after the closing brace at end.
+func (p *Parser) runBody(scene *core.Scene, start, end int) {
+	p.pos = start
+	p.parseStatements(scene, end)
+	p.pos = end + 1
+}
+
+// skipBody expects a "{" and moves past the matching "}". It returns the
+// index of the first statement of the body and the index of its "}", or -1
+// if there is no body. When the header before the body had errors
+// (ok is false), the statement starting at keyword is skipped.
+func (p *Parser) skipBody(keyword int, ok bool) (int, int) {
+	if !ok {
+		p.recover(keyword)
+		return 0, -1
+	}
+
+	if _, ok := p.expect(LBrace); !ok {
+		p.recover(keyword)
+		return 0, -1
+	}
+
+	start := p.pos
+	depth := 1
+	for {
+		tok := p.next()
+		switch tok.Kind {
+		case LBrace:
+			depth++
+		case RBrace:
+			depth--
+			if depth == 0 {
+				return start, p.pos - 1
+			}
+		case EOF:
+			p.errorf(tok.Pos, "expected '}', found %s", tok)
+			return 0, -1
+		}
+	}
+}
+
+// skipExpr moves past the condition of a branch that is not taken.
+func (p *Parser) skipExpr() {
+	for p.peek().Kind != LBrace && p.peek().Kind != EOF {
+		p.next()
+	}
+}
+
+// parseBlock parses "{ key value ... }" calling field for every key. field
+// consumes the value and reports whether the key is known. After an error
+// the rest of the line of the key is skipped. parseBlock reports whether
+// the block was free of errors.
+func (p *Parser) parseBlock(name string, field func(key Token) bool) bool {
+	numErrors := len(*p.errors)
+	keyword := p.pos - 1
+
+	if _, ok := p.expect(LBrace); !ok {
+		p.recover(keyword)
+		return false
+	}
+
+	for {
+		tok := p.peek()
+		switch tok.Kind {
+		case RBrace:
+			p.next()
+			return len(*p.errors) == numErrors
+		case EOF:
+			p.errorf(tok.Pos, "%s: expected '}', found %s", name, tok)
 			return false
 		}
+
+		start := p.pos
+		numFieldErrors := len(*p.errors)
+		p.next()
+
+		if tok.Kind != Ident {
+			p.errorf(tok.Pos, "%s: expected a property, found %s", name, tok)
+		} else if !field(tok) {
+			p.errorf(tok.Pos, "%s: unknown property %q", name, tok.Text)
+		}
+
+		if len(*p.errors) > numFieldErrors {
+			p.recover(start)
+		}
 	}
-	return true
+}
+
+// recover skips the statement starting at token start after an error:
+// the rest of its line and, if the line opens a block, the whole block.
+func (p *Parser) recover(start int) {
+	line := p.tokens[start].Pos.Line
+	p.pos = start + 1
+	p.skipLine(line)
+
+	if p.pos > 0 && p.tokens[p.pos-1].Kind == LBrace {
+		depth := 1
+		for depth > 0 && p.peek().Kind != EOF {
+			switch p.next().Kind {
+			case LBrace:
+				depth++
+			case RBrace:
+				depth--
+			}
+		}
+	}
+}
+
+// skipLine skips the tokens on the given line up to a closing brace.
+func (p *Parser) skipLine(line int) {
+	for {
+		tok := p.peek()
+		if tok.Kind == EOF || tok.Kind == RBrace || tok.Pos.Line != line {
+			return
+		}
+		p.next()
+		if tok.Kind == LBrace {
+			return
+		}
+	}
+}
+
+func (p *Parser) peek() Token {
+	return p.tokens[p.pos]
+}
+
+func (p *Parser) next() Token {
+	tok := p.tokens[p.pos]
+	if tok.Kind != EOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *Parser) expect(kind TokenKind) (Token, bool) {
+	tok := p.next()
+	if tok.Kind != kind {
+		p.errorf(tok.Pos, "expected %s, found %s", kind, tok)
+		return tok, false
+	}
+	return tok, true
+}
+
+func (p *Parser) errorf(pos Pos, format string, args ...any) {
+	p.errors.add(pos, fmt.Sprintf(format, args...))
+}
+
+func (p *Parser) parseNumber() (float64, bool) {
+	pos := p.peek().Pos
+	x, ok := p.parseValue()
+	if ok && x.isVec {
+		p.errorf(pos, "expected a number, found a vector")
+		return 0, false
+	}
+	return x.num, ok
+}
+
+// parseInt parses a positive whole number.
+func (p *Parser) parseInt() (int, bool) {
+	return p.parseWhole(1, "a positive")
+}
+
+// parseCount parses a non-negative whole number, for settings where 0 means
+// none or the first.
+func (p *Parser) parseCount() (int, bool) {
+	return p.parseWhole(0, "a non-negative")
+}
+
+// parseWhole parses a whole number of at least least.
+func (p *Parser) parseWhole(least float64, what string) (int, bool) {
+	pos := p.peek().Pos
+	f, ok := p.parseNumber()
+	if ok && (f != math.Trunc(f) || f < least || f > math.MaxInt32) {
+		p.errorf(pos, "expected %s integer, found %v", what, f)
+		return 0, false
+	}
+	return int(f), ok
+}
+
+func (p *Parser) parseVec() (geometry.Vec3, bool) {
+	pos := p.peek().Pos
+	x, ok := p.parseValue()
+	if ok && !x.isVec {
+		p.errorf(pos, "expected a vector, found a number")
+		return geometry.Vec3{}, false
+	}
+	return x.vec, ok
+}
+
+// parseScale parses either a uniform scale factor or a vector of per-axis factors.
+func (p *Parser) parseScale() (geometry.Vec3, bool) {
+	x, ok := p.parseValue()
+	if ok && !x.isVec {
+		return geometry.Vec3{X: x.num, Y: x.num, Z: x.num}, true
+	}
+	return x.vec, ok
+}
+
+// parseColor parses a color: a #RRGGBB literal or a vector of its components.
+func (p *Parser) parseColor() (shading.Color, bool) {
+	v, ok := p.parseVec()
+	return shading.Color{R: v.X, G: v.Y, B: v.Z}, ok
+}
+
+func (p *Parser) parseString() (string, bool) {
+	tok, ok := p.expect(String)
+	return tok.Text, ok
+}
+
+// parseChoice parses an identifier that must be one of choices.
+func (p *Parser) parseChoice(choices []string) (string, bool) {
+	tok, ok := p.expect(Ident)
+	if !ok {
+		return "", false
+	}
+	if !slices.Contains(choices, tok.Text) {
+		p.errorf(tok.Pos, "expected one of %s, found %q", strings.Join(choices, ", "), tok.Text)
+		return "", false
+	}
+	return tok.Text, true
+}
+
+func (p *Parser) parseMaterial() (shading.Material, bool) {
+	tok, ok := p.expect(Ident)
+	if !ok {
+		return shading.Material{}, false
+	}
+
+	m := p.materialByName(tok.Text)
+	if m == nil {
+		p.errorf(tok.Pos, "unknown material %q", tok.Text)
+		return shading.Material{}, false
+	}
+
+	return *m, true
+}
+
+// loadMesh loads a mesh file relative to the directory of the scene.
+func (p *Parser) loadMesh(file string, transform geometry.Transform, material *shading.Material) (*geometry.Mesh, error) {
+	if file == "" {
+		return nil, fmt.Errorf("missing file")
+	}
+
+	path := file
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(p.Dir, path)
+	}
+
+	data, err := geometry.LoadMesh(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return geometry.NewMesh(file, data, transform, material), nil
+}
+
+// materialByName looks up a material defined in the scene or a built-in one.
+func (p *Parser) materialByName(name string) *shading.Material {
+	if m, ok := p.env.materials[name]; ok {
+		return &m
+	}
+
+	return shading.Builtin(name)
 }
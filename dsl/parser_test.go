@@ -1,9 +1,17 @@
 package dsl
 
 import (
+	"bufio"
+	"io"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/danradchuk/raytracer/core"
+	"github.com/danradchuk/raytracer/geometry"
+	"github.com/danradchuk/raytracer/shading"
 )
 
 func TestNewParser(t *testing.T) {
@@ -12,11 +20,915 @@ func TestNewParser(t *testing.T) {
 		t.Fatal(err)
 	}
 	p := NewParser(string(str))
-	if got, err := p.Parse(); err != nil {
-		t.Errorf("NewParser() = %v, want %v", got, str)
-	} else {
-		if !reflect.DeepEqual(got, str) {
-			t.Errorf("NewParser() = %v, want %v", got, str)
+	got, err := p.Parse()
+	if err != nil {
+		t.Fatalf("NewParser(): %v", err)
+	}
+
+	// a written scene parses back to the same scene
+	out, err := Format(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := NewParser(string(out)).Parse()
+	if err != nil {
+		t.Fatalf("parsing the written scene: %v\n%s", err, out)
+	}
+	if !reflect.DeepEqual(got, again) {
+		t.Errorf("NewParser() = %v, want %v", again, got)
+	}
+
+	// and writing it again gives the same source
+	out2, err := Format(again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != string(out2) {
+		t.Errorf("Format() is not stable:\n%s\nvs\n%s", out, out2)
+	}
+}
+
+func TestWriteMaterials(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tri.obj"), []byte("v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	src := "background 0.3, 0.2, 0.1\n" +
+		"render { width 640  height 480  spp 4  reflectiondepth 2  terminal black  mincontribution 0.01  tileorder spiral  output \"shot.gif\" }\n" +
+		"material red {\n" +
+		"    diffuse 1, 0, 0\n" +
+		"}\n" +
+		"material gold {\n" +
+		"    diffuse #BF9938\n" +
+		"    shininess 51.2\n" +
+		"}\n" +
+		"sphere { radius 1  center 0, 0, 0  material red }\n" +
+		"sphere { radius 2  center 1, 0, 0  material gold }\n" +
+		"sphere { radius 3  center 2, 0, 0 }\n" +
+		"mesh { file \"tri.obj\"  material gold  translate 1, 2, 3  scale 1, 2, 1 }\n"
+
+	p := NewParser(src)
+	p.Dir = dir
+	got, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := Format(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p = NewParser(string(out))
+	p.Dir = dir
+	again, err := p.Parse()
+	if err != nil {
+		t.Fatalf("parsing the written scene: %v\n%s", err, out)
+	}
+	if !reflect.DeepEqual(got, again) {
+		t.Errorf("written scene differs:\n%s", out)
+	}
+
+	depth := 2
+	want := core.RenderSettings{Width: 640, Height: 480, SPP: 4, ReflectionDepth: &depth, Terminal: core.TerminalBlack, MinContribution: 0.01, TileOrder: core.TileOrderSpiral, Output: "shot.gif"}
+	if !reflect.DeepEqual(again.Settings, want) {
+		t.Errorf("render settings: got %+v, want %+v", again.Settings, want)
+	}
+}
+
+func TestParseRenderZero(t *testing.T) {
+	src := "render {\n" +
+		"    reflectiondepth 0\n" +
+		"    refractiondepth 0\n" +
+		"    diffusedepth 0\n" +
+		"    delay 0\n" +
+		"    firstframe 0\n" +
+		"    lastframe 0\n" +
+		"}\n"
+
+	got, err := NewParser(src).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zero := 0
+	want := core.RenderSettings{ReflectionDepth: &zero, RefractionDepth: &zero, DiffuseDepth: &zero, FirstFrame: &zero, LastFrame: &zero}
+	if !reflect.DeepEqual(got.Settings, want) {
+		t.Errorf("render settings: got %+v, want %+v", got.Settings, want)
+	}
+
+	// zero is set, unlike a missing setting, so it is written back
+	out, err := Format(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := NewParser(string(out)).Parse()
+	if err != nil {
+		t.Fatalf("parsing the written scene: %v\n%s", err, out)
+	}
+	if !reflect.DeepEqual(again.Settings, want) {
+		t.Errorf("written settings: got %+v, want %+v\n%s", again.Settings, want, out)
+	}
+
+	for _, key := range []string{"width", "height", "spp", "maxdepth", "frames"} {
+		_, err := NewParser("render { " + key + " 0 }").Parse()
+		if err == nil || !strings.Contains(err.Error(), "expected a positive integer, found 0") {
+			t.Errorf("%s 0: got %v, want a positive integer error", key, err)
+		}
+	}
+	_, err = NewParser("render { firstframe -1 }").Parse()
+	if err == nil || !strings.Contains(err.Error(), "expected a non-negative integer, found -1") {
+		t.Errorf("firstframe -1: got %v, want a non-negative integer error", err)
+	}
+	for src, want := range map[string]string{
+		"render { firstframe 10  lastframe 5 }": "1:1: render: lastframe 5 is before firstframe 10",
+		"render { lastframe 0 }":                "1:1: render: lastframe 0 is before firstframe 1",
+	} {
+		if _, err := NewParser(src).Parse(); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", src, err, want)
+		}
+	}
+}
+
+// unknownPrimitive is a primitive the writer doesn't know.
+type unknownPrimitive struct{ geometry.Sphere }
+
+func TestWriteUnknownPrimitive(t *testing.T) {
+	sw := &sceneWriter{w: bufio.NewWriter(io.Discard)}
+	sw.writePrimitive(unknownPrimitive{}, nil)
+	if sw.err == nil || !strings.Contains(sw.err.Error(), "cannot write primitive of type dsl.unknownPrimitive") {
+		t.Errorf("got %v, want an error for the unknown primitive", sw.err)
+	}
+
+	s := &core.Scene{Primitives: []geometry.Primitive{unknownPrimitive{}}}
+	if _, err := Format(s); err == nil {
+		t.Error("Format() of an unknown primitive succeeded")
+	}
+}
+
+func TestFormatSource(t *testing.T) {
+	src := "# rig\n" +
+		"let r=2*(1+1)\n\n\n" +
+		"for i in 0 .. r{\n" +
+		"sphere {   radius r/2 // half\n" +
+		"center i*3,-r , sin( i )\n" +
+		"\n" +
+		"}\n" +
+		"box {  min 0,0,0    max 1, 1, 1 }\n" +
+		"}\n"
+	want := "# rig\n" +
+		"let r = 2 * (1 + 1)\n\n" +
+		"for i in 0..r {\n" +
+		"    sphere { radius r / 2 // half\n" +
+		"        center i * 3, -r, sin(i)\n" +
+		"    }\n" +
+		"    box { min 0, 0, 0  max 1, 1, 1 }\n" +
+		"}\n"
+
+	got, err := FormatSource([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("FormatSource() =\n%s\nwant\n%s", got, want)
+	}
+
+	again, err := FormatSource(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != want {
+		t.Errorf("FormatSource() is not idempotent:\n%s", again)
+	}
+}
+
+func TestScenesFormatted(t *testing.T) {
+	files, err := filepath.Glob("../scenes/*.scene")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no scenes found")
+	}
+
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := FormatSource(src)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		if string(got) != string(src) {
+			t.Errorf("%s is not formatted; run raytracer fmt -w", file)
+		}
+	}
+}
+
+func TestParseMesh(t *testing.T) {
+	dir := t.TempDir()
+	obj := "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n"
+	if err := os.MkdirAll(filepath.Join(dir, "models"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "models", "tri.obj"), []byte(obj), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	scene := "mesh {\n" +
+		"    file \"models/tri.obj\"\n" +
+		"    material ivory\n" +
+		"    translate 10,0,0\n" +
+		"    rotate 0,0,90\n" +
+		"    scale 2\n" +
+		"}\n"
+	scenePath := filepath.Join(dir, "mesh.scene")
+	if err := os.WriteFile(scenePath, []byte(scene), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := ParseFile(scenePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Primitives) != 1 {
+		t.Fatalf("expected 1 primitive, got %d", len(s.Primitives))
+	}
+
+	mesh, ok := s.Primitives[0].(*geometry.Mesh)
+	if !ok {
+		t.Fatalf("expected a mesh, got %T", s.Primitives[0])
+	}
+	if len(mesh.Triangles) != 1 {
+		t.Fatalf("expected 1 triangle, got %d", len(mesh.Triangles))
+	}
+
+	tr := mesh.Triangles[0]
+	if tr.Material.Name != "ivory" {
+		t.Errorf("expected ivory, got %q", tr.Material.Name)
+	}
+
+	// (1,0,0) is scaled to (2,0,0), rotated to (0,2,0) and moved to (10,2,0)
+	want := geometry.Vec3{X: 10, Y: 2, Z: 0}
+	if tr.V1.Sub(want).Norm() > 1e-9 {
+		t.Errorf("V1: got %v, want %v", tr.V1, want)
+	}
+}
+
+func TestParseShapes(t *testing.T) {
+	src := "box { min -1, 0, -1  max 1, 2, 1  rotate 0, 45, 0  material red }\n" +
+		"cylinder {\n" +
+		"    base 0, 0, 0\n" +
+		"    top 0, 3, 0\n" +
+		"    radius 1\n" +
+		"    open\n" +
+		"}\n" +
+		"cone { base 0, 0, 0  top 0, 3, 0  radius 1  topradius 0.5 }\n" +
+		"disk { center 0, 1, 0  normal 0, 0, -1  radius 2  inner 1 }\n" +
+		"torus { center 0, 1, 0  axis 0, 1, 0  major 2  minor 0.5  material ivory }\n"
+
+	s, err := NewParser(src).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []geometry.Primitive{
+		geometry.Box{Min: geometry.Vec3{X: -1, Z: -1}, Max: geometry.Vec3{X: 1, Y: 2, Z: 1}, Rotate: geometry.Vec3{Y: 45}, Material: shading.RedRubber},
+		geometry.Cylinder{Top: geometry.Vec3{Y: 3}, Radius: 1, Open: true},
+		geometry.Cone{Top: geometry.Vec3{Y: 3}, Radius: 1, TopRadius: .5},
+		geometry.Disk{Center: geometry.Vec3{Y: 1}, Normal: geometry.Vec3{Z: -1}, Radius: 2, Inner: 1},
+		geometry.Torus{Center: geometry.Vec3{Y: 1}, Axis: geometry.Vec3{Y: 1}, Major: 2, Minor: .5, Material: shading.Ivory},
+	}
+	if !reflect.DeepEqual(s.Primitives, want) {
+		t.Errorf("got %+v, want %+v", s.Primitives, want)
+	}
+
+	// the writer keeps the flags and leaves out the defaults
+	out, err := Format(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"    open\n", "    topradius 0.5\n", "    inner 1\n", "    rotate 0, 45, 0\n"} {
+		if strings.Count(string(out), line) != 1 {
+			t.Errorf("expected %q once in\n%s", line, out)
+		}
+	}
+	again, err := NewParser(string(out)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.Primitives, want) {
+		t.Errorf("the written scene parses to %+v", again.Primitives)
+	}
+}
+
+func TestParseCSG(t *testing.T) {
+	src := "difference {\n" +
+		"    box { min -1, -1, -1  max 1, 1, 1  material red }\n" +
+		"    union {\n" +
+		"        cylinder { base 0, -2, 0  top 0, 2, 0  radius 0.5 }\n" +
+		"        cylinder { base -2, 0, 0  top 2, 0, 0  radius 0.5 }\n" +
+		"    }\n" +
+		"    sphere { center 0, 1, 0  radius 0.5 }\n" +
+		"}\n"
+
+	s, err := NewParser(src).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	must := func(c *geometry.CSG, err error) *geometry.CSG {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	cross := must(geometry.NewCSG(geometry.CSGUnion,
+		geometry.Cylinder{Base: geometry.Vec3{Y: -2}, Top: geometry.Vec3{Y: 2}, Radius: .5},
+		geometry.Cylinder{Base: geometry.Vec3{X: -2}, Top: geometry.Vec3{X: 2}, Radius: .5}))
+	cut := must(geometry.NewCSG(geometry.CSGDifference,
+		geometry.Box{Min: geometry.Vec3{X: -1, Y: -1, Z: -1}, Max: geometry.Vec3{X: 1, Y: 1, Z: 1}, Material: shading.RedRubber},
+		cross))
+	want := []geometry.Primitive{must(geometry.NewCSG(geometry.CSGDifference, cut,
+		geometry.Sphere{Center: geometry.Vec3{Y: 1}, R: .5}))}
+	if !reflect.DeepEqual(s.Primitives, want) {
+		t.Errorf("got %+v, want %+v", s.Primitives, want)
+	}
+
+	// the written scene nests the blocks the same way
+	out, err := Format(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "difference {\n    box {\n") || !strings.Contains(string(out), "    union {\n        cylinder {\n") {
+		t.Errorf("unexpected nesting in\n%s", out)
+	}
+	again, err := NewParser(string(out)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.Primitives, want) {
+		t.Errorf("the written scene parses to %+v", again.Primitives)
+	}
+}
+
+func TestParseCSGErrors(t *testing.T) {
+	src := "union {\n" +
+		"    sphere { radius 1 }\n" +
+		"    plane { normal 0, 1, 0 }\n" +
+		"}\n" +
+		"intersection {\n" +
+		"    sphere { radius 1 }\n" +
+		"    cylinder { top 0, 1, 0  radius 1  open }\n" +
+		"}\n" +
+		"difference {\n" +
+		"    box { max 1, 1, 1 }\n" +
+		"}\n" +
+		"union {\n" +
+		"    sphere { radius 1 }\n" +
+		"    sphere { radius 1  animate radius { key 1 1  key 10 2 } }\n" +
+		"}\n"
+
+	p := NewParser(src)
+	p.File = "test.scene"
+	_, err := p.Parse()
+
+	want := []string{
+		"test.scene:3:5: union: a plane has no inside",
+		"test.scene:7:5: intersection: an open cylinder has no inside",
+		"test.scene:9:1: difference: expected at least two solids, found 1",
+		"test.scene:12:1: union: the parts can't be animated",
+	}
+	errs, ok := err.(ErrorList)
+	if !ok || len(errs) != len(want) {
+		t.Fatalf("expected %d errors, got:\n%v", len(want), err)
+	}
+	for i, e := range errs {
+		if e.Error() != want[i] {
+			t.Errorf("error %d: got %q, want %q", i, e.Error(), want[i])
+		}
+	}
+}
+
+func TestParseSDF(t *testing.T) {
+	src := "sdf {\n" +
+		"    min -2, 0, -2\n" +
+		"    max 2, 3, 2\n" +
+		"    material red\n" +
+		"    union {\n" +
+		"        smooth 0.5\n" +
+		"        box { center 0, 0.5, 0  size 2, 1, 2  round 0.1 }\n" +
+		"        translate { offset 0, 1, 0  twist { angle 45  cylinder { radius 0.5  height 2 } } }\n" +
+		"        repeat { period 1, 0, 1  sphere { radius 0.2 } }\n" +
+		"    }\n" +
+		"}\n" +
+		"sdf { min -2, -2, -2  max 2, 2, 2  mandelbulb { power 6 } }\n" +
+		"sdf { min -2, -2, -2  max 2, 2, 2  difference { torus { major 1  minor 0.3 }  sphere { radius 1 } } }\n"
+
+	s, err := NewParser(src).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []geometry.Primitive{
+		&geometry.SDFPrimitive{
+			Shape: geometry.SDFCombine{Op: geometry.CSGUnion, Smooth: .5, Parts: []geometry.SDF{
+				geometry.SDFBox{Center: geometry.Vec3{Y: .5}, Size: geometry.Vec3{X: 2, Y: 1, Z: 2}, Round: .1},
+				geometry.SDFTranslate{Offset: geometry.Vec3{Y: 1}, Shape: geometry.SDFTwist{Angle: 45, Shape: geometry.SDFCylinder{Radius: .5, Height: 2}}},
+				geometry.SDFRepeat{Period: geometry.Vec3{X: 1, Z: 1}, Shape: geometry.SDFSphere{Radius: .2}},
+			}},
+			Min:      geometry.Vec3{X: -2, Z: -2},
+			Max:      geometry.Vec3{X: 2, Y: 3, Z: 2},
+			Material: shading.RedRubber,
+		},
+		&geometry.SDFPrimitive{
+			Shape: geometry.SDFMandelbulb{Scale: 1, Power: 6, Iterations: 8},
+			Min:   geometry.Vec3{X: -2, Y: -2, Z: -2},
+			Max:   geometry.Vec3{X: 2, Y: 2, Z: 2},
+		},
+		&geometry.SDFPrimitive{
+			Shape: geometry.SDFCombine{Op: geometry.CSGDifference, Parts: []geometry.SDF{
+				geometry.SDFTorus{Major: 1, Minor: .3},
+				geometry.SDFSphere{Radius: 1},
+			}},
+			Min: geometry.Vec3{X: -2, Y: -2, Z: -2},
+			Max: geometry.Vec3{X: 2, Y: 2, Z: 2},
+		},
+	}
+	if !reflect.DeepEqual(s.Primitives, want) {
+		t.Errorf("got %+v, want %+v", s.Primitives, want)
+	}
+
+	out, err := Format(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := NewParser(string(out)).Parse()
+	if err != nil {
+		t.Fatalf("parsing the written scene: %v\n%s", err, out)
+	}
+	if !reflect.DeepEqual(again.Primitives, want) {
+		t.Errorf("the written scene parses to %+v", again.Primitives)
+	}
+}
+
+func TestParseSDFErrors(t *testing.T) {
+	src := "sdf { min 1, 1, 1  max 0, 0, 0  sphere { radius 1 } }\n" +
+		"sdf { min -1, -1, -1  max 1, 1, 1 }\n" +
+		"sdf {\n" +
+		"    min -1, -1, -1\n" +
+		"    max 1, 1, 1\n" +
+		"    union { sphere { radius 1 } }\n" +
+		"}\n" +
+		"sdf {\n" +
+		"    min -1, -1, -1\n" +
+		"    max 1, 1, 1\n" +
+		"    twist { angle 10  sphere { radius 1 }  sphere { radius 2 } }\n" +
+		"    plane { normal 0, 1, 0 }\n" +
+		"}\n"
+
+	p := NewParser(src)
+	p.File = "test.scene"
+	_, err := p.Parse()
+
+	want := []string{
+		"test.scene:1:1: sdf: expected the bounds min below max",
+		"test.scene:2:1: sdf: expected one shape, found 0",
+		"test.scene:6:5: union: expected at least two shapes, found 1",
+		"test.scene:11:5: twist: expected one shape, found 2",
+		"test.scene:12:5: sdf: unknown property \"plane\"",
+	}
+	errs, ok := err.(ErrorList)
+	if !ok || len(errs) != len(want) {
+		t.Fatalf("expected %d errors, got:\n%v", len(want), err)
+	}
+	for i, e := range errs {
+		if e.Error() != want[i] {
+			t.Errorf("error %d: got %q, want %q", i, e.Error(), want[i])
+		}
+	}
+}
+
+func TestParseQuadrics(t *testing.T) {
+	src := "quadric {\n" +
+		"    squares 1, 0, 1\n" +
+		"    mixed 0, 0.5, 0\n" +
+		"    linear 0, -1, 0\n" +
+		"    constant -2\n" +
+		"    min -1, -1, -1\n" +
+		"    max 1, 1, 1\n" +
+		"    material red\n" +
+		"}\n" +
+		"ellipsoid { center 0, 1, 0  radii 2, 1, 1  material ivory }\n" +
+		"paraboloid { radii 1, 2, 1  height 4 }\n" +
+		"hyperboloid { radii 1, 1, 1  height 2  sheets 2 }\n" +
+		"implicit { equation \"x^4 + y^4 + z^4 - (x^2 + y^2 + z^2)\"  min -1.2, -1.2, -1.2  max 1.2, 1.2, 1.2  material red }\n"
+
+	s, err := NewParser(src).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	equation, err := geometry.ParsePolynomial("x^4 + y^4 + z^4 - x^2 - y^2 - z^2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []geometry.Primitive{
+		geometry.Quadric{
+			Squares:  geometry.Vec3{X: 1, Z: 1},
+			Mixed:    geometry.Vec3{Y: .5},
+			Linear:   geometry.Vec3{Y: -1},
+			Constant: -2,
+			Min:      geometry.Vec3{X: -1, Y: -1, Z: -1},
+			Max:      geometry.Vec3{X: 1, Y: 1, Z: 1},
+			Material: shading.RedRubber,
+		},
+		geometry.NewEllipsoid(geometry.Vec3{Y: 1}, geometry.Vec3{X: 2, Y: 1, Z: 1}, shading.Ivory),
+		geometry.NewParaboloid(geometry.Vec3{}, geometry.Vec3{X: 1, Y: 2, Z: 1}, 4, shading.Material{}),
+		geometry.NewHyperboloid(geometry.Vec3{}, geometry.Vec3{X: 1, Y: 1, Z: 1}, 2, 2, shading.Material{}),
+		&geometry.Implicit{
+			Equation: equation,
+			Min:      geometry.Vec3{X: -1.2, Y: -1.2, Z: -1.2},
+			Max:      geometry.Vec3{X: 1.2, Y: 1.2, Z: 1.2},
+			Material: shading.RedRubber,
+		},
+	}
+	if !reflect.DeepEqual(s.Primitives, want) {
+		t.Errorf("got %+v, want %+v", s.Primitives, want)
+	}
+
+	out, err := Format(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := NewParser(string(out)).Parse()
+	if err != nil {
+		t.Fatalf("parsing the written scene: %v\n%s", err, out)
+	}
+	if !reflect.DeepEqual(again.Primitives, want) {
+		t.Errorf("the written scene parses to %+v", again.Primitives)
+	}
+}
+
+func TestParseQuadricErrors(t *testing.T) {
+	src := "quadric { squares 1, 1, 1  constant -1 }\n" +
+		"ellipsoid { radii 1, 0, 1 }\n" +
+		"ellipsoid { radii 1, 1, 1  height 2 }\n" +
+		"paraboloid { radii 1, 1, 1 }\n" +
+		"hyperboloid { radii 1, 1, 1  height 1  sheets 3 }\n" +
+		"implicit { min -1, -1, -1  max 1, 1, 1 }\n" +
+		"implicit { equation \"x^2 + 2y\"  min -1, -1, -1  max 1, 1, 1 }\n" +
+		"implicit { equation \"2 + 2\"  min -1, -1, -1  max 1, 1, 1 }\n" +
+		"implicit { equation \"x\" }\n"
+
+	p := NewParser(src)
+	p.File = "test.scene"
+	_, err := p.Parse()
+
+	want := []string{
+		"test.scene:1:1: quadric: expected the bounds min below max",
+		"test.scene:2:1: ellipsoid: expected nonzero radii",
+		"test.scene:3:28: ellipsoid: unknown property \"height\"",
+		"test.scene:4:1: paraboloid: expected a positive height",
+		"test.scene:5:1: hyperboloid: expected 1 or 2 sheets, found 3",
+		"test.scene:6:1: implicit: missing equation",
+		"test.scene:7:1: implicit: equation: column 8: unexpected 'y'",
+		"test.scene:8:1: implicit: expected an equation in x, y or z",
+		"test.scene:9:1: implicit: expected the bounds min below max",
+	}
+	errs, ok := err.(ErrorList)
+	if !ok || len(errs) != len(want) {
+		t.Fatalf("expected %d errors, got:\n%v", len(want), err)
+	}
+	for i, e := range errs {
+		if e.Error() != want[i] {
+			t.Errorf("error %d: got %q, want %q", i, e.Error(), want[i])
+		}
+	}
+}
+
+func TestParseHeightfield(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hills.pgm"), []byte("P5 3 2 255\n\x00\x80\xff\x00\x00\x00"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	src := "heightfield { file \"hills.pgm\"  origin -1, 0, -1  scale 0.5, 2, 1  material ivory }\n" +
+		"heightfield { file \"missing.pgm\" }\n"
+	p := NewParser(src)
+	p.Dir = dir
+	p.File = "test.scene"
+	if _, err := p.Parse(); err == nil || !strings.Contains(err.Error(), "test.scene:2:1: heightfield: open") {
+		t.Fatalf("expected an error for the missing file, got %v", err)
+	}
+
+	p = NewParser(src[:strings.Index(src, "\n")+1])
+	p.Dir = dir
+	s, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, ok := s.Primitives[0].(*geometry.Heightfield)
+	if !ok {
+		t.Fatalf("got %T", s.Primitives[0])
+	}
+	if h.File != "hills.pgm" || h.Origin != (geometry.Vec3{X: -1, Z: -1}) || h.Scale != (geometry.Vec3{X: .5, Y: 2, Z: 1}) || h.Material != shading.Ivory {
+		t.Errorf("got %+v", h)
+	}
+	if b := h.Bounds(); b.PMax != (geometry.Point3{X: 0, Y: 2, Z: 0}) {
+		t.Errorf("bounds %v", b)
+	}
+
+	out, err := Format(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p = NewParser(string(out))
+	p.Dir = dir
+	again, err := p.Parse()
+	if err != nil {
+		t.Fatalf("parsing the written scene: %v\n%s", err, out)
+	}
+	if !reflect.DeepEqual(again.Primitives, s.Primitives) {
+		t.Errorf("the written scene parses to %+v", again.Primitives)
+	}
+}
+
+func TestParseComments(t *testing.T) {
+	src := "# lights and camera\n" +
+		"background #194D4D // teal\n" +
+		"camera 0, 5 , -5.5e1\n" +
+		"light {\n" +
+		"    pos 0,30,-10 # above\n" +
+		"}\n"
+
+	s, err := NewParser(src).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (geometry.Vec3{X: 0, Y: 5, Z: -55}); s.Camera != want {
+		t.Errorf("camera: got %v, want %v", s.Camera, want)
+	}
+	if len(s.Lights) != 1 || s.Lights[0].Pos != (geometry.Vec3{X: 0, Y: 30, Z: -10}) {
+		t.Errorf("unexpected lights %v", s.Lights)
+	}
+	if want := 0x4D / 255.; s.Background.B != want {
+		t.Errorf("background: got %v, want %v", s.Background.B, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	src := "camera 0,0\n" +
+		"sphere {\n" +
+		"    radius 1\n" +
+		"    colour red\n" +
+		"    material gold\n" +
+		"}\n" +
+		"blob 1\n"
+
+	p := NewParser(src)
+	p.File = "test.scene"
+	_, err := p.Parse()
+
+	errs, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("expected ErrorList, got %T: %v", err, err)
+	}
+
+	want := []string{
+		"test.scene:2:1: expected ',', found identifier \"sphere\"",
+		"test.scene:4:5: sphere: unknown property \"colour\"",
+		"test.scene:5:14: unknown material \"gold\"",
+		"test.scene:7:1: unknown statement \"blob\"",
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d errors, got:\n%v", len(want), err)
+	}
+	for i, e := range errs {
+		if e.Error() != want[i] {
+			t.Errorf("error %d: got %q, want %q", i, e.Error(), want[i])
+		}
+	}
+}
+
+func TestParseIncludeAndExpressions(t *testing.T) {
+	dir := t.TempDir()
+	rig := "let key = 0, 30, -10\n" +
+		"light {\n" +
+		"    pos key\n" +
+		"    diffuse 0.8 * (1, 1, 1)\n" +
+		"}\n" +
+		"material gold {\n" +
+		"    diffuse #BF9938\n" +
+		"    shininess 51.2\n" +
+		"}\n"
+	if err := os.WriteFile(filepath.Join(dir, "rig.scene"), []byte(rig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	scene := "include \"rig.scene\"\n" +
+		"let r = 2 * 5\n" +
+		"sphere {\n" +
+		"    radius r / 2 + 1\n" +
+		"    center key - (0, r * cos(radians(60)), -max(1, 2, 3))\n" +
+		"    material gold\n" +
+		"}\n"
+	scenePath := filepath.Join(dir, "main.scene")
+	if err := os.WriteFile(scenePath, []byte(scene), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := ParseFile(scenePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.Lights) != 1 || s.Lights[0].DiffuseIntensity.G != 0.8 {
+		t.Fatalf("unexpected lights %v", s.Lights)
+	}
+
+	sphere := s.Primitives[0].(geometry.Sphere)
+	if sphere.R != 6 {
+		t.Errorf("radius: got %v, want 6", sphere.R)
+	}
+	if want := (geometry.Vec3{X: 0, Y: 25, Z: -7}); sphere.Center.Sub(want).Norm() > 1e-9 {
+		t.Errorf("center: got %v, want %v", sphere.Center, want)
+	}
+	if sphere.Material.Name != "gold" || sphere.Material.Alpha != 51.2 {
+		t.Errorf("unexpected material %+v", sphere.Material)
+	}
+}
+
+func TestParseIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.scene"), []byte("include \"b.scene\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.scene"), []byte("include \"a.scene\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := ParseFile(filepath.Join(dir, "a.scene"))
+	if err == nil || !strings.Contains(err.Error(), "include cycle: a.scene -> b.scene -> a.scene") {
+		t.Errorf("expected an include cycle error, got %v", err)
+	}
+}
+
+func TestParseLoopsAndConditionals(t *testing.T) {
+	src := "let n = 4\n" +
+		"for i in 0..n {\n" +
+		"    if i % 2 == 0 {\n" +
+		"        sphere { radius 1  center i * 2, 0, 0  material red }\n" +
+		"    } else if i == 1 {\n" +
+		"        sphere { radius rand(7, i)  center i * 2, 0, 0  material ivory }\n" +
+		"    } else {\n" +
+		"        light { pos i, 10, 0 }\n" +
+		"    }\n" +
+		"}\n"
+
+	s, err := NewParser(src).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.Primitives) != 3 || len(s.Lights) != 1 {
+		t.Fatalf("expected 3 primitives and 1 light, got %d and %d", len(s.Primitives), len(s.Lights))
+	}
+
+	wantX := []float64{0, 2, 4}
+	wantMaterial := []string{"red", "ivory", "red"}
+	for i, p := range s.Primitives {
+		sphere := p.(geometry.Sphere)
+		if sphere.Center.X != wantX[i] || sphere.Material.Name != wantMaterial[i] {
+			t.Errorf("sphere %d: got center %v and material %q", i, sphere.Center, sphere.Material.Name)
+		}
+	}
+	if s.Lights[0].Pos.X != 3 {
+		t.Errorf("light: got %v", s.Lights[0].Pos)
+	}
+
+	// rand is deterministic
+	r := s.Primitives[1].(geometry.Sphere).R
+	again, err := NewParser(src).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r <= 0 || r >= 1 || again.Primitives[1].(geometry.Sphere).R != r {
+		t.Errorf("rand: got %v and %v", r, again.Primitives[1].(geometry.Sphere).R)
+	}
+
+	// the loop variable does not leak
+	if _, err := NewParser(src + "camera i, 0, 0\n").Parse(); err == nil || !strings.Contains(err.Error(), "undefined: i") {
+		t.Errorf("expected an undefined variable error, got %v", err)
+	}
+
+	// a missing in is a single error, whatever takes its place
+	for _, src := range []string{"for i 0..2 { }\n", "for i to 0..2 { }\n"} {
+		_, err := NewParser(src).Parse()
+		errs, ok := err.(ErrorList)
+		if !ok || len(errs) != 1 || !strings.Contains(errs[0].Error(), "expected in") {
+			t.Errorf("%q: got %v, want a single missing in error", src, err)
+		}
+	}
+
+	// nested loops share the limit of iterations and stop with one error
+	_, err = NewParser("for i in 0..100000 {\n    for j in 0..100000 {\n        let k = j\n    }\n}\n").Parse()
+	errs, ok := err.(ErrorList)
+	if !ok || len(errs) != 1 || !strings.Contains(errs[0].Error(), "2:9: for: more than 1000000 loop iterations") {
+		t.Errorf("nested loops: got %v, want a single iteration limit error", err)
+	}
+}
+
+func TestParseAnimation(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tri.obj"), []byte("v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	src := "sphere { radius 1  center 0, 0, 0\n" +
+		"    animate radius bezier { key 1 1 out 2  key 10 3 }\n" +
+		"}\n" +
+		"camera { pos 0, 0, -10  target 0, 0, 0\n" +
+		"    animate pos catmullrom {\n" +
+		"        key 1 -5, 0, -10\n" +
+		"        key (2 * 5) 5, 0, -10\n" +
+		"    }\n" +
+		"}\n" +
+		"mesh { file \"tri.obj\"  animate scale { key 1 1  key 10 2 } }\n" +
+		"light { pos 0, 10, 0  animate diffuse { key 1 1, 1, 1  key 10 1, 0, 0 } }\n"
+
+	p := NewParser(src)
+	p.Dir = dir
+	got, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []core.Animation{
+		{Target: core.AnimateCamera, Property: "pos", Track: core.Track{
+			Interpolation: core.InterpolateCatmullRom,
+			Keys: []core.Keyframe{
+				{Frame: 1, Value: geometry.Vec3{X: -5, Z: -10}, In: geometry.Vec3{X: -5, Z: -10}, Out: geometry.Vec3{X: -5, Z: -10}},
+				{Frame: 10, Value: geometry.Vec3{X: 5, Z: -10}, In: geometry.Vec3{X: 5, Z: -10}, Out: geometry.Vec3{X: 5, Z: -10}},
+			},
+		}},
+		{Target: core.AnimateLight, Property: "diffuse", Track: core.Track{
+			Interpolation: core.InterpolateLinear,
+			Keys: []core.Keyframe{
+				{Frame: 1, Value: geometry.Vec3{X: 1, Y: 1, Z: 1}, In: geometry.Vec3{X: 1, Y: 1, Z: 1}, Out: geometry.Vec3{X: 1, Y: 1, Z: 1}},
+				{Frame: 10, Value: geometry.Vec3{X: 1}, In: geometry.Vec3{X: 1}, Out: geometry.Vec3{X: 1}},
+			},
+		}},
+		{Target: core.AnimatePrimitive, Index: 0, Property: "radius", Track: core.Track{
+			Interpolation: core.InterpolateBezier,
+			Keys: []core.Keyframe{
+				{Frame: 1, Value: geometry.Vec3{X: 1}, In: geometry.Vec3{X: 1}, Out: geometry.Vec3{X: 2}},
+				{Frame: 10, Value: geometry.Vec3{X: 3}, In: geometry.Vec3{X: 3}, Out: geometry.Vec3{X: 3}},
+			},
+		}},
+		{Target: core.AnimatePrimitive, Index: 1, Property: "scale", Track: core.Track{
+			Interpolation: core.InterpolateLinear,
+			Keys: []core.Keyframe{
+				{Frame: 1, Value: geometry.Vec3{X: 1, Y: 1, Z: 1}, In: geometry.Vec3{X: 1, Y: 1, Z: 1}, Out: geometry.Vec3{X: 1, Y: 1, Z: 1}},
+				{Frame: 10, Value: geometry.Vec3{X: 2, Y: 2, Z: 2}, In: geometry.Vec3{X: 2, Y: 2, Z: 2}, Out: geometry.Vec3{X: 2, Y: 2, Z: 2}},
+			},
+		}},
+	}
+	if !reflect.DeepEqual(got.Animations, want) {
+		t.Errorf("Animations = %+v, want %+v", got.Animations, want)
+	}
+	if got.Target == nil || *got.Target != (geometry.Vec3{}) {
+		t.Errorf("Target = %v, want the origin", got.Target)
+	}
+
+	// animations survive a round trip through the writer
+	out, err := Format(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p = NewParser(string(out))
+	p.Dir = dir
+	again, err := p.Parse()
+	if err != nil {
+		t.Fatalf("parsing the written scene: %v\n%s", err, out)
+	}
+	if !reflect.DeepEqual(got, again) {
+		t.Errorf("written scene differs:\n%s", out)
+	}
+
+	for _, tc := range []struct{ src, err string }{
+		{"sphere { radius 1  animate rotate { key 1 0, 0, 0 } }", `"rotate" can't be animated`},
+		{"light { animate pos { key 2 0, 0, 0  key 1 1, 1, 1 } }", "keys must have increasing frames"},
+		{"sphere { radius 1  animate radius smooth { key 1 1 } }", `expected one of linear, bezier, catmullrom, found "smooth"`},
+		{"sphere { radius 1  animate center { key 1 2 } }", "expected a vector, found a number"},
+	} {
+		_, err := NewParser(tc.src).Parse()
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("Parse(%q) = %v, want an error containing %q", tc.src, err, tc.err)
 		}
 	}
 }
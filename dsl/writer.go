@@ -0,0 +1,589 @@
+package dsl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/danradchuk/raytracer/core"
+	"github.com/danradchuk/raytracer/geometry"
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// Format returns the canonical source of a scene. See Write.
+func Format(s *core.Scene) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := Write(&buf, s); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Write writes the canonical source of a scene: global settings, lights,
+// material definitions and primitives, in this order. Parsing the output
+// gives a scene equal to s. Materials that are not built in are written as
+// material definitions; unnamed or conflicting ones get generated names.
+func Write(w io.Writer, s *core.Scene) error {
+	sw := &sceneWriter{w: bufio.NewWriter(w)}
+
+	sw.printf("background %s\n\n", formatColor(s.Background))
+	sw.printf("ambient %s\n\n", formatVec(colorToVec(s.AmbientIntensity)))
+	anims := func(target string, index int) []core.Animation {
+		var list []core.Animation
+		for _, a := range s.Animations {
+			if a.Target == target && (target == core.AnimateCamera || a.Index == index) {
+				list = append(list, a)
+			}
+		}
+		return list
+	}
+
+	if cameraAnims := anims(core.AnimateCamera, 0); s.Target != nil || len(cameraAnims) > 0 {
+		sw.printf("camera {\n")
+		sw.property("pos", formatVec(s.Camera))
+		if s.Target != nil {
+			sw.property("target", formatVec(*s.Target))
+		}
+		sw.writeAnimations(cameraAnims)
+		sw.printf("}\n")
+	} else {
+		sw.printf("camera %s\n", formatVec(s.Camera))
+	}
+
+	if s.Settings != (core.RenderSettings{}) {
+		sw.writeRender(s.Settings)
+	}
+
+	for i, l := range s.Lights {
+		sw.printf("\nlight {\n")
+		sw.property("pos", formatVec(l.Pos))
+		sw.property("diffuse", formatVec(colorToVec(l.DiffuseIntensity)))
+		sw.property("specular", formatVec(colorToVec(l.SpecularIntensity)))
+		sw.writeAnimations(anims(core.AnimateLight, i))
+		sw.printf("}\n")
+	}
+
+	// name the materials first, so that definitions precede their use
+	for _, prim := range s.Primitives {
+		if err := sw.nameMaterials(prim); err != nil {
+			return err
+		}
+	}
+	for _, nm := range sw.materials {
+		if !nm.builtin {
+			sw.writeMaterial(nm.name, nm.material)
+		}
+	}
+
+	for i, prim := range s.Primitives {
+		sw.writePrimitive(prim, anims(core.AnimatePrimitive, i))
+	}
+
+	if sw.err != nil {
+		return sw.err
+	}
+	return sw.w.Flush()
+}
+
+type sceneWriter struct {
+	w         *bufio.Writer
+	err       error
+	materials []namedMaterial
+	indent    string // of the block being written
+}
+
+// namedMaterial is a material of the scene and the name it is written with.
+type namedMaterial struct {
+	name     string
+	material shading.Material
+	builtin  bool
+}
+
+func (sw *sceneWriter) printf(format string, args ...any) {
+	if sw.err != nil {
+		return
+	}
+	_, sw.err = fmt.Fprintf(sw.w, format, args...)
+}
+
+// open starts a block; top-level blocks are separated by blank lines.
+func (sw *sceneWriter) open(name string) {
+	if sw.indent == "" {
+		sw.printf("\n")
+	}
+	sw.printf("%s%s {\n", sw.indent, name)
+}
+
+func (sw *sceneWriter) close() {
+	sw.printf("%s}\n", sw.indent)
+}
+
+func (sw *sceneWriter) property(key, value string) {
+	sw.printf("%s    %s %s\n", sw.indent, key, value)
+}
+
+// writePrimitive writes the block of a primitive.
+func (sw *sceneWriter) writePrimitive(prim geometry.Primitive, anims []core.Animation) {
+	switch p := prim.(type) {
+	case geometry.Sphere:
+		sw.open("sphere")
+		sw.property("radius", formatFloat(p.R))
+		sw.property("center", formatVec(p.Center))
+		sw.materialProperty(p.Material)
+		sw.writeAnimations(anims)
+		sw.close()
+	case geometry.Plane:
+		sw.open("plane")
+		sw.property("width", formatFloat(p.Width))
+		sw.property("point", formatVec(p.Point))
+		sw.property("normal", formatVec(p.Normal))
+		sw.materialProperty(p.Material)
+		sw.close()
+	case *geometry.Triangle:
+		sw.open("triangle")
+		sw.property("v0", formatVec(p.V0))
+		sw.property("v1", formatVec(p.V1))
+		sw.property("v2", formatVec(p.V2))
+		sw.materialProperty(p.Material)
+		sw.close()
+	case geometry.Box:
+		sw.open("box")
+		sw.property("min", formatVec(p.Min))
+		sw.property("max", formatVec(p.Max))
+		if p.Rotate != (geometry.Vec3{}) {
+			sw.property("rotate", formatVec(p.Rotate))
+		}
+		sw.materialProperty(p.Material)
+		sw.close()
+	case geometry.Cylinder:
+		sw.open("cylinder")
+		sw.property("base", formatVec(p.Base))
+		sw.property("top", formatVec(p.Top))
+		sw.property("radius", formatFloat(p.Radius))
+		sw.openProperty(p.Open)
+		sw.materialProperty(p.Material)
+		sw.close()
+	case geometry.Cone:
+		sw.open("cone")
+		sw.property("base", formatVec(p.Base))
+		sw.property("top", formatVec(p.Top))
+		sw.property("radius", formatFloat(p.Radius))
+		if p.TopRadius != 0 {
+			sw.property("topradius", formatFloat(p.TopRadius))
+		}
+		sw.openProperty(p.Open)
+		sw.materialProperty(p.Material)
+		sw.close()
+	case geometry.Disk:
+		sw.open("disk")
+		sw.property("center", formatVec(p.Center))
+		sw.property("normal", formatVec(p.Normal))
+		sw.property("radius", formatFloat(p.Radius))
+		if p.Inner != 0 {
+			sw.property("inner", formatFloat(p.Inner))
+		}
+		sw.materialProperty(p.Material)
+		sw.close()
+	case geometry.Torus:
+		sw.open("torus")
+		sw.property("center", formatVec(p.Center))
+		sw.property("axis", formatVec(p.Axis))
+		sw.property("major", formatFloat(p.Major))
+		sw.property("minor", formatFloat(p.Minor))
+		sw.materialProperty(p.Material)
+		sw.close()
+	case *geometry.CSG:
+		sw.open(string(p.Op))
+		sw.indent += "    "
+		for _, part := range p.Parts() {
+			sw.writePrimitive(part, nil)
+		}
+		sw.indent = sw.indent[4:]
+		sw.close()
+	case *geometry.SDFPrimitive:
+		sw.open("sdf")
+		sw.property("min", formatVec(p.Min))
+		sw.property("max", formatVec(p.Max))
+		sw.materialProperty(p.Material)
+		sw.indent += "    "
+		sw.writeSDF(p.Shape)
+		sw.indent = sw.indent[4:]
+		sw.close()
+	case geometry.Quadric:
+		sw.open("quadric")
+		sw.property("squares", formatVec(p.Squares))
+		sw.property("mixed", formatVec(p.Mixed))
+		sw.property("linear", formatVec(p.Linear))
+		sw.property("constant", formatFloat(p.Constant))
+		sw.property("min", formatVec(p.Min))
+		sw.property("max", formatVec(p.Max))
+		sw.materialProperty(p.Material)
+		sw.close()
+	case *geometry.Implicit:
+		sw.open("implicit")
+		sw.property("equation", quote(p.Equation.String()))
+		sw.property("min", formatVec(p.Min))
+		sw.property("max", formatVec(p.Max))
+		sw.materialProperty(p.Material)
+		sw.close()
+	case *geometry.Heightfield:
+		sw.open("heightfield")
+		sw.property("file", quote(p.File))
+		sw.property("origin", formatVec(p.Origin))
+		sw.property("scale", formatVec(p.Scale))
+		sw.materialProperty(p.Material)
+		sw.close()
+	case *geometry.Mesh:
+		sw.writeMesh(p, anims)
+	default:
+		if sw.err == nil {
+			sw.err = fmt.Errorf("dsl: cannot write primitive of type %T", prim)
+		}
+	}
+}
+
+// writeSDF writes the blocks of an SDF shape and of its operands.
+func (sw *sceneWriter) writeSDF(shape geometry.SDF) {
+	var operands []geometry.SDF
+	switch s := shape.(type) {
+	case geometry.SDFSphere:
+		sw.open("sphere")
+		sw.property("center", formatVec(s.Center))
+		sw.property("radius", formatFloat(s.Radius))
+	case geometry.SDFBox:
+		sw.open("box")
+		sw.property("center", formatVec(s.Center))
+		sw.property("size", formatVec(s.Size))
+		if s.Round != 0 {
+			sw.property("round", formatFloat(s.Round))
+		}
+	case geometry.SDFTorus:
+		sw.open("torus")
+		sw.property("center", formatVec(s.Center))
+		sw.property("major", formatFloat(s.Major))
+		sw.property("minor", formatFloat(s.Minor))
+	case geometry.SDFCylinder:
+		sw.open("cylinder")
+		sw.property("center", formatVec(s.Center))
+		sw.property("radius", formatFloat(s.Radius))
+		sw.property("height", formatFloat(s.Height))
+	case geometry.SDFMandelbulb:
+		sw.open("mandelbulb")
+		sw.property("center", formatVec(s.Center))
+		sw.property("scale", formatFloat(s.Scale))
+		sw.property("power", formatFloat(s.Power))
+		sw.property("iterations", strconv.Itoa(s.Iterations))
+	case geometry.SDFCombine:
+		sw.open(string(s.Op))
+		if s.Smooth != 0 {
+			sw.property("smooth", formatFloat(s.Smooth))
+		}
+		operands = s.Parts
+	case geometry.SDFTwist:
+		sw.open("twist")
+		sw.property("angle", formatFloat(s.Angle))
+		operands = []geometry.SDF{s.Shape}
+	case geometry.SDFTranslate:
+		sw.open("translate")
+		sw.property("offset", formatVec(s.Offset))
+		operands = []geometry.SDF{s.Shape}
+	case geometry.SDFRepeat:
+		sw.open("repeat")
+		sw.property("period", formatVec(s.Period))
+		operands = []geometry.SDF{s.Shape}
+	default:
+		if sw.err == nil {
+			sw.err = fmt.Errorf("dsl: cannot write SDF shape of type %T", shape)
+		}
+		return
+	}
+
+	sw.indent += "    "
+	for _, operand := range operands {
+		sw.writeSDF(operand)
+	}
+	sw.indent = sw.indent[4:]
+	sw.close()
+}
+
+// nameMaterials names the materials of a primitive and of its parts.
+func (sw *sceneWriter) nameMaterials(prim geometry.Primitive) error {
+	switch p := prim.(type) {
+	case geometry.Sphere:
+		sw.nameMaterial(p.Material)
+	case geometry.Plane:
+		sw.nameMaterial(p.Material)
+	case *geometry.Triangle:
+		sw.nameMaterial(p.Material)
+	case geometry.Box:
+		sw.nameMaterial(p.Material)
+	case geometry.Cylinder:
+		sw.nameMaterial(p.Material)
+	case geometry.Cone:
+		sw.nameMaterial(p.Material)
+	case geometry.Disk:
+		sw.nameMaterial(p.Material)
+	case geometry.Torus:
+		sw.nameMaterial(p.Material)
+	case *geometry.CSG:
+		for _, part := range p.Parts() {
+			if err := sw.nameMaterials(part); err != nil {
+				return err
+			}
+		}
+	case *geometry.SDFPrimitive:
+		sw.nameMaterial(p.Material)
+	case geometry.Quadric:
+		sw.nameMaterial(p.Material)
+	case *geometry.Implicit:
+		sw.nameMaterial(p.Material)
+	case *geometry.Heightfield:
+		sw.nameMaterial(p.Material)
+	case *geometry.Mesh:
+		if p.Material != nil {
+			sw.nameMaterial(*p.Material)
+		}
+	default:
+		return fmt.Errorf("dsl: cannot write primitive of type %T", prim)
+	}
+	return nil
+}
+
+func (sw *sceneWriter) writeRender(rs core.RenderSettings) {
+	sw.printf("\nrender {\n")
+	// unset settings are nil; of the plain ones, zero is unset
+	nonZero := func(n int) *int {
+		if n == 0 {
+			return nil
+		}
+		return &n
+	}
+	for _, p := range []struct {
+		key   string
+		value *int
+	}{
+		{"width", nonZero(rs.Width)},
+		{"height", nonZero(rs.Height)},
+		{"fov", nonZero(rs.Fov)},
+		{"spp", nonZero(rs.SPP)},
+		{"maxdepth", nonZero(rs.MaxDepth)},
+		{"reflectiondepth", rs.ReflectionDepth},
+		{"refractiondepth", rs.RefractionDepth},
+		{"diffusedepth", rs.DiffuseDepth},
+		{"workers", nonZero(rs.Workers)},
+		{"frames", nonZero(rs.Frames)},
+		{"delay", nonZero(rs.FrameDelay)},
+		{"firstframe", rs.FirstFrame},
+		{"lastframe", rs.LastFrame},
+	} {
+		if p.value != nil {
+			sw.property(p.key, strconv.Itoa(*p.value))
+		}
+	}
+	if rs.MinContribution != 0 {
+		sw.property("mincontribution", formatFloat(rs.MinContribution))
+	}
+	for _, p := range []struct {
+		key   string
+		value float64
+	}{
+		{"radius", rs.OrbitRadius},
+		{"shutteropen", rs.ShutterOpen},
+		{"shutterclose", rs.ShutterClose},
+	} {
+		if p.value != 0 {
+			sw.property(p.key, formatFloat(p.value))
+		}
+	}
+	for _, p := range []struct{ key, value string }{
+		{"terminal", rs.Terminal},
+		{"tileorder", rs.TileOrder},
+		{"palette", rs.Palette},
+		{"dither", rs.Dither},
+	} {
+		if p.value != "" {
+			sw.property(p.key, p.value)
+		}
+	}
+	if rs.Output != "" {
+		sw.property("output", quote(rs.Output))
+	}
+	sw.printf("}\n")
+}
+
+// materialProperty writes the material of a primitive unless it has none.
+func (sw *sceneWriter) materialProperty(m shading.Material) {
+	if hasMaterial(m) {
+		sw.property("material", sw.materialName(m))
+	}
+}
+
+// openProperty writes the flag of open cylinders and cones.
+func (sw *sceneWriter) openProperty(open bool) {
+	if open {
+		sw.printf("%s    open\n", sw.indent)
+	}
+}
+
+func (sw *sceneWriter) nameMaterial(m shading.Material) {
+	if hasMaterial(m) {
+		sw.materialName(m)
+	}
+}
+
+// hasMaterial reports whether a primitive was given a material.
+func hasMaterial(m shading.Material) bool {
+	return !reflect.DeepEqual(m, shading.Material{})
+}
+
+func (sw *sceneWriter) writeMesh(m *geometry.Mesh, anims []core.Animation) {
+	sw.open("mesh")
+	sw.property("file", quote(m.File))
+	if m.Material != nil {
+		sw.property("material", sw.materialName(*m.Material))
+	}
+
+	t := m.Transform
+	if t.Translate != (geometry.Vec3{}) {
+		sw.property("translate", formatVec(t.Translate))
+	}
+	if t.Rotate != (geometry.Vec3{}) {
+		sw.property("rotate", formatVec(t.Rotate))
+	}
+	if t.Scale.X == t.Scale.Y && t.Scale.Y == t.Scale.Z {
+		if t.Scale.X != 1 {
+			sw.property("scale", formatFloat(t.Scale.X))
+		}
+	} else {
+		sw.property("scale", formatVec(t.Scale))
+	}
+	sw.writeAnimations(anims)
+	sw.close()
+}
+
+// writeAnimations writes the keyframes of the animated properties of a block.
+func (sw *sceneWriter) writeAnimations(anims []core.Animation) {
+	for _, a := range anims {
+		format := formatVec
+		if core.IsScalarProperty(a.Property) {
+			format = func(v geometry.Vec3) string { return formatFloat(v.X) }
+		}
+
+		sw.printf("%s    animate %s", sw.indent, a.Property)
+		if a.Track.Interpolation != core.InterpolateLinear {
+			sw.printf(" %s", a.Track.Interpolation)
+		}
+		sw.printf(" {\n")
+		for _, k := range a.Track.Keys {
+			sw.printf("%s        key %s %s", sw.indent, formatFloat(k.Frame), format(k.Value))
+			if k.In != k.Value {
+				sw.printf(" in %s", format(k.In))
+			}
+			if k.Out != k.Value {
+				sw.printf(" out %s", format(k.Out))
+			}
+			sw.printf("\n")
+		}
+		sw.printf("%s    }\n", sw.indent)
+	}
+}
+
+func (sw *sceneWriter) writeMaterial(name string, m shading.Material) {
+	sw.printf("\nmaterial %s {\n", name)
+	sw.property("ambient", formatVec(colorToVec(m.KAmbient)))
+	sw.property("diffuse", formatVec(colorToVec(m.KDiffuse)))
+	sw.property("specular", formatVec(colorToVec(m.KSpecular)))
+	sw.property("reflection", formatVec(colorToVec(m.KReflection)))
+	sw.property("shininess", formatFloat(m.Alpha))
+	sw.property("ior", formatFloat(m.IOR))
+	if m.Transparency != 0 {
+		sw.property("transparency", formatFloat(m.Transparency))
+	}
+	sw.printf("}\n")
+}
+
+// materialName returns the name a material is written with. Built-in
+// materials are referenced by name; other materials keep their names
+// unless the name is not an identifier or is taken by another material.
+func (sw *sceneWriter) materialName(m shading.Material) string {
+	for _, nm := range sw.materials {
+		if reflect.DeepEqual(m, nm.material) {
+			return nm.name
+		}
+	}
+
+	name := m.Name
+	builtin := shading.Builtin(name)
+	isBuiltin := builtin != nil && reflect.DeepEqual(m, *builtin)
+
+	if !isBuiltin && (!isIdent(name) || sw.taken(name)) {
+		base := "material"
+		if isIdent(name) {
+			base = name
+		}
+		for i := 1; ; i++ {
+			name = base + strconv.Itoa(i)
+			if !sw.taken(name) && shading.Builtin(name) == nil {
+				break
+			}
+		}
+	}
+
+	sw.materials = append(sw.materials, namedMaterial{name: name, material: m, builtin: isBuiltin})
+
+	return name
+}
+
+func (sw *sceneWriter) taken(name string) bool {
+	for _, nm := range sw.materials {
+		if nm.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func colorToVec(c shading.Color) geometry.Vec3 {
+	return geometry.Vec3{X: c.R, Y: c.G, Z: c.B}
+}
+
+// formatColor writes colors that are exact multiples of 1/255 as #RRGGBB.
+func formatColor(c shading.Color) string {
+	var rgb [3]int
+	for i, f := range []float64{c.R, c.G, c.B} {
+		b := math.Round(f * 255)
+		if b < 0 || b > 255 || b/255. != f {
+			return formatVec(colorToVec(c))
+		}
+		rgb[i] = int(b)
+	}
+	return fmt.Sprintf("#%02X%02X%02X", rgb[0], rgb[1], rgb[2])
+}
+
+func formatVec(v geometry.Vec3) string {
+	return formatFloat(v.X) + ", " + formatFloat(v.Y) + ", " + formatFloat(v.Z)
+}
+
+// formatFloat writes the shortest representation that parses back to f.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func isIdent(s string) bool {
+	if s == "" || isDigit(s[0]) || keywords[s] {
+		return false
+	}
+	return strings.IndexFunc(s, func(r rune) bool {
+		return r > 127 || !(isLetter(byte(r)) || isDigit(byte(r)))
+	}) < 0
+}
+
+// keywords can't be used as material names.
+var keywords = map[string]bool{
+	"else": true, "for": true, "if": true, "in": true,
+}
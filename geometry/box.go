@@ -0,0 +1,177 @@
+package geometry
+
+import (
+	"math"
+
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// Box represents a box between the corners Min and Max. A nonzero Rotate
+// orients the box by rotating it around its center by the angles around X,
+// Y and Z in degrees, like Transform.
+type Box struct {
+	Min, Max Vec3
+	Rotate   Vec3
+	Material shading.Material
+}
+
+// boxFaces are the texture axes of the faces -X, +X, -Y, +Y, -Z and +Z: the
+// axis and direction of u and of v. Looking at a face from outside, u goes
+// to the right and v up.
+var boxFaces = [6][2]struct {
+	axis int
+	sign float64
+}{
+	{{2, -1}, {1, 1}},
+	{{2, 1}, {1, 1}},
+	{{0, 1}, {2, -1}},
+	{{0, 1}, {2, 1}},
+	{{0, 1}, {1, 1}},
+	{{0, -1}, {1, 1}},
+}
+
+// Intersect computes the intersection of a ray with the box using the slab
+// method in the space of the box.
+func (b Box) Intersect(r Ray) *HitRecord {
+	o, d := b.local(r)
+	tNear, tFar, near, far, ok := b.slabs(o, d)
+	if !ok || tFar < 0 {
+		return nil
+	}
+
+	// a ray starting inside the box hits it on the way out
+	hit := b.hit(o, d, tNear, near)
+	if tNear < 0 {
+		hit = b.hit(o, d, tFar, far)
+	}
+	return &hit
+}
+
+// Hits returns where the line of the ray enters and leaves the box.
+func (b Box) Hits(r Ray) []HitRecord {
+	o, d := b.local(r)
+	tNear, tFar, near, far, ok := b.slabs(o, d)
+	if !ok {
+		return nil
+	}
+	return []HitRecord{b.hit(o, d, tNear, near), b.hit(o, d, tFar, far)}
+}
+
+// slabs returns where the line o + t*d in the space of the box enters and
+// leaves it and the faces it goes through, as 2*axis+side.
+func (b Box) slabs(o, d Vec3) (tNear, tFar float64, near, far int, ok bool) {
+	origin, dir := vecArray(o), vecArray(d)
+	lo, hi := vecArray(b.Min), vecArray(b.Max)
+
+	tNear, tFar = math.Inf(-1), math.Inf(1)
+	for i := 0; i < 3; i++ {
+		if dir[i] == 0 {
+			if origin[i] < lo[i] || origin[i] > hi[i] {
+				return 0, 0, 0, 0, false
+			}
+			continue
+		}
+
+		t1 := (lo[i] - origin[i]) / dir[i]
+		t2 := (hi[i] - origin[i]) / dir[i]
+		in, out := 2*i, 2*i+1
+		if t1 > t2 {
+			t1, t2 = t2, t1
+			in, out = out, in
+		}
+		if t1 > tNear {
+			tNear, near = t1, in
+		}
+		if t2 < tFar {
+			tFar, far = t2, out
+		}
+	}
+
+	return tNear, tFar, near, far, tNear <= tFar
+}
+
+// hit returns the hit of the line o + t*d in the space of the box on a face.
+func (b Box) hit(o, d Vec3, t float64, face int) HitRecord {
+	p := vecArray(o.Add(d.Scale(t)))
+	lo, hi := vecArray(b.Min), vecArray(b.Max)
+	axis := face / 2
+	sign := float64(2*(face%2) - 1)
+
+	var n, dpdu, dpdv [3]float64
+	n[axis] = sign
+	coord := func(i int, s float64) float64 {
+		f := (p[i] - lo[i]) / (hi[i] - lo[i])
+		if s < 0 {
+			f = 1 - f
+		}
+		return f
+	}
+	fu, fv := boxFaces[face][0], boxFaces[face][1]
+	dpdu[fu.axis] = fu.sign * (hi[fu.axis] - lo[fu.axis])
+	dpdv[fv.axis] = fv.sign * (hi[fv.axis] - lo[fv.axis])
+
+	hit := HitRecord{
+		T:         t,
+		Primitive: b,
+		Material:  b.Material,
+		Normal:    arrayVec(n),
+		UV:        UV{U: coord(fu.axis, fu.sign), V: coord(fv.axis, fv.sign)},
+		DPDU:      arrayVec(dpdu),
+		DPDV:      arrayVec(dpdv),
+	}
+	if rotation, oriented := b.rotation(); oriented {
+		hit.Normal = rotation.ApplyVector(hit.Normal)
+		hit.DPDU = rotation.ApplyVector(hit.DPDU)
+		hit.DPDV = rotation.ApplyVector(hit.DPDV)
+	}
+
+	return hit
+}
+
+// local returns the ray in the space of the box before its rotation.
+func (b Box) local(r Ray) (Vec3, Vec3) {
+	rotation, oriented := b.rotation()
+	if !oriented {
+		return r.Origin, r.Direction
+	}
+
+	inv := rotation.Transpose()
+	center := b.center()
+	return center.Add(inv.ApplyVector(r.Origin.Sub(center))), inv.ApplyVector(r.Direction)
+}
+
+// Bounds returns the bounding box of the box.
+func (b Box) Bounds() Bounds3 {
+	box := Bounds3{
+		PMin: Point3{X: b.Min.X, Y: b.Min.Y, Z: b.Min.Z},
+		PMax: Point3{X: b.Max.X, Y: b.Max.Y, Z: b.Max.Z},
+	}
+	rotation, oriented := b.rotation()
+	if !oriented {
+		return box
+	}
+
+	// the corners rotated around the center
+	center := b.center()
+	return Translation(center).Mul(rotation).Mul(Translation(center.Scale(-1))).ApplyBounds(box)
+}
+
+func (b Box) center() Vec3 {
+	return b.Min.Add(b.Max).Scale(.5)
+}
+
+// rotation returns the rotation of the box and reports whether it has one.
+func (b Box) rotation() (Matrix4, bool) {
+	if b.Rotate == (Vec3{}) {
+		return Identity(), false
+	}
+	return Transform{Rotate: b.Rotate, Scale: Vec3{X: 1, Y: 1, Z: 1}}.Matrix(), true
+}
+
+func vecArray(v Vec3) [3]float64 {
+	return [3]float64{v.X, v.Y, v.Z}
+}
+
+func arrayVec(a [3]float64) Vec3 {
+	return Vec3{X: a[0], Y: a[1], Z: a[2]}
+}
@@ -16,26 +16,53 @@ type BVHNode struct {
 // 4. Split a slice of Primitives by the midpoint
 // 5. Recursively build a BVH
 func BuildBVH(prims []Primitive) *BVHNode {
-	n := len(prims)
+	n, _ := BuildIndexedBVH(prims)
+	return n
+}
+
+// BuildIndexedBVH constructs a BVH like BuildBVH and returns the leaf slots
+// of the primitives: slots[i] is the child of a node holding prims[i]. A
+// primitive that has moved is swapped in by assigning to its slot, after
+// which the boxes are updated by Refit. The order of prims is kept.
+func BuildIndexedBVH(prims []Primitive) (*BVHNode, []*Primitive) {
+	leaves := make([]indexedPrimitive, len(prims))
+	for i, p := range prims {
+		leaves[i] = indexedPrimitive{p, i}
+	}
+	slots := make([]*Primitive, len(prims))
+	return buildBVH(leaves, slots), slots
+}
 
-	var left Primitive
-	var right Primitive
-	var bbox = EmptyAABB()
+// indexedPrimitive is a primitive with its index in the slice a BVH is
+// built from.
+type indexedPrimitive struct {
+	Primitive
+	index int
+}
+
+func buildBVH(prims []indexedPrimitive, slots []*Primitive) *BVHNode {
+	n := len(prims)
+	node := &BVHNode{Box: EmptyAABB()}
 
-	if n == 1 {
+	if n == 0 {
+		// an empty scene; the box of the node can't be hit
+		return node
+	} else if n == 1 {
 		// leaf node case
-		left = prims[0]
-		right = nil
-		bbox = left.Bounds()
+		node.Left = prims[0].Primitive
+		node.Box = node.Left.Bounds()
+		slots[prims[0].index] = &node.Left
 	} else if n == 2 { // leaf node case
-		left = prims[0]
-		right = prims[1]
-		bbox = left.Bounds().Union(right.Bounds())
+		node.Left = prims[0].Primitive
+		node.Right = prims[1].Primitive
+		node.Box = node.Left.Bounds().Union(node.Right.Bounds())
+		slots[prims[0].index] = &node.Left
+		slots[prims[1].index] = &node.Right
 	} else { // interior node case
 
 		// 1. compute a compound bounds of all primitives
 		for _, o := range prims {
-			bbox = bbox.Union(o.Bounds())
+			node.Box = node.Box.Union(o.Bounds())
 		}
 
 		// 2. calculate bounds for the centroids and pick the longest axis
@@ -51,7 +78,7 @@ func BuildBVH(prims []Primitive) *BVHNode {
 
 		// 4. divide set of primitives into two equal parts such that coordinate
 		// of a centroid < pMid goes to the first half, and other goes to the other
-		mid := partition(prims, func(p Primitive) bool {
+		mid := partition(prims, func(p indexedPrimitive) bool {
 			return centroid(p).GetCoordinateByAxis(axis) < mPoint
 		})
 
@@ -59,28 +86,29 @@ func BuildBVH(prims []Primitive) *BVHNode {
 			mid = n / 2
 		}
 
-		left = BuildBVH(prims[0:mid])
-		right = BuildBVH(prims[mid:n])
+		node.Left = buildBVH(prims[0:mid], slots)
+		node.Right = buildBVH(prims[mid:n], slots)
 	}
 
-	return &BVHNode{
-		Left:  left,
-		Box:   bbox,
-		Right: right,
-	}
+	return node
 }
 
 // Intersect search (recursively) intersection of the ray r with a Primitive.
 func (n *BVHNode) Intersect(r Ray) *HitRecord {
 	var hit *HitRecord = nil
+	if r.Stats != nil {
+		r.Stats.NodeTests++
+	}
 	if n.Box.Intersect(r) {
 		var leftHit *HitRecord
 		var rightHit *HitRecord
 
 		if n.Left != nil {
+			r.countPrimitive(n.Left)
 			leftHit = n.Left.Intersect(r)
 		}
 		if n.Right != nil {
+			r.countPrimitive(n.Right)
 			rightHit = n.Right.Intersect(r)
 		}
 
@@ -100,17 +128,32 @@ func (n *BVHNode) Intersect(r Ray) *HitRecord {
 	return hit
 }
 
+// countPrimitive counts the test of a child of a BVH node that isn't a node.
+func (r Ray) countPrimitive(p Primitive) {
+	if r.Stats == nil {
+		return
+	}
+	if _, ok := p.(*BVHNode); !ok {
+		r.Stats.PrimitiveTests++
+	}
+}
+
 // IntersectExclude search (recursively) intersection of the shadow ray r with a Primitive excluding p
 func (n *BVHNode) IntersectExclude(r Ray, p Primitive) *HitRecord {
 	var hit *HitRecord = nil
+	if r.Stats != nil {
+		r.Stats.NodeTests++
+	}
 	if n.Box.Intersect(r) {
 		var leftHit *HitRecord
 		var rightHit *HitRecord
 
 		if n.Left != nil {
+			r.countPrimitive(n.Left)
 			leftHit = n.Left.Intersect(r)
 		}
 		if n.Right != nil {
+			r.countPrimitive(n.Right)
 			rightHit = n.Right.Intersect(r)
 		}
 
@@ -140,12 +183,30 @@ func (n *BVHNode) Bounds() Bounds3 {
 	return n.Box
 }
 
+// Refit recomputes the boxes of the hierarchy bottom-up after its primitives
+// moved. The structure is kept, which is much cheaper than a rebuild but
+// gets less efficient the further the primitives move from where they were.
+func (n *BVHNode) Refit() Bounds3 {
+	box := EmptyAABB()
+	for _, child := range []Primitive{n.Left, n.Right} {
+		switch c := child.(type) {
+		case nil:
+		case *BVHNode:
+			box = box.Union(c.Refit())
+		default:
+			box = box.Union(c.Bounds())
+		}
+	}
+	n.Box = box
+	return box
+}
+
 func centroid(p Primitive) Point3 {
 	primBounds := p.Bounds()
 	return primBounds.PMin.Scale(.5).Add(primBounds.PMax.Scale(.5))
 }
 
-func partition(slice []Primitive, predicate func(Primitive) bool) int {
+func partition(slice []indexedPrimitive, predicate func(indexedPrimitive) bool) int {
 	i := 0
 	j := len(slice) - 1
 	for i < j {
@@ -0,0 +1,32 @@
+package geometry
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBuildIndexedBVH(t *testing.T) {
+	// two equal spheres and one apart; each has a slot of its own
+	s := Sphere{Center: Vec3{X: 5}, R: 1}
+	prims := []Primitive{s, Sphere{Center: Vec3{X: -5}, R: 1}, s}
+	bvh, slots := BuildIndexedBVH(prims)
+
+	for i, slot := range slots {
+		if *slot != prims[i] {
+			t.Errorf("slot %d holds %v, want %v", i, *slot, prims[i])
+		}
+	}
+	if slots[0] == slots[2] {
+		t.Fatal("the equal spheres share a slot")
+	}
+
+	// moving the last sphere leaves the first where it is
+	*slots[2] = Sphere{Center: Vec3{Y: 5}, R: 1}
+	bvh.Refit()
+	for _, c := range []Vec3{{X: 5}, {X: -5}, {Y: 5}} {
+		hit := bvh.Intersect(NewSecondaryRay(Vec3{X: c.X, Y: c.Y, Z: -10}, Vec3{Z: 1}))
+		if hit == nil || math.Abs(hit.T-9) > 1e-9 {
+			t.Errorf("ray at %v: %+v, want a hit at t = 9", c, hit)
+		}
+	}
+}
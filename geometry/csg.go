@@ -0,0 +1,138 @@
+package geometry
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Solid is a closed primitive with an inside, which CSG can combine. Hits
+// returns the hits of the whole line of the ray, before and after its
+// origin, ordered by T. The line enters the solid at the even hits and
+// leaves it at the odd ones; the normals point outwards.
+type Solid interface {
+	Primitive
+	Hits(r Ray) []HitRecord
+}
+
+// CSGOp is the operation of a CSG node.
+type CSGOp string
+
+// The operations of CSG nodes.
+const (
+	CSGUnion        CSGOp = "union"        // inside either part
+	CSGIntersection CSGOp = "intersection" // inside both parts
+	CSGDifference   CSGOp = "difference"   // inside the left part but not the right one
+)
+
+// CSGOps are the names of the operations.
+var CSGOps = []string{string(CSGUnion), string(CSGIntersection), string(CSGDifference)}
+
+// CSG is a solid combined from two solids by constructive solid geometry.
+// Its surface keeps the materials and texture coordinates of the parts;
+// where the right part of a difference cuts into the left one, the normals
+// of the right part are turned inwards.
+type CSG struct {
+	Op          CSGOp
+	Left, Right Solid
+	box         Bounds3
+}
+
+// NewCSG combines two solids.
+func NewCSG(op CSGOp, left, right Solid) (*CSG, error) {
+	if !slices.Contains(CSGOps, string(op)) {
+		return nil, fmt.Errorf("unknown CSG operation %q", op)
+	}
+
+	c := &CSG{Op: op, Left: left, Right: right}
+	l, r := left.Bounds(), right.Bounds()
+	switch op {
+	case CSGUnion:
+		c.box = l.Union(r)
+	case CSGIntersection:
+		c.box = Bounds3{
+			PMin: Point3{X: max(l.PMin.X, r.PMin.X), Y: max(l.PMin.Y, r.PMin.Y), Z: max(l.PMin.Z, r.PMin.Z)},
+			PMax: Point3{X: min(l.PMax.X, r.PMax.X), Y: min(l.PMax.Y, r.PMax.Y), Z: min(l.PMax.Z, r.PMax.Z)},
+		}
+	case CSGDifference:
+		c.box = l
+	}
+
+	return c, nil
+}
+
+// Parts returns the solids combined by the chain of nodes with the same
+// operation on the left, e.g. a, b and c of (a - b) - c.
+func (c *CSG) Parts() []Solid {
+	var parts []Solid
+	if left, ok := c.Left.(*CSG); ok && left.Op == c.Op {
+		parts = left.Parts()
+	} else {
+		parts = []Solid{c.Left}
+	}
+	return append(parts, c.Right)
+}
+
+// Intersect returns the first hit of the ray with the surface of the solid.
+func (c *CSG) Intersect(r Ray) *HitRecord {
+	for _, hit := range c.Hits(r) {
+		if hit.T >= 0 {
+			return &hit
+		}
+	}
+	return nil
+}
+
+// Hits returns where the line of the ray enters and leaves the solid by
+// going along the hits of both parts and keeping track of whether the line
+// is inside each of them.
+func (c *CSG) Hits(r Ray) []HitRecord {
+	left := c.Left.Hits(r)
+	if len(left) == 0 && c.Op != CSGUnion {
+		return nil
+	}
+	right := c.Right.Hits(r)
+	if len(right) == 0 && c.Op != CSGIntersection {
+		return left
+	}
+	left, right = left[:len(left)&^1], right[:len(right)&^1]
+
+	var hits []HitRecord
+	inside := false
+	i, j := 0, 0
+	for i < len(left) || j < len(right) {
+		// the next hit and the sides of the line after it
+		var hit HitRecord
+		if j == len(right) || i < len(left) && left[i].T <= right[j].T {
+			hit = left[i]
+			i++
+		} else {
+			hit = right[j]
+			j++
+			if c.Op == CSGDifference {
+				hit.Normal = hit.Normal.Scale(-1)
+			}
+		}
+		inLeft, inRight := i%2 == 1, j%2 == 1
+
+		var in bool
+		switch c.Op {
+		case CSGUnion:
+			in = inLeft || inRight
+		case CSGIntersection:
+			in = inLeft && inRight
+		case CSGDifference:
+			in = inLeft && !inRight
+		}
+		if in != inside {
+			hits = append(hits, hit)
+			inside = in
+		}
+	}
+
+	return hits
+}
+
+// Bounds returns the bounding box of the solid.
+func (c *CSG) Bounds() Bounds3 {
+	return c.box
+}
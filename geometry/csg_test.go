@@ -0,0 +1,114 @@
+package geometry
+
+import (
+	"math"
+	"testing"
+)
+
+func mustCSG(t *testing.T, op CSGOp, left, right Solid) *CSG {
+	t.Helper()
+	c, err := NewCSG(op, left, right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCSGHits(t *testing.T) {
+	cube := Box{Min: Vec3{-1, -1, -1}, Max: Vec3{1, 1, 1}}
+	ball := Sphere{Center: Vec3{}, R: .5}
+	left := Sphere{Center: Vec3{X: -.5}, R: 1}
+	right := Sphere{Center: Vec3{X: .5}, R: 1}
+	alongX := Ray{Origin: Vec3{X: -5}, Direction: Vec3{X: 1}}
+	alongZ := Ray{Origin: Vec3{Z: -5}, Direction: Vec3{Z: 1}}
+
+	tests := []struct {
+		name    string
+		solid   Solid
+		ray     Ray
+		ts      []float64
+		normals []Vec3
+	}{
+		{"lens", mustCSG(t, CSGIntersection, left, right), alongX,
+			[]float64{4.5, 5.5}, []Vec3{{X: -1}, {X: 1}}},
+		{"union", mustCSG(t, CSGUnion, left, right), alongX,
+			[]float64{3.5, 6.5}, []Vec3{{X: -1}, {X: 1}}},
+		{"hollow cube", mustCSG(t, CSGDifference, cube, ball), alongZ,
+			[]float64{4, 4.5, 5.5, 6}, []Vec3{{Z: -1}, {Z: 1}, {Z: -1}, {Z: 1}}},
+		{"disjoint", mustCSG(t, CSGIntersection, Sphere{Center: Vec3{X: -2}, R: 1}, Sphere{Center: Vec3{X: 2}, R: 1}), alongX,
+			nil, nil},
+		{"cut away", mustCSG(t, CSGDifference, ball, cube), alongZ,
+			nil, nil},
+		{"nested", mustCSG(t, CSGDifference, cube, mustCSG(t, CSGUnion, left, right)), alongX,
+			nil, nil},
+		{"nested through the side", mustCSG(t, CSGDifference, cube, mustCSG(t, CSGUnion, left, right)), alongZ,
+			[]float64{4, 5 - math.Sqrt(.75), 5 + math.Sqrt(.75), 6}, nil},
+	}
+
+	for _, test := range tests {
+		hits := test.solid.Hits(test.ray)
+		if len(hits) != len(test.ts) {
+			t.Errorf("%s: %d hits, want %d", test.name, len(hits), len(test.ts))
+			continue
+		}
+		for i, hit := range hits {
+			if math.Abs(hit.T-test.ts[i]) > 1e-6 {
+				t.Errorf("%s: hit %d at %v, want %v", test.name, i, hit.T, test.ts[i])
+			}
+			if test.normals != nil && !near(hit.Normal, test.normals[i]) {
+				t.Errorf("%s: hit %d has the normal %v, want %v", test.name, i, hit.Normal, test.normals[i])
+			}
+		}
+	}
+}
+
+func TestCSGIntersect(t *testing.T) {
+	hollow := mustCSG(t, CSGDifference, Box{Min: Vec3{-1, -1, -1}, Max: Vec3{1, 1, 1}}, Sphere{R: .5})
+
+	// from inside the hole the ray hits the inner surface, facing it
+	hit := hollow.Intersect(Ray{Direction: Vec3{Z: 1}})
+	if hit == nil || math.Abs(hit.T-.5) > 1e-6 || !near(hit.Normal, Vec3{Z: -1}) {
+		t.Fatalf("hit = %+v, want T .5 and the normal -Z", hit)
+	}
+	if _, ok := hit.Primitive.(Sphere); !ok {
+		t.Errorf("the hit is of %T, want the sphere", hit.Primitive)
+	}
+
+	if hit := hollow.Intersect(Ray{Origin: Vec3{Z: 2}, Direction: Vec3{Z: 1}}); hit != nil {
+		t.Errorf("hit behind the ray at %v", hit.T)
+	}
+}
+
+func TestCSGBoundsAndParts(t *testing.T) {
+	a := Sphere{Center: Vec3{X: -1}, R: 1}
+	b := Sphere{Center: Vec3{X: 1}, R: 1}
+	c := Box{Min: Vec3{-3, -3, -3}, Max: Vec3{0, 0, 0}}
+
+	tests := []struct {
+		op   CSGOp
+		want Bounds3
+	}{
+		{CSGUnion, Bounds3{PMin: Point3{-3, -3, -3}, PMax: Point3{2, 1, 1}}},
+		{CSGIntersection, Bounds3{PMin: Point3{0, -1, -1}, PMax: Point3{0, 0, 0}}},
+		{CSGDifference, Bounds3{PMin: Point3{-2, -1, -1}, PMax: Point3{0, 1, 1}}},
+	}
+	for _, test := range tests {
+		csg := mustCSG(t, test.op, mustCSG(t, test.op, a, b), c)
+		if got := csg.Bounds(); got != test.want {
+			t.Errorf("%s: bounds %v, want %v", test.op, got, test.want)
+		}
+		if parts := csg.Parts(); len(parts) != 3 || parts[0] != a || parts[1] != b || parts[2] != c {
+			t.Errorf("%s: parts %v", test.op, parts)
+		}
+	}
+
+	// a different operation on the left is a part of its own
+	csg := mustCSG(t, CSGDifference, mustCSG(t, CSGUnion, a, b), c)
+	if parts := csg.Parts(); len(parts) != 2 || parts[0] != csg.Left {
+		t.Errorf("parts %v", parts)
+	}
+
+	if _, err := NewCSG("xor", a, b); err == nil {
+		t.Error("NewCSG accepted an unknown operation")
+	}
+}
@@ -0,0 +1,257 @@
+package geometry
+
+import (
+	"math"
+
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// Cylinder represents a cylinder around the axis from Base to Top. The ends
+// are closed by disks unless the cylinder is Open.
+type Cylinder struct {
+	Base, Top Vec3
+	Radius    float64
+	Open      bool
+	Material  shading.Material
+}
+
+// Intersect computes the intersection of a ray with the cylinder.
+func (c Cylinder) Intersect(r Ray) *HitRecord {
+	hit := intersectCone(r, c.Base, c.Top, c.Radius, c.Radius, c.Open)
+	if hit != nil {
+		hit.Primitive, hit.Material = c, c.Material
+	}
+	return hit
+}
+
+// Hits returns where the line of the ray enters and leaves the cylinder.
+// An open cylinder has no inside and so no hits.
+func (c Cylinder) Hits(r Ray) []HitRecord {
+	if c.Open {
+		return nil
+	}
+	return convexHits(coneHits(r, c.Base, c.Top, c.Radius, c.Radius, false), c, c.Material)
+}
+
+// Bounds returns the bounding box of the cylinder.
+func (c Cylinder) Bounds() Bounds3 {
+	axis := c.Top.Sub(c.Base).Normalize()
+	return circleBounds(c.Base, axis, c.Radius).Union(circleBounds(c.Top, axis, c.Radius))
+}
+
+// Cone represents a cone around the axis from Base to Top with the radius
+// Radius at the base. A nonzero TopRadius cuts the cone short of its apex.
+// The ends are closed by disks unless the cone is Open.
+type Cone struct {
+	Base, Top Vec3
+	Radius    float64
+	TopRadius float64
+	Open      bool
+	Material  shading.Material
+}
+
+// Intersect computes the intersection of a ray with the cone.
+func (c Cone) Intersect(r Ray) *HitRecord {
+	hit := intersectCone(r, c.Base, c.Top, c.Radius, c.TopRadius, c.Open)
+	if hit != nil {
+		hit.Primitive, hit.Material = c, c.Material
+	}
+	return hit
+}
+
+// Hits returns where the line of the ray enters and leaves the cone. An
+// open cone has no inside and so no hits.
+func (c Cone) Hits(r Ray) []HitRecord {
+	if c.Open {
+		return nil
+	}
+	return convexHits(coneHits(r, c.Base, c.Top, c.Radius, c.TopRadius, false), c, c.Material)
+}
+
+// Bounds returns the bounding box of the cone.
+func (c Cone) Bounds() Bounds3 {
+	axis := c.Top.Sub(c.Base).Normalize()
+	return circleBounds(c.Base, axis, c.Radius).Union(circleBounds(c.Top, axis, c.TopRadius))
+}
+
+// convexHits returns the first and the last of the hits of a line with a
+// convex solid, which are where it enters and leaves the solid.
+func convexHits(hits []HitRecord, prim Primitive, material shading.Material) []HitRecord {
+	if len(hits) < 2 {
+		return nil
+	}
+
+	first, last := 0, 0
+	for i, h := range hits {
+		if h.T < hits[first].T {
+			first = i
+		}
+		if h.T > hits[last].T {
+			last = i
+		}
+	}
+
+	res := []HitRecord{hits[first], hits[last]}
+	for i := range res {
+		res[i].Primitive, res[i].Material = prim, material
+	}
+	return res
+}
+
+// intersectCone intersects a ray with the cone from base with radius r0 to
+// top with radius r1. The inside of an open cone is seen from both sides.
+func intersectCone(r Ray, base, top Vec3, r0, r1 float64, open bool) *HitRecord {
+	var hit *HitRecord
+	hits := coneHits(r, base, top, r0, r1, open)
+	for i := range hits {
+		if hits[i].T >= 0 && (hit == nil || hits[i].T < hit.T) {
+			hit = &hits[i]
+		}
+	}
+
+	if hit != nil && open && hit.Normal.Dot(r.Direction) > 0 {
+		hit.Normal = hit.Normal.Scale(-1)
+	}
+	return hit
+}
+
+// coneHits returns the hits of the line of the ray with the side of the
+// cone from base with radius r0 to top with radius r1 and, unless it is
+// open, with its caps. The normals point outwards. The side is mapped with
+// u around the axis and v from the base to the top, the caps are mapped
+// like a square around them.
+func coneHits(r Ray, base, top Vec3, r0, r1 float64, open bool) []HitRecord {
+	axis := top.Sub(base)
+	h := axis.Norm()
+	a := axis.Scale(1 / h)
+	e1, e2 := basis(a)
+
+	// the ray relative to the base, split along the axis and across it
+	o := r.Origin.Sub(base)
+	oy, dy := o.Dot(a), r.Direction.Dot(a)
+	op, dp := o.Sub(a.Scale(oy)), r.Direction.Sub(a.Scale(dy))
+
+	// the radius grows by k per unit of height: |p across| = r0 + k*y
+	k := (r1 - r0) / h
+	ro := r0 + k*oy
+
+	var hits []HitRecord
+	for _, t := range solveQuadratic(dp.Dot(dp)-k*k*dy*dy, 2*(op.Dot(dp)-k*ro*dy), op.Dot(op)-ro*ro) {
+		y := oy + t*dy
+		if y < 0 || y > h {
+			continue
+		}
+
+		p := op.Add(dp.Scale(t))
+		phi := math.Atan2(p.Dot(e2), p.Dot(e1))
+		sin, cos := math.Sincos(phi)
+		radial := e1.Scale(cos).Add(e2.Scale(sin))
+		hits = append(hits, HitRecord{
+			T:      t,
+			Normal: radial.Sub(a.Scale(k)).Normalize(),
+			UV:     UV{U: .5 + phi/(2*math.Pi), V: y / h},
+			DPDU:   e2.Scale(cos).Sub(e1.Scale(sin)).Scale(2 * math.Pi * (r0 + k*y)),
+			DPDV:   axis.Add(radial.Scale(r1 - r0)),
+		})
+	}
+
+	if open || dy == 0 {
+		return hits
+	}
+
+	capRadius := max(r0, r1)
+	for _, c := range []struct {
+		y, radius float64
+		normal    Vec3
+	}{{0, r0, a.Scale(-1)}, {h, r1, a}} {
+		t := (c.y - oy) / dy
+		if p := op.Add(dp.Scale(t)); p.Dot(p) <= c.radius*c.radius {
+			hits = append(hits, HitRecord{
+				T:      t,
+				Normal: c.normal,
+				UV:     UV{U: .5 + p.Dot(e1)/(2*capRadius), V: .5 + p.Dot(e2)/(2*capRadius)},
+				DPDU:   e1.Scale(2 * capRadius),
+				DPDV:   e2.Scale(2 * capRadius),
+			})
+		}
+	}
+
+	return hits
+}
+
+// Disk represents a disk around Center facing Normal. A nonzero Inner
+// radius cuts a hole into the disk, which makes it an annulus.
+type Disk struct {
+	Center, Normal Vec3
+	Radius         float64
+	Inner          float64
+	Material       shading.Material
+}
+
+// Intersect computes the intersection of a ray with the disk. The disk is
+// seen from both sides, u goes around the center and v from the inner to the
+// outer edge.
+func (d Disk) Intersect(r Ray) *HitRecord {
+	n := d.Normal.Normalize()
+	denom := n.Dot(r.Direction)
+	if math.Abs(denom) < 1e-12 {
+		return nil
+	}
+
+	t := d.Center.Sub(r.Origin).Dot(n) / denom
+	if t < 0 {
+		return nil
+	}
+
+	p := r.At(t).Sub(d.Center)
+	rho := p.Norm()
+	if rho > d.Radius || rho < d.Inner {
+		return nil
+	}
+
+	e1, e2 := basis(n)
+	phi := math.Atan2(p.Dot(e2), p.Dot(e1))
+	sin, cos := math.Sincos(phi)
+	radial := e1.Scale(cos).Add(e2.Scale(sin))
+	if denom > 0 {
+		n = n.Scale(-1)
+	}
+
+	return &HitRecord{
+		T:         t,
+		Primitive: d,
+		Material:  d.Material,
+		Normal:    n,
+		UV:        UV{U: .5 + phi/(2*math.Pi), V: (rho - d.Inner) / (d.Radius - d.Inner)},
+		DPDU:      e2.Scale(cos).Sub(e1.Scale(sin)).Scale(2 * math.Pi * rho),
+		DPDV:      radial.Scale(d.Radius - d.Inner),
+	}
+}
+
+// Bounds returns the bounding box of the disk.
+func (d Disk) Bounds() Bounds3 {
+	return circleBounds(d.Center, d.Normal.Normalize(), d.Radius)
+}
+
+// basis returns two unit vectors that are perpendicular to the unit vector n
+// and to each other. For the Y axis they are the X and the Z axis.
+func basis(n Vec3) (Vec3, Vec3) {
+	helper := Vec3{X: 1}
+	if math.Abs(n.X) > .9 {
+		helper = Vec3{Y: 1}
+	}
+	e2 := helper.Cross(n).Normalize()
+	return n.Cross(e2), e2
+}
+
+// circleBounds returns the bounding box of a circle around center in the
+// plane with the unit normal n.
+func circleBounds(center, n Vec3, radius float64) Bounds3 {
+	ext := Vec3{
+		X: radius * math.Sqrt(max(0, 1-n.X*n.X)),
+		Y: radius * math.Sqrt(max(0, 1-n.Y*n.Y)),
+		Z: radius * math.Sqrt(max(0, 1-n.Z*n.Z)),
+	}
+	lo, hi := center.Sub(ext), center.Add(ext)
+	return Bounds3{PMin: Point3{X: lo.X, Y: lo.Y, Z: lo.Z}, PMax: Point3{X: hi.X, Y: hi.Y, Z: hi.Z}}
+}
@@ -0,0 +1,428 @@
+package geometry
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// HeightMap is a grid of Width by Depth heights between 0 and 1. Row k of
+// Heights holds the samples at z = k; the first row is the bottom row of
+// the image, so that the top of the image is the far side of the terrain.
+type HeightMap struct {
+	Width, Depth int
+	Heights      []float64
+}
+
+// At returns the height of the sample in column i of row k.
+func (m *HeightMap) At(i, k int) float64 {
+	return m.Heights[k*m.Width+i]
+}
+
+// LoadHeightMap reads a grayscale image in the format given by its
+// extension: .png, of 8 or 16 bits, or .pgm, binary PGM of 8 or 16 bits.
+// The heights are the gray levels scaled to [0, 1]; colors are converted
+// to gray.
+func LoadHeightMap(fName string) (*HeightMap, error) {
+	f, err := os.Open(fName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var m *HeightMap
+	switch ext := strings.ToLower(filepath.Ext(fName)); ext {
+	case ".png":
+		var img image.Image
+		if img, err = png.Decode(f); err == nil {
+			m = NewHeightMap(img)
+		}
+	case ".pgm":
+		m, err = ReadPGM(f)
+	default:
+		return nil, fmt.Errorf("%s: unknown height map format %q", fName, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fName, err)
+	}
+	if m.Width < 2 || m.Depth < 2 {
+		return nil, fmt.Errorf("%s: a height map needs at least 2 by 2 pixels, got %d by %d", fName, m.Width, m.Depth)
+	}
+	return m, nil
+}
+
+// NewHeightMap converts the gray levels of an image into heights with the
+// full precision of 16-bit images.
+func NewHeightMap(img image.Image) *HeightMap {
+	b := img.Bounds()
+	m := &HeightMap{Width: b.Dx(), Depth: b.Dy(), Heights: make([]float64, b.Dx()*b.Dy())}
+	for y := 0; y < m.Depth; y++ {
+		k := m.Depth - 1 - y
+		for x := 0; x < m.Width; x++ {
+			gray := color.Gray16Model.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray16)
+			m.Heights[k*m.Width+x] = float64(gray.Y) / 0xFFFF
+		}
+	}
+	return m
+}
+
+// ReadPGM reads a binary (P5) PGM image as a height map. Samples are one
+// byte for a maximum gray value below 256 and two big-endian bytes
+// otherwise.
+func ReadPGM(r io.Reader) (*HeightMap, error) {
+	br := bufio.NewReader(r)
+
+	// the header is the magic number, width, height and maximum value,
+	// separated by whitespace and comments, and a single whitespace
+	var header [4]int
+	magic, err := pgmToken(br)
+	if err != nil {
+		return nil, err
+	}
+	if magic != "P5" {
+		return nil, fmt.Errorf("not a binary PGM file: %q", magic)
+	}
+	for i := 1; i < 4; i++ {
+		tok, err := pgmToken(br)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fmt.Sscanf(tok, "%d", &header[i]); err != nil || header[i] <= 0 {
+			return nil, fmt.Errorf("bad PGM header value %q", tok)
+		}
+	}
+	width, depth, maxVal := header[1], header[2], header[3]
+	if maxVal > 0xFFFF {
+		return nil, fmt.Errorf("bad PGM maximum value %d", maxVal)
+	}
+
+	size := 1
+	if maxVal > 0xFF {
+		size = 2
+	}
+	data := make([]byte, width*depth*size)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return nil, fmt.Errorf("PGM pixels: %w", err)
+	}
+
+	m := &HeightMap{Width: width, Depth: depth, Heights: make([]float64, width*depth)}
+	for y := 0; y < depth; y++ {
+		k := depth - 1 - y
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			v := float64(data[i])
+			if size == 2 {
+				v = float64(binary.BigEndian.Uint16(data[2*i:]))
+			}
+			m.Heights[k*width+x] = min(v/float64(maxVal), 1)
+		}
+	}
+	return m, nil
+}
+
+// pgmToken reads a token of a PGM header and the whitespace after it.
+func pgmToken(br *bufio.Reader) (string, error) {
+	var tok []byte
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return "", fmt.Errorf("PGM header: %w", err)
+		}
+		switch {
+		case c == '#' && len(tok) == 0:
+			if _, err := br.ReadString('\n'); err != nil {
+				return "", fmt.Errorf("PGM header: %w", err)
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if len(tok) > 0 {
+				return string(tok), nil
+			}
+		default:
+			tok = append(tok, c)
+		}
+	}
+}
+
+// heightfieldLeaf is the largest number of cells along each side of a leaf
+// of the quadtree of a heightfield.
+const heightfieldLeaf = 4
+
+// Heightfield is a terrain whose heights come from a height map. The first
+// sample is at Origin; Scale.X and Scale.Z are the distances between the
+// samples and Scale.Y the height of a sample of 1. Every cell between four
+// samples is split into two triangles, which are shaded with normals
+// interpolated from the slopes of the height map. Texture coordinates go
+// from 0 to 1 over the terrain with v along Z.
+//
+// Rays are traced through a quadtree of the cells that holds the lowest and
+// highest point of each node. Like the triangles of a mesh, the triangles
+// of a heightfield are separate primitives in its hits, so that the
+// terrain shadows itself.
+type Heightfield struct {
+	File          string
+	Origin, Scale Vec3
+	Material      shading.Material
+
+	data    *HeightMap
+	normals []Vec3
+	root    heightfieldNode
+	nodes   []heightfieldNode
+}
+
+// heightfieldNode is a node of the quadtree over the cells [x0, x1) by
+// [z0, z1) with the heights lo to hi in world space. The children of inner
+// nodes are nodes[child:child+count].
+type heightfieldNode struct {
+	x0, z0, x1, z1 int32
+	lo, hi         float64
+	child, count   int32
+}
+
+// NewHeightfield builds the normals and the quadtree of a height map of at
+// least 2 by 2 samples, placed at origin with the given scale.
+func NewHeightfield(file string, data *HeightMap, origin, scale Vec3, material shading.Material) *Heightfield {
+	h := &Heightfield{File: file, Origin: origin, Scale: scale, Material: material, data: data}
+
+	// the normals are the cross products of the central differences
+	h.normals = make([]Vec3, len(data.Heights))
+	for k := 0; k < data.Depth; k++ {
+		for i := 0; i < data.Width; i++ {
+			i0, i1 := max(i-1, 0), min(i+1, data.Width-1)
+			k0, k1 := max(k-1, 0), min(k+1, data.Depth-1)
+			dx := Vec3{X: float64(i1-i0) * scale.X, Y: (data.At(i1, k) - data.At(i0, k)) * scale.Y}
+			dz := Vec3{Y: (data.At(i, k1) - data.At(i, k0)) * scale.Y, Z: float64(k1-k0) * scale.Z}
+			h.normals[k*data.Width+i] = dz.Cross(dx).Normalize()
+		}
+	}
+
+	h.root = h.build(0, 0, int32(data.Width-1), int32(data.Depth-1))
+	return h
+}
+
+// build returns the node of the cells [x0, x1) by [z0, z1) and adds the
+// nodes below it.
+func (h *Heightfield) build(x0, z0, x1, z1 int32) heightfieldNode {
+	n := heightfieldNode{x0: x0, z0: z0, x1: x1, z1: z1, lo: math.Inf(1), hi: math.Inf(-1)}
+	if x1-x0 <= heightfieldLeaf && z1-z0 <= heightfieldLeaf {
+		for k := z0; k <= z1; k++ {
+			for i := x0; i <= x1; i++ {
+				y := h.vertex(i, k).Y
+				n.lo, n.hi = min(n.lo, y), max(n.hi, y)
+			}
+		}
+		return n
+	}
+
+	xs, zs := halves(x0, x1), halves(z0, z1)
+	n.child = int32(len(h.nodes))
+	n.count = int32((len(xs) - 1) * (len(zs) - 1))
+	h.nodes = append(h.nodes, make([]heightfieldNode, n.count)...)
+	c := n.child
+	for a := 0; a+1 < len(xs); a++ {
+		for b := 0; b+1 < len(zs); b++ {
+			child := h.build(xs[a], zs[b], xs[a+1], zs[b+1])
+			h.nodes[c] = child
+			n.lo, n.hi = min(n.lo, child.lo), max(n.hi, child.hi)
+			c++
+		}
+	}
+	return n
+}
+
+// halves returns the bounds of the halves of [lo, hi), or of the range
+// itself when it fits into a leaf.
+func halves(lo, hi int32) []int32 {
+	if hi-lo <= heightfieldLeaf {
+		return []int32{lo, hi}
+	}
+	return []int32{lo, (lo + hi) / 2, hi}
+}
+
+// vertex returns the sample in column i of row k in world space.
+func (h *Heightfield) vertex(i, k int32) Vec3 {
+	return Vec3{
+		X: h.Origin.X + float64(i)*h.Scale.X,
+		Y: h.Origin.Y + h.data.At(int(i), int(k))*h.Scale.Y,
+		Z: h.Origin.Z + float64(k)*h.Scale.Z,
+	}
+}
+
+// box returns the bounding box of a node.
+func (h *Heightfield) box(n *heightfieldNode) Box {
+	a := h.vertex(n.x0, n.z0)
+	b := h.vertex(n.x1, n.z1)
+	return Box{
+		Min: Vec3{X: min(a.X, b.X), Y: n.lo, Z: min(a.Z, b.Z)},
+		Max: Vec3{X: max(a.X, b.X), Y: n.hi, Z: max(a.Z, b.Z)},
+	}
+}
+
+// heightfieldHit is the closest hit found so far: the triangle and its
+// barycentric coordinates.
+type heightfieldHit struct {
+	t, u, v  float64
+	triangle int32
+}
+
+// Intersect computes the closest hit of a ray with the triangles of the
+// terrain by visiting the nodes of the quadtree the ray goes through from
+// near to far.
+func (h *Heightfield) Intersect(r Ray) *HitRecord {
+	best := heightfieldHit{t: math.Inf(1), triangle: -1}
+	box := h.box(&h.root)
+	if tNear, tFar, _, _, ok := box.slabs(r.Origin, r.Direction); ok && tFar >= 0 && tNear < best.t {
+		h.visit(&h.root, r, &best)
+	}
+	if best.triangle < 0 {
+		return nil
+	}
+	return h.hit(best)
+}
+
+func (h *Heightfield) visit(n *heightfieldNode, r Ray, best *heightfieldHit) {
+	if n.count == 0 {
+		for k := n.z0; k < n.z1; k++ {
+			for i := n.x0; i < n.x1; i++ {
+				cell := k*int32(h.data.Width-1) + i
+				h.intersectTriangle(2*cell, r, best)
+				h.intersectTriangle(2*cell+1, r, best)
+			}
+		}
+		return
+	}
+
+	// the children the ray enters, by distance
+	var order [4]struct {
+		t    float64
+		node *heightfieldNode
+	}
+	m := 0
+	for c := n.child; c < n.child+n.count; c++ {
+		child := &h.nodes[c]
+		box := h.box(child)
+		tNear, tFar, _, _, ok := box.slabs(r.Origin, r.Direction)
+		if !ok || tFar < 0 || tNear >= best.t {
+			continue
+		}
+		j := m
+		for ; j > 0 && order[j-1].t > tNear; j-- {
+			order[j] = order[j-1]
+		}
+		order[j].t, order[j].node = tNear, child
+		m++
+	}
+	for _, o := range order[:m] {
+		if o.t < best.t {
+			h.visit(o.node, r, best)
+		}
+	}
+}
+
+// corners returns the vertices of a triangle: the cells are split along
+// the diagonal from their corner at the lowest x and z.
+func (h *Heightfield) corners(triangle int32) (i, k [3]int32) {
+	cell := triangle / 2
+	x, z := cell%int32(h.data.Width-1), cell/int32(h.data.Width-1)
+	if triangle%2 == 0 {
+		return [3]int32{x, x, x + 1}, [3]int32{z, z + 1, z + 1}
+	}
+	return [3]int32{x, x + 1, x + 1}, [3]int32{z, z + 1, z}
+}
+
+// intersectTriangle updates best with the hit of the ray with a triangle
+// closer than it.
+func (h *Heightfield) intersectTriangle(triangle int32, r Ray, best *heightfieldHit) {
+	const epsilon = 0.000001
+	i, k := h.corners(triangle)
+	v0, v1, v2 := h.vertex(i[0], k[0]), h.vertex(i[1], k[1]), h.vertex(i[2], k[2])
+
+	edge1, edge2 := v1.Sub(v0), v2.Sub(v0)
+	p := r.Direction.Cross(edge2)
+	det := edge1.Dot(p)
+	if math.Abs(det) < epsilon*epsilon {
+		return
+	}
+	inv := 1 / det
+	s := r.Origin.Sub(v0)
+	u := s.Dot(p) * inv
+	if u < 0 || u > 1 {
+		return
+	}
+	q := s.Cross(edge1)
+	v := r.Direction.Dot(q) * inv
+	if v < 0 || u+v > 1 {
+		return
+	}
+	if t := edge2.Dot(q) * inv; t > epsilon && t < best.t {
+		*best = heightfieldHit{t: t, u: u, v: v, triangle: triangle}
+	}
+}
+
+// hit returns the hit record of a hit with a triangle.
+func (h *Heightfield) hit(best heightfieldHit) *HitRecord {
+	i, k := h.corners(best.triangle)
+	w := [3]float64{1 - best.u - best.v, best.u, best.v}
+
+	var n Vec3
+	var x, z float64
+	for j := range w {
+		n = n.Add(h.normals[int(k[j])*h.data.Width+int(i[j])].Scale(w[j]))
+		x += w[j] * float64(i[j])
+		z += w[j] * float64(k[j])
+	}
+
+	width, depth := float64(h.data.Width-1), float64(h.data.Depth-1)
+	return &HitRecord{
+		T:         best.t,
+		Primitive: heightfieldTriangle{h, best.triangle},
+		Material:  h.Material,
+		Normal:    n.Normalize(),
+		UV:        UV{U: x / width, V: z / depth},
+		DPDU:      Vec3{X: width * h.Scale.X},
+		DPDV:      Vec3{Z: depth * h.Scale.Z},
+	}
+}
+
+// Bounds returns the bounding box of the terrain.
+func (h *Heightfield) Bounds() Bounds3 {
+	box := h.box(&h.root)
+	return Bounds3{
+		PMin: Point3{X: box.Min.X, Y: box.Min.Y, Z: box.Min.Z},
+		PMax: Point3{X: box.Max.X, Y: box.Max.Y, Z: box.Max.Z},
+	}
+}
+
+// heightfieldTriangle is a triangle of a heightfield, the primitive of its
+// hits.
+type heightfieldTriangle struct {
+	h        *Heightfield
+	triangle int32
+}
+
+func (t heightfieldTriangle) Intersect(r Ray) *HitRecord {
+	best := heightfieldHit{t: math.Inf(1), triangle: -1}
+	t.h.intersectTriangle(t.triangle, r, &best)
+	if best.triangle < 0 {
+		return nil
+	}
+	return t.h.hit(best)
+}
+
+func (t heightfieldTriangle) Bounds() Bounds3 {
+	i, k := t.h.corners(t.triangle)
+	v0, v1, v2 := t.h.vertex(i[0], k[0]), t.h.vertex(i[1], k[1]), t.h.vertex(i[2], k[2])
+	return Bounds3{
+		PMin: Point3{X: min(v0.X, v1.X, v2.X), Y: min(v0.Y, v1.Y, v2.Y), Z: min(v0.Z, v1.Z, v2.Z)},
+		PMax: Point3{X: max(v0.X, v1.X, v2.X), Y: max(v0.Y, v1.Y, v2.Y), Z: max(v0.Z, v1.Z, v2.Z)},
+	}
+}
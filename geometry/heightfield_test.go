@@ -0,0 +1,167 @@
+package geometry
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danradchuk/raytracer/shading"
+)
+
+func TestReadPGM(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []float64
+	}{
+		{"8 bits", "P5\n# a comment\n2 2\n255\n\x00\xff\x33\x66", []float64{.2, .4, 0, 1}},
+		{"16 bits", "P5 2 2 65535\n\x00\x00\xff\xff\x80\x00\x00\x01", []float64{32768. / 65535, 1. / 65535, 0, 1}},
+	}
+	for _, test := range tests {
+		m, err := ReadPGM(strings.NewReader(test.data))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if m.Width != 2 || m.Depth != 2 {
+			t.Fatalf("%s: %d by %d samples", test.name, m.Width, m.Depth)
+		}
+		for i, h := range m.Heights {
+			if math.Abs(h-test.want[i]) > 1e-12 {
+				t.Errorf("%s: heights %v, want %v", test.name, m.Heights, test.want)
+				break
+			}
+		}
+	}
+
+	for _, data := range []string{"P2 2 2 255\n0 0 0 0", "P5 2 x 255\n", "P5 2 2 255\n\x00"} {
+		if _, err := ReadPGM(strings.NewReader(data)); err == nil {
+			t.Errorf("ReadPGM(%q) succeeded", data)
+		}
+	}
+}
+
+func TestLoadHeightMap(t *testing.T) {
+	img := image.NewGray16(image.Rect(0, 0, 3, 2))
+	img.SetGray16(0, 0, color.Gray16{Y: 0xFFFF})
+	img.SetGray16(2, 1, color.Gray16{Y: 1})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "terrain.png")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := LoadHeightMap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the top row of the image is the far row
+	want := []float64{0, 0, 1. / 0xFFFF, 1, 0, 0}
+	if m.Width != 3 || m.Depth != 2 || len(m.Heights) != len(want) {
+		t.Fatalf("got %d by %d samples", m.Width, m.Depth)
+	}
+	for i := range want {
+		if m.Heights[i] != want[i] {
+			t.Fatalf("heights %v, want %v", m.Heights, want)
+		}
+	}
+
+	if _, err := LoadHeightMap(filepath.Join(t.TempDir(), "terrain.tif")); err == nil {
+		t.Error("LoadHeightMap accepted a .tif file")
+	}
+}
+
+// TestHeightfieldIntersect compares the hits of random rays with the
+// closest hits of the triangles of the tessellated terrain.
+func TestHeightfieldIntersect(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	data := &HeightMap{Width: 37, Depth: 23, Heights: make([]float64, 37*23)}
+	for i := range data.Heights {
+		data.Heights[i] = rng.Float64()
+	}
+	h := NewHeightfield("terrain.png", data, Vec3{-3, 1, -2}, Vec3{.2, 2, .25}, shading.Ivory)
+
+	m, err := Tessellate(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.NumTriangles() != 2*36*22 {
+		t.Fatalf("%d triangles", m.NumTriangles())
+	}
+	triangles := m.GetTrianglesFromMesh(shading.Ivory)
+
+	hits := 0
+	for i := 0; i < 500; i++ {
+		target := Vec3{-3 + 7.2*rng.Float64(), 1 + 2*rng.Float64(), -2 + 5.5*rng.Float64()}
+		dir := Vec3{rng.NormFloat64(), rng.NormFloat64(), rng.NormFloat64()}.Normalize()
+		r := Ray{Origin: target.Sub(dir.Scale(10)), Direction: dir}
+
+		want := math.Inf(1)
+		for _, tri := range triangles {
+			if hit := tri.Intersect(r); hit != nil {
+				want = min(want, hit.T)
+			}
+		}
+
+		hit := h.Intersect(r)
+		if hit == nil {
+			if !math.IsInf(want, 1) {
+				t.Fatalf("ray %d: no hit, want %v", i, want)
+			}
+			continue
+		}
+		hits++
+		if math.Abs(hit.T-want) > 1e-9 {
+			t.Fatalf("ray %d: hit at %v, want %v", i, hit.T, want)
+		}
+		if hit.Primitive == Primitive(h) {
+			t.Fatalf("ray %d: the hit is of the whole terrain", i)
+		}
+		if again := hit.Primitive.Intersect(r); again == nil || again.T != hit.T {
+			t.Fatalf("ray %d: the triangle of the hit is hit at %+v", i, again)
+		}
+		if hit.UV.U < 0 || hit.UV.U > 1 || hit.UV.V < 0 || hit.UV.V > 1 || math.Abs(hit.Normal.Norm()-1) > 1e-9 {
+			t.Fatalf("ray %d: UV %v and normal %v", i, hit.UV, hit.Normal)
+		}
+	}
+	if hits < 100 {
+		t.Errorf("only %d of the rays hit", hits)
+	}
+}
+
+func TestHeightfieldNormals(t *testing.T) {
+	// a slope rising by 1 over 2 along X
+	data := &HeightMap{Width: 5, Depth: 3, Heights: make([]float64, 15)}
+	for k := 0; k < 3; k++ {
+		for i := 0; i < 5; i++ {
+			data.Heights[k*5+i] = float64(i) / 4
+		}
+	}
+	h := NewHeightfield("", data, Vec3{}, Vec3{.5, 1, 1}, shading.Material{})
+
+	hit := h.Intersect(Ray{Origin: Vec3{X: 1.1, Y: 5, Z: 1.3}, Direction: Vec3{Y: -1}})
+	if hit == nil {
+		t.Fatal("no hit")
+	}
+	want := Vec3{X: -1, Y: 2}.Normalize()
+	if math.Abs(hit.T-(5-.55)) > 1e-9 || !near(hit.Normal, want) {
+		t.Errorf("hit at %v with normal %v, want %v and %v", hit.T, hit.Normal, 5-.55, want)
+	}
+	if math.Abs(hit.UV.U-.55) > 1e-9 || math.Abs(hit.UV.V-.65) > 1e-9 {
+		t.Errorf("UV = %v", hit.UV)
+	}
+
+	b := h.Bounds()
+	if b.PMin != (Point3{}) || b.PMax != (Point3{X: 2, Y: 1, Z: 2}) {
+		t.Errorf("bounds %v", b)
+	}
+}
@@ -0,0 +1,441 @@
+package geometry
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// MaxPolynomialDegree is the highest degree of an implicit surface.
+const MaxPolynomialDegree = 16
+
+// Monomial is the term Coeff * x^X * y^Y * z^Z of a polynomial.
+type Monomial struct {
+	Coeff   float64
+	X, Y, Z int
+}
+
+func (m Monomial) degree() int {
+	return m.X + m.Y + m.Z
+}
+
+// Polynomial is a polynomial in x, y and z, the sum of its monomials. The
+// polynomials of ParsePolynomial have no zero and no like terms and start
+// with the highest degree.
+type Polynomial []Monomial
+
+// ParsePolynomial parses an equation such as "(x^2 + y^2 + z^2 + 3)^2 -
+// 16*(x^2 + z^2)" made of numbers, x, y and z, parentheses, +, -, * and /
+// by numbers, and ^ to whole powers, and expands it into a polynomial.
+func ParsePolynomial(s string) (Polynomial, error) {
+	p := &polyParser{s: s}
+	terms, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos < len(p.s) {
+		return nil, p.errorf("unexpected %q", p.s[p.pos])
+	}
+	return terms.polynomial(), nil
+}
+
+// Degree returns the highest degree of the monomials.
+func (p Polynomial) Degree() int {
+	n := 0
+	for _, m := range p {
+		n = max(n, m.degree())
+	}
+	return n
+}
+
+// Eval returns the value of the polynomial at p.
+func (p Polynomial) Eval(v Vec3) float64 {
+	f := 0.
+	for _, m := range p {
+		f += m.Coeff * ipow(v.X, m.X) * ipow(v.Y, m.Y) * ipow(v.Z, m.Z)
+	}
+	return f
+}
+
+// Gradient returns the gradient of the polynomial at v.
+func (p Polynomial) Gradient(v Vec3) Vec3 {
+	var g Vec3
+	for _, m := range p {
+		x, y, z := ipow(v.X, m.X), ipow(v.Y, m.Y), ipow(v.Z, m.Z)
+		if m.X > 0 {
+			g.X += m.Coeff * float64(m.X) * ipow(v.X, m.X-1) * y * z
+		}
+		if m.Y > 0 {
+			g.Y += m.Coeff * float64(m.Y) * x * ipow(v.Y, m.Y-1) * z
+		}
+		if m.Z > 0 {
+			g.Z += m.Coeff * float64(m.Z) * x * y * ipow(v.Z, m.Z-1)
+		}
+	}
+	return g
+}
+
+// along returns the coefficients, lowest first, of the polynomial on the
+// line o + s*d as a polynomial in s.
+func (p Polynomial) along(o, d Vec3) []float64 {
+	n := p.Degree()
+
+	// powers[axis][k] holds the coefficients of (o + s*d)^k along the axis
+	var powers [3][][]float64
+	for axis, line := range [3][2]float64{{o.X, d.X}, {o.Y, d.Y}, {o.Z, d.Z}} {
+		powers[axis] = make([][]float64, n+1)
+		powers[axis][0] = []float64{1}
+		for k := 1; k <= n; k++ {
+			powers[axis][k] = polyMul(powers[axis][k-1], line[:])
+		}
+	}
+
+	c := make([]float64, n+1)
+	for _, m := range p {
+		for i, v := range polyMul(polyMul(powers[0][m.X], powers[1][m.Y]), powers[2][m.Z]) {
+			c[i] += m.Coeff * v
+		}
+	}
+	return c
+}
+
+// String writes the polynomial in the syntax of ParsePolynomial.
+func (p Polynomial) String() string {
+	if len(p) == 0 {
+		return "0"
+	}
+
+	var b strings.Builder
+	for i, m := range p {
+		c := m.Coeff
+		switch {
+		case i > 0 && c < 0:
+			b.WriteString(" - ")
+			c = -c
+		case i > 0:
+			b.WriteString(" + ")
+		case c < 0:
+			b.WriteString("-")
+			c = -c
+		}
+
+		var vars []string
+		for _, v := range []struct {
+			name  string
+			power int
+		}{{"x", m.X}, {"y", m.Y}, {"z", m.Z}} {
+			switch {
+			case v.power == 1:
+				vars = append(vars, v.name)
+			case v.power > 1:
+				vars = append(vars, v.name+"^"+strconv.Itoa(v.power))
+			}
+		}
+		if c != 1 || len(vars) == 0 {
+			vars = slices.Insert(vars, 0, strconv.FormatFloat(c, 'g', -1, 64))
+		}
+		b.WriteString(strings.Join(vars, "*"))
+	}
+	return b.String()
+}
+
+// ipow returns x^n for n >= 0.
+func ipow(x float64, n int) float64 {
+	f := 1.
+	for ; n > 0; n-- {
+		f *= x
+	}
+	return f
+}
+
+// polyMul multiplies the polynomials with the coefficients a and b, lowest
+// first.
+func polyMul(a, b []float64) []float64 {
+	c := make([]float64, len(a)+len(b)-1)
+	for i, x := range a {
+		for j, y := range b {
+			c[i+j] += x * y
+		}
+	}
+	return c
+}
+
+// terms is a polynomial being parsed, the coefficients of its monomials by
+// their powers of x, y and z.
+type terms map[[3]int]float64
+
+func (t terms) degree() int {
+	n := 0
+	for k := range t {
+		n = max(n, k[0]+k[1]+k[2])
+	}
+	return n
+}
+
+func (t terms) add(u terms, sign float64) terms {
+	sum := make(terms, len(t)+len(u))
+	for k, c := range t {
+		sum[k] += c
+	}
+	for k, c := range u {
+		sum[k] += sign * c
+	}
+	return sum
+}
+
+func (t terms) mul(u terms) terms {
+	prod := make(terms)
+	for k1, c1 := range t {
+		for k2, c2 := range u {
+			prod[[3]int{k1[0] + k2[0], k1[1] + k2[1], k1[2] + k2[2]}] += c1 * c2
+		}
+	}
+	return prod
+}
+
+// constant returns the value of a polynomial without variables.
+func (t terms) constant() (float64, bool) {
+	for k, c := range t {
+		if k != [3]int{} && c != 0 {
+			return 0, false
+		}
+	}
+	return t[[3]int{}], true
+}
+
+// polynomial returns the nonzero terms, sorted by decreasing degree and then
+// by decreasing powers of x, y and z.
+func (t terms) polynomial() Polynomial {
+	var p Polynomial
+	for k, c := range t {
+		if c != 0 {
+			p = append(p, Monomial{Coeff: c, X: k[0], Y: k[1], Z: k[2]})
+		}
+	}
+	slices.SortFunc(p, func(a, b Monomial) int {
+		if a.degree() != b.degree() {
+			return b.degree() - a.degree()
+		}
+		if a.X != b.X {
+			return b.X - a.X
+		}
+		return b.Y - a.Y
+	})
+	return p
+}
+
+// polyParser parses an equation by recursive descent.
+type polyParser struct {
+	s   string
+	pos int
+}
+
+func (p *polyParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("equation: column %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *polyParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// accept skips c if it comes next.
+func (p *polyParser) accept(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.s) && p.s[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// sum parses products separated by + and -.
+func (p *polyParser) sum() (terms, error) {
+	t, err := p.product()
+	for err == nil {
+		sign := 1.
+		if p.accept('-') {
+			sign = -1
+		} else if !p.accept('+') {
+			break
+		}
+		var u terms
+		if u, err = p.product(); err == nil {
+			t = t.add(u, sign)
+		}
+	}
+	return t, err
+}
+
+// product parses signed powers separated by * and /.
+func (p *polyParser) product() (terms, error) {
+	t, err := p.signed()
+	for err == nil {
+		div := p.accept('/')
+		if !div && !p.accept('*') {
+			break
+		}
+		pos := p.pos
+		var u terms
+		if u, err = p.signed(); err != nil {
+			break
+		}
+		if !div {
+			t = t.mul(u)
+		} else if c, ok := u.constant(); !ok || c == 0 {
+			p.pos = pos
+			return nil, p.errorf("expected to divide by a nonzero number")
+		} else {
+			t = t.mul(terms{{}: 1 / c})
+		}
+		if t.degree() > MaxPolynomialDegree {
+			return nil, p.errorf("the degree exceeds %d", MaxPolynomialDegree)
+		}
+	}
+	return t, err
+}
+
+// signed parses a power with an optional sign.
+func (p *polyParser) signed() (terms, error) {
+	if p.accept('-') {
+		t, err := p.signed()
+		return terms{}.add(t, -1), err
+	}
+	p.accept('+')
+	return p.power()
+}
+
+// power parses an operand raised to an optional whole power.
+func (p *polyParser) power() (terms, error) {
+	t, err := p.operand()
+	if err != nil || !p.accept('^') {
+		return t, err
+	}
+
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+		p.pos++
+	}
+	n, err := strconv.Atoi(p.s[start:p.pos])
+	if err != nil {
+		p.pos = start
+		return nil, p.errorf("expected a whole power")
+	}
+	if n > MaxPolynomialDegree || n*t.degree() > MaxPolynomialDegree {
+		return nil, p.errorf("the degree exceeds %d", MaxPolynomialDegree)
+	}
+
+	pow := terms{{}: 1}
+	for range n {
+		pow = pow.mul(t)
+	}
+	return pow, nil
+}
+
+// operand parses a number, a variable or an equation in parentheses.
+func (p *polyParser) operand() (terms, error) {
+	p.skipSpace()
+	if p.pos == len(p.s) {
+		return nil, p.errorf("unexpected end")
+	}
+
+	switch c := p.s[p.pos]; {
+	case c == 'x' || c == 'y' || c == 'z':
+		p.pos++
+		var k [3]int
+		k[c-'x'] = 1
+		return terms{k: 1}, nil
+	case c == '(':
+		p.pos++
+		t, err := p.sum()
+		if err == nil && !p.accept(')') {
+			err = p.errorf("expected ')'")
+		}
+		return t, err
+	case c == '.' || c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos < len(p.s) && (p.s[p.pos] == '.' || p.s[p.pos] >= '0' && p.s[p.pos] <= '9') {
+			p.pos++
+		}
+		// an exponent, as in 1e-06
+		if rest := p.s[p.pos:]; len(rest) > 1 && (rest[0] == 'e' || rest[0] == 'E') {
+			i := 1
+			if rest[i] == '+' || rest[i] == '-' {
+				i++
+			}
+			if i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+				p.pos += i
+				for p.pos < len(p.s) && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+					p.pos++
+				}
+			}
+		}
+		f, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+		if err != nil {
+			p.pos = start
+			return nil, p.errorf("malformed number %q", p.s[start:p.pos])
+		}
+		return terms{{}: f}, nil
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+}
+
+// implicitEpsilon is the distance, relative to the diagonal of its bounds,
+// within which an implicit surface isn't hit again by a ray leaving it.
+const implicitEpsilon = 1e-6
+
+// Implicit represents the algebraic surface where Equation is zero within
+// the box between Min and Max. Normals point to where the equation is
+// positive, so surfaces that are negative inside face out. It has no texture
+// coordinates.
+type Implicit struct {
+	Equation Polynomial
+	Min, Max Vec3
+	Material shading.Material
+}
+
+// Intersect restricts the equation to the part of the ray inside the bounds,
+// which makes it a polynomial of one variable, and isolates its first root by
+// the roots of its derivatives.
+func (s *Implicit) Intersect(r Ray) *HitRecord {
+	tNear, tFar, _, _, ok := Box{Min: s.Min, Max: s.Max}.slabs(r.Origin, r.Direction)
+	if !ok || tFar < 0 {
+		return nil
+	}
+
+	// the polynomial is better conditioned on the segment from 0 to 1
+	start := max(tNear, 0)
+	length := tFar - start
+	if length <= 0 {
+		return nil
+	}
+	eps := implicitEpsilon * s.Max.Sub(s.Min).Norm() / r.Direction.Norm()
+
+	for _, root := range polyRoots(s.Equation.along(r.At(start), r.Direction.Scale(length)), 0, 1) {
+		t := start + root*length
+		if t <= eps {
+			continue
+		}
+
+		n := s.Equation.Gradient(r.At(t))
+		if n == (Vec3{}) {
+			n = r.Direction.Scale(-1)
+		}
+		return &HitRecord{T: t, Primitive: s, Material: s.Material, Normal: n.Normalize()}
+	}
+	return nil
+}
+
+// Bounds returns the bounds given with the surface.
+func (s *Implicit) Bounds() Bounds3 {
+	return Bounds3{
+		PMin: Point3{X: s.Min.X, Y: s.Min.Y, Z: s.Min.Z},
+		PMax: Point3{X: s.Max.X, Y: s.Max.Y, Z: s.Max.Z},
+	}
+}
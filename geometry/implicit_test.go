@@ -0,0 +1,106 @@
+package geometry
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestParsePolynomial(t *testing.T) {
+	tests := []struct {
+		equation string
+		want     Polynomial
+		text     string
+	}{
+		{"x^2 + y^2 + z^2 - 1", Polynomial{{1, 2, 0, 0}, {1, 0, 2, 0}, {1, 0, 0, 2}, {-1, 0, 0, 0}}, "x^2 + y^2 + z^2 - 1"},
+		{"(x - 1)^2", Polynomial{{1, 2, 0, 0}, {-2, 1, 0, 0}, {1, 0, 0, 0}}, "x^2 - 2*x + 1"},
+		{"-2*x*y*z/4 + 3", Polynomial{{-.5, 1, 1, 1}, {3, 0, 0, 0}}, "-0.5*x*y*z + 3"},
+		{"-x^2 + x*x + 1.5e-3*z", Polynomial{{.0015, 0, 0, 1}}, "0.0015*z"},
+		{"(x + y)^2 - 2*(x*y)", Polynomial{{1, 2, 0, 0}, {1, 0, 2, 0}}, "x^2 + y^2"},
+		{"x - x", nil, "0"},
+	}
+
+	for _, test := range tests {
+		p, err := ParsePolynomial(test.equation)
+		if err != nil {
+			t.Errorf("%q: %v", test.equation, err)
+			continue
+		}
+		if !reflect.DeepEqual(p, test.want) {
+			t.Errorf("%q = %v, want %v", test.equation, p, test.want)
+		}
+		if got := p.String(); got != test.text {
+			t.Errorf("%q is written as %q, want %q", test.equation, got, test.text)
+		}
+		if again, err := ParsePolynomial(p.String()); err != nil || !reflect.DeepEqual(again, p) {
+			t.Errorf("%q: parsing %q gives %v, %v", test.equation, p.String(), again, err)
+		}
+	}
+
+	for _, equation := range []string{"", "x +", "2x", "(x + 1", "x / y", "x / 0", "x^y", "x^17", "(x*y)^9", "w", "1.2.3"} {
+		if _, err := ParsePolynomial(equation); err == nil {
+			t.Errorf("ParsePolynomial(%q) succeeded", equation)
+		}
+	}
+}
+
+// TestImplicitIntersect compares the hits of an implicit torus with those of
+// the torus primitive.
+func TestImplicitIntersect(t *testing.T) {
+	equation, err := ParsePolynomial("(x^2 + y^2 + z^2 + 4 - 0.25)^2 - 16*(x^2 + z^2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	implicit := &Implicit{Equation: equation, Min: Vec3{-3, -1, -3}, Max: Vec3{3, 1, 3}}
+	torus := Torus{Axis: Vec3{Y: 1}, Major: 2, Minor: .5}
+
+	rng := rand.New(rand.NewSource(1))
+	hits := 0
+	for i := 0; i < 500; i++ {
+		target := Vec3{X: 6*rng.Float64() - 3, Y: 2*rng.Float64() - 1, Z: 6*rng.Float64() - 3}
+		dir := Vec3{X: rng.NormFloat64(), Y: rng.NormFloat64(), Z: rng.NormFloat64()}.Normalize()
+		r := Ray{Origin: target.Sub(dir.Scale(8)), Direction: dir}
+
+		want := torus.Intersect(r)
+		hit := implicit.Intersect(r)
+		if (hit == nil) != (want == nil) {
+			t.Fatalf("ray %d: hit %+v, want %+v", i, hit, want)
+		}
+		if hit == nil {
+			continue
+		}
+		hits++
+		if math.Abs(hit.T-want.T) > 1e-9 || !near(hit.Normal, want.Normal) {
+			t.Fatalf("ray %d: hit at %v with normal %v, want %v and %v", i, hit.T, hit.Normal, want.T, want.Normal)
+		}
+
+		// a ray leaving the surface doesn't hit it again right away
+		p := r.At(hit.T)
+		if again := implicit.Intersect(Ray{Origin: p, Direction: hit.Normal}); again != nil && again.T < 1e-3 {
+			t.Fatalf("ray %d: the surface is hit again at %v", i, again.T)
+		}
+	}
+	if hits < 100 {
+		t.Errorf("only %d of the rays hit", hits)
+	}
+}
+
+func TestImplicitHighDegree(t *testing.T) {
+	// a rounded cube of degree 8 hit along the diagonal
+	equation, err := ParsePolynomial("x^8 + y^8 + z^8 - 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cube := &Implicit{Equation: equation, Min: Vec3{-1, -1, -1}, Max: Vec3{1, 1, 1}}
+
+	hit := cube.Intersect(Ray{Origin: Vec3{X: 5, Y: 5, Z: 5}, Direction: Vec3{X: -1, Y: -1, Z: -1}})
+	want := 5 - math.Pow(3, -1./8)
+	if hit == nil || math.Abs(hit.T-want) > 1e-9 || !near(hit.Normal, Vec3{X: 1, Y: 1, Z: 1}.Normalize()) {
+		t.Fatalf("hit = %+v, want T %v", hit, want)
+	}
+
+	if hit := cube.Intersect(Ray{Origin: Vec3{X: 5, Y: 1.5}, Direction: Vec3{X: -1}}); hit != nil {
+		t.Errorf("hit outside the bounds at %v", hit.T)
+	}
+}
@@ -1,100 +1,192 @@
 package geometry
 
 import (
-	"bufio"
-	"os"
-	"strconv"
+	"fmt"
+	"path/filepath"
 	"strings"
 
 	"github.com/danradchuk/raytracer/shading"
 )
 
-// IndexedMesh represents a mesh with vertices and their indices for triangles.
+// LoadMesh loads a mesh file in the format given by its extension: .obj,
+// .ply or .stl.
+func LoadMesh(fName string) (*IndexedMesh, error) {
+	switch ext := strings.ToLower(filepath.Ext(fName)); ext {
+	case ".obj":
+		return LoadOBJ(fName)
+	case ".ply":
+		return LoadPLY(fName)
+	case ".stl":
+		return LoadSTL(fName)
+	default:
+		return nil, fmt.Errorf("%s: unknown mesh format %q", fName, ext)
+	}
+}
+
+// IndexedMesh is a triangle mesh whose triangles index shared vertices.
+// Indices holds three vertex indices per triangle. UVIndices holds three
+// texture coordinate indices per triangle (-1 when a face has none) or is
+// empty when no face has any, and TriangleMaterials holds an index into
+// Materials per triangle (-1 when a face has none). Normals and Colors are
+// either empty or hold the normal and the diffuse color of every vertex.
 type IndexedMesh struct {
-	TrianglesToIdxs [][]int
-	Verts           []Vec3
+	Indices           []int32
+	UVIndices         []int32
+	TriangleMaterials []int32
+	Verts             []Vec3
+	Normals           []Vec3
+	Colors            []shading.Color
+	UVs               []UV
+	Materials         []shading.Material
+	Groups            []Group
 }
 
-// LoadOBJ loads a mesh from an OBJ file and returns an IndexedMesh.
-func LoadOBJ(fName string) *IndexedMesh {
-	var tInd [][]int
-	var verts []Vec3
+// Group is a named run of consecutive triangles of a mesh, an object or a
+// group of an OBJ file.
+type Group struct {
+	Name  string
+	First int // index of the first triangle
+	Count int
+}
 
-	f, err := os.Open(fName)
-	check(err)
+// NumTriangles returns the number of triangles of the mesh.
+func (m *IndexedMesh) NumTriangles() int {
+	return len(m.Indices) / 3
+}
 
-	s := bufio.NewScanner(f)
-	for s.Scan() {
-		fields := strings.Fields(s.Text())
+// Triangle returns the vertex indices of the triangle i.
+func (m *IndexedMesh) Triangle(i int) (int32, int32, int32) {
+	return m.Indices[3*i], m.Indices[3*i+1], m.Indices[3*i+2]
+}
 
-		if len(fields) == 0 {
-			continue
-		}
+// GetTrianglesFromMesh returns a slice of Triangles constructed from the mesh.
+// Faces without a material of their own get the given material.
+func (m *IndexedMesh) GetTrianglesFromMesh(material shading.Material) []*Triangle {
+	n := m.NumTriangles()
+
+	// one allocation for all the triangles and one for each vertex attribute
+	storage := make([]Triangle, n)
+	triangles := make([]*Triangle, n)
+	var normals [][3]Vec3
+	if len(m.Normals) > 0 {
+		normals = make([][3]Vec3, n)
+	}
+	var colors [][3]shading.Color
+	if len(m.Colors) > 0 {
+		colors = make([][3]shading.Color, n)
+	}
 
-		switch fields[0] {
-		case "v":
-			x, err := strconv.ParseFloat(fields[1], 64)
-			check(err)
-			y, err := strconv.ParseFloat(fields[2], 64)
-			check(err)
-			z, err := strconv.ParseFloat(fields[3], 64)
-			check(err)
-
-			verts = append(verts, Vec3{X: x, Y: y, Z: z})
-		case "f":
-			// Compute all vertices for n-2 vertexes
-			// where n - number of all vertexes in the current row.
-			// a row in .obj file is - v 1/1/1 2/2/2/2 3/3/3/3 4/4/4/4
-			numVerts := len(fields[1:])
-			for i := 2; i <= numVerts-1; i++ {
-				var verts []int
-
-				// push the first vertex into the slice
-				x, err := strconv.Atoi(strings.Split(fields[1], "/")[0])
-				check(err)
-				verts = append(verts, x-1)
-
-				// push y
-				y, err := strconv.Atoi(strings.Split(fields[i], "/")[0])
-				check(err)
-				verts = append(verts, y-1)
-
-				// push z
-				z, err := strconv.Atoi(strings.Split(fields[i+1], "/")[0])
-				check(err)
-				verts = append(verts, z-1)
-
-				tInd = append(tInd, verts)
-			}
-		default:
-			continue // skip textures, normals, etc. for now
+	for i := range storage {
+		i0, i1, i2 := m.Triangle(i)
+		t := &storage[i]
+		t.V0, t.V1, t.V2 = m.Verts[i0], m.Verts[i1], m.Verts[i2]
+		if normals != nil {
+			normals[i] = [3]Vec3{m.Normals[i0], m.Normals[i1], m.Normals[i2]}
+			t.Normals = &normals[i]
+		}
+		if colors != nil {
+			colors[i] = [3]shading.Color{m.Colors[i0], m.Colors[i1], m.Colors[i2]}
+			t.Colors = &colors[i]
+		}
+		t.Material = material
+		if i < len(m.TriangleMaterials) && m.TriangleMaterials[i] >= 0 {
+			t.Material = m.Materials[m.TriangleMaterials[i]]
 		}
+		if 3*i < len(m.UVIndices) && m.UVIndices[3*i] >= 0 {
+			uv := m.UVIndices[3*i : 3*i+3]
+			t.UV0, t.UV1, t.UV2 = m.UVs[uv[0]], m.UVs[uv[1]], m.UVs[uv[2]]
+		}
+		triangles[i] = t
 	}
 
-	return &IndexedMesh{
-		TrianglesToIdxs: tInd,
-		Verts:           verts,
-	}
+	return triangles
+}
+
+// Mesh is a triangle mesh placed in the scene. It keeps its own BVH,
+// so the whole mesh is a single Primitive of the scene.
+// File is the path the mesh was loaded from as written in the scene, Material
+// overrides the materials of the mesh file when set.
+type Mesh struct {
+	File      string
+	Transform Transform
+	Material  *shading.Material
+	Triangles []*Triangle
+	BVH       *BVHNode
+
+	data *IndexedMesh // untransformed vertices
 }
 
-func check(e error) {
-	if e != nil {
-		panic(e)
+// NewMesh transforms the triangles of an IndexedMesh and builds their BVH.
+// Faces without a material of their own are painted with shading.RedRubber.
+func NewMesh(file string, data *IndexedMesh, transform Transform, material *shading.Material) *Mesh {
+	m := &Mesh{
+		File:      file,
+		Transform: transform,
+		Material:  material,
+		data:      data,
+	}
+
+	fallback := shading.RedRubber
+	if material != nil {
+		fallback = *material
 	}
+
+	var prims []Primitive
+	for _, t := range data.GetTrianglesFromMesh(fallback) {
+		if material != nil {
+			// the material replaces the vertex colors too
+			t.Material = *material
+			t.Colors = nil
+		}
+
+		m.Triangles = append(m.Triangles, t)
+		prims = append(prims, t)
+	}
+	m.place(transform)
+
+	m.BVH = BuildBVH(prims)
+
+	return m
 }
 
-// GetTrianglesFromMesh returns a slice of Triangles constructed from the mesh with the given material.
-func (m *IndexedMesh) GetTrianglesFromMesh(material shading.Material) []*Triangle {
-	var triangles []*Triangle
-	for _, mapping := range m.TrianglesToIdxs {
-		t := Triangle{
-			V0:       m.Verts[mapping[0]],
-			V1:       m.Verts[mapping[1]],
-			V2:       m.Verts[mapping[2]],
-			Material: material,
+// SetTransform places the mesh anew and refits its BVH.
+func (m *Mesh) SetTransform(transform Transform) {
+	m.Transform = transform
+	m.place(transform)
+	m.BVH.Refit()
+}
+
+// place transforms the vertices and normals of the mesh data into the
+// triangles.
+func (m *Mesh) place(transform Transform) {
+	matrix := transform.Matrix()
+
+	// normals are transformed by the inverse transpose
+	normals, ok := matrix.Inverse()
+	normals = normals.Transpose()
+
+	for i, t := range m.Triangles {
+		i0, i1, i2 := m.data.Triangle(i)
+		t.V0 = matrix.ApplyPoint(m.data.Verts[i0])
+		t.V1 = matrix.ApplyPoint(m.data.Verts[i1])
+		t.V2 = matrix.ApplyPoint(m.data.Verts[i2])
+
+		if t.Normals != nil && ok {
+			for k, j := range [3]int32{i0, i1, i2} {
+				if n := normals.ApplyVector(m.data.Normals[j]); n != (Vec3{}) {
+					t.Normals[k] = n.Normalize()
+				}
+			}
 		}
-		triangles = append(triangles, &t)
 	}
+}
 
-	return triangles
+// Intersect computes the closest intersection of a ray with the triangles of the mesh.
+func (m *Mesh) Intersect(r Ray) *HitRecord {
+	return m.BVH.Intersect(r)
+}
+
+// Bounds returns the bounding box of the mesh.
+func (m *Mesh) Bounds() Bounds3 {
+	return m.BVH.Bounds()
 }
@@ -1,9 +1,16 @@
 package geometry
 
 import (
+	"image"
+	"image/png"
 	"math"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+
+	"github.com/danradchuk/raytracer/shading"
 )
 
 func TestLoadOBJ(t *testing.T) {
@@ -21,6 +28,7 @@ func TestLoadOBJ(t *testing.T) {
 	f.WriteString("f 1/32/100 2/4/5 3/123/5\n")
 	f.WriteString("f 3/32/100 2/4/5 3/123/5\n")
 	f.WriteString("f 1//100 3//1 3//5\n")
+	f.Close()
 
 	var expectedVerteces = make([][]Vec3, 3)
 	expectedVerteces[0] = []Vec3{
@@ -39,22 +47,35 @@ func TestLoadOBJ(t *testing.T) {
 		{X: 10.859, Y: 6.771, Z: -1.542},
 	}
 
+	// the faces are [1,2,3], [3,2,3] and [1,3,3] in 1-based OBJ indices
 	var expectedIdxs = make([][]int, 3)
 	expectedIdxs[0] = []int{0, 1, 2} // idx - 1
 	expectedIdxs[1] = []int{2, 1, 2}
 	expectedIdxs[2] = []int{0, 2, 2}
 
-	// tInd = 0 -> [1,2,3], 1 -> [3,2,3], 2 -> [1,3,3]
-	mesh := LoadOBJ(f.Name())
+	mesh, err := LoadOBJ(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	for i, m := range mesh.TrianglesToIdxs {
-		if equalSlices(expectedIdxs[i], m) == false {
+	if mesh.NumTriangles() != 3 {
+		t.Fatalf("failed: expected 3 triangles, got %d", mesh.NumTriangles())
+	}
+	for i := 0; i < mesh.NumTriangles(); i++ {
+		i0, i1, i2 := mesh.Triangle(i)
+		if m := []int{int(i0), int(i1), int(i2)}; equalSlices(expectedIdxs[i], m) == false {
 			t.Fatalf("failed: wrong verts expected %v, got %v\n", expectedIdxs[i], m)
 		}
 	}
 
-	for i, idxs := range mesh.TrianglesToIdxs {
-		for j, idx := range idxs {
+	// no texture coordinates are defined, so the faces have none
+	if mesh.UVIndices != nil {
+		t.Errorf("failed: UV indices expected none, got %v", mesh.UVIndices)
+	}
+
+	for i := 0; i < mesh.NumTriangles(); i++ {
+		i0, i1, i2 := mesh.Triangle(i)
+		for j, idx := range []int32{i0, i1, i2} {
 			v := mesh.Verts[idx]
 			ev := expectedVerteces[i][j]
 
@@ -101,3 +122,152 @@ func nearlyEqual(a, b, epsilon float64) bool {
 		return float64(diff/math.Min(float64(absA+absB), math.MaxFloat64)) < epsilon
 	}
 }
+
+func TestLoadOBJWithMTL(t *testing.T) {
+	dir := t.TempDir()
+
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	tex, err := os.Create(filepath.Join(dir, "white.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(tex, img); err != nil {
+		t.Fatal(err)
+	}
+	tex.Close()
+
+	mtl := "newmtl shiny\n" +
+		"Ka 0.1 0.1 0.1\n" +
+		"Kd 0.5 0.25 0\n" +
+		"Ks 0.8\n" +
+		"Ns 50\n" +
+		"Ni 1.5\n" +
+		"d 0.75\n" +
+		"illum 3\n" +
+		"map_Kd -s 1 1 1 white.png\n" +
+		"newmtl matte\n" +
+		"Kd 0 0 1\n"
+	if err := os.WriteFile(filepath.Join(dir, "scene.mtl"), []byte(mtl), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	obj := "mtllib scene.mtl\n" +
+		"v 0 0 0\nv 1 0 0\nv 1 1 0\nv 0 1 0\n" +
+		"vt 0 0\nvt 1 0\nvt 1 1\nvt 0 1\n" +
+		"f 1 2 3\n" +
+		"usemtl shiny\n" +
+		"f 1/1 2/2 3/3 4/4\n" +
+		"usemtl matte\n" +
+		"f 1 3 4\n"
+	objPath := filepath.Join(dir, "scene.obj")
+	if err := os.WriteFile(objPath, []byte(obj), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mesh, err := LoadOBJ(objPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	triangles := mesh.GetTrianglesFromMesh(shading.RedRubber)
+	if len(triangles) != 4 {
+		t.Fatalf("expected 4 triangles, got %d", len(triangles))
+	}
+
+	wantNames := []string{"red", "shiny", "shiny", "matte"}
+	for i, tr := range triangles {
+		if tr.Material.Name != wantNames[i] {
+			t.Errorf("triangle %d: expected material %q, got %q", i, wantNames[i], tr.Material.Name)
+		}
+	}
+
+	shiny := triangles[1].Material
+	if shiny.KDiffuse != (shading.Color{R: 0.5, G: 0.25, B: 0}) {
+		t.Errorf("Kd: got %v", shiny.KDiffuse)
+	}
+	if shiny.KSpecular != (shading.Color{R: 0.8, G: 0.8, B: 0.8}) || shiny.KReflection != shiny.KSpecular {
+		t.Errorf("Ks: got %v, reflection %v", shiny.KSpecular, shiny.KReflection)
+	}
+	if shiny.Alpha != 50 || shiny.IOR != 1.5 || shiny.Transparency != 0.25 || shiny.Illum != 3 {
+		t.Errorf("unexpected scalars: %+v", shiny)
+	}
+	if shiny.DiffuseMap == nil || shiny.DiffuseMap.Sample(.3, .7) != (shading.Color{R: 1, G: 1, B: 1}) {
+		t.Errorf("map_Kd was not loaded")
+	}
+
+	if triangles[2].UV2 != (UV{U: 0, V: 1}) {
+		t.Errorf("UV2: got %v", triangles[2].UV2)
+	}
+}
+
+func TestReadOBJGroups(t *testing.T) {
+	obj := "v 0 0 0\nv 1 0 0\nv 1 1 0\nv 0 1 0\n" +
+		"f 1 2 3\n" +
+		"o box\n" +
+		"g front side\n" +
+		"f 1 2 3 4\n" +
+		"g empty\n" +
+		"g back\n" +
+		"f -4 -2 -1\n"
+
+	mesh, err := ReadOBJ(strings.NewReader(obj), "groups.obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Group{{Name: "front side", First: 1, Count: 2}, {Name: "back", First: 3, Count: 1}}
+	if !slices.Equal(mesh.Groups, want) {
+		t.Errorf("groups %v, want %v", mesh.Groups, want)
+	}
+	if want := []int32{0, 1, 2, 0, 1, 2, 0, 2, 3, 0, 2, 3}; !slices.Equal(mesh.Indices, want) {
+		t.Errorf("indices %v, want %v", mesh.Indices, want)
+	}
+}
+
+func TestReadOBJErrors(t *testing.T) {
+	for _, tc := range []struct{ obj, err string }{
+		{"v 0 0 0\nv 1 0\n", "bad.obj:2: v: expected 3 coordinates"},
+		{"v 0 0 0\nv 1 x 0\n", "bad.obj:2: v: strconv.ParseFloat"},
+		{"v 0 0 0\nv 1 0 0\n\nf 1 2\n", "bad.obj:4: f: expected at least 3 vertices"},
+		{"v 0 0 0\nv 1 0 0\nv 1 1 0\nf 1 2 4\n", "bad.obj:4: f: index 4 out of range, 3 defined"},
+		{"v 0 0 0\nv 1 0 0\nv 1 1 0\nf 1 2 -4\n", "bad.obj:4: f: index -4 out of range"},
+		{"v 0 0 0\nv 1 0 0\nv 1 1 0\nf 1 2 0\n", "bad.obj:4: f: index 0 out of range"},
+		{"v 0 0 0\nf a 2 3\n", `bad.obj:2: f: bad index "a"`},
+	} {
+		_, err := ReadOBJ(strings.NewReader(tc.obj), "bad.obj")
+		if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+			t.Errorf("ReadOBJ(%q) = %v, want %q", tc.obj, err, tc.err)
+		}
+	}
+}
+
+func TestReadOBJTextureRange(t *testing.T) {
+	const verts = "v 0 0 0\nv 1 0 0\nv 1 1 0\nvt 0 0\nvt 1 0\n"
+	for _, tc := range []struct {
+		face string
+		want []int32
+	}{
+		{"f 1/1 2/2 3/-1\n", []int32{0, 1, 1}},
+		{"f 1/1 2/2 3/3\n", nil},
+		{"f 1/1 2/2 3/0\n", nil},
+		{"f 1/-3 2/1 3/2\n", nil},
+		{"f 1/1 2 3\n", nil},
+		{"f 1/1/9 2/2/9 3/2/9\n", []int32{0, 1, 1}}, // normals aren't read
+	} {
+		m, err := ReadOBJ(strings.NewReader(verts+tc.face), "tex.obj")
+		if err != nil {
+			t.Errorf("ReadOBJ(%q) = %v", tc.face, err)
+			continue
+		}
+		if !slices.Equal(m.UVIndices, tc.want) {
+			t.Errorf("ReadOBJ(%q): UV indices %v, want %v", tc.face, m.UVIndices, tc.want)
+		}
+	}
+
+	_, err := ReadOBJ(strings.NewReader(verts+"f 1/x 2 3\n"), "bad.obj")
+	if want := `bad.obj:6: f: bad index "x"`; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("ReadOBJ() = %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,193 @@
+package geometry
+
+import "math"
+
+// Motion is a primitive that moves while the shutter is open. Transforms
+// place the primitive at increasing Times relative to where it is; at other
+// times their translations, rotations and scales are interpolated separately,
+// so a turning primitive keeps its shape. Instead of moving the primitive, a
+// ray is moved into its space by the inverse transform at the time of the ray.
+type Motion struct {
+	Prim  Primitive
+	Times []float64
+	keys  []motionKey
+	pivot Vec3 // the center of the primitive, which the rotations turn around
+	box   Bounds3
+}
+
+// motionKey is a transform split into a scale, which may shear, a rotation
+// and a translation, applied in this order to points relative to the pivot.
+type motionKey struct {
+	scale     Matrix4
+	rotate    quaternion
+	translate Vec3
+}
+
+// NewMotion creates the motion of a primitive from its transforms at times.
+func NewMotion(prim Primitive, times []float64, transforms []Matrix4) *Motion {
+	b := prim.Bounds()
+	m := &Motion{Prim: prim, Times: times, box: EmptyAABB()}
+	m.pivot = Vec3{X: (b.PMin.X + b.PMax.X) / 2, Y: (b.PMin.Y + b.PMax.Y) / 2, Z: (b.PMin.Z + b.PMax.Z) / 2}
+
+	for _, t := range transforms {
+		m.keys = append(m.keys, decompose(t.Mul(Translation(m.pivot))))
+	}
+
+	// the corners of the primitive relative to the pivot
+	half := b.Diagonal().Scale(.5)
+	local := Bounds3{PMin: Point3{X: -half.X, Y: -half.Y, Z: -half.Z}, PMax: Point3{X: half.X, Y: half.Y, Z: half.Z}}
+	for i := range m.keys {
+		next := min(i+1, len(m.keys)-1)
+		m.box = m.box.Union(sweptBounds(m.keys[i], m.keys[next], local))
+	}
+
+	return m
+}
+
+// decompose splits the transform m by the polar decomposition of its linear
+// part into a rotation and the remaining scale.
+func decompose(m Matrix4) motionKey {
+	k := motionKey{translate: Vec3{X: m[0][3], Y: m[1][3], Z: m[2][3]}}
+	linear := m
+	linear[0][3], linear[1][3], linear[2][3] = 0, 0, 0
+
+	// averaging a matrix with its inverse transpose converges to its rotation
+	r := linear
+	for i := 0; i < 100; i++ {
+		inv, ok := r.Transpose().Inverse()
+		if !ok {
+			// a collapsed primitive keeps no rotation
+			r = Identity()
+			break
+		}
+		next := r.Lerp(inv, .5)
+		converged := maxDiff(next, r) < 1e-12
+		r = next
+		if converged {
+			break
+		}
+	}
+	if det3(r) < 0 {
+		// a mirroring goes into the scale
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				r[i][j] = -r[i][j]
+			}
+		}
+	}
+
+	k.rotate = quaternionFromMatrix(r)
+	k.scale = r.Transpose().Mul(linear)
+	return k
+}
+
+// sweptBounds returns a box covering the corners of local, which is relative
+// to the pivot, while they move from key a to key b.
+func sweptBounds(a, b motionKey, local Bounds3) Bounds3 {
+	if math.Abs(a.rotate.dot(b.rotate)) > 1-1e-12 {
+		// without a rotation every point moves along a straight line
+		return a.matrix().ApplyBounds(local).Union(b.matrix().ApplyBounds(local))
+	}
+
+	// the scaled corners turn on spheres around the pivot, which moves along a
+	// straight line; the norm is convex, so the ends bound the radius
+	radius := 0.
+	for i := 0; i < 8; i++ {
+		corner := Vec3{X: local.PMin.X, Y: local.PMin.Y, Z: local.PMin.Z}
+		if i&1 != 0 {
+			corner.X = local.PMax.X
+		}
+		if i&2 != 0 {
+			corner.Y = local.PMax.Y
+		}
+		if i&4 != 0 {
+			corner.Z = local.PMax.Z
+		}
+		radius = max(radius, a.scale.ApplyVector(corner).Norm(), b.scale.ApplyVector(corner).Norm())
+	}
+
+	r := Vec3{X: radius, Y: radius, Z: radius}
+	box := EmptyAABB()
+	for _, p := range []Vec3{a.translate.Sub(r), a.translate.Add(r), b.translate.Sub(r), b.translate.Add(r)} {
+		box = box.UnionPoint3(Point3{X: p.X, Y: p.Y, Z: p.Z})
+	}
+	return box
+}
+
+// matrix returns the transform of the key for points relative to the pivot.
+func (k motionKey) matrix() Matrix4 {
+	return Translation(k.translate).Mul(k.rotate.matrix()).Mul(k.scale)
+}
+
+// lerp interpolates the parts of the keys k and other.
+func (k motionKey) lerp(other motionKey, t float64) motionKey {
+	return motionKey{
+		scale:     k.scale.Lerp(other.scale, t),
+		rotate:    k.rotate.slerp(other.rotate, t),
+		translate: k.translate.Lerp(other.translate, t),
+	}
+}
+
+// At returns the transform at time t.
+func (m *Motion) At(t float64) Matrix4 {
+	n := len(m.Times)
+	k := m.keys[0]
+	switch {
+	case t >= m.Times[n-1]:
+		k = m.keys[n-1]
+	case t > m.Times[0]:
+		i := 0
+		for m.Times[i+1] <= t {
+			i++
+		}
+		k = m.keys[i].lerp(m.keys[i+1], (t-m.Times[i])/(m.Times[i+1]-m.Times[i]))
+	}
+	return k.matrix().Mul(Translation(m.pivot.Scale(-1)))
+}
+
+// Intersect intersects the primitive placed at the time of the ray. A
+// primitive collapsed by its transform isn't hit.
+func (m *Motion) Intersect(r Ray) *HitRecord {
+	at := m.At(r.Time)
+	inv, ok := at.Inverse()
+	if !ok {
+		return nil
+	}
+
+	// the direction isn't normalized, so T is the same in both spaces
+	local := Ray{Origin: inv.ApplyPoint(r.Origin), Direction: inv.ApplyVector(r.Direction), Time: r.Time, Stats: r.Stats}
+	hit := m.Prim.Intersect(local)
+	if hit == nil {
+		return nil
+	}
+
+	normals := inv.Transpose()
+	hit.Normal = normals.ApplyVector(hit.Normal).Normalize()
+	hit.DPDU = at.ApplyVector(hit.DPDU)
+	hit.DPDV = at.ApplyVector(hit.DPDV)
+
+	return hit
+}
+
+// Bounds returns the box covering the whole motion.
+func (m *Motion) Bounds() Bounds3 {
+	return m.box
+}
+
+// det3 returns the determinant of the linear part of m.
+func det3(m Matrix4) float64 {
+	return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+}
+
+// maxDiff returns the largest difference between the elements of a and b.
+func maxDiff(a, b Matrix4) float64 {
+	d := 0.
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			d = max(d, math.Abs(a[i][j]-b[i][j]))
+		}
+	}
+	return d
+}
@@ -0,0 +1,98 @@
+package geometry
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMotionRotation(t *testing.T) {
+	// a flat square turning by 90 degrees around its center during the shutter
+	center := Vec3{X: 5}
+	box := Box{Min: Vec3{X: 4, Y: -.5, Z: -1}, Max: Vec3{X: 6, Y: .5, Z: 1}}
+	turn := func(deg float64) Matrix4 {
+		return Translation(center).Mul(RotationY(deg)).Mul(Translation(center.Scale(-1)))
+	}
+	m := NewMotion(box, []float64{0, 1}, []Matrix4{turn(0), turn(90)})
+
+	// halfway it is turned by 45 degrees, not shrunk or sheared: points just
+	// inside its corners are hit from above, points just outside aren't
+	mid := turn(45)
+	for _, tc := range []struct {
+		p   Vec3
+		hit bool
+	}{
+		{Vec3{X: 5.95, Z: .95}, true},
+		{Vec3{X: 4.05, Z: -.95}, true},
+		{Vec3{X: 6.05, Z: .95}, false},
+		{Vec3{X: 5.95, Z: 1.05}, false},
+	} {
+		p := mid.ApplyPoint(tc.p)
+		hit := m.Intersect(Ray{Origin: Vec3{X: p.X, Y: 10, Z: p.Z}, Direction: Vec3{Y: -1}, Time: .5})
+		if (hit != nil) != tc.hit {
+			t.Errorf("ray above %v: hit %v, want %v", tc.p, hit != nil, tc.hit)
+			continue
+		}
+		if hit != nil {
+			if math.Abs(hit.T-9.5) > 1e-9 {
+				t.Errorf("ray above %v: T = %v, want 9.5", tc.p, hit.T)
+			}
+			if n := hit.Normal; math.Abs(n.Y-1) > 1e-9 {
+				t.Errorf("ray above %v: normal %v, want up", tc.p, n)
+			}
+		}
+	}
+
+	// the bounds cover the square at every moment, though its corners reach
+	// beyond where it is at the start and the end
+	bounds := m.Bounds()
+	for i := 0; i <= 100; i++ {
+		b := m.At(float64(i) / 100).ApplyBounds(box.Bounds())
+		if b.PMin.X < bounds.PMin.X || b.PMin.Y < bounds.PMin.Y || b.PMin.Z < bounds.PMin.Z ||
+			b.PMax.X > bounds.PMax.X || b.PMax.Y > bounds.PMax.Y || b.PMax.Z > bounds.PMax.Z {
+			t.Fatalf("at time %v the square %v leaves the bounds %v", float64(i)/100, b, bounds)
+		}
+	}
+}
+
+func TestMotionTranslationBounds(t *testing.T) {
+	sphere := Sphere{Center: Vec3{X: 1}, R: 1}
+	m := NewMotion(sphere, []float64{0, 1}, []Matrix4{Identity(), Translation(Vec3{Y: 4})})
+
+	// without a rotation the bounds are the boxes at the ends
+	want := Bounds3{PMin: Point3{X: 0, Y: -1, Z: -1}, PMax: Point3{X: 2, Y: 5, Z: 1}}
+	if got := m.Bounds(); got != want {
+		t.Errorf("Bounds() = %v, want %v", got, want)
+	}
+
+	hit := m.Intersect(Ray{Origin: Vec3{X: 1, Y: 2, Z: -10}, Direction: Vec3{Z: 1}, Time: .5})
+	if hit == nil || math.Abs(hit.T-9) > 1e-9 {
+		t.Errorf("Intersect() = %+v, want a hit of the moved sphere at t = 9", hit)
+	}
+}
+
+func TestMotionTangents(t *testing.T) {
+	// a box turned by 90 degrees around Z: the tangents of a hit turn with it
+	box := Box{Min: Vec3{Y: -1, Z: -1}, Max: Vec3{X: 2, Y: 1, Z: 1}}
+	turn := Translation(Vec3{X: 1}).Mul(RotationZ(90)).Mul(Translation(Vec3{X: -1}))
+	m := NewMotion(box, []float64{0, 1}, []Matrix4{turn, turn})
+
+	r := Ray{Origin: Vec3{X: 1.3, Y: .4, Z: -10}, Direction: Vec3{Z: 1}, Time: .5}
+	hit := m.Intersect(r)
+	inv, _ := turn.Inverse()
+	local := box.Intersect(Ray{Origin: inv.ApplyPoint(r.Origin), Direction: inv.ApplyVector(r.Direction)})
+	if hit == nil || local == nil {
+		t.Fatalf("Intersect() = %+v, the unturned box %+v", hit, local)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		got, want Vec3
+	}{
+		{"DPDU", hit.DPDU, turn.ApplyVector(local.DPDU)},
+		{"DPDV", hit.DPDV, turn.ApplyVector(local.DPDV)},
+	} {
+		if tc.want == (Vec3{}) || tc.got.Sub(tc.want).Norm() > 1e-9 || math.Abs(tc.got.Dot(hit.Normal)) > 1e-9 {
+			t.Errorf("%s = %v, want %v along the surface with the normal %v", tc.name, tc.got, tc.want, hit.Normal)
+		}
+	}
+}
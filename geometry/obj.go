@@ -0,0 +1,329 @@
+package geometry
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// LoadOBJ loads a mesh from an OBJ file. See ReadOBJ.
+func LoadOBJ(fName string) (*IndexedMesh, error) {
+	f, err := os.Open(fName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	return ReadOBJ(f, fName)
+}
+
+// ReadOBJ reads a mesh in the Wavefront OBJ format. name is the path of the
+// file: errors are reported as name:line: message, and material libraries
+// referenced by mtllib are resolved relative to its directory.
+//
+// Polygons are split into fans of triangles. Indices may be negative, which
+// counts back from the last vertex read. A triangle with a texture
+// coordinate index beyond those read has no texture coordinates. Objects (o)
+// and groups (g) become the Groups of the mesh. Normals, smoothing groups
+// and other statements are skipped.
+func ReadOBJ(r io.Reader, name string) (*IndexedMesh, error) {
+	m := &IndexedMesh{}
+	rd := objReader{
+		name:    name,
+		mesh:    m,
+		library: make(map[string]*shading.Material),
+		matIdx:  make(map[string]int32),
+		currMat: -1,
+	}
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var fields []string
+	for s.Scan() {
+		rd.line++
+		fields = splitFields(fields[:0], s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if err := rd.statement(fields); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%s:%d: %w", name, rd.line+1, err)
+	}
+	rd.endGroup()
+
+	return m, nil
+}
+
+// objReader holds the state of ReadOBJ between the lines.
+type objReader struct {
+	name    string
+	line    int
+	mesh    *IndexedMesh
+	library map[string]*shading.Material
+	matIdx  map[string]int32
+	currMat int32
+	group   *Group
+}
+
+func (rd *objReader) errorf(format string, args ...any) error {
+	return fmt.Errorf("%s:%d: %s", rd.name, rd.line, fmt.Sprintf(format, args...))
+}
+
+func (rd *objReader) statement(fields []string) error {
+	m := rd.mesh
+
+	switch fields[0] {
+	case "v":
+		if len(fields) < 4 {
+			return rd.errorf("v: expected 3 coordinates")
+		}
+		var xyz [3]float64
+		for i := range xyz {
+			f, err := strconv.ParseFloat(fields[i+1], 64)
+			if err != nil {
+				return rd.errorf("v: %v", err)
+			}
+			xyz[i] = f
+		}
+		m.Verts = append(m.Verts, Vec3{X: xyz[0], Y: xyz[1], Z: xyz[2]})
+	case "vt":
+		if len(fields) < 2 {
+			return rd.errorf("vt: expected texture coordinates")
+		}
+		u, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return rd.errorf("vt: %v", err)
+		}
+		v := 0.
+		if len(fields) > 2 {
+			if v, err = strconv.ParseFloat(fields[2], 64); err != nil {
+				return rd.errorf("vt: %v", err)
+			}
+		}
+		m.UVs = append(m.UVs, UV{U: u, V: v})
+	case "mtllib":
+		for _, lib := range fields[1:] {
+			materials, err := shading.LoadMTL(filepath.Join(filepath.Dir(rd.name), lib))
+			if err != nil {
+				return rd.errorf("mtllib: %v", err)
+			}
+			for k, mat := range materials {
+				rd.library[k] = mat
+			}
+		}
+	case "usemtl":
+		rd.currMat = -1
+		if len(fields) < 2 {
+			return nil
+		}
+		if i, ok := rd.matIdx[fields[1]]; ok {
+			rd.currMat = i
+		} else if mat, ok := rd.library[fields[1]]; ok {
+			rd.currMat = int32(len(m.Materials))
+			rd.matIdx[fields[1]] = rd.currMat
+			m.Materials = append(m.Materials, *mat)
+		}
+	case "o", "g":
+		rd.endGroup()
+		rd.group = &Group{Name: strings.Join(fields[1:], " "), First: m.NumTriangles()}
+	case "f":
+		return rd.face(fields[1:])
+	}
+
+	return nil
+}
+
+// face adds a polygon as a fan of triangles around its first vertex.
+func (rd *objReader) face(corners []string) error {
+	if len(corners) < 3 {
+		return rd.errorf("f: expected at least 3 vertices")
+	}
+
+	m := rd.mesh
+
+	var verts, uvs [3]int32
+	for i, c := range corners {
+		v, vt, err := rd.corner(c)
+		if err != nil {
+			return err
+		}
+
+		// corners 0, 1 and the current one form a triangle from the third on
+		j := min(i, 2)
+		verts[j], uvs[j] = v, vt
+		if i < 2 {
+			continue
+		}
+
+		m.Indices = append(m.Indices, verts[0], verts[1], verts[2])
+		m.TriangleMaterials = append(m.TriangleMaterials, rd.currMat)
+
+		// texture coordinates are only stored once a triangle has them
+		if uvs[0] >= 0 && uvs[1] >= 0 && uvs[2] >= 0 {
+			for len(m.UVIndices) < len(m.Indices)-3 {
+				m.UVIndices = append(m.UVIndices, -1)
+			}
+			m.UVIndices = append(m.UVIndices, uvs[0], uvs[1], uvs[2])
+		} else if m.UVIndices != nil {
+			m.UVIndices = append(m.UVIndices, -1, -1, -1)
+		}
+
+		verts[1], uvs[1] = verts[2], uvs[2]
+	}
+
+	return nil
+}
+
+// corner parses the indices v, v/vt, v//vn or v/vt/vn of a face corner into
+// zero-based vertex and texture coordinate indices. Vertices must exist,
+// but texture coordinates that don't are left out like missing ones, with
+// vt -1; normals are skipped, so their indices aren't read either.
+func (rd *objReader) corner(c string) (v, vt int32, err error) {
+	vs, rest, _ := strings.Cut(c, "/")
+	vts, _, _ := strings.Cut(rest, "/")
+
+	v, ok, err := rd.index(vs, len(rd.mesh.Verts))
+	if err != nil {
+		return 0, 0, err
+	}
+	if !ok {
+		return 0, 0, rd.errorf("f: index %s out of range, %d defined", vs, len(rd.mesh.Verts))
+	}
+	vt = -1
+	if vts != "" {
+		i, ok, err := rd.index(vts, len(rd.mesh.UVs))
+		if err != nil {
+			return 0, 0, err
+		}
+		if ok {
+			vt = i
+		}
+	}
+	return v, vt, nil
+}
+
+// index resolves a one-based or negative index into a list of n elements
+// and reports whether it is in the list.
+func (rd *objReader) index(s string, n int) (int32, bool, error) {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, false, rd.errorf("f: bad index %q", s)
+	}
+	if i < 0 {
+		i += n
+	} else {
+		i--
+	}
+	return int32(i), i >= 0 && i < n, nil
+}
+
+// endGroup closes the current group unless it has no triangles.
+func (rd *objReader) endGroup() {
+	g := rd.group
+	if g == nil {
+		return
+	}
+	if g.Count = rd.mesh.NumTriangles() - g.First; g.Count > 0 {
+		rd.mesh.Groups = append(rd.mesh.Groups, *g)
+	}
+	rd.group = nil
+}
+
+// splitFields appends the space separated fields of s to fields, reusing
+// its memory across lines.
+func splitFields(fields []string, s string) []string {
+	for {
+		s = strings.TrimLeft(s, " \t\r")
+		if s == "" {
+			return fields
+		}
+		end := strings.IndexAny(s, " \t\r")
+		if end < 0 {
+			return append(fields, s)
+		}
+		fields = append(fields, s[:end])
+		s = s[end:]
+	}
+}
+
+// WriteOBJ writes the mesh in the Wavefront OBJ format. Groups become
+// objects (o) and materials are referenced by name from the material
+// library mtllib, which is left out when empty; see shading.WriteMTL.
+// Vertex colors follow the coordinates of their vertex. Normals are written
+// for the faces whose three normals aren't zero.
+func (m *IndexedMesh) WriteOBJ(w io.Writer, mtllib string) error {
+	bw := bufio.NewWriter(w)
+
+	if mtllib != "" && len(m.Materials) > 0 {
+		fmt.Fprintf(bw, "mtllib %s\n", mtllib)
+	}
+	for i, v := range m.Verts {
+		fmt.Fprintf(bw, "v %s %s %s", formatFloat(v.X), formatFloat(v.Y), formatFloat(v.Z))
+		if len(m.Colors) > 0 {
+			c := m.Colors[i]
+			fmt.Fprintf(bw, " %s %s %s", formatFloat(c.R), formatFloat(c.G), formatFloat(c.B))
+		}
+		fmt.Fprintln(bw)
+	}
+	for _, uv := range m.UVs {
+		fmt.Fprintf(bw, "vt %s %s\n", formatFloat(uv.U), formatFloat(uv.V))
+	}
+	for _, n := range m.Normals {
+		fmt.Fprintf(bw, "vn %s %s %s\n", formatFloat(n.X), formatFloat(n.Y), formatFloat(n.Z))
+	}
+
+	group, material := 0, int32(-1)
+	for i := 0; i < m.NumTriangles(); i++ {
+		for group < len(m.Groups) && m.Groups[group].First == i {
+			fmt.Fprintf(bw, "o %s\n", m.Groups[group].Name)
+			group++
+		}
+		if i < len(m.TriangleMaterials) && m.TriangleMaterials[i] != material {
+			material = m.TriangleMaterials[i]
+			if material >= 0 {
+				fmt.Fprintf(bw, "usemtl %s\n", m.Materials[material].Name)
+			} else {
+				fmt.Fprintln(bw, "usemtl")
+			}
+		}
+
+		corners := m.Indices[3*i : 3*i+3]
+		hasUVs := 3*i < len(m.UVIndices) && m.UVIndices[3*i] >= 0
+		hasNormals := len(m.Normals) > 0
+		for _, v := range corners {
+			hasNormals = hasNormals && m.Normals[v] != (Vec3{})
+		}
+
+		fmt.Fprint(bw, "f")
+		for k, v := range corners {
+			switch {
+			case hasUVs && hasNormals:
+				fmt.Fprintf(bw, " %d/%d/%d", v+1, m.UVIndices[3*i+k]+1, v+1)
+			case hasUVs:
+				fmt.Fprintf(bw, " %d/%d", v+1, m.UVIndices[3*i+k]+1)
+			case hasNormals:
+				fmt.Fprintf(bw, " %d//%d", v+1, v+1)
+			default:
+				fmt.Fprintf(bw, " %d", v+1)
+			}
+		}
+		fmt.Fprintln(bw)
+	}
+
+	return bw.Flush()
+}
+
+// formatFloat formats a number in the shortest form that reads back the same.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,401 @@
+package geometry
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// LoadPLY loads a mesh from a PLY file. See ReadPLY.
+func LoadPLY(fName string) (*IndexedMesh, error) {
+	f, err := os.Open(fName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	return ReadPLY(f, fName)
+}
+
+// ReadPLY reads a mesh in the PLY format, as ASCII or binary little or big
+// endian. name is used in errors. Besides the positions (x, y, z), vertices
+// may have normals (nx, ny, nz), colors (red, green, blue) and texture
+// coordinates (u, v, s, t or texture_u, texture_v). Polygons are split into
+// fans of triangles like in ReadOBJ. Other elements and properties are
+// skipped.
+func ReadPLY(r io.Reader, name string) (*IndexedMesh, error) {
+	br := bufio.NewReader(r)
+
+	elements, format, err := readPLYHeader(br)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	var values plyValues
+	switch format {
+	case "ascii":
+		s := bufio.NewScanner(br)
+		s.Split(bufio.ScanWords)
+		values = &plyASCII{s: s}
+	case "binary_little_endian":
+		values = &plyBinary{r: br, order: binary.LittleEndian}
+	case "binary_big_endian":
+		values = &plyBinary{r: br, order: binary.BigEndian}
+	default:
+		return nil, fmt.Errorf("%s: unknown format %q", name, format)
+	}
+
+	m := &IndexedMesh{}
+	for _, e := range elements {
+		if err := readPLYElement(values, e, m); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	for _, i := range m.Indices {
+		if int(i) >= len(m.Verts) {
+			return nil, fmt.Errorf("%s: face: index %d out of range, %d vertices", name, i, len(m.Verts))
+		}
+	}
+	if len(m.UVs) > 0 {
+		m.UVIndices = slices.Clone(m.Indices)
+	}
+
+	return m, nil
+}
+
+type plyProperty struct {
+	name      string
+	typ       string // the type of the value or of the items of a list
+	countType string // the type of the length of a list; empty for a value
+}
+
+type plyElement struct {
+	name  string
+	count int
+	props []plyProperty
+}
+
+// plySizes are the sizes of the PLY types in bytes.
+var plySizes = map[string]int{
+	"char": 1, "uchar": 1, "int8": 1, "uint8": 1,
+	"short": 2, "ushort": 2, "int16": 2, "uint16": 2,
+	"int": 4, "uint": 4, "int32": 4, "uint32": 4,
+	"float": 4, "float32": 4,
+	"double": 8, "float64": 8,
+}
+
+// readPLYHeader reads the header up to end_header and returns the elements
+// and the format of the file.
+func readPLYHeader(br *bufio.Reader) ([]plyElement, string, error) {
+	var elements []plyElement
+	var format string
+
+	for line := 1; ; line++ {
+		text, err := br.ReadString('\n')
+		if err != nil {
+			return nil, "", fmt.Errorf("header line %d: %w", line, err)
+		}
+		fields := strings.Fields(text)
+		errorf := func(format string, args ...any) error {
+			return fmt.Errorf("header line %d: %s", line, fmt.Sprintf(format, args...))
+		}
+
+		if line == 1 {
+			if len(fields) != 1 || fields[0] != "ply" {
+				return nil, "", errorf("not a PLY file")
+			}
+			continue
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "format":
+			if len(fields) < 2 {
+				return nil, "", errorf("format: missing format")
+			}
+			format = fields[1]
+		case "element":
+			if len(fields) != 3 {
+				return nil, "", errorf("element: expected a name and a count")
+			}
+			n, err := strconv.Atoi(fields[2])
+			if err != nil || n < 0 {
+				return nil, "", errorf("element %s: bad count %q", fields[1], fields[2])
+			}
+			elements = append(elements, plyElement{name: fields[1], count: n})
+		case "property":
+			if len(elements) == 0 {
+				return nil, "", errorf("property before element")
+			}
+			var p plyProperty
+			switch {
+			case len(fields) == 3:
+				p = plyProperty{name: fields[2], typ: fields[1]}
+			case len(fields) == 5 && fields[1] == "list":
+				p = plyProperty{name: fields[4], typ: fields[3], countType: fields[2]}
+				if _, ok := plySizes[p.countType]; !ok {
+					return nil, "", errorf("property %s: unknown type %q", p.name, p.countType)
+				}
+			default:
+				return nil, "", errorf("property: expected a type and a name")
+			}
+			if _, ok := plySizes[p.typ]; !ok {
+				return nil, "", errorf("property %s: unknown type %q", p.name, p.typ)
+			}
+			e := &elements[len(elements)-1]
+			e.props = append(e.props, p)
+		case "end_header":
+			if format == "" {
+				return nil, "", errorf("missing format")
+			}
+			return elements, format, nil
+		case "comment", "obj_info":
+		default:
+			return nil, "", errorf("unknown keyword %q", fields[0])
+		}
+	}
+}
+
+// readPLYElement reads the items of an element into the mesh.
+func readPLYElement(values plyValues, e plyElement, m *IndexedMesh) error {
+	// the vertex attributes present in the file
+	has := make(map[string]bool)
+	for _, p := range e.props {
+		has[p.name] = true
+	}
+	isVertex := e.name == "vertex"
+	hasNormals := isVertex && has["nx"] && has["ny"] && has["nz"]
+	hasColors := isVertex && has["red"] && has["green"] && has["blue"]
+	hasUVs := isVertex && (has["u"] || has["s"] || has["texture_u"])
+
+	var list []float64
+	for i := 0; i < e.count; i++ {
+		var v Vec3
+		var n Vec3
+		var c shading.Color
+		var uv UV
+
+		for _, p := range e.props {
+			if p.countType != "" {
+				count, err := values.next(p.countType)
+				if err != nil {
+					return fmt.Errorf("%s %d: %s: %w", e.name, i, p.name, err)
+				}
+				list = list[:0]
+				for k := 0; k < int(count); k++ {
+					x, err := values.next(p.typ)
+					if err != nil {
+						return fmt.Errorf("%s %d: %s: %w", e.name, i, p.name, err)
+					}
+					list = append(list, x)
+				}
+				if e.name == "face" && (p.name == "vertex_indices" || p.name == "vertex_index") {
+					if err := addPLYFace(m, list); err != nil {
+						return fmt.Errorf("%s %d: %w", e.name, i, err)
+					}
+				}
+				continue
+			}
+
+			x, err := values.next(p.typ)
+			if err != nil {
+				return fmt.Errorf("%s %d: %s: %w", e.name, i, p.name, err)
+			}
+			if !isVertex {
+				continue
+			}
+			switch p.name {
+			case "x":
+				v.X = x
+			case "y":
+				v.Y = x
+			case "z":
+				v.Z = x
+			case "nx":
+				n.X = x
+			case "ny":
+				n.Y = x
+			case "nz":
+				n.Z = x
+			case "red", "green", "blue":
+				// integer colors range up to 255, floating point ones up to 1
+				if p.typ != "float" && p.typ != "float32" && p.typ != "double" && p.typ != "float64" {
+					x /= 255
+				}
+				switch p.name {
+				case "red":
+					c.R = x
+				case "green":
+					c.G = x
+				case "blue":
+					c.B = x
+				}
+			case "u", "s", "texture_u":
+				uv.U = x
+			case "v", "t", "texture_v":
+				uv.V = x
+			}
+		}
+
+		if isVertex {
+			m.Verts = append(m.Verts, v)
+			if hasNormals {
+				if n != (Vec3{}) {
+					n = n.Normalize()
+				}
+				m.Normals = append(m.Normals, n)
+			}
+			if hasColors {
+				m.Colors = append(m.Colors, c)
+			}
+			if hasUVs {
+				m.UVs = append(m.UVs, uv)
+			}
+		}
+	}
+
+	return nil
+}
+
+// addPLYFace adds a polygon as a fan of triangles around its first vertex.
+func addPLYFace(m *IndexedMesh, corners []float64) error {
+	if len(corners) < 3 {
+		return fmt.Errorf("expected at least 3 vertices, got %d", len(corners))
+	}
+	for _, c := range corners {
+		if c < 0 || c > math.MaxInt32 {
+			return fmt.Errorf("bad vertex index %v", c)
+		}
+	}
+	for i := 2; i < len(corners); i++ {
+		m.Indices = append(m.Indices, int32(corners[0]), int32(corners[i-1]), int32(corners[i]))
+		m.TriangleMaterials = append(m.TriangleMaterials, -1)
+	}
+	return nil
+}
+
+// plyValues reads the values of the body of a PLY file one at a time.
+type plyValues interface {
+	next(typ string) (float64, error)
+}
+
+// plyASCII reads values separated by white space.
+type plyASCII struct {
+	s *bufio.Scanner
+}
+
+func (a *plyASCII) next(typ string) (float64, error) {
+	if !a.s.Scan() {
+		if err := a.s.Err(); err != nil {
+			return 0, err
+		}
+		return 0, io.ErrUnexpectedEOF
+	}
+	return strconv.ParseFloat(a.s.Text(), 64)
+}
+
+// plyBinary reads values in their binary representation.
+type plyBinary struct {
+	r     *bufio.Reader
+	order binary.ByteOrder
+	buf   [8]byte
+}
+
+func (b *plyBinary) next(typ string) (float64, error) {
+	p := b.buf[:plySizes[typ]]
+	if _, err := io.ReadFull(b.r, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+
+	switch typ {
+	case "char", "int8":
+		return float64(int8(p[0])), nil
+	case "uchar", "uint8":
+		return float64(p[0]), nil
+	case "short", "int16":
+		return float64(int16(b.order.Uint16(p))), nil
+	case "ushort", "uint16":
+		return float64(b.order.Uint16(p)), nil
+	case "int", "int32":
+		return float64(int32(b.order.Uint32(p))), nil
+	case "uint", "uint32":
+		return float64(b.order.Uint32(p)), nil
+	case "float", "float32":
+		return float64(math.Float32frombits(b.order.Uint32(p))), nil
+	default:
+		return math.Float64frombits(b.order.Uint64(p)), nil
+	}
+}
+
+// WritePLY writes the mesh as a binary little endian PLY file of vertices
+// with their normals, when the mesh has any, and colors, and of triangles.
+// PLY has no materials, so vertices without colors of their own get the
+// diffuse color of the material of a triangle. Texture coordinates are left
+// out.
+func (m *IndexedMesh) WritePLY(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	colors := m.Colors
+	if len(colors) == 0 {
+		colors = m.vertexColors()
+	}
+
+	fmt.Fprintf(bw, "ply\nformat binary_little_endian 1.0\nelement vertex %d\n", len(m.Verts))
+	fmt.Fprint(bw, "property float x\nproperty float y\nproperty float z\n")
+	if len(m.Normals) > 0 {
+		fmt.Fprint(bw, "property float nx\nproperty float ny\nproperty float nz\n")
+	}
+	fmt.Fprint(bw, "property uchar red\nproperty uchar green\nproperty uchar blue\n")
+	fmt.Fprintf(bw, "element face %d\nproperty list uchar int vertex_indices\nend_header\n", m.NumTriangles())
+
+	var buf []byte
+	le := binary.LittleEndian
+	float := func(f float64) {
+		buf = le.AppendUint32(buf, math.Float32bits(float32(f)))
+	}
+	channel := func(c float64) {
+		buf = append(buf, uint8(math.Round(255*min(max(c, 0), 1))))
+	}
+	for i, v := range m.Verts {
+		buf = buf[:0]
+		float(v.X)
+		float(v.Y)
+		float(v.Z)
+		if len(m.Normals) > 0 {
+			float(m.Normals[i].X)
+			float(m.Normals[i].Y)
+			float(m.Normals[i].Z)
+		}
+		channel(colors[i].R)
+		channel(colors[i].G)
+		channel(colors[i].B)
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < m.NumTriangles(); i++ {
+		buf = append(buf[:0], 3)
+		for _, v := range m.Indices[3*i : 3*i+3] {
+			buf = le.AppendUint32(buf, uint32(v))
+		}
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
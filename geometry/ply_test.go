@@ -0,0 +1,96 @@
+package geometry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/danradchuk/raytracer/shading"
+)
+
+const plyHeader = "element vertex 4\n" +
+	"property float x\nproperty float y\nproperty float z\n" +
+	"property float nx\nproperty float ny\nproperty float nz\n" +
+	"property uchar red\nproperty uchar green\nproperty uchar blue\n" +
+	"property float u\nproperty float v\n" +
+	"element face 1\n" +
+	"property list uchar int vertex_indices\n" +
+	"element edge 1\n" +
+	"property int vertex1\nproperty int vertex2\n" +
+	"end_header\n"
+
+func TestReadPLY(t *testing.T) {
+	ascii := "ply\nformat ascii 1.0\ncomment a unit quad\n" + plyHeader +
+		"0 0 0 0 0 2 255 0 0 0 0\n" +
+		"1 0 0 0 0 2 0 255 0 1 0\n" +
+		"1 1 0 0 0 2 0 0 255 1 1\n" +
+		"0 1 0 0 0 2 255 255 255 0 1\n" +
+		"4 0 1 2 3\n" +
+		"0 2\n"
+
+	// the same file in both binary byte orders
+	binaryPLY := func(format string, order binary.ByteOrder) string {
+		var buf bytes.Buffer
+		buf.WriteString("ply\nformat " + format + " 1.0\n" + plyHeader)
+		for _, v := range []struct {
+			pos, normal [3]float32
+			rgb         [3]uint8
+			uv          [2]float32
+		}{
+			{[3]float32{0, 0, 0}, [3]float32{0, 0, 2}, [3]uint8{255, 0, 0}, [2]float32{0, 0}},
+			{[3]float32{1, 0, 0}, [3]float32{0, 0, 2}, [3]uint8{0, 255, 0}, [2]float32{1, 0}},
+			{[3]float32{1, 1, 0}, [3]float32{0, 0, 2}, [3]uint8{0, 0, 255}, [2]float32{1, 1}},
+			{[3]float32{0, 1, 0}, [3]float32{0, 0, 2}, [3]uint8{255, 255, 255}, [2]float32{0, 1}},
+		} {
+			_ = binary.Write(&buf, order, v)
+		}
+		buf.WriteByte(4)
+		_ = binary.Write(&buf, order, []int32{0, 1, 2, 3})
+		_ = binary.Write(&buf, order, []int32{0, 2})
+		return buf.String()
+	}
+
+	for name, src := range map[string]string{
+		"ascii":                ascii,
+		"binary_little_endian": binaryPLY("binary_little_endian", binary.LittleEndian),
+		"binary_big_endian":    binaryPLY("binary_big_endian", binary.BigEndian),
+	} {
+		m, err := ReadPLY(strings.NewReader(src), name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if want := []int32{0, 1, 2, 0, 2, 3}; !slices.Equal(m.Indices, want) || !slices.Equal(m.UVIndices, want) {
+			t.Errorf("%s: indices %v and %v, want the fan %v", name, m.Indices, m.UVIndices, want)
+		}
+		if len(m.Verts) != 4 || m.Verts[2] != (Vec3{X: 1, Y: 1}) {
+			t.Errorf("%s: vertices %v", name, m.Verts)
+		}
+		if len(m.Normals) != 4 || m.Normals[0] != (Vec3{Z: 1}) {
+			t.Errorf("%s: normals %v, want normalized ones", name, m.Normals)
+		}
+		if len(m.Colors) != 4 || m.Colors[1] != (shading.Color{G: 1}) || m.Colors[3] != (shading.Color{R: 1, G: 1, B: 1}) {
+			t.Errorf("%s: colors %v", name, m.Colors)
+		}
+		if len(m.UVs) != 4 || m.UVs[2] != (UV{U: 1, V: 1}) {
+			t.Errorf("%s: texture coordinates %v", name, m.UVs)
+		}
+	}
+}
+
+func TestReadPLYErrors(t *testing.T) {
+	for _, tc := range []struct{ ply, err string }{
+		{"obj\n", "bad.ply: header line 1: not a PLY file"},
+		{"ply\nformat ascii 1.0\nelement vertex 1\nproperty half x\nend_header\n", `bad.ply: header line 4: property x: unknown type "half"`},
+		{"ply\nformat ascii 1.0\nelement vertex 2\nproperty float x\nend_header\n1\n", "bad.ply: vertex 1: x: unexpected EOF"},
+		{"ply\nformat ascii 1.0\nelement vertex 1\nproperty float x\nproperty float y\nproperty float z\n" +
+			"element face 1\nproperty list uchar int vertex_indices\nend_header\n0 0 0\n3 0 1 2\n", "bad.ply: face: index 1 out of range, 1 vertices"},
+	} {
+		_, err := ReadPLY(strings.NewReader(tc.ply), "bad.ply")
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("ReadPLY(%q) = %v, want %q", tc.ply, err, tc.err)
+		}
+	}
+}
@@ -44,3 +44,8 @@ func (p Point3) GetCoordinateByAxis(axis int) float64 {
 
 	return p.Z
 }
+
+// UV represents a point in 2D texture space.
+type UV struct {
+	U, V float64
+}
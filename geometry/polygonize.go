@@ -0,0 +1,209 @@
+package geometry
+
+import (
+	"math"
+	"slices"
+
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// FieldCells is the number of grid cells along the longest side of the
+// bounds over which CSG solids, SDF shapes, quadrics and implicit surfaces
+// are polygonized.
+const FieldCells = 48
+
+// field describes a surface by its sides: inside reports whether a point is
+// on the inner side, and crossing returns the point where the segment from
+// the inner point a to the outer point b crosses the surface, with its
+// outward normal and its material.
+type field struct {
+	inside   func(p Vec3) bool
+	crossing func(a, b Vec3) (Vec3, Vec3, shading.Material)
+}
+
+// scalarField returns the field of the surface where f is zero, inside
+// where it is negative, with the normals along the gradient of f.
+func scalarField(f func(Vec3) float64, gradient func(Vec3) Vec3, material shading.Material) field {
+	return field{
+		inside: func(p Vec3) bool { return f(p) < 0 },
+		crossing: func(a, b Vec3) (Vec3, Vec3, shading.Material) {
+			// bisection keeps to the segment where interpolation might not
+			for i := 0; i < 30; i++ {
+				mid := a.Lerp(b, .5)
+				if f(mid) < 0 {
+					a = mid
+				} else {
+					b = mid
+				}
+			}
+			p := a.Lerp(b, .5)
+			n := gradient(p)
+			if n == (Vec3{}) {
+				n = b.Sub(a)
+			}
+			return p, n.Normalize(), material
+		},
+	}
+}
+
+// solidField returns the field of a solid, whose inside is found by the
+// hits of a line through the point and whose surface by the hits of the
+// segment, which have the materials of the parts of a CSG solid.
+func solidField(s Solid) field {
+	// an oblique direction, which grazes edges of boxes less often
+	dir := Vec3{X: .48, Y: .6, Z: .64}
+	return field{
+		inside: func(p Vec3) bool {
+			// the line is inside after the even hits
+			n := 0
+			for _, hit := range s.Hits(Ray{Origin: p, Direction: dir}) {
+				if hit.T <= 0 {
+					n++
+				}
+			}
+			return n%2 == 1
+		},
+		crossing: func(a, b Vec3) (Vec3, Vec3, shading.Material) {
+			// the hit closest to the middle of the segment
+			var best *HitRecord
+			hits := s.Hits(Ray{Origin: a, Direction: b.Sub(a)})
+			for i := range hits {
+				if best == nil || math.Abs(hits[i].T-.5) < math.Abs(best.T-.5) {
+					best = &hits[i]
+				}
+			}
+			if best == nil {
+				return a.Lerp(b, .5), b.Sub(a).Normalize(), shading.RedRubber
+			}
+			t := min(max(best.T, 0), 1)
+			return a.Lerp(b, t), best.Normal, best.Material
+		},
+	}
+}
+
+// cubeTetrahedra divide a grid cell along its diagonal from corner 0 to
+// corner 7; bit 0 of a corner is its X, bit 1 its Y and bit 2 its Z.
+var cubeTetrahedra = [6][4]int{
+	{0, 7, 1, 3}, {0, 7, 3, 2}, {0, 7, 2, 6}, {0, 7, 6, 4}, {0, 7, 4, 5}, {0, 7, 5, 1},
+}
+
+// polygonize returns the surface of the field within the bounds by marching
+// tetrahedra over a grid of FieldCells along the longest side. Vertices are
+// shared between the triangles, which face outwards. The surface
+// ends at the bounds, where it isn't closed.
+func polygonize(name string, b Bounds3, f field) *IndexedMesh {
+	lo := Vec3{X: b.PMin.X, Y: b.PMin.Y, Z: b.PMin.Z}
+	size := Vec3{X: b.PMax.X - b.PMin.X, Y: b.PMax.Y - b.PMin.Y, Z: b.PMax.Z - b.PMin.Z}
+	cell := max(size.X, size.Y, size.Z) / FieldCells
+	m := &IndexedMesh{}
+	if !(cell > 0) || math.IsInf(cell, 0) {
+		m.Groups = []Group{{Name: name}}
+		return m
+	}
+
+	var n [3]int
+	for i, s := range vecArray(size) {
+		n[i] = max(1, int(math.Ceil(s/cell-1e-9)))
+	}
+	step := Vec3{X: size.X / float64(n[0]), Y: size.Y / float64(n[1]), Z: size.Z / float64(n[2])}
+	index := func(i, j, k int) int { return i + (n[0]+1)*(j+(n[1]+1)*k) }
+	point := func(g int) Vec3 {
+		i, j, k := g%(n[0]+1), g/(n[0]+1)%(n[1]+1), g/((n[0]+1)*(n[1]+1))
+		return lo.Add(Vec3{X: float64(i) * step.X, Y: float64(j) * step.Y, Z: float64(k) * step.Z})
+	}
+
+	inside := make([]bool, (n[0]+1)*(n[1]+1)*(n[2]+1))
+	for g := range inside {
+		inside[g] = f.inside(point(g))
+	}
+
+	// the vertex of each edge of the tetrahedra crossing the surface, from
+	// the inner corner to the outer one; neighbouring cells split their
+	// common faces alike, so they share the vertex. Edges meeting where the
+	// surface goes through a corner share it too.
+	vertices := make(map[[2]int]int32)
+	welded := make(map[Vec3]int32)
+	var vertexMaterials []int32
+	vertex := func(in, out int) int32 {
+		if v, ok := vertices[[2]int{in, out}]; ok {
+			return v
+		}
+		p, normal, material := f.crossing(point(in), point(out))
+		if v, ok := welded[p]; ok {
+			vertices[[2]int{in, out}] = v
+			return v
+		}
+		mat := slices.Index(m.Materials, material)
+		if mat < 0 {
+			mat = len(m.Materials)
+			m.Materials = append(m.Materials, material)
+		}
+
+		v := int32(len(m.Verts))
+		m.Verts = append(m.Verts, p)
+		m.Normals = append(m.Normals, normal)
+		vertices[[2]int{in, out}] = v
+		welded[p] = v
+		vertexMaterials = append(vertexMaterials, int32(mat))
+		return v
+	}
+	triangle := func(a, b, c int32, outward Vec3) {
+		if a == b || b == c || c == a {
+			return
+		}
+		pa, pb, pc := m.Verts[a], m.Verts[b], m.Verts[c]
+		if pb.Sub(pa).Cross(pc.Sub(pa)).Dot(outward) < 0 {
+			b, c = c, b
+		}
+		m.Indices = append(m.Indices, a, b, c)
+		m.TriangleMaterials = append(m.TriangleMaterials, vertexMaterials[a])
+	}
+
+	for k := 0; k < n[2]; k++ {
+		for j := 0; j < n[1]; j++ {
+			for i := 0; i < n[0]; i++ {
+				var corners [8]int
+				for c := range corners {
+					corners[c] = index(i+(c&1), j+(c>>1&1), k+(c>>2&1))
+				}
+				for _, tet := range cubeTetrahedra {
+					var in, out []int
+					for _, c := range tet {
+						if g := corners[c]; inside[g] {
+							in = append(in, g)
+						} else {
+							out = append(out, g)
+						}
+					}
+					if len(in) == 0 || len(out) == 0 {
+						continue
+					}
+
+					// from the inner corners to the outer ones
+					var center [2]Vec3
+					for side, gs := range [2][]int{in, out} {
+						for _, g := range gs {
+							center[side] = center[side].Add(point(g).Scale(1 / float64(len(gs))))
+						}
+					}
+					outward := center[1].Sub(center[0])
+
+					switch len(in) {
+					case 1:
+						triangle(vertex(in[0], out[0]), vertex(in[0], out[1]), vertex(in[0], out[2]), outward)
+					case 3:
+						triangle(vertex(in[0], out[0]), vertex(in[1], out[0]), vertex(in[2], out[0]), outward)
+					case 2:
+						a, b := vertex(in[0], out[0]), vertex(in[0], out[1])
+						c, d := vertex(in[1], out[1]), vertex(in[1], out[0])
+						triangle(a, b, c, outward)
+						triangle(a, c, d, outward)
+					}
+				}
+			}
+		}
+	}
+
+	m.Groups = []Group{{Name: name, Count: m.NumTriangles()}}
+	return m
+}
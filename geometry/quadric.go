@@ -0,0 +1,159 @@
+package geometry
+
+import (
+	"math"
+
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// Quadric represents the surface
+//
+//	Squares.X*x^2 + Squares.Y*y^2 + Squares.Z*z^2 +
+//	Mixed.X*x*y + Mixed.Y*x*z + Mixed.Z*y*z +
+//	Linear.X*x + Linear.Y*y + Linear.Z*z + Constant = 0
+//
+// within the box between Min and Max. Ellipsoids, whose squares make a
+// definite form, have outward normals; the normals of the other quadrics,
+// which are open, face the ray, so both of their sides are lit. Quadrics have
+// no texture coordinates.
+type Quadric struct {
+	Squares, Mixed, Linear Vec3
+	Constant               float64
+	Min, Max               Vec3
+	Material               shading.Material
+}
+
+// NewEllipsoid returns the ellipsoid around center with the radii along X,
+// Y and Z.
+func NewEllipsoid(center, radii Vec3, material shading.Material) Quadric {
+	q := scaledQuadric(Vec3{X: 1, Y: 1, Z: 1}, Vec3{}, -1, center, radii)
+	extent := Vec3{X: math.Abs(radii.X), Y: math.Abs(radii.Y), Z: math.Abs(radii.Z)}
+	q.Min, q.Max, q.Material = center.Sub(extent), center.Add(extent), material
+	return q
+}
+
+// NewParaboloid returns the paraboloid y/b = x^2/a^2 + z^2/c^2 for the radii
+// a, b and c, which opens upwards from its vertex at center up to height:
+// radii.Y above the vertex it has the radii radii.X and radii.Z.
+func NewParaboloid(center, radii Vec3, height float64, material shading.Material) Quadric {
+	q := scaledQuadric(Vec3{X: 1, Z: 1}, Vec3{Y: -1}, 0, center, radii)
+	k := math.Sqrt(height / math.Abs(radii.Y))
+	extent := Vec3{X: math.Abs(radii.X) * k, Z: math.Abs(radii.Z) * k}
+	q.Min = center.Sub(extent)
+	q.Max = center.Add(extent).Add(Vec3{Y: height})
+	q.Material = material
+	return q
+}
+
+// NewHyperboloid returns the hyperboloid x^2/a^2 - y^2/b^2 + z^2/c^2 = 1 of
+// one sheet, or = -1 of two sheets, for the radii a, b and c around the
+// vertical axis through center, cut off height above and below center.
+func NewHyperboloid(center, radii Vec3, height float64, sheets int, material shading.Material) Quadric {
+	k := 1.
+	if sheets == 2 {
+		k = -1
+	}
+	q := scaledQuadric(Vec3{X: 1, Y: -1, Z: 1}, Vec3{}, -k, center, radii)
+	s := math.Sqrt(max(0, height*height/(radii.Y*radii.Y)+k))
+	extent := Vec3{X: math.Abs(radii.X) * s, Y: height, Z: math.Abs(radii.Z) * s}
+	q.Min, q.Max, q.Material = center.Sub(extent), center.Add(extent), material
+	return q
+}
+
+// scaledQuadric returns the quadric of p with the squares, linear terms and
+// constant given for (p - center)/radii.
+func scaledQuadric(squares, linear Vec3, constant float64, center, radii Vec3) Quadric {
+	var q Quadric
+	q.Constant = constant
+	axis := func(square, linear, center, radius float64) (float64, float64) {
+		s := 1 / radius
+		q.Constant += square*center*center*s*s - linear*center*s
+		return square * s * s, linear*s - 2*square*center*s*s
+	}
+	q.Squares.X, q.Linear.X = axis(squares.X, linear.X, center.X, radii.X)
+	q.Squares.Y, q.Linear.Y = axis(squares.Y, linear.Y, center.Y, radii.Y)
+	q.Squares.Z, q.Linear.Z = axis(squares.Z, linear.Z, center.Z, radii.Z)
+	return q
+}
+
+// Intersect computes the intersection of a ray with the quadric by solving
+// a quadratic equation from where the ray enters the bounds.
+func (q Quadric) Intersect(r Ray) *HitRecord {
+	tNear, tFar, _, _, ok := Box{Min: q.Min, Max: q.Max}.slabs(r.Origin, r.Direction)
+	if !ok || tFar < 0 {
+		return nil
+	}
+
+	// the equation of distant rays loses precision, so start at the bounds
+	start := max(tNear, 0)
+	o := r.At(start)
+	for _, root := range solveQuadratic(q.form(r.Direction), q.Gradient(o).Dot(r.Direction), q.Eval(o)) {
+		if t := start + root; root >= 0 && t <= tFar {
+			return &HitRecord{T: t, Primitive: q, Material: q.Material, Normal: q.normal(r, t)}
+		}
+	}
+	return nil
+}
+
+// Eval returns the value of the equation of the quadric at p, positive
+// outside an ellipsoid.
+func (q Quadric) Eval(p Vec3) float64 {
+	return q.form(p) + q.Linear.Dot(p) + q.Constant
+}
+
+// Gradient returns the gradient of the equation of the quadric at p.
+func (q Quadric) Gradient(p Vec3) Vec3 {
+	return Vec3{
+		X: 2*q.Squares.X*p.X + q.Mixed.X*p.Y + q.Mixed.Y*p.Z + q.Linear.X,
+		Y: 2*q.Squares.Y*p.Y + q.Mixed.X*p.X + q.Mixed.Z*p.Z + q.Linear.Y,
+		Z: 2*q.Squares.Z*p.Z + q.Mixed.Y*p.X + q.Mixed.Z*p.Y + q.Linear.Z,
+	}
+}
+
+// form returns the value of the squares and mixed terms at p.
+func (q Quadric) form(p Vec3) float64 {
+	return q.Squares.X*p.X*p.X + q.Squares.Y*p.Y*p.Y + q.Squares.Z*p.Z*p.Z +
+		q.Mixed.X*p.X*p.Y + q.Mixed.Y*p.X*p.Z + q.Mixed.Z*p.Y*p.Z
+}
+
+// definite returns 1 if the squares and mixed terms make a positive definite
+// form, -1 if they make a negative definite one, and 0 otherwise, by the
+// signs of the leading minors of their matrix.
+func (q Quadric) definite() int {
+	a, b, c := q.Squares.X, q.Squares.Y, q.Squares.Z
+	d, e, f := q.Mixed.X/2, q.Mixed.Y/2, q.Mixed.Z/2
+	m2 := a*b - d*d
+	m3 := a*(b*c-f*f) - d*(d*c-f*e) + e*(d*f-b*e)
+	switch {
+	case a > 0 && m2 > 0 && m3 > 0:
+		return 1
+	case a < 0 && m2 > 0 && m3 < 0:
+		return -1
+	}
+	return 0
+}
+
+// normal returns the normal of the hit of the ray at t.
+func (q Quadric) normal(r Ray, t float64) Vec3 {
+	n := q.Gradient(r.At(t))
+	switch q.definite() {
+	case -1:
+		n = n.Scale(-1)
+	case 0:
+		if n.Dot(r.Direction) > 0 {
+			n = n.Scale(-1)
+		}
+	}
+	if n == (Vec3{}) {
+		return r.Direction.Scale(-1).Normalize()
+	}
+	return n.Normalize()
+}
+
+// Bounds returns the bounds given with the quadric.
+func (q Quadric) Bounds() Bounds3 {
+	return Bounds3{
+		PMin: Point3{X: q.Min.X, Y: q.Min.Y, Z: q.Min.Z},
+		PMax: Point3{X: q.Max.X, Y: q.Max.Y, Z: q.Max.Z},
+	}
+}
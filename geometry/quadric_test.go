@@ -0,0 +1,93 @@
+package geometry
+
+import (
+	"math"
+	"testing"
+
+	"github.com/danradchuk/raytracer/shading"
+)
+
+func TestQuadricIntersect(t *testing.T) {
+	ellipsoid := NewEllipsoid(Vec3{Y: 1}, Vec3{X: 2, Y: 1, Z: 1}, shading.Ivory)
+	paraboloid := NewParaboloid(Vec3{}, Vec3{X: 1, Y: 1, Z: 1}, 4, shading.Ivory)
+	hyperboloid := NewHyperboloid(Vec3{}, Vec3{X: 1, Y: 1, Z: 1}, 2, 1, shading.Ivory)
+	twoSheets := NewHyperboloid(Vec3{}, Vec3{X: 1, Y: 1, Z: 1}, 2, 2, shading.Ivory)
+
+	tests := []struct {
+		name   string
+		q      Quadric
+		ray    Ray
+		t      float64
+		normal Vec3
+	}{
+		{"ellipsoid", ellipsoid, Ray{Origin: Vec3{X: -5, Y: 1}, Direction: Vec3{X: 1}}, 3, Vec3{X: -1}},
+		{"inside the ellipsoid", ellipsoid, Ray{Origin: Vec3{Y: 1}, Direction: Vec3{Y: 2}}, .5, Vec3{Y: 1}},
+		{"paraboloid from above", paraboloid, Ray{Origin: Vec3{Y: 5}, Direction: Vec3{Y: -1}}, 5, Vec3{Y: 1}},
+		{"paraboloid from the side", paraboloid, Ray{Origin: Vec3{X: -5, Y: 1}, Direction: Vec3{X: 1}}, 4, Vec3{X: -2, Y: -1}.Normalize()},
+		{"inside of the paraboloid", paraboloid, Ray{Origin: Vec3{Y: 1}, Direction: Vec3{X: 1}}, 1, Vec3{X: -2, Y: 1}.Normalize()},
+		{"waist of the hyperboloid", hyperboloid, Ray{Origin: Vec3{Z: -5}, Direction: Vec3{Z: 1}}, 4, Vec3{Z: -1}},
+		{"through the hyperboloid", hyperboloid, Ray{Origin: Vec3{Y: -5}, Direction: Vec3{Y: 1}}, math.Inf(1), Vec3{}},
+		{"two sheets", twoSheets, Ray{Origin: Vec3{Y: -5}, Direction: Vec3{Y: 1}}, 4, Vec3{Y: -1}},
+		// the cylinder x^2 + z^2 = 1 given by its coefficients, cut at |y| <= 1
+		{"coefficients", Quadric{Squares: Vec3{X: 1, Z: 1}, Constant: -1, Min: Vec3{-1, -1, -1}, Max: Vec3{1, 1, 1}},
+			Ray{Origin: Vec3{X: 5, Y: .5}, Direction: Vec3{X: -1}}, 4, Vec3{X: 1}},
+		{"above the cut", Quadric{Squares: Vec3{X: 1, Z: 1}, Constant: -1, Min: Vec3{-1, -1, -1}, Max: Vec3{1, 1, 1}},
+			Ray{Origin: Vec3{X: 5, Y: 1.5}, Direction: Vec3{X: -1}}, math.Inf(1), Vec3{}},
+		// the rotated saddle z = x*y
+		{"saddle", Quadric{Mixed: Vec3{X: 1}, Linear: Vec3{Z: -1}, Min: Vec3{-1, -1, -1}, Max: Vec3{1, 1, 1}},
+			Ray{Origin: Vec3{X: .5, Y: .5, Z: 5}, Direction: Vec3{Z: -1}}, 4.75, Vec3{X: -.5, Y: -.5, Z: 1}.Normalize()},
+	}
+
+	for _, test := range tests {
+		hit := test.q.Intersect(test.ray)
+		if math.IsInf(test.t, 1) {
+			if hit != nil {
+				t.Errorf("%s: hit at %v", test.name, hit.T)
+			}
+			continue
+		}
+		if hit == nil {
+			t.Errorf("%s: no hit", test.name)
+			continue
+		}
+		if math.Abs(hit.T-test.t) > 1e-9 || !near(hit.Normal, test.normal) {
+			t.Errorf("%s: hit at %v with normal %v, want %v and %v", test.name, hit.T, hit.Normal, test.t, test.normal)
+		}
+	}
+}
+
+func TestQuadricInstances(t *testing.T) {
+	center := Vec3{X: 1, Y: 2, Z: 3}
+	radii := Vec3{X: 2, Y: .5, Z: 3}
+	tests := []struct {
+		name    string
+		q       Quadric
+		surface []Vec3
+		min     Vec3
+		max     Vec3
+	}{
+		{"ellipsoid", NewEllipsoid(center, radii, shading.Material{}),
+			[]Vec3{{X: 3, Y: 2, Z: 3}, {X: 1, Y: 1.5, Z: 3}, {X: 1, Y: 2, Z: 6}},
+			Vec3{X: -1, Y: 1.5, Z: 0}, Vec3{X: 3, Y: 2.5, Z: 6}},
+		{"paraboloid", NewParaboloid(center, radii, 2, shading.Material{}),
+			[]Vec3{{X: 1, Y: 2, Z: 3}, {X: 3, Y: 2.5, Z: 3}, {X: 1, Y: 4, Z: 9}},
+			Vec3{X: -3, Y: 2, Z: -3}, Vec3{X: 5, Y: 4, Z: 9}},
+		{"hyperboloid", NewHyperboloid(center, radii, 1, 1, shading.Material{}),
+			[]Vec3{{X: 3, Y: 2, Z: 3}, {X: 1, Y: 2, Z: 0}, {X: 1 + 2*math.Sqrt(5), Y: 3, Z: 3}},
+			Vec3{X: 1 - 2*math.Sqrt(5), Y: 1, Z: 3 - 3*math.Sqrt(5)}, Vec3{X: 1 + 2*math.Sqrt(5), Y: 3, Z: 3 + 3*math.Sqrt(5)}},
+		{"hyperboloid of two sheets", NewHyperboloid(center, radii, 1, 2, shading.Material{}),
+			[]Vec3{{X: 1, Y: 1.5, Z: 3}, {X: 1, Y: 2.5, Z: 3}, {X: 1 + 2*math.Sqrt(3), Y: 1, Z: 3}},
+			Vec3{X: 1 - 2*math.Sqrt(3), Y: 1, Z: 3 - 3*math.Sqrt(3)}, Vec3{X: 1 + 2*math.Sqrt(3), Y: 3, Z: 3 + 3*math.Sqrt(3)}},
+	}
+
+	for _, test := range tests {
+		for _, p := range test.surface {
+			if f := test.q.Eval(p); math.Abs(f) > 1e-9 {
+				t.Errorf("%s: %v is %v off the surface", test.name, p, f)
+			}
+		}
+		if !near(test.q.Min, test.min) || !near(test.q.Max, test.max) {
+			t.Errorf("%s: bounds %v to %v, want %v to %v", test.name, test.q.Min, test.q.Max, test.min, test.max)
+		}
+	}
+}
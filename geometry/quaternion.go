@@ -0,0 +1,75 @@
+package geometry
+
+import "math"
+
+// quaternion is the unit quaternion w + xi + yj + zk of a rotation.
+type quaternion struct {
+	w, x, y, z float64
+}
+
+// quaternionFromMatrix returns the quaternion of the rotation matrix m.
+func quaternionFromMatrix(m Matrix4) quaternion {
+	// start from the largest of the four components, which keeps the
+	// divisions away from zero
+	var q quaternion
+	switch tr := m[0][0] + m[1][1] + m[2][2]; {
+	case tr > 0:
+		s := math.Sqrt(tr+1) * 2
+		q = quaternion{w: s / 4, x: (m[2][1] - m[1][2]) / s, y: (m[0][2] - m[2][0]) / s, z: (m[1][0] - m[0][1]) / s}
+	case m[0][0] > m[1][1] && m[0][0] > m[2][2]:
+		s := math.Sqrt(1+m[0][0]-m[1][1]-m[2][2]) * 2
+		q = quaternion{w: (m[2][1] - m[1][2]) / s, x: s / 4, y: (m[0][1] + m[1][0]) / s, z: (m[0][2] + m[2][0]) / s}
+	case m[1][1] > m[2][2]:
+		s := math.Sqrt(1+m[1][1]-m[0][0]-m[2][2]) * 2
+		q = quaternion{w: (m[0][2] - m[2][0]) / s, x: (m[0][1] + m[1][0]) / s, y: s / 4, z: (m[1][2] + m[2][1]) / s}
+	default:
+		s := math.Sqrt(1+m[2][2]-m[0][0]-m[1][1]) * 2
+		q = quaternion{w: (m[1][0] - m[0][1]) / s, x: (m[0][2] + m[2][0]) / s, y: (m[1][2] + m[2][1]) / s, z: s / 4}
+	}
+	return q.normalize()
+}
+
+func (q quaternion) dot(p quaternion) float64 {
+	return q.w*p.w + q.x*p.x + q.y*p.y + q.z*p.z
+}
+
+func (q quaternion) scale(s float64) quaternion {
+	return quaternion{w: q.w * s, x: q.x * s, y: q.y * s, z: q.z * s}
+}
+
+func (q quaternion) add(p quaternion) quaternion {
+	return quaternion{w: q.w + p.w, x: q.x + p.x, y: q.y + p.y, z: q.z + p.z}
+}
+
+func (q quaternion) normalize() quaternion {
+	return q.scale(1 / math.Sqrt(q.dot(q)))
+}
+
+// slerp interpolates the rotations q and p along the shorter arc at a
+// constant angular speed.
+func (q quaternion) slerp(p quaternion, t float64) quaternion {
+	cos := q.dot(p)
+	if cos < 0 {
+		// q and -q are the same rotation
+		p, cos = p.scale(-1), -cos
+	}
+	if cos > 0.9995 {
+		// nearly parallel; linear interpolation is accurate and stable
+		return q.scale(1 - t).add(p.scale(t)).normalize()
+	}
+
+	theta := math.Acos(cos)
+	sin := math.Sin(theta)
+	return q.scale(math.Sin((1-t)*theta) / sin).add(p.scale(math.Sin(t*theta) / sin))
+}
+
+// matrix returns the rotation matrix of q.
+func (q quaternion) matrix() Matrix4 {
+	w, x, y, z := q.w, q.x, q.y, q.z
+	return Matrix4{
+		{1 - 2*(y*y+z*z), 2 * (x*y - w*z), 2 * (x*z + w*y), 0},
+		{2 * (x*y + w*z), 1 - 2*(x*x+z*z), 2 * (y*z - w*x), 0},
+		{2 * (x*z - w*y), 2 * (y*z + w*x), 1 - 2*(x*x+y*y), 0},
+		{0, 0, 0, 1},
+	}
+}
@@ -6,11 +6,30 @@ import "math"
 type Ray struct {
 	Origin    Vec3
 	Direction Vec3
+	Time      float64         // the moment within the exposure, in frames
+	Stats     *TraversalStats // counts the intersection tests of the ray; may be nil
+}
+
+// TraversalStats counts the work of intersecting rays with a BVH.
+type TraversalStats struct {
+	NodeTests      int64 // bounding boxes of BVH nodes tested
+	PrimitiveTests int64 // primitives in BVH leaves tested
+}
+
+// Add adds the counts of other to ts.
+func (ts *TraversalStats) Add(other TraversalStats) {
+	ts.NodeTests += other.NodeTests
+	ts.PrimitiveTests += other.PrimitiveTests
 }
 
 // NewPrimaryRay creates a primary (camera) ray from the camera for a given screen position (x, y)
 // with the specified field of view (fov).
 func NewPrimaryRay(eye Vec3, width, height float64, x, y float64, fov float64) Ray {
+	return NewCameraRay(eye, Vec3{0., 0., 1.}, width, height, x, y, fov)
+}
+
+// NewCameraRay creates a primary ray like NewPrimaryRay from a camera at eye looking at target.
+func NewCameraRay(eye, target Vec3, width, height float64, x, y float64, fov float64) Ray {
 	aspectRatio := width / height
 	fovRad := (float64(fov) * math.Pi) / 180
 	angle := math.Tan(fovRad * 0.5)
@@ -20,11 +39,10 @@ func NewPrimaryRay(eye Vec3, width, height float64, x, y float64, fov float64) R
 	beta := (1 - 2.*((y+.5)/height)) * angle
 
 	// for left-handed coordinate system
-	lookAt := Vec3{0., 0., 1.}
 	up := Vec3{0., 1., 0.}
 
 	// u,v,w unit basis vectors
-	w := eye.Sub(lookAt).Normalize() // z-axis
+	w := eye.Sub(target).Normalize() // z-axis
 	u := w.Cross(up).Normalize()     // x-axis
 	v := u.Cross(w)                  // y-axis
 
@@ -45,6 +63,16 @@ func NewSecondaryRay(o Vec3, d Vec3) Ray {
 	}
 }
 
+// Spawn creates a secondary ray at the time of r that is counted with r.
+func (r Ray) Spawn(o Vec3, d Vec3) Ray {
+	return Ray{
+		Origin:    o,
+		Direction: d,
+		Time:      r.Time,
+		Stats:     r.Stats,
+	}
+}
+
 // At calculates the position of the ray at distance t.
 func (r Ray) At(t float64) Vec3 {
 	return r.Origin.Add(r.Direction.Scale(t))
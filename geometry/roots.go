@@ -0,0 +1,204 @@
+package geometry
+
+import (
+	"math"
+	"slices"
+)
+
+// solveQuadratic returns the real roots of a*x^2 + b*x + c in increasing
+// order. It avoids the cancellation of the textbook formula.
+func solveQuadratic(a, b, c float64) []float64 {
+	if a == 0 {
+		if b == 0 {
+			return nil
+		}
+		return []float64{-c / b}
+	}
+
+	d := b*b - 4*a*c
+	if d < 0 {
+		return nil
+	}
+
+	q := -.5 * (b + math.Copysign(math.Sqrt(d), b))
+	if q == 0 {
+		return []float64{0, 0}
+	}
+	x1, x2 := q/a, c/q
+	if x1 > x2 {
+		x1, x2 = x2, x1
+	}
+	return []float64{x1, x2}
+}
+
+// solveCubic returns the real roots of x^3 + a*x^2 + b*x + c.
+func solveCubic(a, b, c float64) []float64 {
+	q := (a*a - 3*b) / 9
+	r := (2*a*a*a - 9*a*b + 27*c) / 54
+
+	if r*r < q*q*q {
+		// three real roots
+		theta := math.Acos(r / math.Sqrt(q*q*q))
+		s := -2 * math.Sqrt(q)
+		return []float64{
+			s*math.Cos(theta/3) - a/3,
+			s*math.Cos((theta+2*math.Pi)/3) - a/3,
+			s*math.Cos((theta-2*math.Pi)/3) - a/3,
+		}
+	}
+
+	u := -math.Copysign(math.Cbrt(math.Abs(r)+math.Sqrt(r*r-q*q*q)), r)
+	v := 0.
+	if u != 0 {
+		v = q / u
+	}
+	return []float64{u + v - a/3}
+}
+
+// solveQuartic returns the real roots of a*x^4 + b*x^3 + c*x^2 + d*x + e in
+// increasing order. The roots of Ferrari's method are polished with Newton
+// steps on the original polynomial.
+func solveQuartic(a, b, c, d, e float64) []float64 {
+	if a == 0 {
+		roots := solveCubic3(b, c, d, e)
+		slices.Sort(roots)
+		return roots
+	}
+	b, c, d, e = b/a, c/a, d/a, e/a
+
+	// the depressed quartic y^4 + p*y^2 + q*y + r with x = y - b/4
+	bb := b * b
+	p := c - 3*bb/8
+	q := d - b*c/2 + bb*b/8
+	r := e - b*d/4 + bb*c/16 - 3*bb*bb/256
+
+	var ys []float64
+	if math.Abs(q) < 1e-12 {
+		// biquadratic
+		for _, z := range solveQuadratic(1, p, r) {
+			if z >= 0 {
+				ys = append(ys, -math.Sqrt(z), math.Sqrt(z))
+			}
+		}
+	} else {
+		// a positive root m of the resolvent cubic splits the quartic
+		// into two quadratics
+		m := 0.
+		for _, x := range solveCubic(p, p*p/4-r, -q*q/8) {
+			m = max(m, x)
+		}
+		if m <= 0 {
+			return nil
+		}
+		s := math.Sqrt(2 * m)
+		ys = append(ys, solveQuadratic(1, -s, p/2+m+q/(2*s))...)
+		ys = append(ys, solveQuadratic(1, s, p/2+m-q/(2*s))...)
+	}
+
+	roots := make([]float64, 0, len(ys))
+	for _, y := range ys {
+		x := y - b/4
+		for range 2 {
+			f := (((x+b)*x+c)*x+d)*x + e
+			df := ((4*x+3*b)*x+2*c)*x + d
+			if df == 0 {
+				break
+			}
+			x -= f / df
+		}
+		roots = append(roots, x)
+	}
+	slices.Sort(roots)
+	return roots
+}
+
+// solveCubic3 returns the real roots of a*x^3 + b*x^2 + c*x + d.
+func solveCubic3(a, b, c, d float64) []float64 {
+	if a == 0 {
+		return solveQuadratic(b, c, d)
+	}
+	return solveCubic(b/a, c/a, d/a)
+}
+
+// polyRoots returns the real roots of the polynomial c[0] + c[1]*x + ... +
+// c[n]*x^n in [lo, hi] in increasing order. The roots of its derivative
+// split the interval into pieces where it is monotonic, so that each holds
+// at most one root, which is found by Newton steps kept inside the piece by
+// bisection. Roots where the polynomial only touches zero are missed unless
+// it vanishes there exactly.
+func polyRoots(c []float64, lo, hi float64) []float64 {
+	n := len(c) - 1
+	for n > 0 && c[n] == 0 {
+		n--
+	}
+	if n <= 0 {
+		return nil
+	}
+	if n == 1 {
+		if x := -c[0] / c[1]; lo <= x && x <= hi {
+			return []float64{x}
+		}
+		return nil
+	}
+
+	d := make([]float64, n)
+	for i := range d {
+		d[i] = float64(i+1) * c[i+1]
+	}
+
+	var roots []float64
+	a, fa := lo, polyEval(c, lo)
+	if fa == 0 {
+		roots = append(roots, lo)
+	}
+	for _, b := range append(polyRoots(d, lo, hi), hi) {
+		if b <= a {
+			continue
+		}
+		fb := polyEval(c, b)
+		if fb == 0 {
+			roots = append(roots, b)
+		} else if fa != 0 && (fa < 0) != (fb < 0) {
+			roots = append(roots, polyRefine(c, d, a, b, fa))
+		}
+		a, fa = b, fb
+	}
+	return roots
+}
+
+// polyRefine returns the root of the polynomial c with the derivative d in
+// [a, b], where it changes sign from fa at a.
+func polyRefine(c, d []float64, a, b, fa float64) float64 {
+	x := (a + b) / 2
+	for range 100 {
+		fx := polyEval(c, x)
+		if fx == 0 {
+			return x
+		}
+		if (fx < 0) == (fa < 0) {
+			a = x
+		} else {
+			b = x
+		}
+		if b-a <= 1e-15*max(1, math.Abs(a), math.Abs(b)) {
+			break
+		}
+
+		// a Newton step, or bisection when it leaves the bracket
+		next := x - fx/polyEval(d, x)
+		if !(next > a && next < b) {
+			next = (a + b) / 2
+		}
+		x = next
+	}
+	return x
+}
+
+// polyEval evaluates the polynomial c[0] + c[1]*x + ... by Horner's rule.
+func polyEval(c []float64, x float64) float64 {
+	f := 0.
+	for i := len(c) - 1; i >= 0; i-- {
+		f = f*x + c[i]
+	}
+	return f
+}
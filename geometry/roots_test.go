@@ -0,0 +1,86 @@
+package geometry
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSolveQuartic(t *testing.T) {
+	tests := []struct {
+		coeffs [5]float64
+		want   []float64
+	}{
+		// (x+3)(x-0.5)(x-1)(x-2)
+		{[5]float64{1, -0.5, -7, 9.5, -3}, []float64{-3, .5, 1, 2}},
+		// 2(x^2-1)(x^2-4)
+		{[5]float64{2, 0, -10, 0, 8}, []float64{-2, -1, 1, 2}},
+		// (x-1)^2 (x^2+1)
+		{[5]float64{1, -2, 2, -2, 1}, []float64{1, 1}},
+		{[5]float64{1, 0, 0, 0, 1}, nil},
+		// a cubic: (x-1)(x-2)(x-3)
+		{[5]float64{0, 1, -6, 11, -6}, []float64{1, 2, 3}},
+	}
+
+	for _, test := range tests {
+		c := test.coeffs
+		got := solveQuartic(c[0], c[1], c[2], c[3], c[4])
+		if len(got) != len(test.want) {
+			t.Errorf("solveQuartic%v = %v, want %v", c, got, test.want)
+			continue
+		}
+		for i := range got {
+			if math.Abs(got[i]-test.want[i]) > 1e-6 {
+				t.Errorf("solveQuartic%v = %v, want %v", c, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestPolyRoots(t *testing.T) {
+	tests := []struct {
+		name   string
+		coeffs []float64
+		lo, hi float64
+		want   []float64
+	}{
+		{"line", []float64{-1, 2}, 0, 1, []float64{.5}},
+		{"constant", []float64{1}, -10, 10, nil},
+		// (x-1)(x-2)(x-3), with a trailing zero coefficient
+		{"cubic", []float64{-6, 11, -6, 1, 0}, -10, 10, []float64{1, 2, 3}},
+		{"cubic in part", []float64{-6, 11, -6, 1}, 1.5, 10, []float64{2, 3}},
+		{"root at the end", []float64{-6, 11, -6, 1}, 0, 2, []float64{1, 2}},
+		// x^6 - 1
+		{"no roots inside", []float64{-1, 0, 0, 0, 0, 0, 1}, -.5, .5, nil},
+		// a Wilkinson-like polynomial with roots 0.1, 0.2, ..., 0.8
+		{"close roots", wilkinson(8), 0, 1, []float64{.1, .2, .3, .4, .5, .6, .7, .8}},
+	}
+
+	for _, test := range tests {
+		got := polyRoots(test.coeffs, test.lo, test.hi)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: roots %v, want %v", test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if math.Abs(got[i]-test.want[i]) > 1e-9 {
+				t.Errorf("%s: roots %v, want %v", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+// wilkinson returns the coefficients of (x - 0.1)(x - 0.2)...(x - n/10).
+func wilkinson(n int) []float64 {
+	c := []float64{1}
+	for k := 1; k <= n; k++ {
+		next := make([]float64, len(c)+1)
+		for i, v := range c {
+			next[i+1] += v
+			next[i] -= v * float64(k) / 10
+		}
+		c = next
+	}
+	return c
+}
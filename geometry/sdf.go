@@ -0,0 +1,245 @@
+package geometry
+
+import (
+	"math"
+
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// SDF is a signed distance function: Dist returns the distance from p to the
+// surface of a shape, negative inside it. A bound that never exceeds the
+// distance works as well, at the cost of smaller steps.
+type SDF interface {
+	Dist(p Vec3) float64
+}
+
+// SDFSphere is a sphere.
+type SDFSphere struct {
+	Center Vec3
+	Radius float64
+}
+
+func (s SDFSphere) Dist(p Vec3) float64 {
+	return p.Sub(s.Center).Norm() - s.Radius
+}
+
+// SDFBox is a box of the given Size around Center with its edges rounded
+// by the radius Round.
+type SDFBox struct {
+	Center, Size Vec3
+	Round        float64
+}
+
+func (b SDFBox) Dist(p Vec3) float64 {
+	p = p.Sub(b.Center)
+	qx := math.Abs(p.X) - b.Size.X/2 + b.Round
+	qy := math.Abs(p.Y) - b.Size.Y/2 + b.Round
+	qz := math.Abs(p.Z) - b.Size.Z/2 + b.Round
+	outside := Vec3{X: max(qx, 0), Y: max(qy, 0), Z: max(qz, 0)}.Norm()
+	return outside + min(max(qx, qy, qz), 0) - b.Round
+}
+
+// SDFTorus is a torus around the vertical axis through Center.
+type SDFTorus struct {
+	Center       Vec3
+	Major, Minor float64
+}
+
+func (t SDFTorus) Dist(p Vec3) float64 {
+	p = p.Sub(t.Center)
+	return math.Hypot(math.Hypot(p.X, p.Z)-t.Major, p.Y) - t.Minor
+}
+
+// SDFCylinder is a capped vertical cylinder of the given Height centered on
+// Center.
+type SDFCylinder struct {
+	Center         Vec3
+	Radius, Height float64
+}
+
+func (c SDFCylinder) Dist(p Vec3) float64 {
+	p = p.Sub(c.Center)
+	dx := math.Hypot(p.X, p.Z) - c.Radius
+	dy := math.Abs(p.Y) - c.Height/2
+	return min(max(dx, dy), 0) + math.Hypot(max(dx, 0), max(dy, 0))
+}
+
+// SDFMandelbulb is the Mandelbulb fractal of the given Power, about 1.2
+// times Scale in radius, with its poles on the vertical axis. Dist is the
+// usual distance estimate after Iterations steps of the iteration.
+type SDFMandelbulb struct {
+	Center     Vec3
+	Scale      float64
+	Power      float64
+	Iterations int
+}
+
+func (m SDFMandelbulb) Dist(p Vec3) float64 {
+	c := p.Sub(m.Center).Scale(1 / m.Scale)
+	z := c
+	n := m.Power
+	dr, r := 1., 0.
+	for i := 0; i < m.Iterations; i++ {
+		r = z.Norm()
+		if r > 2 || r == 0 {
+			break
+		}
+
+		// z = z^n + c in spherical coordinates
+		theta := math.Acos(max(-1, min(1, z.Y/r))) * n
+		phi := math.Atan2(z.Z, z.X) * n
+		dr = math.Pow(r, n-1)*n*dr + 1
+		sinTheta, cosTheta := math.Sincos(theta)
+		sinPhi, cosPhi := math.Sincos(phi)
+		z = Vec3{X: sinTheta * cosPhi, Y: cosTheta, Z: sinTheta * sinPhi}.Scale(math.Pow(r, n)).Add(c)
+	}
+	if r == 0 {
+		return 0
+	}
+	return .5 * math.Log(r) * r / dr * m.Scale
+}
+
+// SDFCombine combines shapes from the first one on like CSG. A nonzero
+// Smooth blends the surfaces where they are closer than it.
+type SDFCombine struct {
+	Op     CSGOp
+	Parts  []SDF
+	Smooth float64
+}
+
+func (c SDFCombine) Dist(p Vec3) float64 {
+	d := c.Parts[0].Dist(p)
+	for _, part := range c.Parts[1:] {
+		switch c.Op {
+		case CSGUnion:
+			d = smoothMin(d, part.Dist(p), c.Smooth)
+		case CSGIntersection:
+			d = -smoothMin(-d, -part.Dist(p), c.Smooth)
+		case CSGDifference:
+			d = -smoothMin(-d, part.Dist(p), c.Smooth)
+		}
+	}
+	return d
+}
+
+// smoothMin is the polynomial smooth minimum, which is below min(a, b) by
+// at most k/4.
+func smoothMin(a, b, k float64) float64 {
+	if k <= 0 {
+		return min(a, b)
+	}
+	h := max(k-math.Abs(a-b), 0) / k
+	return min(a, b) - h*h*k/4
+}
+
+// SDFTwist twists a shape around the vertical axis by Angle degrees per
+// unit of height.
+type SDFTwist struct {
+	Shape SDF
+	Angle float64
+}
+
+func (t SDFTwist) Dist(p Vec3) float64 {
+	k := t.Angle * math.Pi / 180
+	sin, cos := math.Sincos(k * p.Y)
+	q := Vec3{X: cos*p.X + sin*p.Z, Y: p.Y, Z: cos*p.Z - sin*p.X}
+
+	// the twist stretches distances by up to the largest singular value
+	// of its shear
+	s := math.Abs(k) * math.Hypot(p.X, p.Z)
+	return t.Shape.Dist(q) / ((s + math.Sqrt(s*s+4)) / 2)
+}
+
+// SDFTranslate moves a shape by Offset, e.g. one made around the origin.
+type SDFTranslate struct {
+	Shape  SDF
+	Offset Vec3
+}
+
+func (t SDFTranslate) Dist(p Vec3) float64 {
+	return t.Shape.Dist(p.Sub(t.Offset))
+}
+
+// SDFRepeat repeats a shape in cells of the size Period around the origin
+// along the axes where Period isn't zero. The shape should fit into the
+// cell at the origin.
+type SDFRepeat struct {
+	Shape  SDF
+	Period Vec3
+}
+
+func (r SDFRepeat) Dist(p Vec3) float64 {
+	cell := func(x, period float64) float64 {
+		if period == 0 {
+			return x
+		}
+		return x - period*math.Round(x/period)
+	}
+	return r.Shape.Dist(Vec3{X: cell(p.X, r.Period.X), Y: cell(p.Y, r.Period.Y), Z: cell(p.Z, r.Period.Z)})
+}
+
+// Sphere tracing gives up after sdfSteps steps; it stops at distances below
+// sdfEpsilon times the diagonal of the bounds.
+const (
+	sdfSteps   = 1000
+	sdfEpsilon = 1e-4
+)
+
+// SDFPrimitive is a shape given by a signed distance function within the
+// bounds Min and Max, which must contain it. It has no texture coordinates.
+type SDFPrimitive struct {
+	Shape    SDF
+	Min, Max Vec3
+	Material shading.Material
+}
+
+// Intersect sphere traces the ray through the bounds: it steps along the
+// ray by the distance to the surface until the distance vanishes. Normals
+// are the gradient of the distance.
+func (s *SDFPrimitive) Intersect(r Ray) *HitRecord {
+	tNear, tFar, _, _, ok := Box{Min: s.Min, Max: s.Max}.slabs(r.Origin, r.Direction)
+	if !ok || tFar < 0 {
+		return nil
+	}
+
+	length := r.Direction.Norm()
+	dir := r.Direction.Scale(1 / length)
+	eps := sdfEpsilon * s.Max.Sub(s.Min).Norm()
+
+	// t is the distance along the ray. A ray starting on the surface, like
+	// the secondary rays, has to leave it before it can hit it.
+	t, end := max(tNear, 0)*length, tFar*length
+	left := tNear > 0
+	for i := 0; i < sdfSteps && t <= end; i++ {
+		d := math.Abs(s.Shape.Dist(r.Origin.Add(dir.Scale(t))))
+		if d < eps {
+			if left {
+				p := r.Origin.Add(dir.Scale(t))
+				return &HitRecord{T: t / length, Primitive: s, Material: s.Material, Normal: s.normal(p, eps)}
+			}
+			t += eps
+			continue
+		}
+		left = true
+		t += d
+	}
+	return nil
+}
+
+// normal estimates the gradient of the distance at p from the corners of a
+// tetrahedron of the size h.
+func (s *SDFPrimitive) normal(p Vec3, h float64) Vec3 {
+	var n Vec3
+	for _, k := range [4]Vec3{{X: 1, Y: -1, Z: -1}, {X: -1, Y: -1, Z: 1}, {X: -1, Y: 1, Z: -1}, {X: 1, Y: 1, Z: 1}} {
+		n = n.Add(k.Scale(s.Shape.Dist(p.Add(k.Scale(h)))))
+	}
+	return n.Normalize()
+}
+
+// Bounds returns the bounds given with the shape.
+func (s *SDFPrimitive) Bounds() Bounds3 {
+	return Bounds3{
+		PMin: Point3{X: s.Min.X, Y: s.Min.Y, Z: s.Min.Z},
+		PMax: Point3{X: s.Max.X, Y: s.Max.Y, Z: s.Max.Z},
+	}
+}
@@ -0,0 +1,107 @@
+package geometry
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSDFDist(t *testing.T) {
+	sphere := SDFSphere{Center: Vec3{X: 1}, Radius: 1}
+	box := SDFBox{Size: Vec3{2, 2, 2}}
+	tests := []struct {
+		name string
+		sdf  SDF
+		p    Vec3
+		want float64
+	}{
+		{"sphere", sphere, Vec3{X: 4}, 2},
+		{"inside the sphere", sphere, Vec3{X: 1}, -1},
+		{"box face", box, Vec3{Y: 3}, 2},
+		{"box corner", box, Vec3{2, 2, 1}, math.Sqrt2},
+		{"inside the box", box, Vec3{Z: .5}, -.5},
+		{"rounded box corner", SDFBox{Size: Vec3{2, 2, 2}, Round: .5}, Vec3{1, 1, 1}, math.Sqrt(.75) - .5},
+		{"torus", SDFTorus{Major: 2, Minor: .5}, Vec3{Z: 2, Y: 1}, .5},
+		{"hole of the torus", SDFTorus{Major: 2, Minor: .5}, Vec3{}, 1.5},
+		{"cylinder side", SDFCylinder{Radius: 1, Height: 2}, Vec3{X: 3}, 2},
+		{"cylinder edge", SDFCylinder{Radius: 1, Height: 2}, Vec3{X: 4, Y: 5}, 5},
+		{"union", SDFCombine{Op: CSGUnion, Parts: []SDF{sphere, box}}, Vec3{X: -2}, 1},
+		{"intersection", SDFCombine{Op: CSGIntersection, Parts: []SDF{sphere, box}}, Vec3{X: -2}, 2},
+		{"difference", SDFCombine{Op: CSGDifference, Parts: []SDF{box, sphere}}, Vec3{X: .5}, .5},
+		{"smooth union", SDFCombine{Op: CSGUnion, Parts: []SDF{SDFSphere{Center: Vec3{X: -1}, Radius: 1}, sphere}, Smooth: 1}, Vec3{Y: 1}, math.Sqrt2 - 1 - .25},
+		{"translate", SDFTranslate{Shape: SDFSphere{Radius: 1}, Offset: Vec3{Y: 2}}, Vec3{Y: 5}, 2},
+		{"repeat", SDFRepeat{Shape: SDFSphere{Radius: 1}, Period: Vec3{X: 10}}, Vec3{X: 29, Y: 2}, math.Sqrt(5) - 1},
+		{"twist at the base", SDFTwist{Shape: box, Angle: 90}, Vec3{X: 3}, 2 / ((3*math.Pi/2 + math.Sqrt(9*math.Pi*math.Pi/4+4)) / 2)},
+		{"twist on the axis", SDFTwist{Shape: box, Angle: 90}, Vec3{Y: 1}, 0},
+		{"mandelbulb", SDFMandelbulb{Scale: 1, Power: 8, Iterations: 8}, Vec3{X: 10}, .5 * math.Log(10) * 10 / 1},
+	}
+
+	for _, test := range tests {
+		if got := test.sdf.Dist(test.p); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: Dist(%v) = %v, want %v", test.name, test.p, got, test.want)
+		}
+	}
+}
+
+func TestSDFPrimitive(t *testing.T) {
+	sphere := &SDFPrimitive{Shape: SDFSphere{Radius: 1}, Min: Vec3{-1, -1, -1}, Max: Vec3{1, 1, 1}}
+	tests := []struct {
+		name   string
+		ray    Ray
+		t      float64
+		normal Vec3
+	}{
+		{"outside", Ray{Origin: Vec3{Z: -5}, Direction: Vec3{Z: 2}}, 2, Vec3{Z: -1}},
+		{"inside", Ray{Direction: Vec3{X: 1}}, 1, Vec3{X: 1}},
+		{"into the surface", Ray{Origin: Vec3{Z: -1}, Direction: Vec3{Z: 1}}, 2, Vec3{Z: 1}},
+	}
+
+	for _, test := range tests {
+		hit := sphere.Intersect(test.ray)
+		if hit == nil {
+			t.Errorf("%s: no hit", test.name)
+			continue
+		}
+		if math.Abs(hit.T-test.t) > 1e-4 || hit.Normal.Sub(test.normal).Norm() > 1e-3 {
+			t.Errorf("%s: hit at %v with normal %v, want %v and %v", test.name, hit.T, hit.Normal, test.t, test.normal)
+		}
+		if hit.Primitive != sphere {
+			t.Errorf("%s: the hit is of %v", test.name, hit.Primitive)
+		}
+	}
+
+	misses := []Ray{
+		{Origin: Vec3{X: 1.5, Z: -5}, Direction: Vec3{Z: 1}},
+		{Origin: Vec3{Z: -5}, Direction: Vec3{Z: -1}},
+		// leaving the surface
+		{Origin: Vec3{Z: -1}, Direction: Vec3{Z: -1}},
+	}
+	for _, r := range misses {
+		if hit := sphere.Intersect(r); hit != nil {
+			t.Errorf("%v: hit at %v", r, hit.T)
+		}
+	}
+}
+
+// TestSDFMandelbulb checks that the rays reaching the fractal stop on it.
+func TestSDFMandelbulb(t *testing.T) {
+	bulb := SDFMandelbulb{Scale: 1, Power: 8, Iterations: 8}
+	prim := &SDFPrimitive{Shape: bulb, Min: Vec3{-1.5, -1.5, -1.5}, Max: Vec3{1.5, 1.5, 1.5}}
+
+	hits := 0
+	for i := 0; i < 50; i++ {
+		angle := float64(i) / 50 * 2 * math.Pi
+		origin := Vec3{X: 5 * math.Cos(angle), Y: .3, Z: 5 * math.Sin(angle)}
+		hit := prim.Intersect(Ray{Origin: origin, Direction: origin.Scale(-1)})
+		if hit == nil {
+			continue
+		}
+		hits++
+		p := origin.Add(origin.Scale(-hit.T))
+		if d := bulb.Dist(p); math.Abs(d) > 1e-3 {
+			t.Errorf("hit %v is %v away from the surface", p, d)
+		}
+	}
+	if hits < 40 {
+		t.Errorf("only %d of the rays hit", hits)
+	}
+}
@@ -14,11 +14,17 @@ type Primitive interface {
 }
 
 // HitRecord stores information about a ray-object intersection.
+// UV holds the texture coordinates of the hit point, DPDU and DPDV are the
+// partial derivatives of the surface position with respect to them
+// (zero when the primitive has no texture parametrization).
 type HitRecord struct {
 	T         float64
 	Primitive Primitive
 	Material  shading.Material
 	Normal    Vec3
+	UV        UV
+	DPDU      Vec3
+	DPDV      Vec3
 }
 
 // Sphere represents a sphere with a center, radius, and material.
@@ -30,6 +36,35 @@ type Sphere struct {
 
 // Intersect computes the intersection of a ray with the sphere.
 func (s Sphere) Intersect(r Ray) *HitRecord {
+	t1, t2, ok := s.roots(r)
+	if !ok {
+		return nil // no intersection
+	}
+
+	// a ray starting inside the sphere hits it on the way out
+	tMin := math.Min(t1, t2)
+	if tMin < 0 {
+		tMin = math.Max(t1, t2)
+	}
+	if tMin < 0 {
+		return nil
+	}
+
+	hit := s.hit(r, tMin)
+	return &hit
+}
+
+// Hits returns where the line of the ray enters and leaves the sphere.
+func (s Sphere) Hits(r Ray) []HitRecord {
+	t1, t2, ok := s.roots(r)
+	if !ok {
+		return nil
+	}
+	return []HitRecord{s.hit(r, math.Min(t1, t2)), s.hit(r, math.Max(t1, t2))}
+}
+
+// roots solves the quadratic equation of the hits of the ray.
+func (s Sphere) roots(r Ray) (float64, float64, bool) {
 	co := r.Origin.Sub(s.Center)
 
 	a := r.Direction.Dot(r.Direction)
@@ -38,17 +73,26 @@ func (s Sphere) Intersect(r Ray) *HitRecord {
 
 	d := b*b - 4.0*a*c
 	if d < 0 {
-		return nil // no intersection
+		return 0, 0, false
 	}
 
 	t1 := (-b + math.Sqrt(d)) / (2.0 * a)
 	t2 := (-b - math.Sqrt(d)) / (2.0 * a)
+	return t1, t2, true
+}
 
-	tMin := math.Min(t1, t2)
+// hit returns the hit of the ray at t.
+func (s Sphere) hit(r Ray, t float64) HitRecord {
+	p := r.At(t)
+	n := p.Sub(s.Center).Normalize()
 
-	p := r.At(tMin)
+	// spherical mapping: u goes around the Y axis, v from the south to the north pole
+	uv := UV{
+		U: .5 + math.Atan2(n.Z, n.X)/(2*math.Pi),
+		V: .5 + math.Asin(math.Max(-1, math.Min(1, n.Y)))/math.Pi,
+	}
 
-	return &HitRecord{tMin, s, s.Material, p.Sub(s.Center).Normalize()}
+	return HitRecord{T: t, Primitive: s, Material: s.Material, Normal: n, UV: uv}
 }
 
 // Bounds returns the bounding box of the sphere.
@@ -97,7 +141,11 @@ func (p Plane) Intersect(r Ray) *HitRecord {
 
 	ir := r.At(t)
 	if ir.X >= xMin && ir.X <= xMax && ir.Z >= zMin && ir.Z <= zMax {
-		return &HitRecord{t, p, p.Material, p.Normal.Normalize()}
+		uv := UV{
+			U: (ir.X - xMin) / p.Width,
+			V: (ir.Z - zMin) / p.Width,
+		}
+		return &HitRecord{T: t, Primitive: p, Material: p.Material, Normal: p.Normal.Normalize(), UV: uv}
 	}
 
 	return nil
@@ -139,10 +187,15 @@ func (p Plane) Bounds() Bounds3 {
 	return Bounds3{pMin, pMax}
 }
 
-// Triangle represents a triangle with three vertices.
+// Triangle represents a triangle with three vertices and their texture coordinates.
+// Normals and Colors optionally hold the normals and diffuse colors of the
+// vertices, which are interpolated over the triangle.
 type Triangle struct {
-	V0, V1, V2 Vec3
-	Material   shading.Material
+	V0, V1, V2    Vec3
+	UV0, UV1, UV2 UV
+	Material      shading.Material
+	Normals       *[3]Vec3
+	Colors        *[3]shading.Color
 }
 
 // Intersect computes the intersection of a ray with the triangle.
@@ -196,12 +249,52 @@ func (t *Triangle) Intersect(r Ray) *HitRecord {
 	n := a.Cross(b).Normalize()
 
 	if tr > epsilon {
-		return &HitRecord{tr, t, t.Material, n}
+		hit := &HitRecord{T: tr, Primitive: t, Material: t.Material, Normal: n}
+		hit.UV = UV{
+			U: (1-u-v)*t.UV0.U + u*t.UV1.U + v*t.UV2.U,
+			V: (1-u-v)*t.UV0.V + u*t.UV1.V + v*t.UV2.V,
+		}
+		hit.DPDU, hit.DPDV = t.derivatives(a, b)
+
+		if t.Normals != nil {
+			// the smooth normal stays on the side of the face
+			ns := t.Normals[0].Scale(1 - u - v).Add(t.Normals[1].Scale(u)).Add(t.Normals[2].Scale(v))
+			if ns.Norm() > epsilon {
+				ns = ns.Normalize()
+				if ns.Dot(n) < 0 {
+					ns = ns.Scale(-1)
+				}
+				hit.Normal = ns
+			}
+		}
+		if t.Colors != nil {
+			c := t.Colors
+			hit.Material.KDiffuse = c[0].MulByNum(1 - u - v).Add(c[1].MulByNum(u)).Add(c[2].MulByNum(v))
+		}
+		return hit
 	}
 
 	return nil
 }
 
+// derivatives computes dP/du and dP/dv from the edges a = V1-V0, b = V2-V0
+// and the texture coordinates of the triangle.
+func (t *Triangle) derivatives(a, b Vec3) (Vec3, Vec3) {
+	du1, dv1 := t.UV1.U-t.UV0.U, t.UV1.V-t.UV0.V
+	du2, dv2 := t.UV2.U-t.UV0.U, t.UV2.V-t.UV0.V
+
+	det := du1*dv2 - dv1*du2
+	if math.Abs(det) < 1e-12 {
+		return Vec3{}, Vec3{}
+	}
+
+	invDet := 1 / det
+	dpdu := a.Scale(dv2).Sub(b.Scale(dv1)).Scale(invDet)
+	dpdv := b.Scale(du1).Sub(a.Scale(du2)).Scale(invDet)
+
+	return dpdu, dpdv
+}
+
 // Bounds returns the bounding box of the triangle.
 func (t *Triangle) Bounds() Bounds3 {
 	xmin := min(t.V0.X, t.V1.X, t.V2.X)
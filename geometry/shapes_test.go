@@ -1,6 +1,8 @@
 package geometry
 
 import (
+	"math"
+	"math/rand"
 	"testing"
 )
 
@@ -81,3 +83,126 @@ func TestVectorScale(t *testing.T) {
 // 	}
 //
 // }
+
+func near(a, b Vec3) bool {
+	return a.Sub(b).Norm() < 1e-6
+}
+
+func TestPrimitiveHits(t *testing.T) {
+	tests := []struct {
+		name   string
+		prim   Primitive
+		ray    Ray
+		t      float64
+		normal Vec3
+		uv     UV
+	}{
+		{"box", Box{Min: Vec3{-1, -1, -1}, Max: Vec3{1, 1, 1}},
+			Ray{Origin: Vec3{.5, 0, -5}, Direction: Vec3{Z: 1}}, 4, Vec3{Z: -1}, UV{.75, .5}},
+		{"box from inside", Box{Min: Vec3{-1, -1, -1}, Max: Vec3{1, 1, 1}},
+			Ray{Direction: Vec3{Y: 2}}, .5, Vec3{Y: 1}, UV{.5, .5}},
+		{"oriented box", Box{Min: Vec3{-1, -1, -1}, Max: Vec3{1, 1, 1}, Rotate: Vec3{Y: 45}},
+			Ray{Origin: Vec3{.1, 0, -5}, Direction: Vec3{Z: 1}}, 5 - math.Sqrt2 + .1, Vec3{X: math.Sqrt2 / 2, Z: -math.Sqrt2 / 2}, UV{}},
+		{"cylinder", Cylinder{Top: Vec3{Y: 2}, Radius: 1},
+			Ray{Origin: Vec3{0, .5, -5}, Direction: Vec3{Z: 1}}, 4, Vec3{Z: -1}, UV{.25, .25}},
+		{"cylinder cap", Cylinder{Top: Vec3{Y: 2}, Radius: 1},
+			Ray{Origin: Vec3{.5, 5, 0}, Direction: Vec3{Y: -1}}, 3, Vec3{Y: 1}, UV{.75, .5}},
+		{"open cylinder", Cylinder{Top: Vec3{Y: 2}, Radius: 1, Open: true},
+			Ray{Origin: Vec3{0, 5, 0}, Direction: Vec3{X: 1, Y: -4}}, 1, Vec3{X: -1}, UV{.5, .5}},
+		{"cone", Cone{Top: Vec3{Y: 1}, Radius: 1},
+			Ray{Origin: Vec3{0, .5, -5}, Direction: Vec3{Z: 1}}, 4.5, Vec3{Y: math.Sqrt2 / 2, Z: -math.Sqrt2 / 2}, UV{.25, .5}},
+		{"truncated cone", Cone{Top: Vec3{Y: 1}, Radius: 1, TopRadius: .5},
+			Ray{Origin: Vec3{0, 5, 0}, Direction: Vec3{Y: -1}}, 4, Vec3{Y: 1}, UV{.5, .5}},
+		{"disk from below", Disk{Normal: Vec3{Y: 1}, Radius: 2},
+			Ray{Origin: Vec3{1, -1, 0}, Direction: Vec3{Y: 1}}, 1, Vec3{Y: -1}, UV{.5, .5}},
+		{"annulus", Disk{Normal: Vec3{Y: 1}, Radius: 2, Inner: 1},
+			Ray{Origin: Vec3{0, 1, 1.5}, Direction: Vec3{Y: -1}}, 1, Vec3{Y: 1}, UV{.75, .5}},
+		{"torus", Torus{Axis: Vec3{Y: 1}, Major: 2, Minor: .5},
+			Ray{Origin: Vec3{0, 0, -10}, Direction: Vec3{Z: 1}}, 7.5, Vec3{Z: -1}, UV{.25, .5}},
+		{"torus from above", Torus{Center: Vec3{Y: 1}, Axis: Vec3{Y: 1}, Major: 2, Minor: .5},
+			Ray{Origin: Vec3{2, 100, 0}, Direction: Vec3{Y: -2}}, 49.25, Vec3{Y: 1}, UV{.5, .75}},
+	}
+
+	for _, test := range tests {
+		hit := test.prim.Intersect(test.ray)
+		if hit == nil {
+			t.Errorf("%s: no hit", test.name)
+			continue
+		}
+		if math.Abs(hit.T-test.t) > 1e-6 || !near(hit.Normal, test.normal) {
+			t.Errorf("%s: hit at %v with normal %v, want %v and %v", test.name, hit.T, hit.Normal, test.t, test.normal)
+		}
+		if test.uv != (UV{}) && (math.Abs(hit.UV.U-test.uv.U) > 1e-6 || math.Abs(hit.UV.V-test.uv.V) > 1e-6) {
+			t.Errorf("%s: UV = %v, want %v", test.name, hit.UV, test.uv)
+		}
+		if hit.Primitive != test.prim {
+			t.Errorf("%s: the hit is of %v", test.name, hit.Primitive)
+		}
+	}
+}
+
+func TestPrimitiveMisses(t *testing.T) {
+	tests := []struct {
+		name string
+		prim Primitive
+		ray  Ray
+	}{
+		{"box", Box{Min: Vec3{-1, -1, -1}, Max: Vec3{1, 1, 1}}, Ray{Origin: Vec3{1.5, 0, -5}, Direction: Vec3{Z: 1}}},
+		{"oriented box", Box{Min: Vec3{-1, -1, -1}, Max: Vec3{1, 1, 1}, Rotate: Vec3{Y: 45}}, Ray{Origin: Vec3{1.5, 0, -5}, Direction: Vec3{Z: 1}}},
+		{"open cylinder along the axis", Cylinder{Top: Vec3{Y: 2}, Radius: 1, Open: true}, Ray{Origin: Vec3{Y: 5}, Direction: Vec3{Y: -1}}},
+		{"away from the cone", Cone{Top: Vec3{Y: 1}, Radius: 1}, Ray{Origin: Vec3{Y: 1.5}, Direction: Vec3{Y: 1}}},
+		{"hole of the annulus", Disk{Normal: Vec3{Y: 1}, Radius: 2, Inner: 1}, Ray{Origin: Vec3{.5, 1, 0}, Direction: Vec3{Y: -1}}},
+		{"hole of the torus", Torus{Axis: Vec3{Y: 1}, Major: 2, Minor: .5}, Ray{Origin: Vec3{Y: 10}, Direction: Vec3{Y: -1}}},
+	}
+
+	for _, test := range tests {
+		if hit := test.prim.Intersect(test.ray); hit != nil {
+			t.Errorf("%s: hit at %v", test.name, hit.T)
+		}
+	}
+}
+
+// TestPrimitiveBounds shoots random rays at the primitives and checks that
+// the hits are inside the bounds, on the side of their normals and with
+// texture coordinates in the unit square.
+func TestPrimitiveBounds(t *testing.T) {
+	prims := []Primitive{
+		Box{Min: Vec3{-1, 0, 2}, Max: Vec3{1, 3, 2.5}, Rotate: Vec3{10, 20, 30}},
+		Cylinder{Base: Vec3{1, 1, 1}, Top: Vec3{2, 3, 0}, Radius: .5},
+		Cone{Base: Vec3{-1, 0, 0}, Top: Vec3{0, -1, 2}, Radius: 1, TopRadius: .2, Open: true},
+		Disk{Center: Vec3{1, 2, 3}, Normal: Vec3{1, 1, 0}, Radius: 2, Inner: .5},
+		Torus{Center: Vec3{0, 1, 0}, Axis: Vec3{1, 2, 3}, Major: 1.5, Minor: .3},
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for _, prim := range prims {
+		b := prim.Bounds()
+		center := b.PMin.Add(b.PMax).Scale(.5)
+		hits := 0
+		for i := 0; i < 2000; i++ {
+			dir := Vec3{rng.NormFloat64(), rng.NormFloat64(), rng.NormFloat64()}.Normalize()
+			target := Vec3{center.X + rng.NormFloat64(), center.Y + rng.NormFloat64(), center.Z + rng.NormFloat64()}
+			r := Ray{Origin: target.Sub(dir.Scale(20)), Direction: dir}
+			hit := prim.Intersect(r)
+			if hit == nil {
+				continue
+			}
+			hits++
+
+			p := r.At(hit.T)
+			if p.X < b.PMin.X-1e-6 || p.Y < b.PMin.Y-1e-6 || p.Z < b.PMin.Z-1e-6 ||
+				p.X > b.PMax.X+1e-6 || p.Y > b.PMax.Y+1e-6 || p.Z > b.PMax.Z+1e-6 {
+				t.Fatalf("%T: hit %v outside of %v", prim, p, b)
+			}
+			if math.Abs(hit.Normal.Norm()-1) > 1e-6 || hit.Normal.Dot(dir) > 1e-9 {
+				t.Fatalf("%T: normal %v at %v for the direction %v", prim, hit.Normal, p, dir)
+			}
+			if hit.UV.U < -1e-9 || hit.UV.U > 1+1e-9 || hit.UV.V < -1e-9 || hit.UV.V > 1+1e-9 {
+				t.Fatalf("%T: UV %v", prim, hit.UV)
+			}
+		}
+		if hits < 100 {
+			t.Errorf("%T: only %d of the rays hit", prim, hits)
+		}
+	}
+}
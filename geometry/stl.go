@@ -0,0 +1,210 @@
+package geometry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// STLWeldTolerance is the distance, relative to the diagonal of the bounds
+// of the mesh, within which ReadSTL welds vertices.
+const STLWeldTolerance = 1e-6
+
+// LoadSTL loads a mesh from an STL file. See ReadSTL.
+func LoadSTL(fName string) (*IndexedMesh, error) {
+	f, err := os.Open(fName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	return ReadSTL(f, fName)
+}
+
+// ReadSTL reads a mesh in the ASCII or binary STL format. name is used in
+// errors. STL stores every triangle with vertices of its own, which are
+// welded into shared vertices within STLWeldTolerance. Facet normals are
+// ignored, the faces are shaded by their winding.
+func ReadSTL(r io.Reader, name string) (*IndexedMesh, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	// binary files may start with "solid" too, but their size is exact
+	var points []Vec3
+	if len(data) >= 84 && len(data) == 84+50*int(binary.LittleEndian.Uint32(data[80:84])) {
+		points = readBinarySTL(data)
+	} else if bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte("solid")) {
+		if points, err = readASCIISTL(data, name); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("%s: not an STL file", name)
+	}
+
+	m := &IndexedMesh{Verts: points, Indices: make([]int32, len(points))}
+	for i := range m.Indices {
+		m.Indices[i] = int32(i)
+	}
+	m.TriangleMaterials = make([]int32, m.NumTriangles())
+	for i := range m.TriangleMaterials {
+		m.TriangleMaterials[i] = -1
+	}
+
+	if len(points) > 0 {
+		lo, hi := points[0], points[0]
+		for _, p := range points {
+			lo = Vec3{X: min(lo.X, p.X), Y: min(lo.Y, p.Y), Z: min(lo.Z, p.Z)}
+			hi = Vec3{X: max(hi.X, p.X), Y: max(hi.Y, p.Y), Z: max(hi.Z, p.Z)}
+		}
+		m.Weld(STLWeldTolerance * hi.Sub(lo).Norm())
+	}
+
+	return m, nil
+}
+
+// readBinarySTL returns the vertices of the triangles of a binary STL file:
+// an 80 byte header, the number of triangles and 50 bytes per triangle.
+func readBinarySTL(data []byte) []Vec3 {
+	n := int(binary.LittleEndian.Uint32(data[80:84]))
+	points := make([]Vec3, 0, 3*n)
+
+	float := func(b []byte) float64 {
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	}
+	for i := 0; i < n; i++ {
+		// the normal comes first and the attribute byte count last
+		tri := data[84+50*i+12 : 84+50*i+48]
+		for k := 0; k < 3; k++ {
+			v := tri[12*k:]
+			points = append(points, Vec3{X: float(v[0:4]), Y: float(v[4:8]), Z: float(v[8:12])})
+		}
+	}
+
+	return points
+}
+
+// readASCIISTL returns the vertices of the facets of an ASCII STL file.
+func readASCIISTL(data []byte, name string) ([]Vec3, error) {
+	var points []Vec3
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	line, inFacet := 0, 0
+	var fields []string
+	for s.Scan() {
+		line++
+		fields = splitFields(fields[:0], s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		errorf := func(format string, args ...any) error {
+			return fmt.Errorf("%s:%d: %s", name, line, fmt.Sprintf(format, args...))
+		}
+
+		switch fields[0] {
+		case "facet":
+			inFacet = 0
+		case "vertex":
+			if len(fields) != 4 {
+				return nil, errorf("vertex: expected 3 coordinates")
+			}
+			var xyz [3]float64
+			for i := range xyz {
+				f, err := strconv.ParseFloat(fields[i+1], 64)
+				if err != nil {
+					return nil, errorf("vertex: %v", err)
+				}
+				xyz[i] = f
+			}
+			points = append(points, Vec3{X: xyz[0], Y: xyz[1], Z: xyz[2]})
+			inFacet++
+		case "endfacet":
+			if inFacet != 3 {
+				return nil, errorf("facet with %d vertices, expected 3", inFacet)
+			}
+		case "solid", "outer", "endloop", "endsolid":
+		default:
+			return nil, errorf("unknown keyword %q", fields[0])
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%s:%d: %w", name, line+1, err)
+	}
+	if len(points)%3 != 0 {
+		return nil, fmt.Errorf("%s: incomplete facet at the end", name)
+	}
+
+	return points, nil
+}
+
+// Weld merges vertices closer than tolerance into the first of them and
+// drops the duplicates. The merged vertex keeps the normal and the color of
+// the first; texture coordinates, which are indexed separately, are kept.
+func (m *IndexedMesh) Weld(tolerance float64) {
+	// vertices are hashed into cells of the size of the tolerance; a match
+	// may be in a neighboring cell. Without a tolerance only equal vertices
+	// are merged.
+	reach := int64(1)
+	key := func(p Vec3) [3]int64 {
+		return [3]int64{int64(math.Floor(p.X / tolerance)), int64(math.Floor(p.Y / tolerance)), int64(math.Floor(p.Z / tolerance))}
+	}
+	if tolerance <= 0 {
+		reach = 0
+		key = func(p Vec3) [3]int64 {
+			return [3]int64{int64(math.Float64bits(p.X)), int64(math.Float64bits(p.Y)), int64(math.Float64bits(p.Z))}
+		}
+	}
+
+	grid := make(map[[3]int64][]int32)
+	remap := make([]int32, len(m.Verts))
+	var kept []int32
+	for i, p := range m.Verts {
+		k := key(p)
+		remap[i] = -1
+
+	search:
+		for dx := -reach; dx <= reach; dx++ {
+			for dy := -reach; dy <= reach; dy++ {
+				for dz := -reach; dz <= reach; dz++ {
+					for _, j := range grid[[3]int64{k[0] + dx, k[1] + dy, k[2] + dz}] {
+						if m.Verts[kept[j]].Sub(p).Norm() <= tolerance {
+							remap[i] = j
+							break search
+						}
+					}
+				}
+			}
+		}
+
+		if remap[i] < 0 {
+			remap[i] = int32(len(kept))
+			grid[k] = append(grid[k], remap[i])
+			kept = append(kept, int32(i))
+		}
+	}
+
+	for i, idx := range m.Indices {
+		m.Indices[i] = remap[idx]
+	}
+	m.Verts = gather(m.Verts, kept)
+	m.Normals = gather(m.Normals, kept)
+	m.Colors = gather(m.Colors, kept)
+}
+
+// gather returns the elements of s at indices, or s when it's empty.
+func gather[T any](s []T, indices []int32) []T {
+	if len(s) == 0 {
+		return s
+	}
+	res := make([]T, len(indices))
+	for i, j := range indices {
+		res[i] = s[j]
+	}
+	return res
+}
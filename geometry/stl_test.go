@@ -0,0 +1,81 @@
+package geometry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// tetrahedron are the facets of a tetrahedron in STL order.
+var tetrahedron = [][3]Vec3{
+	{{0, 0, 0}, {0, 1, 0}, {1, 0, 0}},
+	{{0, 0, 0}, {1, 0, 0}, {0, 0, 1}},
+	{{0, 0, 0}, {0, 0, 1}, {0, 1, 0}},
+	{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}},
+}
+
+func TestReadSTL(t *testing.T) {
+	var ascii strings.Builder
+	ascii.WriteString("solid tetra\n")
+	for _, f := range tetrahedron {
+		ascii.WriteString("  facet normal 0 0 0\n    outer loop\n")
+		for _, v := range f {
+			// a vertex off by far less than the tolerance is welded
+			ascii.WriteString("      vertex " + formatSTL(v.X+1e-9) + " " + formatSTL(v.Y) + " " + formatSTL(v.Z) + "\n")
+		}
+		ascii.WriteString("    endloop\n  endfacet\n")
+	}
+	ascii.WriteString("endsolid tetra\n")
+
+	// a binary file whose header starts with "solid" like an ASCII one
+	var bin bytes.Buffer
+	bin.WriteString("solid binary")
+	bin.Write(make([]byte, 80-bin.Len()))
+	_ = binary.Write(&bin, binary.LittleEndian, uint32(len(tetrahedron)))
+	for _, f := range tetrahedron {
+		facet := make([]float32, 0, 12)
+		facet = append(facet, 0, 0, 0)
+		for _, v := range f {
+			facet = append(facet, float32(v.X), float32(v.Y), float32(v.Z))
+		}
+		_ = binary.Write(&bin, binary.LittleEndian, facet)
+		_ = binary.Write(&bin, binary.LittleEndian, uint16(0))
+	}
+
+	for name, src := range map[string]string{"ascii": ascii.String(), "binary": bin.String()} {
+		m, err := ReadSTL(strings.NewReader(src), name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if m.NumTriangles() != 4 || len(m.Verts) != 4 {
+			t.Fatalf("%s: %d triangles over %d vertices, want 4 over 4", name, m.NumTriangles(), len(m.Verts))
+		}
+		for i, f := range tetrahedron {
+			i0, i1, i2 := m.Triangle(i)
+			for k, idx := range []int32{i0, i1, i2} {
+				if d := m.Verts[idx].Sub(f[k]).Norm(); d > 1e-6 {
+					t.Errorf("%s: triangle %d vertex %d at %v, want %v", name, i, k, m.Verts[idx], f[k])
+				}
+			}
+		}
+	}
+}
+
+func TestReadSTLErrors(t *testing.T) {
+	for _, tc := range []struct{ stl, err string }{
+		{"ply\n", "bad.stl: not an STL file"},
+		{"solid x\nfacet normal 0 0 1\nouter loop\nvertex 0 0\n", "bad.stl:4: vertex: expected 3 coordinates"},
+		{"solid x\nfacet normal 0 0 1\nouter loop\nvertex 0 0 0\nvertex 1 0 0\nendloop\nendfacet\n", "bad.stl:7: facet with 2 vertices, expected 3"},
+	} {
+		_, err := ReadSTL(strings.NewReader(tc.stl), "bad.stl")
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("ReadSTL(%q) = %v, want %q", tc.stl, err, tc.err)
+		}
+	}
+}
+
+func formatSTL(f float64) string {
+	return strconv.FormatFloat(f, 'e', -1, 64)
+}
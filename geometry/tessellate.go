@@ -0,0 +1,491 @@
+package geometry
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// The resolution of tessellated spheres. Cylinders, cones, disks and tori
+// are divided into SphereSegments around their axis too, and the tube of a
+// torus into SphereRings.
+const (
+	SphereSegments = 32 // around the Y axis
+	SphereRings    = 16 // from pole to pole
+)
+
+// Tessellate returns the triangles of a primitive in world space as a mesh
+// with normals, texture coordinates and a material per triangle. Spheres
+// are tessellated into SphereSegments by SphereRings quads and planes into
+// a square of their width; heightfields keep their triangles, meshes keep
+// their vertices and groups, and moving primitives are placed where their
+// motion starts. CSG solids, SDF shapes, quadrics and implicit surfaces are
+// polygonized within their bounds, see FieldCells. The triangles of the mesh form a group named after the
+// primitive.
+func Tessellate(p Primitive) (*IndexedMesh, error) {
+	switch p := p.(type) {
+	case Sphere:
+		return tessellateSphere(p), nil
+	case Plane:
+		return tessellatePlane(p), nil
+	case *Triangle:
+		return tessellateTriangle(p), nil
+	case Box:
+		return tessellateBox(p), nil
+	case Cylinder:
+		return tessellateCone("cylinder", p.Base, p.Top, p.Radius, p.Radius, p.Open, p.Material), nil
+	case Cone:
+		return tessellateCone("cone", p.Base, p.Top, p.Radius, p.TopRadius, p.Open, p.Material), nil
+	case Disk:
+		return tessellateDisk(p), nil
+	case Torus:
+		return tessellateTorus(p), nil
+	case *Heightfield:
+		return tessellateHeightfield(p), nil
+	case *Mesh:
+		return tessellateMesh(p), nil
+	case *CSG:
+		// the bounds are widened, so that faces on them are inside the grid
+		b := p.Bounds()
+		pad := b.Diagonal().Scale(1. / FieldCells)
+		b.PMin = b.PMin.Add(Point3{X: -pad.X, Y: -pad.Y, Z: -pad.Z})
+		b.PMax = b.PMax.Add(Point3{X: pad.X, Y: pad.Y, Z: pad.Z})
+		return polygonize("csg", b, solidField(p)), nil
+	case *SDFPrimitive:
+		// the estimated normal is the gradient; a small size keeps it sharp
+		h := sdfEpsilon * p.Max.Sub(p.Min).Norm()
+		return polygonize("sdf", p.Bounds(), scalarField(p.Shape.Dist, func(v Vec3) Vec3 { return p.normal(v, h) }, p.Material)), nil
+	case Quadric:
+		return polygonize("quadric", p.Bounds(), scalarField(p.Eval, p.Gradient, p.Material)), nil
+	case *Implicit:
+		return polygonize("implicit", p.Bounds(), scalarField(p.Equation.Eval, p.Equation.Gradient, p.Material)), nil
+	case *Motion:
+		m, err := Tessellate(p.Prim)
+		if err != nil {
+			return nil, err
+		}
+		m.transform(p.At(p.Times[0]))
+		return m, nil
+	default:
+		return nil, fmt.Errorf("can't tessellate %T", p)
+	}
+}
+
+// single returns a mesh of one material and one group.
+func single(name string, material shading.Material, verts, normals []Vec3, uvs []UV, indices []int32) *IndexedMesh {
+	m := &IndexedMesh{
+		Indices:           indices,
+		UVIndices:         slices.Clone(indices),
+		TriangleMaterials: make([]int32, len(indices)/3),
+		Verts:             verts,
+		Normals:           normals,
+		UVs:               uvs,
+		Materials:         []shading.Material{material},
+	}
+	m.Groups = []Group{{Name: name, Count: m.NumTriangles()}}
+	return m
+}
+
+func tessellateSphere(s Sphere) *IndexedMesh {
+	var verts, normals []Vec3
+	var uvs []UV
+
+	// a grid of latitudes and longitudes with the seam doubled for the
+	// texture coordinates, mapped like Sphere.Intersect
+	for j := 0; j <= SphereRings; j++ {
+		v := float64(j) / SphereRings
+		lat := (v - .5) * math.Pi
+		for k := 0; k <= SphereSegments; k++ {
+			u := float64(k) / SphereSegments
+			lon := (u - .5) * 2 * math.Pi
+			n := Vec3{X: math.Cos(lat) * math.Cos(lon), Y: math.Sin(lat), Z: math.Cos(lat) * math.Sin(lon)}
+
+			verts = append(verts, s.Center.Add(n.Scale(s.R)))
+			normals = append(normals, n)
+			uvs = append(uvs, UV{U: u, V: v})
+		}
+	}
+
+	var indices []int32
+	row := int32(SphereSegments + 1)
+	for j := int32(0); j < SphereRings; j++ {
+		for k := int32(0); k < SphereSegments; k++ {
+			a, b := j*row+k, j*row+k+1
+			c, d := a+row, b+row
+			// the quads at the poles are triangles
+			if j > 0 {
+				indices = append(indices, a, c, b)
+			}
+			if j < SphereRings-1 {
+				indices = append(indices, b, c, d)
+			}
+		}
+	}
+
+	return single("sphere", s.Material, verts, normals, uvs, indices)
+}
+
+func tessellatePlane(p Plane) *IndexedMesh {
+	// the plane is cut to a square in X and Z like in Plane.Intersect;
+	// vertical planes are cut to the width in Y
+	n := p.Normal.Normalize()
+	h := p.Width / 2
+	e1 := Vec3{X: h, Y: -h * n.X / n.Y}
+	e2 := Vec3{Y: -h * n.Z / n.Y, Z: h}
+	if math.Abs(n.Y) < 1e-6 {
+		e1 = Vec3{Y: 1}.Cross(n).Normalize().Scale(h)
+		e2 = Vec3{Y: h}
+	}
+
+	verts := []Vec3{
+		p.Point.Sub(e1).Sub(e2),
+		p.Point.Add(e1).Sub(e2),
+		p.Point.Add(e1).Add(e2),
+		p.Point.Sub(e1).Add(e2),
+	}
+	uvs := []UV{{U: 0, V: 0}, {U: 1, V: 0}, {U: 1, V: 1}, {U: 0, V: 1}}
+	indices := []int32{0, 1, 2, 0, 2, 3}
+	if e1.Cross(e2).Dot(n) < 0 {
+		indices = []int32{0, 2, 1, 0, 3, 2}
+	}
+
+	return single("plane", p.Material, verts, []Vec3{n, n, n, n}, uvs, indices)
+}
+
+func tessellateTriangle(t *Triangle) *IndexedMesh {
+	var normals []Vec3
+	if t.Normals != nil {
+		normals = t.Normals[:]
+	}
+	m := single("triangle", t.Material, []Vec3{t.V0, t.V1, t.V2}, slices.Clone(normals),
+		[]UV{t.UV0, t.UV1, t.UV2}, []int32{0, 1, 2})
+	if t.Colors != nil {
+		m.Colors = slices.Clone(t.Colors[:])
+	}
+	return m
+}
+
+// surface tessellates the parametric surface point(u, v) over the unit
+// square into segments by rings quads. point returns the position and the
+// normal; triangles are wound to face the normal and degenerate ones, e.g.
+// at the apex of a cone, are left out.
+func surface(name string, material shading.Material, segments, rings int, point func(u, v float64) (Vec3, Vec3)) *IndexedMesh {
+	var verts, normals []Vec3
+	var uvs []UV
+	for j := 0; j <= rings; j++ {
+		v := float64(j) / float64(rings)
+		for k := 0; k <= segments; k++ {
+			u := float64(k) / float64(segments)
+			p, n := point(u, v)
+			verts = append(verts, p)
+			normals = append(normals, n)
+			uvs = append(uvs, UV{U: u, V: v})
+		}
+	}
+
+	var indices []int32
+	row := int32(segments + 1)
+	for j := int32(0); j < int32(rings); j++ {
+		for k := int32(0); k < int32(segments); k++ {
+			a, b := j*row+k, j*row+k+1
+			c, d := a+row, b+row
+			for _, tri := range [][3]int32{{a, b, c}, {b, d, c}} {
+				i0, i1, i2 := tri[0], tri[1], tri[2]
+				n := verts[i1].Sub(verts[i0]).Cross(verts[i2].Sub(verts[i0]))
+				if n.Norm() < 1e-12 {
+					continue
+				}
+				if n.Dot(normals[i0].Add(normals[i1]).Add(normals[i2])) < 0 {
+					i1, i2 = i2, i1
+				}
+				indices = append(indices, i0, i1, i2)
+			}
+		}
+	}
+
+	return single(name, material, verts, normals, uvs, indices)
+}
+
+// merge appends the parts to the first one and makes them one group.
+func merge(name string, parts ...*IndexedMesh) *IndexedMesh {
+	m := parts[0]
+	for _, part := range parts[1:] {
+		m.Append(part)
+	}
+	m.Groups = []Group{{Name: name, Count: m.NumTriangles()}}
+	return m
+}
+
+func tessellateBox(b Box) *IndexedMesh {
+	rotation, _ := b.rotation()
+	center := b.center()
+	lo, hi := vecArray(b.Min), vecArray(b.Max)
+
+	var faces []*IndexedMesh
+	for face, axes := range boxFaces {
+		axis, sign := face/2, float64(2*(face%2)-1)
+		fu, fv := axes[0], axes[1]
+		faces = append(faces, surface("box", b.Material, 1, 1, func(u, v float64) (Vec3, Vec3) {
+			var p, n [3]float64
+			p[axis] = lo[axis]
+			if sign > 0 {
+				p[axis] = hi[axis]
+			}
+			if fu.sign < 0 {
+				u = 1 - u
+			}
+			if fv.sign < 0 {
+				v = 1 - v
+			}
+			p[fu.axis] = lo[fu.axis] + u*(hi[fu.axis]-lo[fu.axis])
+			p[fv.axis] = lo[fv.axis] + v*(hi[fv.axis]-lo[fv.axis])
+			n[axis] = sign
+			return center.Add(rotation.ApplyVector(arrayVec(p).Sub(center))), rotation.ApplyVector(arrayVec(n))
+		}))
+	}
+
+	return merge("box", faces...)
+}
+
+func tessellateCone(name string, base, top Vec3, r0, r1 float64, open bool, material shading.Material) *IndexedMesh {
+	axis := top.Sub(base)
+	a := axis.Normalize()
+	e1, e2 := basis(a)
+	k := (r1 - r0) / axis.Norm()
+
+	// mapped like intersectCone
+	radial := func(u float64) Vec3 {
+		sin, cos := math.Sincos((u - .5) * 2 * math.Pi)
+		return e1.Scale(cos).Add(e2.Scale(sin))
+	}
+	parts := []*IndexedMesh{surface(name, material, SphereSegments, 1, func(u, v float64) (Vec3, Vec3) {
+		dir := radial(u)
+		p := base.Add(axis.Scale(v)).Add(dir.Scale(r0 + (r1-r0)*v))
+		return p, dir.Sub(a.Scale(k)).Normalize()
+	})}
+
+	if !open {
+		capRadius := max(r0, r1)
+		for _, c := range []struct {
+			center Vec3
+			radius float64
+			normal Vec3
+		}{{base, r0, a.Scale(-1)}, {top, r1, a}} {
+			if c.radius == 0 {
+				continue
+			}
+			lid := surface(name, material, SphereSegments, 1, func(u, v float64) (Vec3, Vec3) {
+				return c.center.Add(radial(u).Scale(v * c.radius)), c.normal
+			})
+			for i, p := range lid.Verts {
+				p = p.Sub(c.center)
+				lid.UVs[i] = UV{U: .5 + p.Dot(e1)/(2*capRadius), V: .5 + p.Dot(e2)/(2*capRadius)}
+			}
+			parts = append(parts, lid)
+		}
+	}
+
+	return merge(name, parts...)
+}
+
+func tessellateDisk(d Disk) *IndexedMesh {
+	n := d.Normal.Normalize()
+	e1, e2 := basis(n)
+
+	return surface("disk", d.Material, SphereSegments, 1, func(u, v float64) (Vec3, Vec3) {
+		sin, cos := math.Sincos((u - .5) * 2 * math.Pi)
+		rho := d.Inner + v*(d.Radius-d.Inner)
+		return d.Center.Add(e1.Scale(cos * rho)).Add(e2.Scale(sin * rho)), n
+	})
+}
+
+func tessellateTorus(t Torus) *IndexedMesh {
+	a := t.Axis.Normalize()
+	e1, e2 := basis(a)
+
+	return surface("torus", t.Material, SphereSegments, SphereRings, func(u, v float64) (Vec3, Vec3) {
+		sinPhi, cosPhi := math.Sincos((u - .5) * 2 * math.Pi)
+		sinTheta, cosTheta := math.Sincos((v - .5) * 2 * math.Pi)
+		radial := e1.Scale(cosPhi).Add(e2.Scale(sinPhi))
+		n := radial.Scale(cosTheta).Add(a.Scale(sinTheta))
+		return t.Center.Add(radial.Scale(t.Major)).Add(n.Scale(t.Minor)), n
+	})
+}
+
+func tessellateMesh(mesh *Mesh) *IndexedMesh {
+	data := mesh.data
+	m := &IndexedMesh{
+		Indices:           slices.Clone(data.Indices),
+		UVIndices:         slices.Clone(data.UVIndices),
+		TriangleMaterials: slices.Clone(data.TriangleMaterials),
+		Verts:             slices.Clone(data.Verts),
+		Normals:           slices.Clone(data.Normals),
+		Colors:            slices.Clone(data.Colors),
+		UVs:               slices.Clone(data.UVs),
+		Materials:         slices.Clone(data.Materials),
+	}
+	m.transform(mesh.Transform.Matrix())
+
+	// materials as NewMesh assigns them
+	if mesh.Material != nil {
+		m.Materials = []shading.Material{*mesh.Material}
+		m.Colors = nil
+	}
+	fallback := int32(-1)
+	for i := range m.TriangleMaterials {
+		if mesh.Material != nil {
+			m.TriangleMaterials[i] = 0
+		} else if m.TriangleMaterials[i] < 0 {
+			if fallback < 0 {
+				fallback = int32(len(m.Materials))
+				m.Materials = append(m.Materials, shading.RedRubber)
+			}
+			m.TriangleMaterials[i] = fallback
+		}
+	}
+
+	name := strings.TrimSuffix(filepath.Base(mesh.File), filepath.Ext(mesh.File))
+	if len(data.Groups) == 0 {
+		m.Groups = []Group{{Name: name, Count: m.NumTriangles()}}
+	}
+	for _, g := range data.Groups {
+		g.Name = name + "/" + g.Name
+		m.Groups = append(m.Groups, g)
+	}
+
+	return m
+}
+
+// transform transforms the vertices and the normals of the mesh.
+func (m *IndexedMesh) transform(matrix Matrix4) {
+	normals, ok := matrix.Inverse()
+	normals = normals.Transpose()
+
+	for i, v := range m.Verts {
+		m.Verts[i] = matrix.ApplyPoint(v)
+	}
+	for i, n := range m.Normals {
+		if n = normals.ApplyVector(n); ok && n != (Vec3{}) {
+			m.Normals[i] = n.Normalize()
+		}
+	}
+}
+
+// Append adds the triangles of other to the mesh. Equal materials are
+// shared and materials of the same name are renamed apart. Vertex normals
+// and colors that only one of the meshes has are filled in for the other,
+// with zero normals and the diffuse colors of the materials.
+func (m *IndexedMesh) Append(other *IndexedMesh) {
+	verts, tris := int32(len(m.Verts)), m.NumTriangles()
+
+	if len(m.Normals) > 0 || len(other.Normals) > 0 {
+		m.Normals = append(m.Normals, make([]Vec3, len(m.Verts)-len(m.Normals))...)
+		m.Normals = append(m.Normals, other.Normals...)
+		m.Normals = append(m.Normals, make([]Vec3, len(other.Verts)-len(other.Normals))...)
+	}
+	if len(m.Colors) > 0 || len(other.Colors) > 0 {
+		if len(m.Colors) == 0 {
+			m.Colors = m.vertexColors()
+		}
+		if len(other.Colors) == 0 {
+			m.Colors = append(m.Colors, other.vertexColors()...)
+		} else {
+			m.Colors = append(m.Colors, other.Colors...)
+		}
+	}
+	m.Verts = append(m.Verts, other.Verts...)
+
+	for _, i := range other.Indices {
+		m.Indices = append(m.Indices, i+verts)
+	}
+
+	if len(m.UVIndices) > 0 || len(other.UVIndices) > 0 {
+		for len(m.UVIndices) < 3*tris {
+			m.UVIndices = append(m.UVIndices, -1)
+		}
+		uvs := int32(len(m.UVs))
+		for k := 0; k < len(other.Indices); k++ {
+			i := int32(-1)
+			if k < len(other.UVIndices) && other.UVIndices[k] >= 0 {
+				i = other.UVIndices[k] + uvs
+			}
+			m.UVIndices = append(m.UVIndices, i)
+		}
+		m.UVs = append(m.UVs, other.UVs...)
+	}
+
+	// the indices of the materials of other in m
+	remap := make([]int32, len(other.Materials))
+	for i, mat := range other.Materials {
+		remap[i] = int32(slices.Index(m.Materials, mat))
+		if remap[i] >= 0 {
+			continue
+		}
+
+		if mat.Name == "" {
+			mat.Name = "material"
+		}
+		name := mat.Name
+		for k := 2; slices.ContainsFunc(m.Materials, func(x shading.Material) bool { return x.Name == mat.Name }); k++ {
+			mat.Name = fmt.Sprintf("%s.%d", name, k)
+		}
+		remap[i] = int32(len(m.Materials))
+		m.Materials = append(m.Materials, mat)
+	}
+	for len(m.TriangleMaterials) < tris {
+		m.TriangleMaterials = append(m.TriangleMaterials, -1)
+	}
+	for k := 0; k < other.NumTriangles(); k++ {
+		i := int32(-1)
+		if k < len(other.TriangleMaterials) && other.TriangleMaterials[k] >= 0 {
+			i = remap[other.TriangleMaterials[k]]
+		}
+		m.TriangleMaterials = append(m.TriangleMaterials, i)
+	}
+
+	for _, g := range other.Groups {
+		g.First += tris
+		m.Groups = append(m.Groups, g)
+	}
+}
+
+// vertexColors returns the diffuse color of the material of a triangle of
+// every vertex.
+func (m *IndexedMesh) vertexColors() []shading.Color {
+	colors := make([]shading.Color, len(m.Verts))
+	for i, idx := range m.Indices {
+		c := shading.RedRubber.KDiffuse
+		if t := i / 3; t < len(m.TriangleMaterials) && m.TriangleMaterials[t] >= 0 {
+			c = m.Materials[m.TriangleMaterials[t]].KDiffuse
+		}
+		colors[idx] = c
+	}
+	return colors
+}
+
+func tessellateHeightfield(h *Heightfield) *IndexedMesh {
+	width, depth := h.data.Width, h.data.Depth
+	verts := make([]Vec3, 0, width*depth)
+	uvs := make([]UV, 0, width*depth)
+	for k := 0; k < depth; k++ {
+		for i := 0; i < width; i++ {
+			verts = append(verts, h.vertex(int32(i), int32(k)))
+			uvs = append(uvs, UV{U: float64(i) / float64(width-1), V: float64(k) / float64(depth-1)})
+		}
+	}
+
+	triangles := int32(2 * (width - 1) * (depth - 1))
+	indices := make([]int32, 0, 3*triangles)
+	for t := int32(0); t < triangles; t++ {
+		i, k := h.corners(t)
+		for j := range i {
+			indices = append(indices, k[j]*int32(width)+i[j])
+		}
+	}
+
+	return single("heightfield", h.Material, verts, slices.Clone(h.normals), uvs, indices)
+}
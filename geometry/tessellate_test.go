@@ -0,0 +1,301 @@
+package geometry
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// faceNormal returns the normal of triangle i by its winding.
+func faceNormal(m *IndexedMesh, i int) Vec3 {
+	i0, i1, i2 := m.Triangle(i)
+	return m.Verts[i1].Sub(m.Verts[i0]).Cross(m.Verts[i2].Sub(m.Verts[i0]))
+}
+
+func TestTessellateSphere(t *testing.T) {
+	s := Sphere{Center: Vec3{X: 1, Y: 2, Z: 3}, R: 2, Material: shading.Ivory}
+	m, err := Tessellate(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := 2 * SphereSegments * (SphereRings - 1); m.NumTriangles() != want {
+		t.Errorf("got %d triangles, want %d", m.NumTriangles(), want)
+	}
+	for i, v := range m.Verts {
+		if d := v.Sub(s.Center).Norm(); math.Abs(d-s.R) > 1e-9 {
+			t.Fatalf("vertex %d at distance %v from the center", i, d)
+		}
+	}
+	for i := 0; i < m.NumTriangles(); i++ {
+		i0, i1, i2 := m.Triangle(i)
+		centroid := m.Verts[i0].Add(m.Verts[i1]).Add(m.Verts[i2]).Scale(1. / 3)
+		if faceNormal(m, i).Dot(centroid.Sub(s.Center)) <= 0 {
+			t.Fatalf("triangle %d faces inwards", i)
+		}
+	}
+
+	// the texture coordinates of the vertices match those of the hits
+	for _, k := range []int{40, 200, 333} {
+		v := m.Verts[k]
+		hit := s.Intersect(Ray{Origin: s.Center.Add(v.Sub(s.Center).Scale(2)), Direction: s.Center.Sub(v)})
+		if uv := m.UVs[k]; hit == nil || math.Abs(hit.UV.U-uv.U) > 1e-9 || math.Abs(hit.UV.V-uv.V) > 1e-9 {
+			t.Errorf("vertex %d has UV %v, the hit there %+v", k, uv, hit)
+		}
+	}
+	if m.Materials[0] != shading.Ivory || m.Groups[0].Name != "sphere" {
+		t.Errorf("material %q, groups %v", m.Materials[0].Name, m.Groups)
+	}
+}
+
+func TestTessellatePlane(t *testing.T) {
+	for _, normal := range []Vec3{{Y: 1}, {Y: -1}, {X: 1, Y: 1}, {Z: 1}} {
+		p := Plane{Point: Vec3{Y: -5}, Normal: normal, Width: 10}
+		m, err := Tessellate(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < m.NumTriangles(); i++ {
+			if faceNormal(m, i).Dot(normal) <= 0 {
+				t.Errorf("plane %v: triangle %d faces away", normal, i)
+			}
+		}
+		for i, v := range m.Verts {
+			if d := v.Sub(p.Point).Dot(normal.Normalize()); math.Abs(d) > 1e-9 {
+				t.Errorf("plane %v: vertex %d %v off the plane", normal, i, v)
+			}
+			if math.Abs(v.X) > 5+1e-9 || math.Abs(v.Z) > 5+1e-9 {
+				t.Errorf("plane %v: vertex %d %v outside the width", normal, i, v)
+			}
+		}
+	}
+}
+
+func TestTessellateShapes(t *testing.T) {
+	prims := []Primitive{
+		Box{Min: Vec3{-1, 0, 2}, Max: Vec3{1, 3, 2.5}, Rotate: Vec3{10, 20, 30}},
+		Cylinder{Base: Vec3{1, 1, 1}, Top: Vec3{2, 3, 0}, Radius: .5},
+		Cone{Base: Vec3{-1, 0, 0}, Top: Vec3{0, -1, 2}, Radius: 1},
+		Disk{Center: Vec3{1, 2, 3}, Normal: Vec3{1, 1, 0}, Radius: 2, Inner: .5},
+		Torus{Center: Vec3{0, 1, 0}, Axis: Vec3{1, 2, 3}, Major: 1.5, Minor: .3},
+	}
+
+	for _, prim := range prims {
+		m, err := Tessellate(prim)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Groups) != 1 || m.Groups[0].Count != m.NumTriangles() || len(m.Materials) != 1 {
+			t.Errorf("%T: groups %v, %d materials", prim, m.Groups, len(m.Materials))
+		}
+
+		for i := 0; i < m.NumTriangles(); i++ {
+			i0, i1, i2 := m.Triangle(i)
+			if faceNormal(m, i).Dot(m.Normals[i0].Add(m.Normals[i1]).Add(m.Normals[i2])) <= 0 {
+				t.Fatalf("%T: triangle %d faces away from its normals", prim, i)
+			}
+		}
+
+		// the vertices are on the surface where it is hit with their normals,
+		// except at the apex of the cone; the rays are moved off the edges
+		// into a triangle of the vertex
+		centroids := make(map[int32]Vec3)
+		for k, i := range m.Indices {
+			i0, i1, i2 := m.Triangle(k / 3)
+			centroids[i] = m.Verts[i0].Add(m.Verts[i1]).Add(m.Verts[i2]).Scale(1. / 3)
+		}
+		for i, centroid := range centroids {
+			v, n := m.Verts[i], m.Normals[i]
+			origin := v.Lerp(centroid, 1e-4).Add(n.Scale(1e-3))
+			hit := prim.Intersect(Ray{Origin: origin, Direction: n.Scale(-1)})
+			apex := hit != nil && hit.DPDU.Norm() < 1e-3
+			if hit == nil || math.Abs(hit.T-1e-3) > 1e-5 || !apex && hit.Normal.Dot(n) < .99 {
+				t.Fatalf("%T: vertex %d %v with normal %v is hit at %+v", prim, i, v, n, hit)
+			}
+		}
+	}
+}
+
+func TestTessellateFields(t *testing.T) {
+	center := Vec3{X: 1, Y: 2, Z: 3}
+	sphere, err := ParsePolynomial("x^2 + y^2 + z^2 - 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blue := shading.Material{Name: "blue", KDiffuse: shading.Color{B: 1}}
+	cut, err := NewCSG(CSGDifference, Box{Min: Vec3{X: -2, Y: -2, Z: -2}, Max: Vec3{X: 2, Y: 2, Z: 2}, Material: shading.Ivory},
+		Sphere{Center: Vec3{Y: 2}, R: 1.5, Material: blue})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		prim      Primitive
+		center    Vec3
+		radius    float64 // of the spheres, 0 for the cut box
+		materials int
+	}{
+		{&SDFPrimitive{Shape: SDFSphere{Center: center, Radius: 2}, Min: Vec3{X: -2, Y: -1, Z: 0}, Max: Vec3{X: 4, Y: 5, Z: 6}}, center, 2, 1},
+		{NewEllipsoid(center, Vec3{X: 2, Y: 2, Z: 2}, shading.Ivory), center, 2, 1},
+		{&Implicit{Equation: sphere, Min: Vec3{X: -3, Y: -3, Z: -3}, Max: Vec3{X: 3, Y: 3, Z: 3}}, Vec3{}, 2, 1},
+		{cut, Vec3{}, 0, 2},
+	} {
+		m, err := Tessellate(tc.prim)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.NumTriangles() == 0 || len(m.Groups) != 1 || m.Groups[0].Count != m.NumTriangles() || len(m.Materials) != tc.materials {
+			t.Fatalf("%T: %d triangles, groups %v, %d materials", tc.prim, m.NumTriangles(), m.Groups, len(m.Materials))
+		}
+
+		// each edge of the closed surfaces is shared by two triangles
+		edges := make(map[[2]int32]int)
+		for i := 0; i < m.NumTriangles(); i++ {
+			i0, i1, i2 := m.Triangle(i)
+			for _, e := range [][2]int32{{i0, i1}, {i1, i2}, {i2, i0}} {
+				edges[[2]int32{min(e[0], e[1]), max(e[0], e[1])}]++
+			}
+			if faceNormal(m, i).Dot(m.Normals[i0].Add(m.Normals[i1]).Add(m.Normals[i2])) <= 0 {
+				t.Fatalf("%T: triangle %d faces away from its normals", tc.prim, i)
+			}
+		}
+		for e, n := range edges {
+			if n != 2 {
+				t.Fatalf("%T: edge %v is shared by %d triangles", tc.prim, e, n)
+			}
+		}
+
+		for i, v := range m.Verts {
+			if tc.radius > 0 {
+				if d := v.Sub(tc.center).Norm(); math.Abs(d-tc.radius) > 1e-6 {
+					t.Fatalf("%T: vertex %d at distance %v from the center", tc.prim, i, d)
+				}
+				continue
+			}
+			// on the box or in its cut
+			onBox := max(math.Abs(v.X), math.Abs(v.Y), math.Abs(v.Z)) > 2-1e-6
+			onCut := math.Abs(v.Sub(Vec3{Y: 2}).Norm()-1.5) < 1e-6
+			if !onBox && !onCut {
+				t.Fatalf("%T: vertex %d %v is off the surface", tc.prim, i, v)
+			}
+		}
+	}
+}
+
+func TestTessellateMesh(t *testing.T) {
+	data := &IndexedMesh{
+		Verts:             []Vec3{{}, {X: 1}, {Y: 1}},
+		Normals:           []Vec3{{Z: 1}, {Z: 1}, {Z: 1}},
+		Indices:           []int32{0, 1, 2},
+		TriangleMaterials: []int32{-1},
+	}
+	transform := IdentityTransform()
+	transform.Translate = Vec3{Z: 5}
+	transform.Rotate = Vec3{Y: 90}
+	mesh := NewMesh("models/tri.obj", data, transform, nil)
+
+	m, err := Tessellate(mesh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range []Vec3{mesh.Triangles[0].V0, mesh.Triangles[0].V1, mesh.Triangles[0].V2} {
+		if m.Verts[i].Sub(v).Norm() > 1e-9 {
+			t.Errorf("vertex %d = %v, want %v", i, m.Verts[i], v)
+		}
+	}
+	if n := m.Normals[0]; n.Sub(Vec3{X: 1}).Norm() > 1e-9 {
+		t.Errorf("normal = %v, want 1, 0, 0", n)
+	}
+	if m.Materials[m.TriangleMaterials[0]] != shading.RedRubber || m.Groups[0].Name != "tri" {
+		t.Errorf("material %v, groups %v", m.TriangleMaterials, m.Groups)
+	}
+	if data.Verts[1] != (Vec3{X: 1}) {
+		t.Errorf("the mesh data was changed")
+	}
+}
+
+func TestAppend(t *testing.T) {
+	red := shading.RedRubber
+	otherRed := red
+	otherRed.KDiffuse = shading.Color{R: 1}
+
+	m, _ := Tessellate(&Triangle{V1: Vec3{X: 1}, V2: Vec3{Y: 1}, Material: red})
+	m.Normals, m.UVIndices = nil, nil
+	m.Append(tessellatePlane(Plane{Normal: Vec3{Y: 1}, Width: 2, Material: red}))
+	m.Append(tessellatePlane(Plane{Normal: Vec3{Y: 1}, Width: 2, Material: otherRed}))
+
+	if m.NumTriangles() != 5 || len(m.Verts) != 11 {
+		t.Fatalf("got %d triangles and %d vertices", m.NumTriangles(), len(m.Verts))
+	}
+	if got := m.Indices[3:6]; got[0] != 3 || got[1] != 5 || got[2] != 4 {
+		t.Errorf("indices of the plane %v, want 3 5 4", got)
+	}
+	if len(m.Normals) != 11 || m.Normals[0] != (Vec3{}) || m.Normals[3] != (Vec3{Y: 1}) {
+		t.Errorf("normals %v", m.Normals)
+	}
+	if len(m.UVIndices) != 15 || m.UVIndices[0] != -1 || m.UVIndices[3] != 3 || m.UVIndices[9] != 7 {
+		t.Errorf("UV indices %v", m.UVIndices)
+	}
+	if len(m.Materials) != 2 || m.Materials[1].Name != "red.2" {
+		t.Errorf("materials %q, %q", m.Materials[0].Name, m.Materials[len(m.Materials)-1].Name)
+	}
+	if tm := m.TriangleMaterials; tm[0] != 0 || tm[1] != 0 || tm[4] != 1 {
+		t.Errorf("triangle materials %v", tm)
+	}
+	if g := m.Groups; len(g) != 3 || g[1].First != 1 || g[2].First != 3 || g[2].Count != 2 {
+		t.Errorf("groups %v", g)
+	}
+}
+
+func TestWriteOBJ(t *testing.T) {
+	m, _ := Tessellate(Sphere{R: 1, Material: shading.Ivory})
+
+	var buf bytes.Buffer
+	if err := m.WriteOBJ(&buf, ""); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadOBJ(&buf, "sphere.obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Verts) != len(m.Verts) || got.Verts[100] != m.Verts[100] {
+		t.Errorf("got %d vertices, want %d", len(got.Verts), len(m.Verts))
+	}
+	if len(got.Indices) != len(m.Indices) || got.Indices[50] != m.Indices[50] {
+		t.Errorf("got %d indices, want %d", len(got.Indices), len(m.Indices))
+	}
+	if len(got.UVIndices) != len(m.UVIndices) || got.UVs[got.UVIndices[50]] != m.UVs[m.UVIndices[50]] {
+		t.Errorf("texture coordinates differ")
+	}
+	if len(got.Groups) != 1 || got.Groups[0].Name != "sphere" {
+		t.Errorf("groups %v", got.Groups)
+	}
+}
+
+func TestWritePLY(t *testing.T) {
+	m, _ := Tessellate(Plane{Point: Vec3{X: 1}, Normal: Vec3{Y: 1}, Width: 2, Material: shading.Ivory})
+
+	var buf bytes.Buffer
+	if err := m.WritePLY(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadPLY(&buf, "plane.ply")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Verts) != 4 || got.Verts[2] != m.Verts[2] || got.Normals[0] != (Vec3{Y: 1}) {
+		t.Errorf("vertices %v, normals %v", got.Verts, got.Normals)
+	}
+	if len(got.Indices) != 6 || got.Indices[4] != m.Indices[4] {
+		t.Errorf("indices %v, want %v", got.Indices, m.Indices)
+	}
+	// the color of the material in 8 bits
+	if c := got.Colors[0]; math.Abs(c.R-shading.Ivory.KDiffuse.R) > .5/255 || math.Abs(c.B-shading.Ivory.KDiffuse.B) > .5/255 {
+		t.Errorf("color %v, want %v", c, shading.Ivory.KDiffuse)
+	}
+}
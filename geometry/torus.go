@@ -0,0 +1,118 @@
+package geometry
+
+import (
+	"math"
+
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// Torus represents a ring around Center in the plane perpendicular to Axis.
+// Major is the distance from the center to the middle of the tube and Minor
+// the radius of the tube.
+type Torus struct {
+	Center, Axis Vec3
+	Major, Minor float64
+	Material     shading.Material
+}
+
+// Intersect computes the intersection of a ray with the torus by solving a
+// quartic equation in the space of the torus, where the axis is Y. u goes
+// around the axis and v around the tube.
+func (t Torus) Intersect(r Ray) *HitRecord {
+	for _, root := range t.roots(r) {
+		if root >= 0 {
+			hit := t.hit(r, root)
+			return &hit
+		}
+	}
+	return nil
+}
+
+// Hits returns where the line of the ray enters and leaves the torus, up to
+// twice.
+func (t Torus) Hits(r Ray) []HitRecord {
+	roots := t.roots(r)
+	hits := make([]HitRecord, 0, len(roots))
+	for _, root := range roots {
+		hits = append(hits, t.hit(r, root))
+	}
+	return hits
+}
+
+// roots returns the hits of the line of the ray in increasing order.
+func (t Torus) roots(r Ray) []float64 {
+	a := t.Axis.Normalize()
+	e1, e2 := basis(a)
+
+	length := r.Direction.Norm()
+	d := r.Direction.Scale(1 / length)
+	o := r.Origin.Sub(t.Center)
+
+	// start at the bounding sphere, since the roots of distant rays are
+	// less precise
+	outer := t.Major + t.Minor
+	b := o.Dot(d)
+	disc := b*b - o.Dot(o) + outer*outer
+	if disc < 0 {
+		return nil
+	}
+	start := -b - math.Sqrt(disc)
+	o = o.Add(d.Scale(start))
+
+	ox, oy, oz := o.Dot(e1), o.Dot(a), o.Dot(e2)
+	dx, dy, dz := d.Dot(e1), d.Dot(a), d.Dot(e2)
+
+	// (|p|^2 + R^2 - r^2)^2 = 4 R^2 (x^2 + z^2) for p = o + t*d
+	rr := t.Major * t.Major
+	e := ox*ox + oy*oy + oz*oz + rr - t.Minor*t.Minor
+	f := ox*dx + oy*dy + oz*dz
+	roots := solveQuartic(1,
+		4*f,
+		4*f*f+2*e-4*rr*(dx*dx+dz*dz),
+		4*f*e-8*rr*(ox*dx+oz*dz),
+		e*e-4*rr*(ox*ox+oz*oz))
+
+	// a line touching the torus gives an odd number of roots
+	if len(roots)%2 != 0 {
+		roots = roots[:len(roots)-1]
+	}
+	for i := range roots {
+		roots[i] = (start + roots[i]) / length
+	}
+	return roots
+}
+
+// hit returns the hit of the ray at root.
+func (t Torus) hit(r Ray, root float64) HitRecord {
+	a := t.Axis.Normalize()
+	e1, e2 := basis(a)
+
+	p := r.At(root).Sub(t.Center)
+	x, y, z := p.Dot(e1), p.Dot(a), p.Dot(e2)
+	rho := math.Hypot(x, z)
+	phi := math.Atan2(z, x)
+	theta := math.Atan2(y, rho-t.Major)
+	sinPhi, cosPhi := math.Sincos(phi)
+	sinTheta, cosTheta := math.Sincos(theta)
+	radial := e1.Scale(cosPhi).Add(e2.Scale(sinPhi))
+
+	return HitRecord{
+		T:         root,
+		Primitive: t,
+		Material:  t.Material,
+		Normal:    radial.Scale(cosTheta).Add(a.Scale(sinTheta)),
+		UV:        UV{U: .5 + phi/(2*math.Pi), V: .5 + theta/(2*math.Pi)},
+		DPDU:      e2.Scale(cosPhi).Sub(e1.Scale(sinPhi)).Scale(2 * math.Pi * rho),
+		DPDV:      a.Scale(cosTheta).Sub(radial.Scale(sinTheta)).Scale(2 * math.Pi * t.Minor),
+	}
+}
+
+// Bounds returns the bounding box of the torus.
+func (t Torus) Bounds() Bounds3 {
+	a := t.Axis.Normalize()
+	box := circleBounds(t.Center, a, t.Major+t.Minor)
+
+	// the tube sticks out along the axis
+	tube := Point3{X: t.Minor * math.Abs(a.X), Y: t.Minor * math.Abs(a.Y), Z: t.Minor * math.Abs(a.Z)}
+	return Bounds3{PMin: box.PMin.Add(tube.Scale(-1)), PMax: box.PMax.Add(tube)}
+}
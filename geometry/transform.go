@@ -0,0 +1,194 @@
+package geometry
+
+import "math"
+
+// Matrix4 is a row-major 4x4 matrix of an affine transformation.
+type Matrix4 [4][4]float64
+
+// Identity returns the identity matrix.
+func Identity() Matrix4 {
+	return Matrix4{
+		{1, 0, 0, 0},
+		{0, 1, 0, 0},
+		{0, 0, 1, 0},
+		{0, 0, 0, 1},
+	}
+}
+
+// Translation returns a matrix that translates points by v.
+func Translation(v Vec3) Matrix4 {
+	m := Identity()
+	m[0][3] = v.X
+	m[1][3] = v.Y
+	m[2][3] = v.Z
+	return m
+}
+
+// Scaling returns a matrix that scales each axis by the corresponding component of v.
+func Scaling(v Vec3) Matrix4 {
+	m := Identity()
+	m[0][0] = v.X
+	m[1][1] = v.Y
+	m[2][2] = v.Z
+	return m
+}
+
+// RotationX returns a matrix that rotates around the X axis by deg degrees.
+func RotationX(deg float64) Matrix4 {
+	sin, cos := math.Sincos(deg * math.Pi / 180)
+	m := Identity()
+	m[1][1], m[1][2] = cos, -sin
+	m[2][1], m[2][2] = sin, cos
+	return m
+}
+
+// RotationY returns a matrix that rotates around the Y axis by deg degrees.
+func RotationY(deg float64) Matrix4 {
+	sin, cos := math.Sincos(deg * math.Pi / 180)
+	m := Identity()
+	m[0][0], m[0][2] = cos, sin
+	m[2][0], m[2][2] = -sin, cos
+	return m
+}
+
+// RotationZ returns a matrix that rotates around the Z axis by deg degrees.
+func RotationZ(deg float64) Matrix4 {
+	sin, cos := math.Sincos(deg * math.Pi / 180)
+	m := Identity()
+	m[0][0], m[0][1] = cos, -sin
+	m[1][0], m[1][1] = sin, cos
+	return m
+}
+
+// Mul returns the product m * other, i.e. other is applied first.
+func (m Matrix4) Mul(other Matrix4) Matrix4 {
+	var res Matrix4
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			for k := 0; k < 4; k++ {
+				res[i][j] += m[i][k] * other[k][j]
+			}
+		}
+	}
+	return res
+}
+
+// Inverse returns the inverse of m. It reports false for a singular matrix,
+// e.g. a scaling by zero.
+func (m Matrix4) Inverse() (Matrix4, bool) {
+	// Gauss-Jordan elimination with partial pivoting
+	a, inv := m, Identity()
+	for col := 0; col < 4; col++ {
+		pivot := col
+		for row := col + 1; row < 4; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return Matrix4{}, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		inv[col], inv[pivot] = inv[pivot], inv[col]
+
+		f := 1 / a[col][col]
+		for j := 0; j < 4; j++ {
+			a[col][j] *= f
+			inv[col][j] *= f
+		}
+		for row := 0; row < 4; row++ {
+			if row == col {
+				continue
+			}
+			f := a[row][col]
+			for j := 0; j < 4; j++ {
+				a[row][j] -= f * a[col][j]
+				inv[row][j] -= f * inv[col][j]
+			}
+		}
+	}
+	return inv, true
+}
+
+// Transpose returns the transpose of m. The transpose of an inverse transform
+// maps normals.
+func (m Matrix4) Transpose() Matrix4 {
+	var res Matrix4
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			res[i][j] = m[j][i]
+		}
+	}
+	return res
+}
+
+// Lerp interpolates the elements of m and other linearly.
+func (m Matrix4) Lerp(other Matrix4, t float64) Matrix4 {
+	var res Matrix4
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			res[i][j] = m[i][j] + (other[i][j]-m[i][j])*t
+		}
+	}
+	return res
+}
+
+// ApplyBounds returns the bounding box of the transformed corners of b.
+func (m Matrix4) ApplyBounds(b Bounds3) Bounds3 {
+	res := EmptyAABB()
+	for i := 0; i < 8; i++ {
+		corner := Vec3{X: b.PMin.X, Y: b.PMin.Y, Z: b.PMin.Z}
+		if i&1 != 0 {
+			corner.X = b.PMax.X
+		}
+		if i&2 != 0 {
+			corner.Y = b.PMax.Y
+		}
+		if i&4 != 0 {
+			corner.Z = b.PMax.Z
+		}
+		p := m.ApplyPoint(corner)
+		res = res.UnionPoint3(Point3{X: p.X, Y: p.Y, Z: p.Z})
+	}
+	return res
+}
+
+// ApplyPoint transforms a point.
+func (m Matrix4) ApplyPoint(p Vec3) Vec3 {
+	return Vec3{
+		X: m[0][0]*p.X + m[0][1]*p.Y + m[0][2]*p.Z + m[0][3],
+		Y: m[1][0]*p.X + m[1][1]*p.Y + m[1][2]*p.Z + m[1][3],
+		Z: m[2][0]*p.X + m[2][1]*p.Y + m[2][2]*p.Z + m[2][3],
+	}
+}
+
+// ApplyVector transforms a direction, ignoring the translation.
+func (m Matrix4) ApplyVector(v Vec3) Vec3 {
+	return Vec3{
+		X: m[0][0]*v.X + m[0][1]*v.Y + m[0][2]*v.Z,
+		Y: m[1][0]*v.X + m[1][1]*v.Y + m[1][2]*v.Z,
+		Z: m[2][0]*v.X + m[2][1]*v.Y + m[2][2]*v.Z,
+	}
+}
+
+// Transform places an object in the scene. Scale is applied first, then the
+// rotation around X, Y and Z (in degrees) and finally the translation.
+type Transform struct {
+	Translate Vec3
+	Rotate    Vec3
+	Scale     Vec3
+}
+
+// IdentityTransform returns a Transform that leaves objects in place.
+func IdentityTransform() Transform {
+	return Transform{Scale: Vec3{X: 1, Y: 1, Z: 1}}
+}
+
+// Matrix returns the matrix of the transform.
+func (t Transform) Matrix() Matrix4 {
+	return Translation(t.Translate).
+		Mul(RotationZ(t.Rotate.Z)).
+		Mul(RotationY(t.Rotate.Y)).
+		Mul(RotationX(t.Rotate.X)).
+		Mul(Scaling(t.Scale))
+}
@@ -0,0 +1,31 @@
+package geometry
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMatrixInverse(t *testing.T) {
+	m := Transform{
+		Translate: Vec3{X: 1, Y: -2, Z: 3},
+		Rotate:    Vec3{X: 30, Y: 45, Z: 60},
+		Scale:     Vec3{X: 2, Y: .5, Z: 3},
+	}.Matrix()
+
+	inv, ok := m.Inverse()
+	if !ok {
+		t.Fatal("Inverse() reports a singular matrix")
+	}
+	id := m.Mul(inv)
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			if want := Identity()[i][j]; math.Abs(id[i][j]-want) > 1e-9 {
+				t.Fatalf("m * m^-1 = %v, want the identity", id)
+			}
+		}
+	}
+
+	if _, ok := Scaling(Vec3{X: 1, Y: 0, Z: 1}).Inverse(); ok {
+		t.Error("Inverse() of a scaling by zero reports no singular matrix")
+	}
+}
@@ -0,0 +1,516 @@
+// Package gltf imports glTF 2.0 scenes, as .gltf JSON files with their
+// buffers and images embedded as data URIs or next to the file, or as binary
+// .glb files. Remote URIs are not fetched.
+//
+// The nodes of the default scene are placed by their TRS or matrix
+// transforms down the hierarchy, and every mesh becomes a geometry.Mesh with
+// the transforms applied to its vertices. Positions, normals and texture
+// coordinates are read from triangles, triangle strips and fans; points and
+// lines are skipped. The PBR metallic-roughness materials, with their base
+// color textures, are approximated by Phong materials. The first perspective
+// camera becomes the camera of the scene and the lights of
+// KHR_lights_punctual become point lights.
+//
+// glTF is right-handed with the camera looking down -Z, the renderer is
+// left-handed, so Z is mirrored on import.
+package gltf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danradchuk/raytracer/core"
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// ReadFile reads the scene of a .gltf or .glb file.
+func ReadFile(path string) (*core.Scene, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	s, err := Decode(f, path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// Decode reads a scene in the glTF JSON or binary format, told apart by the
+// magic of the binary one. name is the path of the file: external buffers
+// and images are resolved relative to its directory, and meshes are named
+// after it.
+func Decode(r io.Reader, name string) (*core.Scene, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var bin []byte
+	if len(data) >= 4 && string(data[:4]) == "glTF" {
+		if data, bin, err = readGLB(data); err != nil {
+			return nil, err
+		}
+	}
+
+	d := &decoder{name: name, bin: bin, textures: make(map[int]*shading.Texture)}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&d.doc); err != nil {
+		return nil, err
+	}
+	if err := d.check(); err != nil {
+		return nil, err
+	}
+
+	return d.scene()
+}
+
+// document is the JSON of a glTF file, reduced to what the import uses.
+type document struct {
+	Asset struct {
+		Version string `json:"version"`
+	} `json:"asset"`
+	ExtensionsRequired []string     `json:"extensionsRequired"`
+	Scene              *int         `json:"scene"`
+	Scenes             []sceneDef   `json:"scenes"`
+	Nodes              []node       `json:"nodes"`
+	Meshes             []mesh       `json:"meshes"`
+	Materials          []material   `json:"materials"`
+	Textures           []texture    `json:"textures"`
+	Images             []imageDef   `json:"images"`
+	Cameras            []camera     `json:"cameras"`
+	Accessors          []accessor   `json:"accessors"`
+	BufferViews        []bufferView `json:"bufferViews"`
+	Buffers            []buffer     `json:"buffers"`
+	Extensions         struct {
+		LightsPunctual struct {
+			Lights []light `json:"lights"`
+		} `json:"KHR_lights_punctual"`
+	} `json:"extensions"`
+}
+
+type sceneDef struct {
+	Nodes []int `json:"nodes"`
+}
+
+type node struct {
+	Name        string    `json:"name"`
+	Children    []int     `json:"children"`
+	Matrix      []float64 `json:"matrix"`
+	Translation []float64 `json:"translation"`
+	Rotation    []float64 `json:"rotation"`
+	Scale       []float64 `json:"scale"`
+	Mesh        *int      `json:"mesh"`
+	Camera      *int      `json:"camera"`
+	Extensions  struct {
+		LightsPunctual struct {
+			Light *int `json:"light"`
+		} `json:"KHR_lights_punctual"`
+	} `json:"extensions"`
+}
+
+type mesh struct {
+	Name       string      `json:"name"`
+	Primitives []primitive `json:"primitives"`
+}
+
+type primitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    *int           `json:"indices"`
+	Material   *int           `json:"material"`
+	Mode       *int           `json:"mode"`
+}
+
+// The primitive modes of glTF.
+const (
+	modeTriangles     = 4
+	modeTriangleStrip = 5
+	modeTriangleFan   = 6
+)
+
+type material struct {
+	Name string `json:"name"`
+	PBR  struct {
+		BaseColorFactor  []float64   `json:"baseColorFactor"`
+		BaseColorTexture *textureRef `json:"baseColorTexture"`
+		MetallicFactor   *float64    `json:"metallicFactor"`
+		RoughnessFactor  *float64    `json:"roughnessFactor"`
+	} `json:"pbrMetallicRoughness"`
+	AlphaMode  string `json:"alphaMode"`
+	Extensions struct {
+		Transmission *struct {
+			Factor float64 `json:"transmissionFactor"`
+		} `json:"KHR_materials_transmission"`
+		IOR *struct {
+			IOR *float64 `json:"ior"`
+		} `json:"KHR_materials_ior"`
+	} `json:"extensions"`
+}
+
+type textureRef struct {
+	Index    int `json:"index"`
+	TexCoord int `json:"texCoord"`
+}
+
+type texture struct {
+	Source *int `json:"source"`
+}
+
+type imageDef struct {
+	URI        string `json:"uri"`
+	MimeType   string `json:"mimeType"`
+	BufferView *int   `json:"bufferView"`
+}
+
+type camera struct {
+	Type        string `json:"type"`
+	Perspective struct {
+		YFov float64 `json:"yfov"` // in radians
+	} `json:"perspective"`
+}
+
+type light struct {
+	Type      string    `json:"type"`
+	Color     []float64 `json:"color"`
+	Intensity *float64  `json:"intensity"`
+}
+
+type accessor struct {
+	BufferView    *int            `json:"bufferView"`
+	ByteOffset    int             `json:"byteOffset"`
+	ComponentType int             `json:"componentType"`
+	Normalized    bool            `json:"normalized"`
+	Count         int             `json:"count"`
+	Type          string          `json:"type"`
+	Sparse        json.RawMessage `json:"sparse"`
+}
+
+type bufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	ByteStride int `json:"byteStride"`
+}
+
+type buffer struct {
+	URI        string `json:"uri"`
+	ByteLength int    `json:"byteLength"`
+}
+
+// supportedExtensions are the extensions a file may require.
+var supportedExtensions = map[string]bool{
+	"KHR_lights_punctual":        true,
+	"KHR_materials_transmission": true,
+	"KHR_materials_ior":          true,
+}
+
+// decoder holds the document and the data loaded from it.
+type decoder struct {
+	name     string
+	doc      document
+	bin      []byte   // the binary chunk of a .glb file
+	buffers  [][]byte // loaded on first use
+	textures map[int]*shading.Texture
+}
+
+func (d *decoder) check() error {
+	if !strings.HasPrefix(d.doc.Asset.Version, "2.") {
+		return fmt.Errorf("unsupported glTF version %q", d.doc.Asset.Version)
+	}
+	for _, ext := range d.doc.ExtensionsRequired {
+		if !supportedExtensions[ext] {
+			return fmt.Errorf("unsupported required extension %s", ext)
+		}
+	}
+	return nil
+}
+
+// readGLB returns the JSON and the binary chunk of a .glb file.
+func readGLB(data []byte) (jsonChunk, bin []byte, err error) {
+	if len(data) < 12 {
+		return nil, nil, fmt.Errorf("glb: truncated header")
+	}
+	if version := binary.LittleEndian.Uint32(data[4:8]); version != 2 {
+		return nil, nil, fmt.Errorf("glb: unsupported version %d", version)
+	}
+	length := int(binary.LittleEndian.Uint32(data[8:12]))
+	if length > len(data) {
+		return nil, nil, fmt.Errorf("glb: file is %d bytes, expected %d", len(data), length)
+	}
+
+	const (
+		chunkJSON = 0x4E4F534A
+		chunkBIN  = 0x004E4942
+	)
+	for rest := data[12:max(length, 12)]; len(rest) > 0; {
+		if len(rest) < 8 {
+			return nil, nil, fmt.Errorf("glb: truncated chunk header")
+		}
+		n, typ := int(binary.LittleEndian.Uint32(rest[0:4])), binary.LittleEndian.Uint32(rest[4:8])
+		if n > len(rest)-8 {
+			return nil, nil, fmt.Errorf("glb: truncated chunk")
+		}
+		chunk := rest[8 : 8+n]
+		rest = rest[8+n:]
+
+		switch {
+		case typ == chunkJSON && jsonChunk == nil:
+			jsonChunk = chunk
+		case typ == chunkBIN && bin == nil:
+			bin = chunk
+		}
+	}
+	if jsonChunk == nil {
+		return nil, nil, fmt.Errorf("glb: missing JSON chunk")
+	}
+
+	return jsonChunk, bin, nil
+}
+
+// load returns the data of a URI: embedded as a base64 data URI or a file
+// relative to the glTF file.
+func (d *decoder) load(uri string) ([]byte, error) {
+	if rest, ok := strings.CutPrefix(uri, "data:"); ok {
+		_, payload, ok := strings.Cut(rest, ";base64,")
+		if !ok {
+			return nil, fmt.Errorf("data URI is not base64")
+		}
+		return base64.StdEncoding.DecodeString(payload)
+	}
+	if strings.Contains(uri, "://") {
+		return nil, fmt.Errorf("remote URI %q", uri)
+	}
+
+	path, err := d.path(uri)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// path returns the path of the file a relative URI refers to.
+func (d *decoder) path(uri string) (string, error) {
+	path, err := url.PathUnescape(uri)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(d.name), filepath.FromSlash(path)), nil
+}
+
+// buffer returns the data of buffer i.
+func (d *decoder) buffer(i int) ([]byte, error) {
+	if i < 0 || i >= len(d.doc.Buffers) {
+		return nil, fmt.Errorf("buffer %d out of range", i)
+	}
+	if d.buffers == nil {
+		d.buffers = make([][]byte, len(d.doc.Buffers))
+	}
+	if d.buffers[i] != nil {
+		return d.buffers[i], nil
+	}
+
+	b := d.doc.Buffers[i]
+	var data []byte
+	if b.URI == "" {
+		// the binary chunk is the first buffer of a .glb file
+		if i != 0 || d.bin == nil {
+			return nil, fmt.Errorf("buffers[%d]: missing uri", i)
+		}
+		data = d.bin
+	} else {
+		var err error
+		if data, err = d.load(b.URI); err != nil {
+			return nil, fmt.Errorf("buffers[%d]: %w", i, err)
+		}
+	}
+	if len(data) < b.ByteLength {
+		return nil, fmt.Errorf("buffers[%d]: %d bytes, expected %d", i, len(data), b.ByteLength)
+	}
+
+	d.buffers[i] = data[:b.ByteLength]
+	return d.buffers[i], nil
+}
+
+// view returns the data of buffer view i and its stride.
+func (d *decoder) view(i int) ([]byte, int, error) {
+	if i < 0 || i >= len(d.doc.BufferViews) {
+		return nil, 0, fmt.Errorf("buffer view %d out of range", i)
+	}
+	v := d.doc.BufferViews[i]
+	data, err := d.buffer(v.Buffer)
+	if err != nil {
+		return nil, 0, err
+	}
+	if v.ByteOffset < 0 || v.ByteLength < 0 || v.ByteStride < 0 || v.ByteOffset+v.ByteLength > len(data) {
+		return nil, 0, fmt.Errorf("bufferViews[%d]: out of the bounds of buffer %d", i, v.Buffer)
+	}
+	return data[v.ByteOffset : v.ByteOffset+v.ByteLength], v.ByteStride, nil
+}
+
+// componentSizes are the sizes in bytes of the component types.
+var componentSizes = map[int]int{
+	5120: 1, // byte
+	5121: 1, // unsigned byte
+	5122: 2, // short
+	5123: 2, // unsigned short
+	5125: 4, // unsigned int
+	5126: 4, // float
+}
+
+// typeComponents are the numbers of components of the accessor types.
+var typeComponents = map[string]int{
+	"SCALAR": 1, "VEC2": 2, "VEC3": 3, "VEC4": 4,
+}
+
+// read returns the elements of accessor i as consecutive components.
+// Normalized integers are mapped to [0, 1] or [-1, 1].
+func (d *decoder) read(i int) ([]float64, int, error) {
+	if i < 0 || i >= len(d.doc.Accessors) {
+		return nil, 0, fmt.Errorf("accessor %d out of range", i)
+	}
+	a := d.doc.Accessors[i]
+	errorf := func(format string, args ...any) error {
+		return fmt.Errorf("accessors[%d]: %s", i, fmt.Sprintf(format, args...))
+	}
+
+	n, ok := typeComponents[a.Type]
+	if !ok {
+		return nil, 0, errorf("unsupported type %q", a.Type)
+	}
+	size, ok := componentSizes[a.ComponentType]
+	if !ok {
+		return nil, 0, errorf("unknown component type %d", a.ComponentType)
+	}
+	if a.Sparse != nil {
+		return nil, 0, errorf("sparse accessors are not supported")
+	}
+	if a.Count < 0 {
+		return nil, 0, errorf("bad count %d", a.Count)
+	}
+
+	if a.BufferView == nil {
+		return make([]float64, a.Count*n), n, nil
+	}
+
+	data, stride, err := d.view(*a.BufferView)
+	if err != nil {
+		return nil, 0, errorf("%v", err)
+	}
+	if stride == 0 {
+		stride = n * size
+	}
+	// check the elements fit the view before allocating them; the division
+	// keeps a huge count from overflowing
+	if a.Count > 0 {
+		room := len(data) - a.ByteOffset - n*size
+		if a.ByteOffset < 0 || room < 0 || a.Count-1 > room/stride {
+			return nil, 0, errorf("out of the bounds of buffer view %d", *a.BufferView)
+		}
+	}
+	values := make([]float64, a.Count*n)
+
+	le := binary.LittleEndian
+	for e := 0; e < a.Count; e++ {
+		elem := data[a.ByteOffset+e*stride:]
+		for c := 0; c < n; c++ {
+			b := elem[c*size:]
+			var x float64
+			switch a.ComponentType {
+			case 5120:
+				x = float64(int8(b[0]))
+				if a.Normalized {
+					x = max(x/127, -1)
+				}
+			case 5121:
+				x = float64(b[0])
+				if a.Normalized {
+					x /= 255
+				}
+			case 5122:
+				x = float64(int16(le.Uint16(b)))
+				if a.Normalized {
+					x = max(x/32767, -1)
+				}
+			case 5123:
+				x = float64(le.Uint16(b))
+				if a.Normalized {
+					x /= 65535
+				}
+			case 5125:
+				x = float64(le.Uint32(b))
+			case 5126:
+				x = float64(math.Float32frombits(le.Uint32(b)))
+			}
+			values[e*n+c] = x
+		}
+	}
+
+	return values, n, nil
+}
+
+// readAs reads accessor i, which must have n components.
+func (d *decoder) readAs(i, n int) ([]float64, error) {
+	values, got, err := d.read(i)
+	if err != nil {
+		return nil, err
+	}
+	if got != n {
+		return nil, fmt.Errorf("accessors[%d]: expected %d components, got %d", i, n, got)
+	}
+	return values, nil
+}
+
+// texture returns the image of texture i, decoded on first use.
+func (d *decoder) texture(i int) (*shading.Texture, error) {
+	if t, ok := d.textures[i]; ok {
+		return t, nil
+	}
+	if i < 0 || i >= len(d.doc.Textures) {
+		return nil, fmt.Errorf("texture %d out of range", i)
+	}
+	src := d.doc.Textures[i].Source
+	if src == nil {
+		return nil, fmt.Errorf("textures[%d]: missing source", i)
+	}
+	if *src < 0 || *src >= len(d.doc.Images) {
+		return nil, fmt.Errorf("textures[%d]: image %d out of range", i, *src)
+	}
+
+	im := d.doc.Images[*src]
+	var data []byte
+	var err error
+	if im.BufferView != nil {
+		data, _, err = d.view(*im.BufferView)
+	} else {
+		data, err = d.load(im.URI)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("images[%d]: %w", *src, err)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("images[%d]: %w", *src, err)
+	}
+	t := shading.NewTexture(img)
+	if im.BufferView == nil && !strings.HasPrefix(im.URI, "data:") {
+		// the image was read, so its URI resolves
+		t.Path, _ = d.path(im.URI)
+	}
+
+	d.textures[i] = t
+	return t, nil
+}
@@ -0,0 +1,276 @@
+package gltf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/danradchuk/raytracer/geometry"
+)
+
+// quad returns a buffer with the positions, normals, texture coordinates
+// and indices of a unit square in the XY plane facing +Z.
+func quad() []byte {
+	var buf bytes.Buffer
+	put := func(values ...any) {
+		for _, v := range values {
+			_ = binary.Write(&buf, binary.LittleEndian, v)
+		}
+	}
+	put(float32(0), float32(0), float32(0), float32(1), float32(0), float32(0),
+		float32(1), float32(1), float32(0), float32(0), float32(1), float32(0)) // 0: positions
+	put(float32(0), float32(0), float32(1), float32(0), float32(0), float32(1),
+		float32(0), float32(0), float32(1), float32(0), float32(0), float32(1)) // 48: normals
+	put(float32(0), float32(1), float32(1), float32(1), float32(1), float32(0), float32(0), float32(0)) // 96: UVs
+	put(uint16(0), uint16(1), uint16(2), uint16(0), uint16(2), uint16(3))                               // 128: indices
+	return buf.Bytes()
+}
+
+// quadScene returns a scene of the quad below a parent node, with a camera
+// and a light. buffer is the JSON of the buffer.
+func quadScene(buffer string) string {
+	var img bytes.Buffer
+	checker := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	checker.Set(0, 0, color.RGBA{R: 255, A: 255})
+	checker.Set(1, 1, color.RGBA{R: 255, A: 255})
+	_ = png.Encode(&img, checker)
+
+	return `{
+		"asset": {"version": "2.0"},
+		"scene": 0,
+		"scenes": [{"nodes": [0, 2, 3]}],
+		"nodes": [
+			{"translation": [1, 2, 3], "rotation": [0, 0.7071068, 0, 0.7071068], "children": [1]},
+			{"scale": [2, 2, 2], "mesh": 0},
+			{"translation": [0, 0, 10], "camera": 0},
+			{"matrix": [1,0,0,0, 0,1,0,0, 0,0,1,0, 0,5,0,1], "extensions": {"KHR_lights_punctual": {"light": 0}}}
+		],
+		"meshes": [{"name": "quad", "primitives": [{
+			"attributes": {"POSITION": 0, "NORMAL": 1, "TEXCOORD_0": 2}, "indices": 3, "material": 0}]}],
+		"materials": [{"name": "paint", "pbrMetallicRoughness": {
+			"baseColorFactor": [1, 0.5, 0.25, 1], "metallicFactor": 0, "roughnessFactor": 0.5,
+			"baseColorTexture": {"index": 0}}}],
+		"textures": [{"source": 0}],
+		"images": [{"uri": "data:image/png;base64,` + base64.StdEncoding.EncodeToString(img.Bytes()) + `"}],
+		"cameras": [{"type": "perspective", "perspective": {"yfov": 0.7853982, "znear": 0.1}}],
+		"extensions": {"KHR_lights_punctual": {"lights": [{"type": "point", "color": [1, 1, 0], "intensity": 0.5}]}},
+		"accessors": [
+			{"bufferView": 0, "componentType": 5126, "count": 4, "type": "VEC3"},
+			{"bufferView": 0, "byteOffset": 48, "componentType": 5126, "count": 4, "type": "VEC3"},
+			{"bufferView": 0, "byteOffset": 96, "componentType": 5126, "count": 4, "type": "VEC2"},
+			{"bufferView": 1, "componentType": 5123, "count": 6, "type": "SCALAR"}
+		],
+		"bufferViews": [{"buffer": 0, "byteLength": 128}, {"buffer": 0, "byteOffset": 128, "byteLength": 12}],
+		"buffers": [` + buffer + `]
+	}`
+}
+
+// glb packs a document and its binary chunk into a .glb file.
+func glb(doc string, bin []byte) []byte {
+	pad := func(b []byte, c byte) []byte {
+		for len(b)%4 != 0 {
+			b = append(b, c)
+		}
+		return b
+	}
+	js, bin := pad([]byte(doc), ' '), pad(bin, 0)
+
+	var buf bytes.Buffer
+	put := func(values ...any) {
+		for _, v := range values {
+			_ = binary.Write(&buf, binary.LittleEndian, v)
+		}
+	}
+	put([]byte("glTF"), uint32(2), uint32(12+8+len(js)+8+len(bin)))
+	put(uint32(len(js)), uint32(0x4E4F534A), js)
+	put(uint32(len(bin)), uint32(0x004E4942), bin)
+	return buf.Bytes()
+}
+
+func near(a, b geometry.Vec3) bool {
+	return a.Sub(b).Norm() < 1e-6
+}
+
+func TestDecode(t *testing.T) {
+	data := quad()
+	embedded := fmt.Sprintf(`{"byteLength": %d, "uri": "data:application/octet-stream;base64,%s"}`,
+		len(data), base64.StdEncoding.EncodeToString(data))
+
+	files := map[string][]byte{
+		"gltf": []byte(quadScene(embedded)),
+		"glb":  glb(quadScene(fmt.Sprintf(`{"byteLength": %d}`, len(data))), data),
+	}
+	for format, file := range files {
+		t.Run(format, func(t *testing.T) {
+			s, err := Decode(bytes.NewReader(file), "quad."+format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(s.Primitives) != 1 {
+				t.Fatalf("got %d primitives, want 1", len(s.Primitives))
+			}
+			m := s.Primitives[0].(*geometry.Mesh)
+			if m.File != "quad."+format+"#quad" {
+				t.Errorf("File = %q", m.File)
+			}
+			if len(m.Triangles) != 2 {
+				t.Fatalf("got %d triangles, want 2", len(m.Triangles))
+			}
+
+			// scaled by 2, turned by 90 degrees around Y, moved and mirrored,
+			// which reverses the winding
+			tri := m.Triangles[0]
+			for i, want := range []geometry.Vec3{{X: 1, Y: 2, Z: -3}, {X: 1, Y: 4, Z: -1}, {X: 1, Y: 2, Z: -1}} {
+				if got := [3]geometry.Vec3{tri.V0, tri.V1, tri.V2}[i]; !near(got, want) {
+					t.Errorf("vertex %d = %v, want %v", i, got, want)
+				}
+			}
+			if n := tri.Normals[0]; !near(n, geometry.Vec3{X: 1}) {
+				t.Errorf("normal = %v, want 1, 0, 0", n)
+			}
+			if tri.UV1 != (geometry.UV{U: 1, V: 1}) {
+				t.Errorf("UV1 = %v, want 1, 1", tri.UV1)
+			}
+
+			mat := tri.Material
+			if mat.Name != "paint" || mat.DiffuseMap == nil || mat.DiffuseMap.Width != 2 {
+				t.Errorf("material %q with texture %v", mat.Name, mat.DiffuseMap)
+			}
+			if mat.KDiffuse.R != 1 || mat.KDiffuse.G != .5 || mat.Alpha != 30 {
+				t.Errorf("KDiffuse = %v, Alpha = %v", mat.KDiffuse, mat.Alpha)
+			}
+
+			if !near(s.Camera, geometry.Vec3{Z: -10}) || s.Target == nil || !near(*s.Target, geometry.Vec3{Z: -9}) {
+				t.Errorf("camera at %v looking at %v", s.Camera, s.Target)
+			}
+			if s.Settings.Fov != 45 {
+				t.Errorf("Fov = %d, want 45", s.Settings.Fov)
+			}
+
+			if len(s.Lights) != 1 {
+				t.Fatalf("got %d lights, want 1", len(s.Lights))
+			}
+			if l := s.Lights[0]; !near(l.Pos, geometry.Vec3{Y: 5}) || l.DiffuseIntensity.G != .5 || l.DiffuseIntensity.B != 0 {
+				t.Errorf("light %+v", *l)
+			}
+		})
+	}
+}
+
+func TestDecodeDefaults(t *testing.T) {
+	data := quad()
+	doc := fmt.Sprintf(`{
+		"asset": {"version": "2.0"},
+		"nodes": [{"mesh": 0}],
+		"meshes": [{"primitives": [{"attributes": {"POSITION": 0}, "mode": 6}]}],
+		"accessors": [{"bufferView": 0, "componentType": 5126, "count": 4, "type": "VEC3"}],
+		"bufferViews": [{"buffer": 0, "byteLength": 48}],
+		"buffers": [{"byteLength": 48, "uri": "data:application/octet-stream;base64,%s"}]
+	}`, base64.StdEncoding.EncodeToString(data[:48]))
+
+	s, err := Decode(strings.NewReader(doc), "fan.gltf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := s.Primitives[0].(*geometry.Mesh)
+	if len(m.Triangles) != 2 || m.Triangles[0].Normals != nil || m.Triangles[0].Material.Name != "default" {
+		t.Errorf("got %d triangles of %+v", len(m.Triangles), m.Triangles[0])
+	}
+
+	// framed from the front with a light at the camera
+	center := geometry.Vec3{X: .5, Y: .5}
+	if s.Target == nil || !near(*s.Target, center) || s.Camera.Z >= 0 || math.Abs(s.Camera.X-.5) > 1e-9 {
+		t.Errorf("camera at %v looking at %v", s.Camera, s.Target)
+	}
+	if len(s.Lights) != 1 || s.Lights[0].Pos != s.Camera {
+		t.Errorf("lights %v", s.Lights)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	data := base64.StdEncoding.EncodeToString(quad())
+	buffer := `[{"byteLength": 140, "uri": "data:application/octet-stream;base64,` + data + `"}]`
+	mesh := `"meshes": [{"primitives": [{"attributes": {"POSITION": 0}, "indices": 1}]}],
+		"bufferViews": [{"buffer": 0, "byteLength": 128}, {"buffer": 0, "byteOffset": 128, "byteLength": 12}],`
+
+	tests := []struct {
+		doc  string
+		want string
+	}{
+		{`{"asset": {"version": "1.0"}}`, `unsupported glTF version "1.0"`},
+		{`{"asset": {"version": "2.0"}, "extensionsRequired": ["KHR_draco_mesh_compression"]}`,
+			"unsupported required extension KHR_draco_mesh_compression"},
+		{`{"asset": {"version": "2.0"}, "nodes": [{"children": [1]}, {"children": [0]}], "scenes": [{"nodes": [0]}]}`,
+			"cycle in the node hierarchy"},
+		{`{"asset": {"version": "2.0"}, "nodes": [{"mesh": 0}], ` + mesh + `
+			"accessors": [{"bufferView": 0, "componentType": 5126, "count": 4, "type": "VEC3"},
+				{"bufferView": 1, "componentType": 5123, "count": 6, "type": "SCALAR"}],
+			"buffers": [{"byteLength": 140, "uri": "https://example.com/quad.bin"}]}`,
+			`buffers[0]: remote URI "https://example.com/quad.bin"`},
+		{`{"asset": {"version": "2.0"}, "nodes": [{"mesh": 0}], ` + mesh + `
+			"accessors": [{"bufferView": 0, "componentType": 5126, "count": 3, "type": "VEC3"},
+				{"bufferView": 1, "componentType": 5123, "count": 6, "type": "SCALAR"}],
+			"buffers": ` + buffer + `}`,
+			"meshes[0].primitives[0]: indices: index 3 out of range, 3 vertices"},
+		{`{"asset": {"version": "2.0"}, "nodes": [{"mesh": 0}], ` + mesh + `
+			"accessors": [{"bufferView": 0, "componentType": 5126, "count": 12, "type": "VEC3"},
+				{"bufferView": 1, "componentType": 5123, "count": 6, "type": "SCALAR"}],
+			"buffers": ` + buffer + `}`,
+			"POSITION: accessors[0]: out of the bounds of buffer view 0"},
+		{`{"asset": {"version": "2.0"}, "nodes": [{"mesh": 0}], ` + mesh + `
+			"accessors": [{"bufferView": 0, "componentType": 5126, "count": 1000000000000000, "type": "VEC3"},
+				{"bufferView": 1, "componentType": 5123, "count": 6, "type": "SCALAR"}],
+			"buffers": ` + buffer + `}`,
+			"POSITION: accessors[0]: out of the bounds of buffer view 0"},
+	}
+
+	for _, test := range tests {
+		_, err := Decode(strings.NewReader(test.doc), "bad.gltf")
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Decode(%s) = %v, want %q", test.doc, err, test.want)
+		}
+	}
+}
+
+func TestReadFileTexturePath(t *testing.T) {
+	// the scene and its files are in a directory other than the working one
+	dir := filepath.Join(t.TempDir(), "models")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var img bytes.Buffer
+	_ = png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 2, 2)))
+	if err := os.WriteFile(filepath.Join(dir, "checker.png"), img.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	data := quad()
+	if err := os.WriteFile(filepath.Join(dir, "quad.bin"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	doc := regexp.MustCompile(`"images": \[.*\]`).ReplaceAllString(
+		quadScene(fmt.Sprintf(`{"byteLength": %d, "uri": "quad.bin"}`, len(data))), `"images": [{"uri": "checker.png"}]`)
+	if err := os.WriteFile(filepath.Join(dir, "quad.gltf"), []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := ReadFile(filepath.Join(dir, "quad.gltf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tex := s.Primitives[0].(*geometry.Mesh).Triangles[0].Material.DiffuseMap
+	if want := filepath.Join(dir, "checker.png"); tex == nil || tex.Path != want {
+		t.Errorf("texture %+v, want the path %q", tex, want)
+	}
+}
@@ -0,0 +1,536 @@
+package gltf
+
+import (
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/danradchuk/raytracer/core"
+	"github.com/danradchuk/raytracer/geometry"
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// mirrorZ converts from the right-handed space of glTF.
+var mirrorZ = geometry.Scaling(geometry.Vec3{X: 1, Y: 1, Z: -1})
+
+// sunDistance is how far directional lights are placed, opposite to their
+// direction, since lights are points.
+const sunDistance = 1e6
+
+// builder converts the nodes of a scene.
+type builder struct {
+	*decoder
+	scene     *core.Scene
+	materials map[int]shading.Material // by material index, -1 is the default
+	camera    bool                     // whether a camera was placed
+	lo, hi    geometry.Vec3            // the bounds of the vertices
+	empty     bool                     // whether there are no vertices
+}
+
+// scene converts the default scene of the document. Without a camera the
+// scene is viewed from the front at a distance that fits its bounds, and
+// without lights a light is placed at the camera.
+func (d *decoder) scene() (*core.Scene, error) {
+	b := &builder{
+		decoder:   d,
+		scene:     &core.Scene{AmbientIntensity: shading.Color{R: .1, G: .1, B: .1}},
+		materials: make(map[int]shading.Material),
+		empty:     true,
+	}
+
+	roots, err := d.roots()
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range roots {
+		if err := b.node(i, mirrorZ, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	s := b.scene
+	if !b.camera && !b.empty {
+		// look at the front, which faces +Z in glTF and -Z here
+		center := b.lo.Add(b.hi).Scale(.5)
+		fov := float64(s.Settings.WithDefaults().Fov) * math.Pi / 180
+		dist := b.hi.Sub(b.lo).Norm() / 2 / math.Sin(fov/2)
+		s.Camera = center.Sub(geometry.Vec3{Z: dist})
+		s.Target = &center
+	}
+	if len(s.Lights) == 0 {
+		s.Lights = append(s.Lights, &core.Light{
+			Pos:               s.Camera,
+			DiffuseIntensity:  shading.Color{R: .8, G: .8, B: .8},
+			SpecularIntensity: shading.Color{R: .8, G: .8, B: .8},
+		})
+	}
+
+	return s, nil
+}
+
+// roots returns the root nodes of the default scene, or all the nodes that
+// aren't children when the document has no scenes.
+func (d *decoder) roots() ([]int, error) {
+	if len(d.doc.Scenes) > 0 {
+		i := 0
+		if d.doc.Scene != nil {
+			i = *d.doc.Scene
+		}
+		if i < 0 || i >= len(d.doc.Scenes) {
+			return nil, fmt.Errorf("scene %d out of range", i)
+		}
+		return d.doc.Scenes[i].Nodes, nil
+	}
+
+	child := make([]bool, len(d.doc.Nodes))
+	for _, n := range d.doc.Nodes {
+		for _, c := range n.Children {
+			if c >= 0 && c < len(child) {
+				child[c] = true
+			}
+		}
+	}
+	var roots []int
+	for i, c := range child {
+		if !c {
+			roots = append(roots, i)
+		}
+	}
+	return roots, nil
+}
+
+// node places node i and its children below a parent transform.
+func (b *builder) node(i int, parent geometry.Matrix4, depth int) error {
+	if i < 0 || i >= len(b.doc.Nodes) {
+		return fmt.Errorf("node %d out of range", i)
+	}
+	if depth > len(b.doc.Nodes) {
+		return fmt.Errorf("nodes[%d]: cycle in the node hierarchy", i)
+	}
+	n := b.doc.Nodes[i]
+
+	local, err := n.matrix()
+	if err != nil {
+		return fmt.Errorf("nodes[%d]: %w", i, err)
+	}
+	world := parent.Mul(local)
+
+	if n.Mesh != nil {
+		if err := b.mesh(*n.Mesh, world); err != nil {
+			return err
+		}
+	}
+	if n.Camera != nil && !b.camera {
+		if err := b.placeCamera(*n.Camera, world); err != nil {
+			return err
+		}
+	}
+	if l := n.Extensions.LightsPunctual.Light; l != nil {
+		if err := b.light(*l, world); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range n.Children {
+		if err := b.node(c, world, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matrix returns the local transform of the node.
+func (n node) matrix() (geometry.Matrix4, error) {
+	if n.Matrix != nil {
+		if len(n.Matrix) != 16 {
+			return geometry.Matrix4{}, fmt.Errorf("matrix: expected 16 numbers, got %d", len(n.Matrix))
+		}
+		// glTF matrices are column-major
+		var m geometry.Matrix4
+		for c := 0; c < 4; c++ {
+			for r := 0; r < 4; r++ {
+				m[r][c] = n.Matrix[4*c+r]
+			}
+		}
+		return m, nil
+	}
+
+	m := geometry.Identity()
+	if n.Translation != nil {
+		if len(n.Translation) != 3 {
+			return m, fmt.Errorf("translation: expected 3 numbers, got %d", len(n.Translation))
+		}
+		m = geometry.Translation(geometry.Vec3{X: n.Translation[0], Y: n.Translation[1], Z: n.Translation[2]})
+	}
+	if n.Rotation != nil {
+		if len(n.Rotation) != 4 {
+			return m, fmt.Errorf("rotation: expected 4 numbers, got %d", len(n.Rotation))
+		}
+		m = m.Mul(quaternion(n.Rotation[0], n.Rotation[1], n.Rotation[2], n.Rotation[3]))
+	}
+	if n.Scale != nil {
+		if len(n.Scale) != 3 {
+			return m, fmt.Errorf("scale: expected 3 numbers, got %d", len(n.Scale))
+		}
+		m = m.Mul(geometry.Scaling(geometry.Vec3{X: n.Scale[0], Y: n.Scale[1], Z: n.Scale[2]}))
+	}
+	return m, nil
+}
+
+// quaternion returns the rotation matrix of the quaternion (x, y, z, w).
+func quaternion(x, y, z, w float64) geometry.Matrix4 {
+	if l := math.Sqrt(x*x + y*y + z*z + w*w); l > 0 {
+		x, y, z, w = x/l, y/l, z/l, w/l
+	}
+	return geometry.Matrix4{
+		{1 - 2*(y*y+z*z), 2 * (x*y - z*w), 2 * (x*z + y*w), 0},
+		{2 * (x*y + z*w), 1 - 2*(x*x+z*z), 2 * (y*z - x*w), 0},
+		{2 * (x*z - y*w), 2 * (y*z + x*w), 1 - 2*(x*x+y*y), 0},
+		{0, 0, 0, 1},
+	}
+}
+
+// mesh adds mesh i, transformed into the world, to the scene. The
+// primitives of the mesh are merged into one geometry.Mesh.
+func (b *builder) mesh(i int, world geometry.Matrix4) error {
+	if i < 0 || i >= len(b.doc.Meshes) {
+		return fmt.Errorf("mesh %d out of range", i)
+	}
+	m := b.doc.Meshes[i]
+
+	normalMatrix, ok := world.Inverse()
+	if !ok {
+		// scaled to nothing
+		return nil
+	}
+	normalMatrix = normalMatrix.Transpose()
+
+	// attributes that only some primitives have are zero for the others
+	hasNormals, hasUVs := false, false
+	for _, p := range m.Primitives {
+		_, n := p.Attributes["NORMAL"]
+		_, uv := p.Attributes[b.texCoord(p)]
+		hasNormals, hasUVs = hasNormals || n, hasUVs || uv
+	}
+
+	data := &geometry.IndexedMesh{}
+	materials := make(map[int]int32)
+	for pi, p := range m.Primitives {
+		if err := b.primitive(data, p, world, normalMatrix, hasNormals, hasUVs, materials); err != nil {
+			return fmt.Errorf("meshes[%d].primitives[%d]: %w", i, pi, err)
+		}
+	}
+	if data.NumTriangles() == 0 {
+		return nil
+	}
+	if hasUVs {
+		data.UVIndices = slices.Clone(data.Indices)
+	}
+
+	for _, v := range data.Verts {
+		if b.empty {
+			b.lo, b.hi, b.empty = v, v, false
+		}
+		b.lo = geometry.Vec3{X: min(b.lo.X, v.X), Y: min(b.lo.Y, v.Y), Z: min(b.lo.Z, v.Z)}
+		b.hi = geometry.Vec3{X: max(b.hi.X, v.X), Y: max(b.hi.Y, v.Y), Z: max(b.hi.Z, v.Z)}
+	}
+
+	name := m.Name
+	if name == "" {
+		name = fmt.Sprintf("mesh%d", i)
+	}
+	b.scene.Primitives = append(b.scene.Primitives,
+		geometry.NewMesh(b.name+"#"+name, data, geometry.IdentityTransform(), nil))
+	return nil
+}
+
+// texCoord returns the attribute of the texture coordinates used by the
+// base color texture of a primitive.
+func (b *builder) texCoord(p primitive) string {
+	set := 0
+	if p.Material != nil && *p.Material >= 0 && *p.Material < len(b.doc.Materials) {
+		if t := b.doc.Materials[*p.Material].PBR.BaseColorTexture; t != nil {
+			set = t.TexCoord
+		}
+	}
+	return fmt.Sprintf("TEXCOORD_%d", set)
+}
+
+// primitive appends the triangles of a mesh primitive to data. materials
+// maps material indices of the document to those of data.
+func (b *builder) primitive(data *geometry.IndexedMesh, p primitive, world, normalMatrix geometry.Matrix4,
+	hasNormals, hasUVs bool, materials map[int]int32) error {
+	mode := modeTriangles
+	if p.Mode != nil {
+		mode = *p.Mode
+	}
+	switch {
+	case mode >= 0 && mode < modeTriangles:
+		// points and lines have no surface
+		return nil
+	case mode > modeTriangleFan:
+		return fmt.Errorf("unknown mode %d", mode)
+	}
+
+	pos, ok := p.Attributes["POSITION"]
+	if !ok {
+		return fmt.Errorf("missing POSITION")
+	}
+	positions, err := b.readAs(pos, 3)
+	if err != nil {
+		return fmt.Errorf("POSITION: %w", err)
+	}
+	count := len(positions) / 3
+	base := int32(len(data.Verts))
+	for k := 0; k < count; k++ {
+		v := geometry.Vec3{X: positions[3*k], Y: positions[3*k+1], Z: positions[3*k+2]}
+		data.Verts = append(data.Verts, world.ApplyPoint(v))
+	}
+
+	if hasNormals {
+		var normals []float64
+		if a, ok := p.Attributes["NORMAL"]; ok {
+			if normals, err = b.readAs(a, 3); err != nil {
+				return fmt.Errorf("NORMAL: %w", err)
+			}
+		}
+		for k := 0; k < count; k++ {
+			var n geometry.Vec3
+			if 3*k+2 < len(normals) {
+				n = normalMatrix.ApplyVector(geometry.Vec3{X: normals[3*k], Y: normals[3*k+1], Z: normals[3*k+2]})
+				if n != (geometry.Vec3{}) {
+					n = n.Normalize()
+				}
+			}
+			data.Normals = append(data.Normals, n)
+		}
+	}
+
+	if hasUVs {
+		var uvs []float64
+		if a, ok := p.Attributes[b.texCoord(p)]; ok {
+			if uvs, err = b.readAs(a, 2); err != nil {
+				return fmt.Errorf("%s: %w", b.texCoord(p), err)
+			}
+		}
+		for k := 0; k < count; k++ {
+			var uv geometry.UV
+			if 2*k+1 < len(uvs) {
+				// glTF puts (0, 0) at the upper left of images
+				uv = geometry.UV{U: uvs[2*k], V: 1 - uvs[2*k+1]}
+			}
+			data.UVs = append(data.UVs, uv)
+		}
+	}
+
+	var indices []float64
+	if p.Indices != nil {
+		if indices, err = b.readAs(*p.Indices, 1); err != nil {
+			return fmt.Errorf("indices: %w", err)
+		}
+		for _, idx := range indices {
+			if idx >= float64(count) {
+				return fmt.Errorf("indices: index %v out of range, %d vertices", idx, count)
+			}
+		}
+	} else {
+		indices = make([]float64, count)
+		for k := range indices {
+			indices[k] = float64(k)
+		}
+	}
+
+	matIndex := -1
+	if p.Material != nil {
+		matIndex = *p.Material
+	}
+	mat, ok := materials[matIndex]
+	if !ok {
+		material, err := b.material(matIndex)
+		if err != nil {
+			return err
+		}
+		mat = int32(len(data.Materials))
+		materials[matIndex] = mat
+		data.Materials = append(data.Materials, material)
+	}
+
+	// a mirroring transform, like the one of Z, turns the faces inside out
+	flip := determinant(world) < 0
+	add := func(i0, i1, i2 float64) {
+		if flip {
+			i1, i2 = i2, i1
+		}
+		data.Indices = append(data.Indices, base+int32(i0), base+int32(i1), base+int32(i2))
+		data.TriangleMaterials = append(data.TriangleMaterials, mat)
+	}
+	switch mode {
+	case modeTriangles:
+		for k := 0; k+2 < len(indices); k += 3 {
+			add(indices[k], indices[k+1], indices[k+2])
+		}
+	case modeTriangleStrip:
+		// every other triangle is flipped to keep the winding
+		for k := 0; k+2 < len(indices); k++ {
+			if k%2 == 0 {
+				add(indices[k], indices[k+1], indices[k+2])
+			} else {
+				add(indices[k+1], indices[k], indices[k+2])
+			}
+		}
+	case modeTriangleFan:
+		for k := 1; k+1 < len(indices); k++ {
+			add(indices[0], indices[k], indices[k+1])
+		}
+	}
+
+	return nil
+}
+
+// determinant returns the determinant of the linear part of m.
+func determinant(m geometry.Matrix4) float64 {
+	return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+}
+
+// material returns material i approximated by the Phong model, or the
+// default material of glTF for -1. Smooth surfaces reflect with the
+// specular color, which goes from a weak gray for dielectrics to the base
+// color for metals; rough metals keep their color as diffuse. The shininess
+// follows from the roughness. Blended alpha and transmission make the
+// material transparent.
+func (b *builder) material(i int) (shading.Material, error) {
+	if m, ok := b.materials[i]; ok {
+		return m, nil
+	}
+
+	var m material
+	name := "default"
+	if i >= 0 {
+		if i >= len(b.doc.Materials) {
+			return shading.Material{}, fmt.Errorf("material %d out of range", i)
+		}
+		m = b.doc.Materials[i]
+		name = m.Name
+		if name == "" {
+			name = fmt.Sprintf("material%d", i)
+		}
+	}
+	errorf := func(format string, args ...any) error {
+		return fmt.Errorf("materials[%d]: %s", i, fmt.Sprintf(format, args...))
+	}
+
+	factor := [4]float64{1, 1, 1, 1}
+	if f := m.PBR.BaseColorFactor; f != nil {
+		if len(f) != 4 {
+			return shading.Material{}, errorf("baseColorFactor: expected 4 numbers, got %d", len(f))
+		}
+		copy(factor[:], f)
+	}
+	metallic, roughness := 1., 1.
+	if m.PBR.MetallicFactor != nil {
+		metallic = min(max(*m.PBR.MetallicFactor, 0), 1)
+	}
+	if m.PBR.RoughnessFactor != nil {
+		roughness = min(max(*m.PBR.RoughnessFactor, 0), 1)
+	}
+
+	gray := func(x float64) shading.Color {
+		return shading.Color{R: x, G: x, B: x}
+	}
+	lerp := func(a, b shading.Color, t float64) shading.Color {
+		return a.MulByNum(1 - t).Add(b.MulByNum(t))
+	}
+	base := shading.Color{R: factor[0], G: factor[1], B: factor[2]}
+	gloss := 1 - roughness
+	diffuse := base.MulByNum(1 - metallic*gloss)
+
+	mat := shading.Material{
+		Name:        name,
+		KAmbient:    diffuse.MulByNum(1. / 3),
+		KDiffuse:    diffuse,
+		KSpecular:   lerp(gray(.5), base, metallic).MulByNum(gloss),
+		KReflection: lerp(gray(.04), base, metallic).MulByNum(gloss * gloss),
+		Alpha:       min(max(2/math.Pow(max(roughness, .01), 4)-2, 1), 10000),
+		IOR:         1.5,
+	}
+	if m.AlphaMode == "BLEND" {
+		mat.Transparency = 1 - factor[3]
+	}
+	if t := m.Extensions.Transmission; t != nil {
+		mat.Transparency = max(mat.Transparency, t.Factor)
+	}
+	if ior := m.Extensions.IOR; ior != nil && ior.IOR != nil {
+		mat.IOR = *ior.IOR
+	}
+	if t := m.PBR.BaseColorTexture; t != nil {
+		tex, err := b.texture(t.Index)
+		if err != nil {
+			return shading.Material{}, errorf("%v", err)
+		}
+		mat.DiffuseMap = tex
+	}
+
+	b.materials[i] = mat
+	return mat, nil
+}
+
+// placeCamera makes camera i placed by world the camera of the scene.
+// Orthographic cameras are skipped.
+func (b *builder) placeCamera(i int, world geometry.Matrix4) error {
+	if i < 0 || i >= len(b.doc.Cameras) {
+		return fmt.Errorf("camera %d out of range", i)
+	}
+	c := b.doc.Cameras[i]
+	if c.Type != "perspective" {
+		return nil
+	}
+	if c.Perspective.YFov <= 0 || c.Perspective.YFov >= math.Pi {
+		return fmt.Errorf("cameras[%d]: bad yfov %v", i, c.Perspective.YFov)
+	}
+
+	s := b.scene
+	s.Camera = world.ApplyPoint(geometry.Vec3{})
+	target := s.Camera.Add(world.ApplyVector(geometry.Vec3{Z: -1}).Normalize())
+	s.Target = &target
+	s.Settings.Fov = int(math.Round(c.Perspective.YFov * 180 / math.Pi))
+	b.camera = true
+	return nil
+}
+
+// light adds light i placed by world. Lights have no falloff, so their
+// intensities are capped at 1; spot lights shine in all directions.
+func (b *builder) light(i int, world geometry.Matrix4) error {
+	lights := b.doc.Extensions.LightsPunctual.Lights
+	if i < 0 || i >= len(lights) {
+		return fmt.Errorf("light %d out of range", i)
+	}
+	l := lights[i]
+
+	c := shading.Color{R: 1, G: 1, B: 1}
+	if l.Color != nil {
+		if len(l.Color) != 3 {
+			return fmt.Errorf("lights[%d]: color: expected 3 numbers, got %d", i, len(l.Color))
+		}
+		c = shading.Color{R: l.Color[0], G: l.Color[1], B: l.Color[2]}
+	}
+	intensity := 1.
+	if l.Intensity != nil {
+		intensity = *l.Intensity
+	}
+	c = c.MulByNum(min(max(intensity, 0), 1))
+
+	var pos geometry.Vec3
+	switch l.Type {
+	case "point", "spot":
+		pos = world.ApplyPoint(geometry.Vec3{})
+	case "directional":
+		pos = world.ApplyVector(geometry.Vec3{Z: -1}).Normalize().Scale(-sunDistance)
+	default:
+		return fmt.Errorf("lights[%d]: unknown type %q", i, l.Type)
+	}
+
+	b.scene.Lights = append(b.scene.Lights, &core.Light{Pos: pos, DiffuseIntensity: c, SpecularIntensity: c})
+	return nil
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
 	"strings"
 
-	"github.com/danradchuk/raytracer/dsl"
+	"github.com/danradchuk/raytracer/core"
 	"github.com/danradchuk/raytracer/geometry"
-	"github.com/danradchuk/raytracer/shading"
 )
 
 var (
@@ -19,18 +23,48 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		var command func([]string) error
+		switch os.Args[1] {
+		case "fmt":
+			command = runFmt
+		case "convert":
+			command = runConvert
+		case "export":
+			command = runExport
+		}
+
+		if command != nil {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	// the defaults of the render flags apply unless the scene has a render block
 	var (
-		width   = flag.Int("width", 1366, "width of the picture in pixels")
-		height  = flag.Int("height", 768, "height of the picture in pixels")
-		fov     = flag.Int("fov", 90, "field of view")
-		input   = flag.String("input", "teapot.obj", "a mesh of an object to render")
-		output  = flag.String("output", "image", "image to render")
-		imgType = flag.String("type", "ppm", "ppm or gif")
-		world   = flag.String("scene", "./scenes/empty.scene", "file for constructing the scene")
+		width    = flag.Int("width", 1366, "width of the picture in pixels")
+		height   = flag.Int("height", 768, "height of the picture in pixels")
+		fov      = flag.Int("fov", 90, "field of view")
+		spp      = flag.Int("spp", 1, "samples per pixel")
+		maxDepth = flag.Int("maxdepth", core.MaxDepth, "maximum recursion depth")
+		workers  = flag.Int("workers", runtime.NumCPU(), "number of render goroutines")
+		frames   = flag.Int("frames", 360, "frames of a gif turntable")
+		input    = flag.String("input", "", "an additional mesh of an object to render")
+		output   = flag.String("output", "image", "image to render")
+		imgType  = flag.String("type", "ppm", "ppm, png or gif")
+		world    = flag.String("scene", "./scenes/teapot.scene", "file for constructing the scene")
+		progress = flag.Bool("progress", true, "show a progress bar when the standard error is a terminal")
+		stats    = flag.Bool("stats", false, "print ray and timing statistics after rendering")
+		statsOut = flag.String("statsjson", "", "write the statistics as JSON to this file, - for the standard output")
 	)
 
 	flag.Parse()
 
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -53,49 +87,108 @@ func main() {
 	}
 
 	// construct the scene
-	content, err := os.ReadFile(*world)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	p := dsl.NewParser(string(content))
-	s, err := p.Parse()
+	s, err := loadScene(*world)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	//load a triangle mesh
 	if *input != "" {
-		mesh := geometry.LoadOBJ(*input)
-		for _, t := range mesh.GetTrianglesFromMesh(shading.RedRubber) {
-			s.Primitives = append(s.Primitives, t)
+		data, err := geometry.LoadMesh(*input)
+		if err != nil {
+			log.Fatal(err)
 		}
+		s.Primitives = append(s.Primitives, geometry.NewMesh(*input, data, geometry.IdentityTransform(), nil))
 	}
 
 	// build a BVH
-	s.AccelBVH = geometry.BuildBVH(s.Primitives)
+	s.BuildBVH()
+
+	// explicit flags override the render settings of the scene
+	settings := s.Settings.WithDefaults()
+	if explicit["width"] {
+		settings.Width = *width
+	}
+	if explicit["height"] {
+		settings.Height = *height
+	}
+	if explicit["fov"] {
+		settings.Fov = *fov
+	}
+	if explicit["spp"] {
+		settings.SPP = *spp
+	}
+	if explicit["maxdepth"] {
+		settings.MaxDepth = *maxDepth
+	}
+	if explicit["workers"] {
+		settings.Workers = *workers
+	}
+	if explicit["frames"] {
+		settings.Frames = *frames
+	}
+	if explicit["output"] {
+		settings.Output = *output
+	}
+
+	typ := settings.ImageType()
+	if explicit["type"] || (typ != "ppm" && typ != "png" && typ != "gif") {
+		typ = *imgType
+	}
+	s.Settings = settings
 
 	// render image
-	split := strings.Split(*output, ".")
+	fileName := strings.TrimSuffix(settings.Output, filepath.Ext(settings.Output)) + "." + typ
+
+	// an interrupt stops the render
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-	fileName := ""
-	if len(split) == 2 {
-		fileName = split[0] + "." + *imgType
-	} else {
-		fileName = *output + "." + *imgType
+	bar := &progressBar{w: os.Stderr, width: 40}
+	if *progress && isTerminal(os.Stderr) {
+		s.Progress = bar
 	}
 
-	if *imgType == "ppm" {
-		err := s.RenderPPM(*width, *height, *fov, fileName)
-		if err != nil {
-			log.Fatal(err)
-		}
-	} else if *imgType == "gif" {
-		err := s.RenderGIF(*width, *height, *fov, fileName)
-		if err != nil {
+	switch {
+	case len(s.Animations) > 0 && typ != "gif":
+		// an animated scene renders into an image sequence, or plays in a GIF
+		err = s.RenderSequence(ctx, settings.Width, settings.Height, settings.Fov, fileName)
+	case typ == "ppm":
+		err = s.RenderPPMContext(ctx, settings.Width, settings.Height, settings.Fov, fileName)
+	case typ == "png":
+		err = s.RenderPNGContext(ctx, settings.Width, settings.Height, settings.Fov, fileName)
+	case typ == "gif":
+		err = s.RenderGIFContext(ctx, settings.Width, settings.Height, settings.Fov, fileName)
+	default:
+		log.Fatal("unknown image type")
+	}
+	bar.done()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *stats {
+		fmt.Fprint(os.Stderr, s.Stats())
+	}
+	if *statsOut != "" {
+		if err := writeStats(*statsOut, s.Stats()); err != nil {
 			log.Fatal(err)
 		}
-	} else {
-		log.Fatal("unknown image type")
 	}
 }
+
+// writeStats writes the statistics of a render as JSON to a file or, for
+// "-", to the standard output.
+func writeStats(path string, stats core.Stats) error {
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/danradchuk/raytracer/core"
+)
+
+// progressBar draws the progress of a render and the estimated time left on
+// a single terminal line.
+type progressBar struct {
+	w     io.Writer
+	width int // of the bar in characters
+	last  time.Time
+}
+
+// Progress redraws the bar at most ten times a second and when the render
+// is done.
+func (pb *progressBar) Progress(p core.Progress) {
+	now := time.Now()
+	if p.Pixels < p.Total && now.Sub(pb.last) < 100*time.Millisecond {
+		return
+	}
+	pb.last = now
+
+	filled := int(p.Fraction() * float64(pb.width))
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", pb.width-filled)
+	_, _ = fmt.Fprintf(pb.w, "\r[%s] %3.0f%% %s left ", bar, 100*p.Fraction(), formatDuration(p.ETA()))
+}
+
+// done ends the line of the bar.
+func (pb *progressBar) done() {
+	if !pb.last.IsZero() {
+		_, _ = fmt.Fprintln(pb.w)
+	}
+}
+
+// formatDuration writes a duration as m:ss or h:mm:ss.
+func formatDuration(d time.Duration) string {
+	s := int(d.Round(time.Second).Seconds())
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// isTerminal reports whether f is a terminal rather than a file or a pipe.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
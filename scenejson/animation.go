@@ -0,0 +1,124 @@
+package scenejson
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/danradchuk/raytracer/core"
+	"github.com/danradchuk/raytracer/geometry"
+)
+
+// Animation is a keyframed property of the camera, of the light or of the
+// primitive at Index of its list.
+type Animation struct {
+	Target        string     `json:"target"`
+	Index         int        `json:"index,omitempty"`
+	Property      string     `json:"property"`
+	Interpolation string     `json:"interpolation,omitempty"`
+	Keys          []Keyframe `json:"keys"`
+}
+
+// Keyframe is the value of a property at a frame with optional Bezier handles.
+type Keyframe struct {
+	Frame float64   `json:"frame"`
+	Value KeyValue  `json:"value"`
+	In    *KeyValue `json:"in,omitempty"`
+	Out   *KeyValue `json:"out,omitempty"`
+}
+
+// KeyValue is a number for scalar properties such as a radius and an array
+// of three numbers otherwise.
+type KeyValue struct {
+	Vec    Vec3
+	Scalar bool
+}
+
+// MarshalJSON writes a number or a vector.
+func (v KeyValue) MarshalJSON() ([]byte, error) {
+	if v.Scalar {
+		return json.Marshal(v.Vec[0])
+	}
+	return json.Marshal(v.Vec)
+}
+
+// UnmarshalJSON reads a number or a vector.
+func (v *KeyValue) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		v.Scalar = false
+		return v.Vec.UnmarshalJSON(data)
+	}
+	v.Scalar = true
+	v.Vec = Vec3{}
+	return json.Unmarshal(data, &v.Vec[0])
+}
+
+func (a Animation) animation() (core.Animation, error) {
+	anim := core.Animation{
+		Target:   a.Target,
+		Index:    a.Index,
+		Property: a.Property,
+		Track:    core.Track{Interpolation: a.Interpolation},
+	}
+	if anim.Track.Interpolation == "" {
+		anim.Track.Interpolation = core.InterpolateLinear
+	}
+
+	scalar := core.IsScalarProperty(a.Property)
+	value := func(v KeyValue) (geometry.Vec3, error) {
+		if v.Scalar != scalar {
+			if scalar {
+				return geometry.Vec3{}, fmt.Errorf("%s: expected a number", a.Property)
+			}
+			return geometry.Vec3{}, fmt.Errorf("%s: expected a vector", a.Property)
+		}
+		return v.Vec.vec(), nil
+	}
+
+	for _, k := range a.Keys {
+		key := core.Keyframe{Frame: k.Frame}
+		var err error
+		if key.Value, err = value(k.Value); err != nil {
+			return anim, err
+		}
+		key.In, key.Out = key.Value, key.Value
+		if k.In != nil {
+			if key.In, err = value(*k.In); err != nil {
+				return anim, err
+			}
+		}
+		if k.Out != nil {
+			if key.Out, err = value(*k.Out); err != nil {
+				return anim, err
+			}
+		}
+		anim.Track.Keys = append(anim.Track.Keys, key)
+	}
+
+	return anim, nil
+}
+
+func fromAnimation(a core.Animation) Animation {
+	doc := Animation{
+		Target:   a.Target,
+		Index:    a.Index,
+		Property: a.Property,
+	}
+	if a.Track.Interpolation != core.InterpolateLinear {
+		doc.Interpolation = a.Track.Interpolation
+	}
+
+	scalar := core.IsScalarProperty(a.Property)
+	for _, k := range a.Track.Keys {
+		key := Keyframe{Frame: k.Frame, Value: KeyValue{Vec: fromVec(k.Value), Scalar: scalar}}
+		if k.In != k.Value {
+			key.In = &KeyValue{Vec: fromVec(k.In), Scalar: scalar}
+		}
+		if k.Out != k.Value {
+			key.Out = &KeyValue{Vec: fromVec(k.Out), Scalar: scalar}
+		}
+		doc.Keys = append(doc.Keys, key)
+	}
+
+	return doc
+}
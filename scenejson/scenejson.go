@@ -0,0 +1,719 @@
+// Package scenejson reads and writes scenes as JSON documents that map
+// one-to-one onto core.Scene:
+//
+//	{
+//	  "background": [0.1, 0.3, 0.3],
+//	  "ambient": [0.1, 0.1, 0.1],
+//	  "camera": [0, 5, -5],
+//	  "target": [0, 0, 1],
+//	  "render": {"width": 1366, "height": 768, "fov": 90, "spp": 4, "maxdepth": 3,
+//	             "reflectiondepth": 2, "terminal": "black", "mincontribution": 0.001, "output": "image.ppm"},
+//	  "lights": [
+//	    {"pos": [0, 30, -10], "diffuse": [0.8, 0.8, 0.8], "specular": [0.8, 0.8, 0.8]}
+//	  ],
+//	  "materials": {
+//	    "gold": {"ambient": [0.25, 0.2, 0.07], "diffuse": [0.75, 0.6, 0.22],
+//	             "specular": [0.63, 0.56, 0.37], "reflection": [0.2, 0.2, 0.2],
+//	             "shininess": 51.2, "ior": 1, "transparency": 0}
+//	  },
+//	  "primitives": [
+//	    {"type": "sphere", "center": [0, 0, 25], "radius": 25, "material": "gold"},
+//	    {"type": "plane", "point": [0, -50, 75], "normal": [0, 1, 0], "width": 250, "material": "glass"},
+//	    {"type": "triangle", "v0": [0, 0, 0], "v1": [1, 0, 0], "v2": [0, 1, 0], "material": "red"},
+//	    {"type": "box", "min": [-1, 0, -1], "max": [1, 2, 1], "rotate": [0, 45, 0]},
+//	    {"type": "cylinder", "base": [0, 0, 0], "top": [0, 3, 0], "radius": 1, "open": true},
+//	    {"type": "cone", "base": [0, 0, 0], "top": [0, 3, 0], "radius": 1, "topradius": 0.5},
+//	    {"type": "disk", "center": [0, 0, 0], "normal": [0, 1, 0], "radius": 2, "inner": 1},
+//	    {"type": "torus", "center": [0, 0, 0], "axis": [0, 1, 0], "major": 2, "minor": 0.5},
+//	    {"type": "difference", "parts": [
+//	      {"type": "sphere", "center": [0, 0, 0], "radius": 1},
+//	      {"type": "box", "min": [0, 0, -2], "max": [2, 2, 2]}
+//	    ]},
+//	    {"type": "sdf", "min": [-1, 0, -1], "max": [1, 2, 1], "material": "red",
+//	     "shape": {"type": "union", "smooth": 0.5, "parts": [
+//	       {"type": "sphere", "center": [0, 1.5, 0], "radius": 0.5},
+//	       {"type": "twist", "angle": 45, "shape": {"type": "box", "center": [0, 0.5, 0], "size": [1, 1, 1]}}
+//	     ]}},
+//	    {"type": "quadric", "squares": [1, -1, 1], "mixed": [0, 0, 0], "linear": [0, 0, 0], "constant": -1,
+//	     "min": [-2, -1, -2], "max": [2, 1, 2]},
+//	    {"type": "implicit", "equation": "x^4 + y^4 + z^4 - 1", "min": [-1, -1, -1], "max": [1, 1, 1]},
+//	    {"type": "heightfield", "file": "terrain.png", "origin": [-50, 0, -50], "scale": [0.1, 8, 0.1]},
+//	    {"type": "mesh", "file": "teapot.obj", "material": "red",
+//	     "translate": [0, 0, 0], "rotate": [0, 45, 0], "scale": [1, 1, 1]}
+//	  ],
+//	  "animations": [
+//	    {"target": "camera", "property": "pos", "interpolation": "catmullrom",
+//	     "keys": [{"frame": 1, "value": [0, 5, -5]}, {"frame": 48, "value": [5, 5, -5]}]},
+//	    {"target": "primitive", "index": 0, "property": "radius",
+//	     "keys": [{"frame": 1, "value": 25, "out": 30}, {"frame": 24, "value": 20}]}
+//	  ]
+//	}
+//
+// The CSG types union, intersection and difference combine their "parts", two
+// or more solids (spheres, boxes, closed cylinders and cones, tori and CSG),
+// from the first one on. The "shape" of an sdf is a tree of the SDF shapes
+// and operators of the scene language with the same keys; the operators
+// take their operands in "parts" or "shape". Ellipsoids, paraboloids and
+// hyperboloids are written as quadrics by their coefficients.
+// Vectors and colors are arrays of three numbers. Materials are referenced by
+// name and are either defined in "materials" or built in (red, ivory, glass).
+// Animations refer to a light or a primitive by its index in "lights" or
+// "primitives"; the interpolation is linear, bezier or catmullrom.
+// All keys are optional except "type"; unknown keys are errors. Mesh and
+// height map files are resolved relative to the directory of the JSON file.
+package scenejson
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/danradchuk/raytracer/core"
+	"github.com/danradchuk/raytracer/geometry"
+	"github.com/danradchuk/raytracer/shading"
+)
+
+// Scene is the JSON document of a scene.
+type Scene struct {
+	Background Vec3                `json:"background"`
+	Ambient    Vec3                `json:"ambient"`
+	Camera     Vec3                `json:"camera"`
+	Target     *Vec3               `json:"target,omitempty"`
+	Render     *Render             `json:"render,omitempty"`
+	Lights     []Light             `json:"lights,omitempty"`
+	Materials  map[string]Material `json:"materials,omitempty"`
+	Primitives []Primitive         `json:"primitives,omitempty"`
+	Animations []Animation         `json:"animations,omitempty"`
+}
+
+// Render holds the render settings; omitted values use the defaults, and so
+// do zero values apart from the depths and frames, where 0 is a value.
+type Render struct {
+	Width           int     `json:"width,omitempty"`
+	Height          int     `json:"height,omitempty"`
+	Fov             int     `json:"fov,omitempty"`
+	SPP             int     `json:"spp,omitempty"`
+	MaxDepth        int     `json:"maxdepth,omitempty"`
+	ReflectionDepth *int    `json:"reflectiondepth,omitempty"`
+	RefractionDepth *int    `json:"refractiondepth,omitempty"`
+	DiffuseDepth    *int    `json:"diffusedepth,omitempty"`
+	Terminal        string  `json:"terminal,omitempty"`
+	MinContribution float64 `json:"mincontribution,omitempty"`
+	Workers         int     `json:"workers,omitempty"`
+	TileOrder       string  `json:"tileorder,omitempty"`
+	Frames          int     `json:"frames,omitempty"`
+	OrbitRadius     float64 `json:"radius,omitempty"`
+	FrameDelay      int     `json:"delay,omitempty"`
+	Palette         string  `json:"palette,omitempty"`
+	Dither          string  `json:"dither,omitempty"`
+	FirstFrame      *int    `json:"firstframe,omitempty"`
+	LastFrame       *int    `json:"lastframe,omitempty"`
+	ShutterOpen     float64 `json:"shutteropen,omitempty"`
+	ShutterClose    float64 `json:"shutterclose,omitempty"`
+	Output          string  `json:"output,omitempty"`
+}
+
+// Vec3 is a vector or a color.
+type Vec3 [3]float64
+
+// UnmarshalJSON requires exactly three numbers.
+func (v *Vec3) UnmarshalJSON(data []byte) error {
+	var components []float64
+	if err := json.Unmarshal(data, &components); err != nil {
+		return err
+	}
+	if len(components) != 3 {
+		return fmt.Errorf("expected 3 components, got %d", len(components))
+	}
+	copy(v[:], components)
+	return nil
+}
+
+// Light is a point light.
+type Light struct {
+	Pos      Vec3 `json:"pos"`
+	Diffuse  Vec3 `json:"diffuse"`
+	Specular Vec3 `json:"specular"`
+}
+
+// Material is a named material of the "materials" object.
+type Material struct {
+	Ambient      Vec3    `json:"ambient"`
+	Diffuse      Vec3    `json:"diffuse"`
+	Specular     Vec3    `json:"specular"`
+	Reflection   Vec3    `json:"reflection"`
+	Shininess    float64 `json:"shininess"`
+	IOR          float64 `json:"ior"`
+	Transparency float64 `json:"transparency,omitempty"`
+}
+
+// Primitive is one of sphere, plane, triangle, box, cylinder, cone, disk,
+// torus, union, intersection, difference, sdf, quadric, implicit,
+// heightfield or mesh, selected by Type. Only the
+// fields of that type may be set.
+type Primitive struct {
+	Type     string `json:"type"`
+	Material string `json:"material,omitempty"`
+
+	// sphere
+	Center *Vec3    `json:"center,omitempty"`
+	Radius *float64 `json:"radius,omitempty"`
+
+	// plane
+	Point  *Vec3    `json:"point,omitempty"`
+	Normal *Vec3    `json:"normal,omitempty"`
+	Width  *float64 `json:"width,omitempty"`
+
+	// triangle
+	V0 *Vec3 `json:"v0,omitempty"`
+	V1 *Vec3 `json:"v1,omitempty"`
+	V2 *Vec3 `json:"v2,omitempty"`
+
+	// box
+	Min *Vec3 `json:"min,omitempty"`
+	Max *Vec3 `json:"max,omitempty"`
+
+	// cylinder and cone
+	Base      *Vec3    `json:"base,omitempty"`
+	Top       *Vec3    `json:"top,omitempty"`
+	TopRadius *float64 `json:"topradius,omitempty"`
+	Open      bool     `json:"open,omitempty"`
+
+	// disk
+	Inner *float64 `json:"inner,omitempty"`
+
+	// torus
+	Axis  *Vec3    `json:"axis,omitempty"`
+	Major *float64 `json:"major,omitempty"`
+	Minor *float64 `json:"minor,omitempty"`
+
+	// mesh
+	File      string `json:"file,omitempty"`
+	Translate *Vec3  `json:"translate,omitempty"`
+	Rotate    *Vec3  `json:"rotate,omitempty"`
+	Scale     *Vec3  `json:"scale,omitempty"`
+
+	// union, intersection and difference
+	Parts []Primitive `json:"parts,omitempty"`
+
+	// sdf, with the bounds min and max
+	Shape *Shape `json:"shape,omitempty"`
+
+	// heightfield, with file and scale
+	Origin *Vec3 `json:"origin,omitempty"`
+
+	// quadric, with the bounds min and max
+	Squares  *Vec3    `json:"squares,omitempty"`
+	Mixed    *Vec3    `json:"mixed,omitempty"`
+	Linear   *Vec3    `json:"linear,omitempty"`
+	Constant *float64 `json:"constant,omitempty"`
+
+	// implicit, with the bounds min and max
+	Equation string `json:"equation,omitempty"`
+}
+
+// ReadFile reads the scene of a JSON file.
+func ReadFile(path string) (*core.Scene, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	s, err := Decode(f, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// Decode reads a JSON scene. Mesh files are resolved relative to dir.
+func Decode(r io.Reader, dir string) (*core.Scene, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	var doc Scene
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	return doc.Scene(dir)
+}
+
+// Scene converts the document into a core.Scene.
+func (doc *Scene) Scene(dir string) (*core.Scene, error) {
+	s := &core.Scene{
+		Background:       doc.Background.color(),
+		AmbientIntensity: doc.Ambient.color(),
+		Camera:           doc.Camera.vec(),
+	}
+	if doc.Target != nil {
+		t := doc.Target.vec()
+		s.Target = &t
+	}
+
+	if doc.Render != nil {
+		s.Settings = core.RenderSettings(*doc.Render)
+		if err := s.Settings.Validate(); err != nil {
+			return nil, fmt.Errorf("render: %v", err)
+		}
+	}
+
+	for _, l := range doc.Lights {
+		s.Lights = append(s.Lights, &core.Light{
+			Pos:               l.Pos.vec(),
+			DiffuseIntensity:  l.Diffuse.color(),
+			SpecularIntensity: l.Specular.color(),
+		})
+	}
+
+	for i, p := range doc.Primitives {
+		prim, err := doc.primitive(p, dir)
+		if err != nil {
+			return nil, fmt.Errorf("primitives[%d]: %w", i, err)
+		}
+		s.Primitives = append(s.Primitives, prim)
+	}
+
+	for i, a := range doc.Animations {
+		anim, err := a.animation()
+		if err != nil {
+			return nil, fmt.Errorf("animations[%d]: %w", i, err)
+		}
+		s.Animations = append(s.Animations, anim)
+	}
+	if err := s.CheckAnimations(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (doc *Scene) primitive(p Primitive, dir string) (geometry.Primitive, error) {
+	allowed := map[string][]string{
+		"sphere":      {"center", "radius"},
+		"plane":       {"point", "normal", "width"},
+		"triangle":    {"v0", "v1", "v2"},
+		"box":         {"min", "max", "rotate"},
+		"cylinder":    {"base", "top", "radius", "open"},
+		"cone":        {"base", "top", "radius", "topradius", "open"},
+		"disk":        {"center", "normal", "radius", "inner"},
+		"torus":       {"center", "axis", "major", "minor"},
+		"mesh":        {"file", "translate", "rotate", "scale"},
+		"sdf":         {"min", "max", "shape"},
+		"heightfield": {"file", "origin", "scale"},
+		"quadric":     {"squares", "mixed", "linear", "constant", "min", "max"},
+		"implicit":    {"equation", "min", "max"},
+	}
+	for _, op := range geometry.CSGOps {
+		allowed[op] = []string{"parts"}
+	}
+	fields, ok := allowed[p.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown type %q", p.Type)
+	}
+	if err := checkFields(p.Type, p, fields); err != nil {
+		return nil, err
+	}
+
+	if slices.Contains(geometry.CSGOps, p.Type) {
+		if p.Material != "" {
+			return nil, fmt.Errorf("%s: unexpected key \"material\"", p.Type)
+		}
+		return doc.csg(p, dir)
+	}
+
+	var material shading.Material
+	if p.Material != "" {
+		if m, ok := doc.Materials[p.Material]; ok {
+			material = m.material(p.Material)
+		} else if m := shading.Builtin(p.Material); m != nil {
+			material = *m
+		} else {
+			return nil, fmt.Errorf("unknown material %q", p.Material)
+		}
+	}
+
+	switch p.Type {
+	case "sphere":
+		return geometry.Sphere{Center: p.Center.vec(), R: float(p.Radius), Material: material}, nil
+	case "plane":
+		return geometry.Plane{Point: p.Point.vec(), Normal: p.Normal.vec(), Width: float(p.Width), Material: material}, nil
+	case "triangle":
+		return &geometry.Triangle{V0: p.V0.vec(), V1: p.V1.vec(), V2: p.V2.vec(), Material: material}, nil
+	case "box":
+		return geometry.Box{Min: p.Min.vec(), Max: p.Max.vec(), Rotate: p.Rotate.vec(), Material: material}, nil
+	case "cylinder":
+		return geometry.Cylinder{Base: p.Base.vec(), Top: p.Top.vec(), Radius: float(p.Radius), Open: p.Open, Material: material}, nil
+	case "cone":
+		return geometry.Cone{Base: p.Base.vec(), Top: p.Top.vec(), Radius: float(p.Radius), TopRadius: float(p.TopRadius),
+			Open: p.Open, Material: material}, nil
+	case "disk":
+		return geometry.Disk{Center: p.Center.vec(), Normal: p.Normal.vec(), Radius: float(p.Radius), Inner: float(p.Inner),
+			Material: material}, nil
+	case "torus":
+		return geometry.Torus{Center: p.Center.vec(), Axis: p.Axis.vec(), Major: float(p.Major), Minor: float(p.Minor),
+			Material: material}, nil
+	case "sdf":
+		prim, err := p.sdf()
+		if err != nil {
+			return nil, err
+		}
+		prim.Material = material
+		return prim, nil
+	case "quadric":
+		q := geometry.Quadric{Squares: p.Squares.vec(), Mixed: p.Mixed.vec(), Linear: p.Linear.vec(),
+			Constant: float(p.Constant), Min: p.Min.vec(), Max: p.Max.vec(), Material: material}
+		if !below(q.Min, q.Max) {
+			return nil, fmt.Errorf("quadric: expected the bounds min below max")
+		}
+		return q, nil
+	case "implicit":
+		return p.implicit(material)
+	}
+
+	if p.File == "" {
+		return nil, fmt.Errorf("%s: missing file", p.Type)
+	}
+	path := p.File
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	if p.Type == "heightfield" {
+		scale := geometry.Vec3{X: 1, Y: 1, Z: 1}
+		if p.Scale != nil {
+			scale = p.Scale.vec()
+		}
+		data, err := geometry.LoadHeightMap(path)
+		if err != nil {
+			return nil, fmt.Errorf("heightfield: %w", err)
+		}
+		return geometry.NewHeightfield(p.File, data, p.Origin.vec(), scale, material), nil
+	}
+
+	transform := geometry.IdentityTransform()
+	transform.Translate = p.Translate.vec()
+	transform.Rotate = p.Rotate.vec()
+	if p.Scale != nil {
+		transform.Scale = p.Scale.vec()
+	}
+
+	var override *shading.Material
+	if p.Material != "" {
+		override = &material
+	}
+
+	data, err := geometry.LoadMesh(path)
+	if err != nil {
+		return nil, fmt.Errorf("mesh: %w", err)
+	}
+
+	return geometry.NewMesh(p.File, data, transform, override), nil
+}
+
+// implicit parses the equation of an implicit surface.
+func (p Primitive) implicit(material shading.Material) (*geometry.Implicit, error) {
+	if p.Equation == "" {
+		return nil, fmt.Errorf("implicit: missing equation")
+	}
+	equation, err := geometry.ParsePolynomial(p.Equation)
+	if err != nil {
+		return nil, fmt.Errorf("implicit: %w", err)
+	}
+	if equation.Degree() == 0 {
+		return nil, fmt.Errorf("implicit: expected an equation in x, y or z")
+	}
+	prim := &geometry.Implicit{Equation: equation, Min: p.Min.vec(), Max: p.Max.vec(), Material: material}
+	if !below(prim.Min, prim.Max) {
+		return nil, fmt.Errorf("implicit: expected the bounds min below max")
+	}
+	return prim, nil
+}
+
+// below reports whether the box from lo to hi has a volume.
+func below(lo, hi geometry.Vec3) bool {
+	return lo.X < hi.X && lo.Y < hi.Y && lo.Z < hi.Z
+}
+
+// csg combines the parts of a CSG primitive.
+func (doc *Scene) csg(p Primitive, dir string) (geometry.Primitive, error) {
+	if len(p.Parts) < 2 {
+		return nil, fmt.Errorf("%s: expected at least two parts, found %d", p.Type, len(p.Parts))
+	}
+
+	var solid geometry.Solid
+	for i, part := range p.Parts {
+		prim, err := doc.primitive(part, dir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: parts[%d]: %w", p.Type, i, err)
+		}
+		s, ok := prim.(geometry.Solid)
+		if !ok || part.Open {
+			return nil, fmt.Errorf("%s: parts[%d]: %s has no inside", p.Type, i, part.Type)
+		}
+
+		if i == 0 {
+			solid = s
+		} else if solid, err = geometry.NewCSG(geometry.CSGOp(p.Type), solid, s); err != nil {
+			return nil, err
+		}
+	}
+	return solid, nil
+}
+
+// checkFields reports fields of the primitive or shape p that are set but
+// don't belong to its type.
+func checkFields(typ string, p any, allowed []string) error {
+	v := reflect.ValueOf(p)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "type" || name == "material" || v.Field(i).IsZero() {
+			continue
+		}
+
+		ok := false
+		for _, a := range allowed {
+			ok = ok || a == name
+		}
+		if !ok {
+			return fmt.Errorf("%s: unexpected key %q", typ, name)
+		}
+	}
+	return nil
+}
+
+// Encode writes a scene as an indented JSON document.
+func Encode(w io.Writer, s *core.Scene) error {
+	doc, err := NewScene(s)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// keep vectors on one line
+	data = vectorPattern.ReplaceAll(data, []byte("[$1, $2, $3]"))
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+var vectorPattern = regexp.MustCompile(`\[\s*([-+.\deE]+),\s*([-+.\deE]+),\s*([-+.\deE]+)\s*\]`)
+
+// NewScene converts a core.Scene into its JSON document. Materials that are
+// not built in are added to Materials under their names, made unique if needed.
+func NewScene(s *core.Scene) (*Scene, error) {
+	doc := &Scene{
+		Background: fromColor(s.Background),
+		Ambient:    fromColor(s.AmbientIntensity),
+		Camera:     fromVec(s.Camera),
+	}
+	if s.Target != nil {
+		t := fromVec(*s.Target)
+		doc.Target = &t
+	}
+
+	if s.Settings != (core.RenderSettings{}) {
+		r := Render(s.Settings)
+		doc.Render = &r
+	}
+
+	for _, l := range s.Lights {
+		doc.Lights = append(doc.Lights, Light{
+			Pos:      fromVec(l.Pos),
+			Diffuse:  fromColor(l.DiffuseIntensity),
+			Specular: fromColor(l.SpecularIntensity),
+		})
+	}
+
+	names := materialNames{doc: doc}
+	for _, prim := range s.Primitives {
+		p, err := names.primitive(prim)
+		if err != nil {
+			return nil, err
+		}
+		doc.Primitives = append(doc.Primitives, p)
+	}
+
+	for _, a := range s.Animations {
+		doc.Animations = append(doc.Animations, fromAnimation(a))
+	}
+
+	return doc, nil
+}
+
+// materialNames assigns names to the materials of a scene.
+type materialNames struct {
+	doc   *Scene
+	names []string
+	mats  []shading.Material
+}
+
+// primitive converts a primitive into its JSON object.
+func (mn *materialNames) primitive(prim geometry.Primitive) (Primitive, error) {
+	var p Primitive
+	switch prim := prim.(type) {
+	case geometry.Sphere:
+		c, r := fromVec(prim.Center), prim.R
+		p = Primitive{Type: "sphere", Center: &c, Radius: &r, Material: mn.name(prim.Material)}
+	case geometry.Plane:
+		pt, n, w := fromVec(prim.Point), fromVec(prim.Normal), prim.Width
+		p = Primitive{Type: "plane", Point: &pt, Normal: &n, Width: &w, Material: mn.name(prim.Material)}
+	case *geometry.Triangle:
+		v0, v1, v2 := fromVec(prim.V0), fromVec(prim.V1), fromVec(prim.V2)
+		p = Primitive{Type: "triangle", V0: &v0, V1: &v1, V2: &v2, Material: mn.name(prim.Material)}
+	case geometry.Box:
+		lo, hi := fromVec(prim.Min), fromVec(prim.Max)
+		p = Primitive{Type: "box", Min: &lo, Max: &hi, Material: mn.name(prim.Material)}
+		if prim.Rotate != (geometry.Vec3{}) {
+			r := fromVec(prim.Rotate)
+			p.Rotate = &r
+		}
+	case geometry.Cylinder:
+		b, t, r := fromVec(prim.Base), fromVec(prim.Top), prim.Radius
+		p = Primitive{Type: "cylinder", Base: &b, Top: &t, Radius: &r, Open: prim.Open, Material: mn.name(prim.Material)}
+	case geometry.Cone:
+		b, t, r, tr := fromVec(prim.Base), fromVec(prim.Top), prim.Radius, prim.TopRadius
+		p = Primitive{Type: "cone", Base: &b, Top: &t, Radius: &r, TopRadius: &tr, Open: prim.Open,
+			Material: mn.name(prim.Material)}
+	case geometry.Disk:
+		c, n, r, in := fromVec(prim.Center), fromVec(prim.Normal), prim.Radius, prim.Inner
+		p = Primitive{Type: "disk", Center: &c, Normal: &n, Radius: &r, Inner: &in, Material: mn.name(prim.Material)}
+	case geometry.Torus:
+		c, a, major, minor := fromVec(prim.Center), fromVec(prim.Axis), prim.Major, prim.Minor
+		p = Primitive{Type: "torus", Center: &c, Axis: &a, Major: &major, Minor: &minor, Material: mn.name(prim.Material)}
+	case *geometry.CSG:
+		p = Primitive{Type: string(prim.Op)}
+		for _, part := range prim.Parts() {
+			pp, err := mn.primitive(part)
+			if err != nil {
+				return Primitive{}, err
+			}
+			p.Parts = append(p.Parts, pp)
+		}
+	case *geometry.SDFPrimitive:
+		lo, hi := fromVec(prim.Min), fromVec(prim.Max)
+		shape, err := fromSDF(prim.Shape)
+		if err != nil {
+			return Primitive{}, err
+		}
+		p = Primitive{Type: "sdf", Min: &lo, Max: &hi, Shape: &shape, Material: mn.name(prim.Material)}
+	case geometry.Quadric:
+		sq, mx, ln, c := fromVec(prim.Squares), fromVec(prim.Mixed), fromVec(prim.Linear), prim.Constant
+		lo, hi := fromVec(prim.Min), fromVec(prim.Max)
+		p = Primitive{Type: "quadric", Squares: &sq, Mixed: &mx, Linear: &ln, Constant: &c, Min: &lo, Max: &hi,
+			Material: mn.name(prim.Material)}
+	case *geometry.Implicit:
+		lo, hi := fromVec(prim.Min), fromVec(prim.Max)
+		p = Primitive{Type: "implicit", Equation: prim.Equation.String(), Min: &lo, Max: &hi, Material: mn.name(prim.Material)}
+	case *geometry.Heightfield:
+		o, sc := fromVec(prim.Origin), fromVec(prim.Scale)
+		p = Primitive{Type: "heightfield", File: prim.File, Origin: &o, Scale: &sc, Material: mn.name(prim.Material)}
+	case *geometry.Mesh:
+		t, r, sc := fromVec(prim.Transform.Translate), fromVec(prim.Transform.Rotate), fromVec(prim.Transform.Scale)
+		p = Primitive{Type: "mesh", File: prim.File, Translate: &t, Rotate: &r, Scale: &sc}
+		if prim.Material != nil {
+			p.Material = mn.name(*prim.Material)
+		}
+	default:
+		return Primitive{}, fmt.Errorf("scenejson: cannot write primitive of type %T", prim)
+	}
+	return p, nil
+}
+
+func (mn *materialNames) name(m shading.Material) string {
+	if reflect.DeepEqual(m, shading.Material{}) {
+		return ""
+	}
+	for i, other := range mn.mats {
+		if reflect.DeepEqual(m, other) {
+			return mn.names[i]
+		}
+	}
+
+	name := m.Name
+	if b := shading.Builtin(name); b == nil || !reflect.DeepEqual(m, *b) {
+		if _, taken := mn.doc.Materials[name]; taken || name == "" {
+			base := name
+			if base == "" {
+				base = "material"
+			}
+			for i := 1; ; i++ {
+				name = base + strconv.Itoa(i)
+				if _, taken := mn.doc.Materials[name]; !taken && shading.Builtin(name) == nil {
+					break
+				}
+			}
+		}
+
+		if mn.doc.Materials == nil {
+			mn.doc.Materials = make(map[string]Material)
+		}
+		mn.doc.Materials[name] = Material{
+			Ambient:      fromColor(m.KAmbient),
+			Diffuse:      fromColor(m.KDiffuse),
+			Specular:     fromColor(m.KSpecular),
+			Reflection:   fromColor(m.KReflection),
+			Shininess:    m.Alpha,
+			IOR:          m.IOR,
+			Transparency: m.Transparency,
+		}
+	}
+
+	mn.names = append(mn.names, name)
+	mn.mats = append(mn.mats, m)
+
+	return name
+}
+
+func (m Material) material(name string) shading.Material {
+	return shading.Material{
+		Name:         name,
+		KAmbient:     m.Ambient.color(),
+		KDiffuse:     m.Diffuse.color(),
+		KSpecular:    m.Specular.color(),
+		KReflection:  m.Reflection.color(),
+		Alpha:        m.Shininess,
+		IOR:          m.IOR,
+		Transparency: m.Transparency,
+	}
+}
+
+func (v *Vec3) vec() geometry.Vec3 {
+	if v == nil {
+		return geometry.Vec3{}
+	}
+	return geometry.Vec3{X: v[0], Y: v[1], Z: v[2]}
+}
+
+func (v *Vec3) color() shading.Color {
+	return shading.Color{R: v[0], G: v[1], B: v[2]}
+}
+
+func fromVec(v geometry.Vec3) Vec3 {
+	return Vec3{v.X, v.Y, v.Z}
+}
+
+func fromColor(c shading.Color) Vec3 {
+	return Vec3{c.R, c.G, c.B}
+}
+
+func float(f *float64) float64 {
+	if f == nil {
+		return 0
+	}
+	return *f
+}
@@ -0,0 +1,150 @@
+package scenejson
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danradchuk/raytracer/dsl"
+	"github.com/danradchuk/raytracer/geometry"
+)
+
+func TestRoundTrip(t *testing.T) {
+	src := "background #194D4D\n" +
+		"camera 0, 5, -5\n" +
+		"render { width 320  height 240  maxdepth 5  diffusedepth 1  terminal background }\n" +
+		"light { pos 0, 30, -10  diffuse 0.8, 0.8, 0.8 }\n" +
+		"material gold { diffuse 0.75, 0.6, 0.22  shininess 51.2 }\n" +
+		"sphere { radius 2  center 0, 1, 2  material gold\n" +
+		"    animate radius bezier { key 1 2 out 3  key 10 1 }\n" +
+		"    animate center { key 1 0, 1, 2  key 10 0, 5, 2 }\n" +
+		"}\n" +
+		"camera { pos 0, 5, -5  target 0, 0, 2  animate target catmullrom { key 1 0, 0, 2  key 5 1, 0, 2  key 9 0, 0, 2 } }\n" +
+		"plane { width 10  point 0, 0, 0  normal 0, 1, 0  material glass }\n" +
+		"triangle { v0 0, 0, 0  v1 1, 0, 0  v2 0, 1, 0 }\n" +
+		"box { min -1, 0, -1  max 1, 2, 1  rotate 0, 45, 0  material gold }\n" +
+		"cylinder { base 0, 0, 0  top 0, 3, 0  radius 1  open }\n" +
+		"cone { base 0, 0, 0  top 0, 3, 0  radius 1  topradius 0.5 }\n" +
+		"disk { center 0, 0, 0  normal 0, 1, 0  radius 2  inner 1 }\n" +
+		"torus { center 0, 1, 0  axis 0, 1, 0  major 2  minor 0.5  material glass }\n" +
+		"difference {\n" +
+		"    box { max 2, 2, 2  material gold }\n" +
+		"    intersection { sphere { radius 1 }  cylinder { top 0, 3, 0  radius 0.5 } }\n" +
+		"    torus { axis 0, 1, 0  major 1  minor 0.25 }\n" +
+		"}\n" +
+		"sdf { min -2, 0, -2  max 2, 3, 2  material gold\n" +
+		"    union { smooth 0.5\n" +
+		"        box { size 1, 1, 1  round 0.1 }\n" +
+		"        translate { offset 0, 1, 0  twist { angle 30  mandelbulb { power 6 } } }\n" +
+		"        repeat { period 1, 0, 0  difference { torus { major 1  minor 0.2 }  cylinder { radius 1  height 2 } } }\n" +
+		"        sphere { radius 0.5 }\n" +
+		"    }\n" +
+		"}\n" +
+		"quadric { squares 1, 0, 1  mixed 0, 0.5, 0  linear 0, -1, 0  constant -2  min -1, -1, -1  max 1, 1, 1 }\n" +
+		"hyperboloid { center 0, 1, 0  radii 1, 2, 1  height 3  sheets 2  material gold }\n" +
+		"implicit { equation \"(x^2 + y^2 + z^2)^2 - 0.1*x*y*z + 1e-3\"  min -1, -1, -1  max 1, 1, 1  material glass }\n"
+
+	want, err := dsl.NewParser(src).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Decode(&buf, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode(Encode(s)) = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	doc := `{
+		"camera": [0, 0, -20],
+		"materials": {"shiny": {"diffuse": [1, 0, 0], "shininess": 10, "ior": 1}},
+		"primitives": [
+			{"type": "sphere", "center": [1, 2, 3], "radius": 4, "material": "shiny"},
+			{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "ivory"}
+		]
+	}`
+
+	s, err := Decode(strings.NewReader(doc), ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sphere := s.Primitives[0].(geometry.Sphere)
+	if sphere.Center != (geometry.Vec3{X: 1, Y: 2, Z: 3}) || sphere.R != 4 {
+		t.Errorf("unexpected sphere %+v", sphere)
+	}
+	if sphere.Material.Name != "shiny" || sphere.Material.KDiffuse.R != 1 {
+		t.Errorf("unexpected material %+v", sphere.Material)
+	}
+	if s.Primitives[1].(geometry.Sphere).Material.Name != "ivory" {
+		t.Errorf("expected the built-in ivory material")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := map[string]string{
+		`{"camra": [0, 0, 0]}`:               `unknown field "camra"`,
+		`{"camera": [0, 0]}`:                 "expected 3 components, got 2",
+		`{"primitives": [{"type": "cube"}]}`: `primitives[0]: unknown type "cube"`,
+		`{"primitives": [{"type": "sphere", "radius": 1, "v0": [0, 0, 0]}]}`:                                         `primitives[0]: sphere: unexpected key "v0"`,
+		`{"primitives": [{"type": "sphere", "material": "gold"}]}`:                                                   `primitives[0]: unknown material "gold"`,
+		`{"primitives": [{"type": "union", "parts": [{"type": "sphere"}]}]}`:                                         `primitives[0]: union: expected at least two parts, found 1`,
+		`{"primitives": [{"type": "union", "parts": [{"type": "sphere"}, {"type": "plane"}]}]}`:                      `primitives[0]: union: parts[1]: plane has no inside`,
+		`{"primitives": [{"type": "sdf", "min": [0, 0, 0], "max": [1, 1, 1]}]}`:                                      `primitives[0]: sdf: missing shape`,
+		`{"primitives": [{"type": "sdf", "max": [1, 1, 1], "shape": {"type": "twist", "shape": {"type": "cube"}}}]}`: `primitives[0]: sdf: shape: twist: shape: unknown shape "cube"`,
+		`{"primitives": [{"type": "sdf", "max": [1, 1, 1], "shape": {"type": "box", "major": 1}}]}`:                  `primitives[0]: sdf: shape: box: unexpected key "major"`,
+		`{"primitives": [{"type": "quadric", "squares": [1, 1, 1], "constant": -1}]}`:                                `primitives[0]: quadric: expected the bounds min below max`,
+		`{"primitives": [{"type": "implicit", "max": [1, 1, 1]}]}`:                                                   `primitives[0]: implicit: missing equation`,
+		`{"primitives": [{"type": "implicit", "equation": "x^2 -", "max": [1, 1, 1]}]}`:                              `primitives[0]: implicit: equation: column 6: unexpected end`,
+		`{"primitives": [{"type": "implicit", "equation": "x", "radius": 1}]}`:                                       `primitives[0]: implicit: unexpected key "radius"`,
+		`{"render": {"firstframe": 10, "lastframe": 5}}`:                                                             `lastframe 5 is before firstframe 10`,
+	}
+
+	for doc, want := range tests {
+		_, err := Decode(strings.NewReader(doc), ".")
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Decode(%s): got error %v, want %q", doc, err, want)
+		}
+	}
+}
+
+func TestHeightfieldRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hills.pgm"), []byte("P5 2 2 255\n\x00\x80\xff\x00"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	doc := `{"primitives": [{"type": "heightfield", "file": "hills.pgm", "origin": [0, -1, 0], "material": "red"}]}`
+
+	s, err := Decode(strings.NewReader(doc), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.Primitives[0].(*geometry.Heightfield)
+	if h.Scale != (geometry.Vec3{X: 1, Y: 1, Z: 1}) || h.Origin != (geometry.Vec3{Y: -1}) || h.Material.Name != "red" {
+		t.Errorf("unexpected heightfield %+v", h)
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, s); err != nil {
+		t.Fatal(err)
+	}
+	again, err := Decode(&buf, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, s) {
+		t.Errorf("Decode(Encode(s)) = %+v, want %+v", again, s)
+	}
+}
@@ -0,0 +1,190 @@
+package scenejson
+
+import (
+	"fmt"
+
+	"github.com/danradchuk/raytracer/geometry"
+)
+
+// Shape is a node of the distance function of an "sdf" primitive: a shape
+// or an operator on the shapes of "parts" (union, intersection and
+// difference) or on a single "shape" (twist, translate and repeat).
+type Shape struct {
+	Type   string   `json:"type"`
+	Center *Vec3    `json:"center,omitempty"`
+	Radius *float64 `json:"radius,omitempty"`
+
+	// box
+	Size  *Vec3    `json:"size,omitempty"`
+	Round *float64 `json:"round,omitempty"`
+
+	// torus and cylinder
+	Major  *float64 `json:"major,omitempty"`
+	Minor  *float64 `json:"minor,omitempty"`
+	Height *float64 `json:"height,omitempty"`
+
+	// mandelbulb, by default of scale 1, power 8 and 8 iterations
+	Scale      *float64 `json:"scale,omitempty"`
+	Power      *float64 `json:"power,omitempty"`
+	Iterations *int     `json:"iterations,omitempty"`
+
+	// operators
+	Smooth *float64 `json:"smooth,omitempty"`
+	Parts  []Shape  `json:"parts,omitempty"`
+	Angle  *float64 `json:"angle,omitempty"`
+	Offset *Vec3    `json:"offset,omitempty"`
+	Period *Vec3    `json:"period,omitempty"`
+	Shape  *Shape   `json:"shape,omitempty"`
+}
+
+// sdf converts the node and its operands.
+func (s Shape) sdf() (geometry.SDF, error) {
+	allowed := map[string][]string{
+		"sphere":     {"center", "radius"},
+		"box":        {"center", "size", "round"},
+		"torus":      {"center", "major", "minor"},
+		"cylinder":   {"center", "radius", "height"},
+		"mandelbulb": {"center", "scale", "power", "iterations"},
+		"twist":      {"angle", "shape"},
+		"translate":  {"offset", "shape"},
+		"repeat":     {"period", "shape"},
+	}
+	for _, op := range geometry.CSGOps {
+		allowed[op] = []string{"smooth", "parts"}
+	}
+	fields, ok := allowed[s.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown shape %q", s.Type)
+	}
+	if err := checkFields(s.Type, s, fields); err != nil {
+		return nil, err
+	}
+
+	switch s.Type {
+	case "sphere":
+		return geometry.SDFSphere{Center: s.Center.vec(), Radius: float(s.Radius)}, nil
+	case "box":
+		return geometry.SDFBox{Center: s.Center.vec(), Size: s.Size.vec(), Round: float(s.Round)}, nil
+	case "torus":
+		return geometry.SDFTorus{Center: s.Center.vec(), Major: float(s.Major), Minor: float(s.Minor)}, nil
+	case "cylinder":
+		return geometry.SDFCylinder{Center: s.Center.vec(), Radius: float(s.Radius), Height: float(s.Height)}, nil
+	case "mandelbulb":
+		bulb := geometry.SDFMandelbulb{Center: s.Center.vec(), Scale: 1, Power: 8, Iterations: 8}
+		if s.Scale != nil {
+			bulb.Scale = *s.Scale
+		}
+		if s.Power != nil {
+			bulb.Power = *s.Power
+		}
+		if s.Iterations != nil {
+			bulb.Iterations = *s.Iterations
+		}
+		return bulb, nil
+	case "twist", "translate", "repeat":
+		if s.Shape == nil {
+			return nil, fmt.Errorf("%s: missing shape", s.Type)
+		}
+		shape, err := s.Shape.sdf()
+		if err != nil {
+			return nil, fmt.Errorf("%s: shape: %w", s.Type, err)
+		}
+		switch s.Type {
+		case "twist":
+			return geometry.SDFTwist{Shape: shape, Angle: float(s.Angle)}, nil
+		case "translate":
+			return geometry.SDFTranslate{Shape: shape, Offset: s.Offset.vec()}, nil
+		}
+		return geometry.SDFRepeat{Shape: shape, Period: s.Period.vec()}, nil
+	}
+
+	if len(s.Parts) < 2 {
+		return nil, fmt.Errorf("%s: expected at least two parts, found %d", s.Type, len(s.Parts))
+	}
+	combine := geometry.SDFCombine{Op: geometry.CSGOp(s.Type), Smooth: float(s.Smooth)}
+	for i, part := range s.Parts {
+		shape, err := part.sdf()
+		if err != nil {
+			return nil, fmt.Errorf("%s: parts[%d]: %w", s.Type, i, err)
+		}
+		combine.Parts = append(combine.Parts, shape)
+	}
+	return combine, nil
+}
+
+// fromSDF converts an SDF shape and its operands into a node.
+func fromSDF(shape geometry.SDF) (Shape, error) {
+	switch s := shape.(type) {
+	case geometry.SDFSphere:
+		c, r := fromVec(s.Center), s.Radius
+		return Shape{Type: "sphere", Center: &c, Radius: &r}, nil
+	case geometry.SDFBox:
+		c, size := fromVec(s.Center), fromVec(s.Size)
+		node := Shape{Type: "box", Center: &c, Size: &size}
+		if s.Round != 0 {
+			node.Round = &s.Round
+		}
+		return node, nil
+	case geometry.SDFTorus:
+		c, major, minor := fromVec(s.Center), s.Major, s.Minor
+		return Shape{Type: "torus", Center: &c, Major: &major, Minor: &minor}, nil
+	case geometry.SDFCylinder:
+		c, r, h := fromVec(s.Center), s.Radius, s.Height
+		return Shape{Type: "cylinder", Center: &c, Radius: &r, Height: &h}, nil
+	case geometry.SDFMandelbulb:
+		c, scale, power, iterations := fromVec(s.Center), s.Scale, s.Power, s.Iterations
+		return Shape{Type: "mandelbulb", Center: &c, Scale: &scale, Power: &power, Iterations: &iterations}, nil
+	case geometry.SDFCombine:
+		node := Shape{Type: string(s.Op)}
+		if s.Smooth != 0 {
+			node.Smooth = &s.Smooth
+		}
+		for _, part := range s.Parts {
+			p, err := fromSDF(part)
+			if err != nil {
+				return Shape{}, err
+			}
+			node.Parts = append(node.Parts, p)
+		}
+		return node, nil
+	case geometry.SDFTwist:
+		node := Shape{Type: "twist", Angle: &s.Angle}
+		err := node.setShape(s.Shape)
+		return node, err
+	case geometry.SDFTranslate:
+		offset := fromVec(s.Offset)
+		node := Shape{Type: "translate", Offset: &offset}
+		err := node.setShape(s.Shape)
+		return node, err
+	case geometry.SDFRepeat:
+		period := fromVec(s.Period)
+		node := Shape{Type: "repeat", Period: &period}
+		err := node.setShape(s.Shape)
+		return node, err
+	}
+	return Shape{}, fmt.Errorf("scenejson: cannot write SDF shape of type %T", shape)
+}
+
+// setShape sets the operand of a twist, translate or repeat node.
+func (s *Shape) setShape(shape geometry.SDF) error {
+	node, err := fromSDF(shape)
+	s.Shape = &node
+	return err
+}
+
+// sdf converts an "sdf" primitive.
+func (p Primitive) sdf() (*geometry.SDFPrimitive, error) {
+	prim := &geometry.SDFPrimitive{Min: p.Min.vec(), Max: p.Max.vec()}
+	if !below(prim.Min, prim.Max) {
+		return nil, fmt.Errorf("sdf: expected the bounds min below max")
+	}
+	if p.Shape == nil {
+		return nil, fmt.Errorf("sdf: missing shape")
+	}
+	shape, err := p.Shape.sdf()
+	if err != nil {
+		return nil, fmt.Errorf("sdf: shape: %w", err)
+	}
+	prim.Shape = shape
+	return prim, nil
+}
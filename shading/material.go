@@ -1,6 +1,7 @@
 package shading
 
 var Glass = Material{
+	Name:        "glass",
 	KAmbient:    Color{R: 0.1, G: 0.1, B: 0.1},
 	KDiffuse:    Color{R: 0.3, G: 0.3, B: 0.3},
 	KSpecular:   Color{R: 0.7, G: 0.7, B: 0.7},
@@ -9,6 +10,7 @@ var Glass = Material{
 }
 
 var Ivory = Material{
+	Name:        "ivory",
 	KAmbient:    Color{R: 0.4, G: 0.4, B: 0.35},
 	KDiffuse:    Color{R: 0.6, G: 0.6, B: 0.5},
 	KSpecular:   Color{R: 0.7, G: 0.7, B: 0.7},
@@ -16,6 +18,7 @@ var Ivory = Material{
 	Alpha:       125.0,
 }
 var RedRubber = Material{
+	Name:        "red",
 	KAmbient:    Color{R: 0.3, G: 0.0, B: 0.0},
 	KDiffuse:    Color{R: 0.9, G: 0.1, B: 0.0},
 	KSpecular:   Color{R: 0.3, G: 0.3, B: 0.3},
@@ -26,10 +29,34 @@ var RedRubber = Material{
 // Material represents the properties of a material used in rendering.
 // It includes ambient, diffuse, specular, and reflection constants,
 // as well as an alpha value for the Phong model.
+//
+// IOR, Transparency and Illum mirror the Ni, d (as 1 - d) and illum
+// statements of a Wavefront MTL file. DiffuseMap modulates KDiffuse and
+// BumpMap perturbs the shading normal; both are optional.
 type Material struct {
-	KAmbient    Color
-	KDiffuse    Color
-	KSpecular   Color
-	KReflection Color
-	Alpha       float64
+	Name         string
+	KAmbient     Color
+	KDiffuse     Color
+	KSpecular    Color
+	KReflection  Color
+	Alpha        float64
+	IOR          float64
+	Transparency float64
+	Illum        int
+	DiffuseMap   *Texture
+	BumpMap      *Texture
+	BumpScale    float64
+}
+
+// Builtin returns the built-in material with the given name or nil.
+func Builtin(name string) *Material {
+	if name == "red" {
+		return &RedRubber
+	} else if name == "ivory" {
+		return &Ivory
+	} else if name == "glass" {
+		return &Glass
+	}
+
+	return nil
 }
@@ -0,0 +1,263 @@
+package shading
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LoadMTL loads the materials of a Wavefront MTL file keyed by their names.
+// Texture paths are resolved relative to the directory of the MTL file.
+//
+// Supported statements are newmtl, Ka, Kd, Ks, Ns, Ni, d, Tr, illum,
+// map_Kd and map_Bump (bump). Illumination models with ray-traced
+// reflection (3 and above) reflect with the Ks color.
+func LoadMTL(fName string) (map[string]*Material, error) {
+	f, err := os.Open(fName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	dir := filepath.Dir(fName)
+	materials := make(map[string]*Material)
+	var m *Material
+
+	s := bufio.NewScanner(f)
+	line := 0
+	for s.Scan() {
+		line++
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		errorf := func(format string, args ...any) error {
+			return fmt.Errorf("%s:%d: %s", fName, line, fmt.Sprintf(format, args...))
+		}
+
+		if fields[0] == "newmtl" {
+			if len(fields) < 2 {
+				return nil, errorf("newmtl: missing material name")
+			}
+			m = &Material{Name: fields[1], IOR: 1, BumpScale: 1}
+			materials[m.Name] = m
+			continue
+		}
+
+		if m == nil {
+			return nil, errorf("%s: statement before newmtl", fields[0])
+		}
+
+		switch fields[0] {
+		case "Ka", "Kd", "Ks":
+			c, err := parseMTLColor(fields[1:])
+			if err != nil {
+				return nil, errorf("%s: %v", fields[0], err)
+			}
+			switch fields[0] {
+			case "Ka":
+				m.KAmbient = c
+			case "Kd":
+				m.KDiffuse = c
+			case "Ks":
+				m.KSpecular = c
+			}
+		case "Ns", "Ni", "d", "Tr":
+			if len(fields) < 2 {
+				return nil, errorf("%s: missing value", fields[0])
+			}
+			v, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				return nil, errorf("%s: %v", fields[0], err)
+			}
+			switch fields[0] {
+			case "Ns":
+				m.Alpha = v
+			case "Ni":
+				m.IOR = v
+			case "d":
+				m.Transparency = 1 - v
+			case "Tr":
+				m.Transparency = v
+			}
+		case "illum":
+			if len(fields) < 2 {
+				return nil, errorf("illum: missing value")
+			}
+			v, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, errorf("illum: %v", err)
+			}
+			m.Illum = v
+		case "map_Kd":
+			path, _, err := parseMTLMap(fields[1:])
+			if err != nil {
+				return nil, errorf("map_Kd: %v", err)
+			}
+			t, err := LoadTexture(filepath.Join(dir, path))
+			if err != nil {
+				return nil, errorf("map_Kd: %v", err)
+			}
+			m.DiffuseMap = t
+		case "map_Bump", "map_bump", "bump":
+			path, scale, err := parseMTLMap(fields[1:])
+			if err != nil {
+				return nil, errorf("%s: %v", fields[0], err)
+			}
+			t, err := LoadTexture(filepath.Join(dir, path))
+			if err != nil {
+				return nil, errorf("%s: %v", fields[0], err)
+			}
+			m.BumpMap = t
+			m.BumpScale = scale
+		default:
+			continue // skip unsupported statements
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, m := range materials {
+		if m.Illum >= 3 {
+			m.KReflection = m.KSpecular
+		}
+	}
+
+	return materials, nil
+}
+
+// WriteMTL writes materials in the Wavefront MTL format. Materials that
+// reflect get illumination model 3 unless they have one, which reflects with
+// the specular color when read back. The paths of texture maps are written
+// relative to dir, the directory of the MTL file; maps without a path, like
+// those decoded from memory, are left out.
+func WriteMTL(w io.Writer, materials []Material, dir string) error {
+	bw := bufio.NewWriter(w)
+
+	color := func(key string, c Color) {
+		fmt.Fprintf(bw, "%s %s %s %s\n", key, formatFloat(c.R), formatFloat(c.G), formatFloat(c.B))
+	}
+	path := func(p string) string {
+		abs, err := filepath.Abs(p)
+		absDir, dirErr := filepath.Abs(dir)
+		if err == nil && dirErr == nil {
+			if rel, err := filepath.Rel(absDir, abs); err == nil {
+				p = rel
+			}
+		}
+		return filepath.ToSlash(p)
+	}
+
+	for i, m := range materials {
+		if i > 0 {
+			fmt.Fprintln(bw)
+		}
+		fmt.Fprintf(bw, "newmtl %s\n", m.Name)
+		color("Ka", m.KAmbient)
+		color("Kd", m.KDiffuse)
+		color("Ks", m.KSpecular)
+		fmt.Fprintf(bw, "Ns %s\n", formatFloat(m.Alpha))
+		if m.IOR != 0 {
+			fmt.Fprintf(bw, "Ni %s\n", formatFloat(m.IOR))
+		}
+		fmt.Fprintf(bw, "d %s\n", formatFloat(1-m.Transparency))
+
+		illum := m.Illum
+		if illum == 0 {
+			illum = 2
+			if m.KReflection != Black {
+				illum = 3
+			}
+		}
+		fmt.Fprintf(bw, "illum %d\n", illum)
+
+		if t := m.DiffuseMap; t != nil && t.Path != "" {
+			fmt.Fprintf(bw, "map_Kd %s\n", path(t.Path))
+		}
+		if t := m.BumpMap; t != nil && t.Path != "" {
+			fmt.Fprintf(bw, "map_Bump -bm %s %s\n", formatFloat(m.BumpScale), path(t.Path))
+		}
+	}
+
+	return bw.Flush()
+}
+
+// formatFloat formats a number in the shortest form that reads back the same.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// parseMTLColor parses "r [g b]"; a single value is used for all three components.
+func parseMTLColor(fields []string) (Color, error) {
+	if len(fields) == 0 {
+		return Color{}, fmt.Errorf("missing color")
+	}
+	if fields[0] == "spectral" || fields[0] == "xyz" {
+		return Color{}, fmt.Errorf("unsupported color format %q", fields[0])
+	}
+
+	var rgb [3]float64
+	for i := range rgb {
+		j := i
+		if len(fields) < 3 {
+			j = 0
+		}
+		v, err := strconv.ParseFloat(fields[j], 64)
+		if err != nil {
+			return Color{}, err
+		}
+		rgb[i] = v
+	}
+
+	return Color{R: rgb[0], G: rgb[1], B: rgb[2]}, nil
+}
+
+// parseMTLMap returns the file name of a texture map statement and its -bm
+// multiplier. Other options are skipped.
+func parseMTLMap(fields []string) (string, float64, error) {
+	// number of arguments taken by each option; -o, -s and -t take one to three values
+	optionArgs := map[string]int{
+		"-blendu": 1, "-blendv": 1, "-boost": 1, "-mm": 2, "-o": 3, "-s": 3,
+		"-t": 3, "-texres": 1, "-clamp": 1, "-bm": 1, "-imfchan": 1, "-type": 1,
+	}
+
+	scale := 1.
+	i := 0
+	for i < len(fields) && strings.HasPrefix(fields[i], "-") {
+		opt := fields[i]
+		n, ok := optionArgs[opt]
+		if !ok {
+			return "", 0, fmt.Errorf("unknown option %s", opt)
+		}
+		i++
+
+		if opt == "-bm" && i < len(fields) {
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return "", 0, err
+			}
+			scale = v
+		}
+
+		variadic := opt == "-o" || opt == "-s" || opt == "-t"
+		for j := 0; j < n && i < len(fields)-1; j++ {
+			if _, err := strconv.ParseFloat(fields[i], 64); variadic && j > 0 && err != nil {
+				break
+			}
+			i++
+		}
+	}
+
+	if i >= len(fields) {
+		return "", 0, fmt.Errorf("missing file name")
+	}
+
+	return strings.Join(fields[i:], " "), scale, nil
+}
@@ -0,0 +1,105 @@
+package shading
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg" // register JPEG decoder
+	_ "image/png"  // register PNG decoder
+	"math"
+	"os"
+)
+
+// Texture is an RGB image sampled with bilinear filtering and repeat wrapping.
+// Texture coordinates (0, 0) refer to the lower left corner of the image.
+type Texture struct {
+	Path   string
+	Width  int
+	Height int
+	Pixels []Color
+}
+
+// LoadTexture decodes a PNG or JPEG file into a Texture.
+func LoadTexture(path string) (*Texture, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	t := NewTexture(img)
+	t.Path = path
+
+	return t, nil
+}
+
+// NewTexture converts an image into a Texture.
+func NewTexture(img image.Image) *Texture {
+	b := img.Bounds()
+	t := &Texture{
+		Width:  b.Dx(),
+		Height: b.Dy(),
+		Pixels: make([]Color, b.Dx()*b.Dy()),
+	}
+
+	for y := 0; y < t.Height; y++ {
+		for x := 0; x < t.Width; x++ {
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			t.Pixels[y*t.Width+x] = Color{
+				R: float64(r) / 0xFFFF,
+				G: float64(g) / 0xFFFF,
+				B: float64(bl) / 0xFFFF,
+			}
+		}
+	}
+
+	return t
+}
+
+// Sample returns the bilinearly filtered color at texture coordinates (u, v).
+func (t *Texture) Sample(u, v float64) Color {
+	if t.Width == 0 || t.Height == 0 {
+		return Black
+	}
+
+	x := (u-math.Floor(u))*float64(t.Width) - .5
+	y := (1-(v-math.Floor(v)))*float64(t.Height) - .5
+
+	x0 := math.Floor(x)
+	y0 := math.Floor(y)
+	fx := x - x0
+	fy := y - y0
+
+	c00 := t.texel(int(x0), int(y0))
+	c10 := t.texel(int(x0)+1, int(y0))
+	c01 := t.texel(int(x0), int(y0)+1)
+	c11 := t.texel(int(x0)+1, int(y0)+1)
+
+	top := c00.MulByNum(1 - fx).Add(c10.MulByNum(fx))
+	bottom := c01.MulByNum(1 - fx).Add(c11.MulByNum(fx))
+
+	return top.MulByNum(1 - fy).Add(bottom.MulByNum(fy))
+}
+
+// Luminance returns the filtered brightness at (u, v). It is used to read height maps.
+func (t *Texture) Luminance(u, v float64) float64 {
+	c := t.Sample(u, v)
+	return 0.2126*c.R + 0.7152*c.G + 0.0722*c.B
+}
+
+func (t *Texture) texel(x, y int) Color {
+	x %= t.Width
+	if x < 0 {
+		x += t.Width
+	}
+	y %= t.Height
+	if y < 0 {
+		y += t.Height
+	}
+
+	return t.Pixels[y*t.Width+x]
+}